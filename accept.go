@@ -0,0 +1,146 @@
+package httpsign
+
+import (
+	"fmt"
+	"github.com/dunglas/httpsfv"
+	"net/http"
+)
+
+// AcceptSignature returns the value of an Accept-Signature header (RFC 9421, Sec. 5.1), asking the peer to sign
+// its response with the given label, covering the verifier's required fields, with the verifier's key ID and algorithm.
+// If the verifier checks the "created" parameter, the header asks for it.
+func AcceptSignature(label string, verifier Verifier) (string, error) {
+	if err := validateLabel(label, 0); err != nil {
+		return "", err
+	}
+	p := httpsfv.NewParams()
+	if verifier.config != nil && verifier.config.verifyCreated {
+		p.Add("created", true)
+	}
+	if verifier.alg != "" {
+		p.Add("alg", verifier.alg)
+	}
+	if verifier.keyID != "" {
+		p.Add("keyid", verifier.keyID)
+	}
+	il := httpsfv.InnerList{Items: []httpsfv.Item{}, Params: p}
+	for _, f := range verifier.fields.f {
+		il.Items = append(il.Items, f.toItem())
+	}
+	dict := httpsfv.NewDictionary()
+	dict.Add(label, il)
+	s, err := httpsfv.Marshal(dict)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal Accept-Signature: %w", err)
+	}
+	return s, nil
+}
+
+// asVerifier returns the Verifier behind a MessageVerifier, or nil for other implementations
+func asVerifier(v MessageVerifier) *Verifier {
+	switch vv := v.(type) {
+	case *Verifier:
+		return vv
+	case Verifier:
+		return &vv
+	}
+	return nil
+}
+
+// AcceptedSignature is a signature requested by an Accept-Signature header. Created and Expires indicate
+// that the corresponding parameters are requested.
+type AcceptedSignature struct {
+	Label   string
+	Fields  Fields
+	KeyID   string
+	Alg     string
+	Created bool
+	Expires bool
+	Nonce   string
+	Tag     string
+}
+
+// ParseAcceptSignature parses the values of an Accept-Signature header, strictly following the RFC 8941 grammar.
+// The requested signatures are returned in the order they appear.
+func ParseAcceptSignature(values []string) ([]AcceptedSignature, error) {
+	if err := validateStrictDictionary("accept-signature", values); err != nil {
+		return nil, err
+	}
+	dict, err := httpsfv.UnmarshalDictionary(values)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse Accept-Signature: %w", err)
+	}
+	var accepted []AcceptedSignature
+	for _, label := range dict.Names() {
+		member, _ := dict.Get(label)
+		il, ok := member.(httpsfv.InnerList)
+		if !ok {
+			return nil, fmt.Errorf("Accept-Signature: signature %s does not have an inner list", label)
+		}
+		s, err := httpsfv.Marshal(il)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal inner list: %w", err)
+		}
+		psi, err := parseSignatureInput(s, label)
+		if err != nil {
+			return nil, err
+		}
+		a := AcceptedSignature{Label: label, Fields: psi.fields}
+		a.KeyID, _ = psi.params["keyid"].(string)
+		a.Alg, _ = psi.params["alg"].(string)
+		a.Created, _ = psi.params["created"].(bool)
+		a.Expires, _ = psi.params["expires"].(bool)
+		a.Nonce, _ = psi.params["nonce"].(string)
+		a.Tag, _ = psi.params["tag"].(string)
+		accepted = append(accepted, a)
+	}
+	return accepted, nil
+}
+
+// acceptedSigner shapes the signer according to the first signature requested by the request's Accept-Signature
+// header that matches the signer's key ID, algorithm and tag. The tag identifies the server's application,
+// so the client may only ask for the tag the signer is configured with. The requested fields are covered
+// in addition to the signer's own fields. Returns false if there is no such header or no matching request.
+func acceptedSigner(r *http.Request, signer MessageSigner) (string, Signer, bool) {
+	values := r.Header.Values("Accept-Signature")
+	s := asSigner(signer)
+	if len(values) == 0 || s == nil {
+		return "", Signer{}, false
+	}
+	accepted, err := ParseAcceptSignature(values)
+	if err != nil {
+		return "", Signer{}, false
+	}
+	for _, a := range accepted {
+		if (a.Alg != "" && a.Alg != s.alg) || (a.KeyID != "" && a.KeyID != s.keyID) || (a.Tag != "" && a.Tag != s.config.tag) {
+			continue
+		}
+		return a.Label, shapeSigner(s, a), true
+	}
+	return "", Signer{}, false
+}
+
+// shapeSigner returns a copy of the signer that also covers the requested fields and parameters. A requested
+// nonce is included, so that the client can bind the response to its request (RFC 9421, Sec. 5.1).
+// A request for "expires" is ignored: the parameter is only included if the signer's configuration sets
+// an expiration, since the client cannot say how long the signature should be valid.
+func shapeSigner(s *Signer, a AcceptedSignature) Signer {
+	shaped := *s
+	config := *s.config
+	shaped.config = &config
+	if a.Created {
+		config.signCreated = true
+	}
+	if a.Nonce != "" {
+		config.nonce = a.Nonce
+		config.nonceGen = nil
+	}
+	fields := Fields{f: append([]field(nil), a.Fields.f...)}
+	for _, f := range s.fields.f {
+		if !fields.contains(&Fields{f: []field{f}}) {
+			fields.f = append(fields.f, f)
+		}
+	}
+	shaped.fields = fields
+	return shaped
+}
@@ -0,0 +1,156 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAcceptSignature(t *testing.T) {
+	verifier, _ := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{1}, 64), nil,
+		*NewFields().AddHeaders("@status", "content-digest").AddRequestHeaders("@method"))
+	accept, err := AcceptSignature("sig1", *verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, `sig1=("@status" "content-digest" "@method";req);created;alg="hmac-sha256";keyid="test-key-hmac"`, accept)
+
+	lax, _ := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{1}, 64), NewVerifyConfig().SetVerifyCreated(false), Headers("@status"))
+	accept, err = AcceptSignature("sig1", *lax)
+	assert.NoError(t, err)
+	assert.Equal(t, `sig1=("@status");alg="hmac-sha256";keyid="test-key-hmac"`, accept)
+
+	_, err = AcceptSignature("Bad Label", *lax)
+	assert.Error(t, err)
+}
+
+func TestClientAcceptSignature(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Accept-Signature")
+	}))
+	defer ts.Close()
+	verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{1}, 64), nil, Headers("@status"))
+
+	client := NewDefaultClient("sig1", nil, nil, nil).SetAcceptSignature(true)
+	_, err := client.Get(ts.URL)
+	assert.Error(t, err, "requires a Verifier")
+
+	client = NewDefaultClient("sig1", nil, verifier, nil).SetAcceptSignature(true)
+	_, err = client.Get(ts.URL)
+	assert.Error(t, err, "response is not signed")
+	assert.Equal(t, `sig1=("@status");created;alg="hmac-sha256";keyid="key"`, received)
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept-Signature", `custom=("@status")`)
+	_, _ = client.Do(req)
+	assert.Equal(t, `custom=("@status")`, received, "existing header is kept")
+}
+
+func TestParseAcceptSignature(t *testing.T) {
+	// RFC 9421, Sec. 5.1
+	accepted, err := ParseAcceptSignature([]string{`sig1=("@method" "@target-uri" "@authority" "content-digest" "cache-control");keyid="test-key-rsa-pss";created;tag="app-123"`})
+	assert.NoError(t, err)
+	assert.Equal(t, []AcceptedSignature{{
+		Label:   "sig1",
+		Fields:  Headers("@method", "@target-uri", "@authority", "content-digest", "cache-control"),
+		KeyID:   "test-key-rsa-pss",
+		Created: true,
+		Tag:     "app-123",
+	}}, accepted)
+
+	accepted, err = ParseAcceptSignature([]string{`a=("@status");alg="ed25519"`, `b=("@status" "content-type";req);nonce="xyz"`})
+	assert.NoError(t, err)
+	assert.Len(t, accepted, 2)
+	assert.Equal(t, "ed25519", accepted[0].Alg)
+	assert.Equal(t, *NewFields().AddHeader("@status").AddRequestHeaders("content-type"), accepted[1].Fields)
+	assert.Equal(t, "xyz", accepted[1].Nonce)
+
+	_, err = ParseAcceptSignature([]string{`sig1=("@status");keyid=x y`})
+	var sfErr *SFParseError
+	assert.ErrorAs(t, err, &sfErr)
+	_, err = ParseAcceptSignature([]string{`sig1=:AAAA:`})
+	assert.Error(t, err, "not an inner list")
+}
+
+func TestWrapHandlerHonorAcceptSignature(t *testing.T) {
+	key := bytes.Repeat([]byte{6}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("server-key", key, NewSignConfig().SignCreated(false), Headers("@status"))
+		return "default", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().
+		SetFetchSigner(fetchSigner).SetHonorAcceptSignature(true)))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"no header", "", `default=("@status");alg="hmac-sha256";keyid="server-key"`},
+		{"shaped", `mine=("content-type" "@method";req);keyid="server-key";created;nonce="n1"`,
+			`mine=("content-type" "@method";req "@status");created=N;nonce="n1";alg="hmac-sha256";keyid="server-key"`},
+		{"second request matches", `a=("@status");alg="ed25519", b=("content-type")`,
+			`b=("content-type" "@status");alg="hmac-sha256";keyid="server-key"`},
+		{"wrong key", `mine=("content-type");keyid="other"`, `default=("@status");alg="hmac-sha256";keyid="server-key"`},
+		{"missing header", `mine=("x-missing")`, `default=("@status");alg="hmac-sha256";keyid="server-key"`},
+		{"malformed", `mine=("content-type"`, `default=("@status");alg="hmac-sha256";keyid="server-key"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Signature", tt.accept)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			got := regexp.MustCompile(`created=\d+`).ReplaceAllString(res.Header.Get("Signature-Input"), "created=N")
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	verifier, _ := NewHMACSHA256Verifier("server-key", key, nil, *NewFields().AddHeader("content-type").AddRequestHeaders("@method"))
+	client := NewDefaultClient("sig1", nil, verifier, nil).SetAcceptSignature(true)
+	res, err := client.Get(ts.URL)
+	assert.NoError(t, err, "the client should get the signature it asked for")
+	if err == nil {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestAcceptedSigner(t *testing.T) {
+	key := bytes.Repeat([]byte{6}, 64)
+	signer, _ := NewHMACSHA256Signer("server-key", key, NewSignConfig().SetTag("srv").SetExpiresIn(time.Minute), Headers("@status"))
+	untimed, _ := NewHMACSHA256Signer("server-key", key, NewSignConfig().SetTag("srv"), Headers("@status"))
+	tests := []struct {
+		name   string
+		signer *Signer
+		accept string
+		want   bool
+	}{
+		{"own tag", signer, `mine=("content-type");tag="srv"`, true},
+		{"no tag", signer, `mine=("content-type")`, true},
+		{"foreign tag", signer, `mine=("content-type");tag="other-app"`, false},
+		{"expiration requested", untimed, `mine=("content-type");expires`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Signature", tt.accept)
+			label, shaped, ok := acceptedSigner(req, *tt.signer)
+			assert.Equal(t, tt.want, ok)
+			if ok {
+				assert.Equal(t, "mine", label)
+				assert.Equal(t, "srv", shaped.config.tag, "the tag is the signer's own")
+				assert.Equal(t, tt.signer.config.expiresIn, shaped.config.expiresIn, "the expiration is the signer's own")
+			}
+		})
+	}
+}
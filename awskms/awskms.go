@@ -0,0 +1,100 @@
+// Package awskms signs HTTP messages with asymmetric keys held in AWS KMS, so that private keys
+// never reside in application memory.
+//
+// To avoid a dependency on the AWS SDK, the package defines the small API interface. With aws-sdk-go-v2,
+// it is implemented by a thin wrapper around kms.Client:
+//
+//	func (c myClient) Sign(ctx context.Context, keyARN string, digest []byte, alg string) ([]byte, error) {
+//		out, err := c.kms.Sign(ctx, &kms.SignInput{KeyId: &keyARN, Message: digest,
+//			MessageType: types.MessageTypeDigest, SigningAlgorithm: types.SigningAlgorithmSpec(alg)})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return out.Signature, nil
+//	}
+//
+//	func (c myClient) GetPublicKey(ctx context.Context, keyARN string) ([]byte, error) {
+//		out, err := c.kms.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: &keyARN})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return out.PublicKey, nil
+//	}
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"github.com/yaronf/httpsign"
+	"io"
+)
+
+// API is the subset of the AWS KMS API used by this package.
+type API interface {
+	// Sign signs a message digest (MessageType DIGEST) with the given KMS signing algorithm, e.g. "ECDSA_SHA_256".
+	Sign(ctx context.Context, keyARN string, digest []byte, signingAlgorithm string) ([]byte, error)
+	// GetPublicKey returns the DER-encoded (PKIX) public key.
+	GetPublicKey(ctx context.Context, keyARN string) ([]byte, error)
+}
+
+// signingAlgorithms maps HTTP signature algorithms to KMS signing algorithms
+var signingAlgorithms = map[string]string{
+	"rsa-v1_5-sha256":   "RSASSA_PKCS1_V1_5_SHA_256",
+	"rsa-pss-sha512":    "RSASSA_PSS_SHA_512",
+	"ecdsa-p256-sha256": "ECDSA_SHA_256",
+	"ecdsa-p384-sha384": "ECDSA_SHA_384",
+}
+
+// NewSigner returns a Signer whose signatures are computed by AWS KMS. The key ARN is used as the "keyid"
+// signature parameter. Alg is the HTTP signature algorithm, which must match the KMS key spec.
+// The public key is fetched once, using ctx. Each signature is computed with the context of the message being
+// signed, see httpsign.ContextSigner. Config may be nil for a default configuration.
+func NewSigner(ctx context.Context, api API, keyARN, alg string, config *httpsign.SignConfig,
+	fields httpsign.Fields) (*httpsign.Signer, error) {
+	s, err := newKMSSigner(ctx, api, keyARN, alg)
+	if err != nil {
+		return nil, err
+	}
+	return httpsign.NewSignerFromCryptoSigner(alg, keyARN, s, config, fields)
+}
+
+// kmsSigner is a crypto.Signer backed by a KMS key
+type kmsSigner struct {
+	api    API
+	keyARN string
+	kmsAlg string
+	public crypto.PublicKey
+}
+
+func newKMSSigner(ctx context.Context, api API, keyARN, alg string) (*kmsSigner, error) {
+	if api == nil {
+		return nil, fmt.Errorf("nil KMS API")
+	}
+	kmsAlg, ok := signingAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("algorithm \"%s\" is not supported by KMS", alg)
+	}
+	der, err := api.GetPublicKey(ctx, keyARN)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch public key for \"%s\": %w", keyARN, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key for \"%s\": %w", keyARN, err)
+	}
+	return &kmsSigner{api: api, keyARN: keyARN, kmsAlg: kmsAlg, public: pub}, nil
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+func (s *kmsSigner) SignContext(ctx context.Context, _ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return s.api.Sign(ctx, s.keyARN, digest, s.kmsAlg)
+}
@@ -0,0 +1,63 @@
+package awskms
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeKMS signs with a local key, the way KMS would
+type fakeKMS struct {
+	key    *ecdsa.PrivateKey
+	gotAlg string
+}
+
+func (f *fakeKMS) Sign(ctx context.Context, _ string, digest []byte, alg string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.gotAlg = alg
+	return ecdsa.SignASN1(rand.Reader, f.key, digest)
+}
+
+func (f *fakeKMS) GetPublicKey(context.Context, string) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+}
+
+func TestNewSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	kms := &fakeKMS{key: key}
+	arn := "arn:aws:kms:us-east-1:111122223333:key/1234abcd"
+	fields := httpsign.Headers("@method", "@path")
+	signer, err := NewSigner(context.Background(), kms, arn, "ecdsa-p256-sha256", nil, fields)
+	assert.NoError(t, err)
+
+	req, _ := http.ReadRequest(bufio.NewReader(strings.NewReader("GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+	sigInput, sig, err := httpsign.SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ECDSA_SHA_256", kms.gotAlg)
+	assert.Contains(t, sigInput, `keyid="`+arn+`"`)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	verifier, _ := httpsign.NewP256Verifier(arn, key.PublicKey, nil, fields)
+	assert.NoError(t, httpsign.VerifyRequest("sig1", *verifier, req))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = httpsign.SignRequest("sig2", *signer, req.WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled, "KMS should be called with the request context")
+
+	_, err = NewSigner(context.Background(), kms, arn, "ed25519", nil, fields)
+	assert.Error(t, err, "ed25519 is not supported by KMS")
+	_, err = NewSigner(context.Background(), kms, arn, "rsa-v1_5-sha256", nil, fields)
+	assert.Error(t, err, "key type mismatch")
+}
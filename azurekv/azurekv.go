@@ -0,0 +1,122 @@
+// Package azurekv signs HTTP messages with keys held in Azure Key Vault (or Managed HSM).
+// The signature base is hashed locally, and only the digest is sent to the vault.
+//
+// To avoid a dependency on the Azure SDK, the package defines the small API interface. With the azkeys package,
+// it is implemented by a thin wrapper around azkeys.Client, where name and version are parsed from the key identifier:
+//
+//	func (c myClient) Sign(ctx context.Context, keyID, alg string, digest []byte) ([]byte, error) {
+//		a := azkeys.SignatureAlgorithm(alg)
+//		res, err := c.kv.Sign(ctx, name, version, azkeys.SignParameters{Algorithm: &a, Value: digest}, nil)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return res.Result, nil
+//	}
+//
+//	func (c myClient) GetKey(ctx context.Context, keyID string) ([]byte, error) {
+//		res, err := c.kv.GetKey(ctx, name, version, nil)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return json.Marshal(res.Key)
+//	}
+package azurekv
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"fmt"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/yaronf/httpsign"
+	"io"
+	"math/big"
+)
+
+// API is the subset of the Key Vault keys API used by this package.
+type API interface {
+	// Sign signs a digest with a JWS algorithm name, e.g. "ES256". For EC keys, the vault returns
+	// the raw (r || s) signature.
+	Sign(ctx context.Context, keyID, alg string, digest []byte) ([]byte, error)
+	// GetKey returns the public key as a JSON Web Key.
+	GetKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// vaultAlgorithms maps HTTP signature algorithms to Key Vault signature algorithms
+var vaultAlgorithms = map[string]string{
+	"rsa-v1_5-sha256":   "RS256",
+	"rsa-pss-sha512":    "PS512",
+	"ecdsa-p256-sha256": "ES256",
+	"ecdsa-p384-sha384": "ES384",
+}
+
+// NewSigner returns a Signer whose signatures are computed by Key Vault. VaultKeyID is the key identifier,
+// e.g. https://myvault.vault.azure.net/keys/mykey/0123456789abcdef. The "keyid" signature parameter is
+// keyID, or the vault key identifier if keyID is empty. Alg is the HTTP signature algorithm, which must
+// match the vault key. The public key is fetched once, using ctx. Each signature is computed with the context
+// of the message being signed, see httpsign.ContextSigner. Config may be nil for a default configuration.
+func NewSigner(ctx context.Context, api API, vaultKeyID, keyID, alg string, config *httpsign.SignConfig,
+	fields httpsign.Fields) (*httpsign.Signer, error) {
+	if api == nil {
+		return nil, fmt.Errorf("nil Key Vault API")
+	}
+	vaultAlg, ok := vaultAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("algorithm \"%s\" is not supported by Key Vault", alg)
+	}
+	jwkBytes, err := api.GetKey(ctx, vaultKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch key \"%s\": %w", vaultKeyID, err)
+	}
+	key, err := jwk.ParseKey(jwkBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse key \"%s\": %w", vaultKeyID, err)
+	}
+	var pub interface{}
+	if err = key.Raw(&pub); err != nil {
+		return nil, fmt.Errorf("cannot parse key \"%s\": %w", vaultKeyID, err)
+	}
+	if keyID == "" {
+		keyID = vaultKeyID
+	}
+	s := &vaultSigner{api: api, vaultKeyID: vaultKeyID, vaultAlg: vaultAlg, public: pub}
+	return httpsign.NewSignerFromCryptoSigner(alg, keyID, s, config, fields)
+}
+
+// vaultSigner is a crypto.Signer backed by a Key Vault key
+type vaultSigner struct {
+	api        API
+	vaultKeyID string
+	vaultAlg   string
+	public     crypto.PublicKey
+}
+
+func (s *vaultSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *vaultSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+func (s *vaultSigner) SignContext(ctx context.Context, _ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	sig, err := s.api.Sign(ctx, s.vaultKeyID, s.vaultAlg, digest)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.public.(*ecdsa.PublicKey); ok { // crypto.Signer returns ASN.1 ECDSA signatures
+		return rawToASN1(sig)
+	}
+	return sig, nil
+}
+
+func rawToASN1(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("malformed ECDSA signature")
+	}
+	n := len(raw) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{new(big.Int).SetBytes(raw[:n]), new(big.Int).SetBytes(raw[n:])})
+}
@@ -0,0 +1,87 @@
+package azurekv
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeVault signs with a local key, returning raw signatures as Key Vault does
+type fakeVault struct {
+	key    *ecdsa.PrivateKey
+	gotAlg string
+}
+
+func (f *fakeVault) Sign(ctx context.Context, _, alg string, digest []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.gotAlg = alg
+	r, s, err := ecdsa.Sign(rand.Reader, f.key, digest)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+	return raw, nil
+}
+
+func (f *fakeVault) GetKey(context.Context, string) ([]byte, error) {
+	key, err := jwk.New(&f.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(key)
+}
+
+func TestNewSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	vault := &fakeVault{key: key}
+	vaultKeyID := "https://myvault.vault.azure.net/keys/mykey/0123456789abcdef"
+	fields := httpsign.Headers("@method", "@path")
+
+	tests := []struct {
+		name      string
+		keyID     string
+		wantKeyID string
+	}{
+		{"vault key identifier", "", vaultKeyID},
+		{"mapped key ID", "my-key", "my-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewSigner(context.Background(), vault, vaultKeyID, tt.keyID, "ecdsa-p256-sha256", nil, fields)
+			assert.NoError(t, err)
+			req, _ := http.ReadRequest(bufio.NewReader(strings.NewReader("GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+			sigInput, sig, err := httpsign.SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			assert.Equal(t, "ES256", vault.gotAlg)
+			assert.Contains(t, sigInput, `keyid="`+tt.wantKeyID+`"`)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := httpsign.NewP256Verifier(tt.wantKeyID, key.PublicKey, nil, fields)
+			assert.NoError(t, httpsign.VerifyRequest("sig1", *verifier, req))
+		})
+	}
+	signer, err := NewSigner(context.Background(), vault, vaultKeyID, "", "ecdsa-p256-sha256", nil, fields)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://example.com/foo", nil)
+	_, _, err = httpsign.SignRequest("sig1", *signer, req)
+	assert.ErrorIs(t, err, context.Canceled, "Key Vault should be called with the request context")
+
+	_, err = NewSigner(context.Background(), vault, vaultKeyID, "", "rsa-pss-sha512", nil, fields)
+	assert.Error(t, err, "key type mismatch")
+}
@@ -0,0 +1,115 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// Benchmarks for signing and verifying requests, by the number of covered fields and by body size.
+// Compare runs with benchstat, e.g. go test -run '^$' -bench 'Sign|Verify' -count 10.
+
+var benchFieldCounts = []int{1, 4, 16}
+
+var benchBodySizes = []int{0, 1 << 10, 64 << 10, 1 << 20}
+
+var benchKey = bytes.Repeat([]byte{1}, 64)
+
+// benchRequest returns a request with the given number of covered fields, all but the first one headers
+func benchRequest(fieldCount, bodySize int) (*http.Request, Fields) {
+	req, _ := http.NewRequest("POST", "https://example.com/foo/bar?param=value&pet=dog", bytes.NewReader(make([]byte, bodySize)))
+	fields := Headers("@method")
+	for i := 1; i < fieldCount; i++ {
+		name := fmt.Sprintf("x-header-%d", i)
+		req.Header.Set(name, fmt.Sprintf("value of header number %d", i))
+		fields.AddHeader(name)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if bodySize > 0 {
+		digest, _ := ContentDigest(DigestSHA256, make([]byte, bodySize))
+		req.Header.Set("Content-Digest", digest)
+		fields.AddHeader("content-digest")
+	}
+	return req, fields
+}
+
+func BenchmarkSign(b *testing.B) {
+	for _, n := range benchFieldCounts {
+		b.Run(fmt.Sprintf("fields=%d", n), func(b *testing.B) {
+			req, fields := benchRequest(n, 0)
+			signer, _ := NewHMACSHA256Signer("key1", benchKey, NewSignConfig(), fields)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := SignRequest("sig1", *signer, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	for _, n := range benchFieldCounts {
+		b.Run(fmt.Sprintf("fields=%d", n), func(b *testing.B) {
+			req, fields := benchRequest(n, 0)
+			signer, _ := NewHMACSHA256Signer("key1", benchKey, NewSignConfig(), fields)
+			verifier, _ := NewHMACSHA256Verifier("key1", benchKey, NewVerifyConfig(), fields)
+			sigInput, sig, _ := SignRequest("sig1", *signer, req)
+			req.Header.Set("Signature-Input", sigInput)
+			req.Header.Set("Signature", sig)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := VerifyRequest("sig1", *verifier, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkVerifyBody(b *testing.B) {
+	for _, size := range benchBodySizes {
+		b.Run(fmt.Sprintf("body=%d", size), func(b *testing.B) {
+			req, fields := benchRequest(4, size)
+			signer, _ := NewHMACSHA256Signer("key1", benchKey, NewSignConfig(), fields)
+			verifier, _ := NewHMACSHA256Verifier("key1", benchKey, NewVerifyConfig(), fields)
+			sigInput, sig, _ := SignRequest("sig1", *signer, req)
+			req.Header.Set("Signature-Input", sigInput)
+			req.Header.Set("Signature", sig)
+			body := make([]byte, size)
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				if err := VerifyRequest("sig1", *verifier, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSignBody(b *testing.B) {
+	for _, size := range benchBodySizes {
+		b.Run(fmt.Sprintf("body=%d", size), func(b *testing.B) {
+			req, fields := benchRequest(4, size)
+			signer, _ := NewHMACSHA256Signer("key1", benchKey, NewSignConfig(), fields)
+			body := make([]byte, size)
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req.Header.Del("Content-Digest") // generated by the signer
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				if _, _, err := SignRequest("sig1", *signer, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// The functions in this file expose the building blocks of the signature input (signature base)
+// for use by adjacent tooling, e.g. proxies, debugging tools, or protocols that reuse the format.
+// Most applications should use SignRequest, VerifyRequest and friends instead.
+
+// RequestDerivedComponent returns the canonical value of a derived component of a request, e.g. "@authority".
+func RequestDerivedComponent(name string, req *http.Request) (string, error) {
+	if req == nil {
+		return "", fmt.Errorf("nil request")
+	}
+	parsedMessage, err := parseRequest(req, nil)
+	if err != nil {
+		return "", err
+	}
+	return derivedComponentValue(name, *parsedMessage)
+}
+
+// ResponseDerivedComponent returns the canonical value of a derived component of a response, e.g. "@status".
+func ResponseDerivedComponent(name string, res *http.Response) (string, error) {
+	if res == nil {
+		return "", fmt.Errorf("nil response")
+	}
+	parsedMessage, err := parseResponse(res, nil)
+	if err != nil {
+		return "", err
+	}
+	return derivedComponentValue(name, *parsedMessage)
+}
+
+func derivedComponentValue(name string, message parsedMessage) (string, error) {
+	if !isKnownDerivedComponent(name) {
+		return "", &UnknownComponentError{Component: name}
+	}
+	v, found := message.derived[name]
+	if !found {
+		return "", fmt.Errorf("derived component %s not available for this message", name)
+	}
+	return v, nil
+}
+
+// CanonicalHeaderValue returns the canonical value of a header as it appears in the signature input,
+// given all of its (ordered) values: each value is trimmed and unfolded, and the values are combined with ", ".
+// This is the CanonicalizeStrict mode.
+func CanonicalHeaderValue(values []string) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("no header values")
+	}
+	return foldFields(values), nil
+}
+
+// SignatureParams returns the value of the "@signature-params" component, which is also the value of
+// the signature's member in the Signature-Input header. The parameters are taken from the configuration,
+// and the "created" parameter (if configured) is the current time. Config may be nil for a default configuration.
+func SignatureParams(fields Fields, keyID, alg string, config *SignConfig) (string, error) {
+	if config == nil {
+		config = NewSignConfig()
+	}
+	return generateSigParams(config, keyID, alg, nil, fields)
+}
+
+// RequestSignatureInput returns the complete signature input (signature base) of a request, given the
+// covered fields and the "@signature-params" value, e.g. as returned by SignatureParams.
+func RequestSignatureInput(req *http.Request, fields Fields, sigParams string) (string, error) {
+	if req == nil {
+		return "", fmt.Errorf("nil request")
+	}
+	parsedMessage, err := parseRequest(req, nil)
+	if err != nil {
+		return "", err
+	}
+	return generateSignatureInput(*parsedMessage, fields, sigParams)
+}
+
+// ResponseSignatureInput returns the complete signature input (signature base) of a response, given the
+// covered fields and the "@signature-params" value.
+func ResponseSignatureInput(res *http.Response, fields Fields, sigParams string) (string, error) {
+	if res == nil {
+		return "", fmt.Errorf("nil response")
+	}
+	parsedMessage, err := parseResponse(res, nil)
+	if err != nil {
+		return "", err
+	}
+	return generateSignatureInput(*parsedMessage, fields, sigParams)
+}
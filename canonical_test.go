@@ -0,0 +1,40 @@
+package httpsign
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizationPrimitives(t *testing.T) {
+	req := readRequest(httpreq1)
+	authority, err := RequestDerivedComponent("@authority", req)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", authority)
+	_, err = RequestDerivedComponent("@status", req)
+	assert.Error(t, err, "@status is not a request component")
+	_, err = RequestDerivedComponent("@nonesuch", req)
+	var unknown *UnknownComponentError
+	assert.ErrorAs(t, err, &unknown)
+
+	status, err := ResponseDerivedComponent("@status", readResponse(httpres1))
+	assert.NoError(t, err)
+	assert.Equal(t, "200", status)
+
+	cc, err := CanonicalHeaderValue(req.Header.Values("Cache-Control"))
+	assert.NoError(t, err)
+	assert.Equal(t, "max-age=60, must-revalidate", cc)
+
+	fields := Headers("@method", "@authority", "cache-control")
+	config := NewSignConfig().SetCreated(1618884475)
+	sigParams, err := SignatureParams(fields, "test-key-hmac", "hmac-sha256", config)
+	assert.NoError(t, err)
+	assert.Equal(t, `("@method" "@authority" "cache-control");created=1618884475;alg="hmac-sha256";keyid="test-key-hmac"`, sigParams)
+
+	got, err := RequestSignatureInput(req, fields, sigParams)
+	assert.NoError(t, err)
+	_, _, want, err := signRequestDebug("sig1", makeHMACSigner(*config, fields), readRequest(httpreq1))
+	assert.NoError(t, err)
+	assert.Equal(t, want, got, "signature input should match the signer's")
+	assert.True(t, strings.HasPrefix(got, "\"@method\": POST\n"))
+}
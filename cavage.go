@@ -0,0 +1,481 @@
+package httpsign
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The functions in this file implement the older format of draft-cavage-http-signatures, which is still used by
+// Mastodon and ActivityPub servers, and by some banking APIs. They allow a gateway to bridge the two formats.
+// A Cavage signature is carried in a single header, e.g.
+//
+//	Signature: keyId="Test",algorithm="rsa-sha256",headers="(request-target) host date",signature="..."
+//
+// Only requests are supported. RSA (PKCS#1 v1.5 and PSS), HMAC and Ed25519 keys can be used, but not ECDSA keys,
+// whose signatures are encoded differently by the two formats.
+
+// DefaultCavageHeaders are the headers signed by SignRequestCavage if none are given, as expected by Mastodon.
+var DefaultCavageHeaders = []string{"(request-target)", "host", "date"}
+
+// cavageSignature is a parsed Cavage Signature header
+type cavageSignature struct {
+	keyID     string
+	algorithm string
+	headers   []string
+	created   int64
+	expires   int64
+	signature []byte
+}
+
+// cavageAlgorithm returns the Cavage name of an algorithm. The PSS and Ed25519 algorithms are only known as "hs2019".
+func cavageAlgorithm(alg string) (string, error) {
+	switch alg {
+	case "rsa-v1_5-sha256":
+		return "rsa-sha256", nil
+	case "hmac-sha256":
+		return "hmac-sha256", nil
+	case "rsa-pss-sha512", "ed25519":
+		return "hs2019", nil
+	}
+	return "", fmt.Errorf("algorithm \"%s\" is not supported for Cavage signatures", alg)
+}
+
+// SignRequestCavage signs a request in the format of draft-cavage-http-signatures, covering the given headers
+// and pseudo-headers "(request-target)", "(created)" and "(expires)", or DefaultCavageHeaders if none are given.
+// Returns the value of the Signature header. If "date" or "digest" are covered and the request does not have
+// these headers, they are added, the latter with a SHA-256 digest of the body.
+// The signer's configuration determines the "created" and "expires" parameters, which are only sent if covered.
+func SignRequestCavage(signer Signer, headers []string, req *http.Request) (string, error) {
+	if req == nil {
+		return "", fmt.Errorf("nil request")
+	}
+	algorithm, err := cavageAlgorithm(signer.alg)
+	if err != nil {
+		return "", err
+	}
+	if len(headers) == 0 {
+		headers = DefaultCavageHeaders
+	}
+	sig := cavageSignature{keyID: signer.keyID, algorithm: algorithm}
+	for _, h := range headers {
+		sig.headers = append(sig.headers, strings.ToLower(h))
+	}
+	created := time.Now().Unix()
+	if signer.config.fakeCreated != 0 {
+		created = signer.config.fakeCreated
+	}
+	if sig.covers("(created)") {
+		sig.created = created
+	}
+	if sig.covers("(expires)") {
+		if signer.config.expiresIn != 0 {
+			sig.expires = time.Unix(created, 0).Add(signer.config.expiresIn).Unix()
+		} else {
+			sig.expires = signer.config.expires
+		}
+		if sig.expires == 0 {
+			return "", fmt.Errorf("\"(expires)\" is covered, but no expiration is configured")
+		}
+	}
+	if sig.covers("date") && req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Unix(created, 0).UTC().Format(http.TimeFormat))
+	}
+	if sig.covers("digest") && req.Header.Get("Digest") == "" {
+		body, err := readBody(&req.Body, req.ContentLength)
+		if err != nil {
+			return "", err
+		}
+		d := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(d[:]))
+	}
+	input, err := sig.signingString(req)
+	if err != nil {
+		return "", err
+	}
+	sig.signature, err = signer.sign(req.Context(), []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return sig.String(), nil
+}
+
+// CavageRequestDetails parses the Cavage signature of a request, from its Signature header or else its Authorization
+// header, and returns its key ID and algorithm, which may be empty. Use it to look up the verifier.
+func CavageRequestDetails(req *http.Request) (keyID, algorithm string, err error) {
+	if req == nil {
+		return "", "", fmt.Errorf("nil request")
+	}
+	sig, err := parseCavageRequest(req)
+	if err != nil {
+		return "", "", err
+	}
+	return sig.keyID, sig.algorithm, nil
+}
+
+// VerifyRequestCavage verifies a request signed in the format of draft-cavage-http-signatures. The signature
+// is taken from the Signature header, or else from an Authorization header of the "Signature" scheme.
+//
+// The verifier's configuration applies, with these differences: the "created" and "expires" parameters are only
+// trusted if the signature covers "(created)" and "(expires)", and otherwise the Date header is checked instead,
+// and it must be covered. A covered Digest header is checked against the body.
+// The "@method", "@path", "@query", "@query-param" and "@request-target" components required by the verifier's
+// fields or minimum coverage are satisfied by "(request-target)", and "@authority" by "host". Other derived
+// components cannot be covered by a Cavage signature. Cavage signatures have neither a tag nor a nonce, so they
+// always fail verification if an expected tag or a nonce is required. With a replay cache, the signature value
+// stands in for the nonce.
+//
+// The "algorithm" parameter must match the verifier's algorithm, except that "hs2019" is accepted with any
+// verifier, since the draft leaves the actual algorithm to be determined by the key. The allowed algorithms of
+// the configuration are checked against the verifier's algorithm.
+func VerifyRequestCavage(verifier Verifier, req *http.Request) error {
+	if req == nil {
+		return fmt.Errorf("nil request")
+	}
+	sig, err := parseCavageRequest(req)
+	if err != nil {
+		return err
+	}
+	algorithm, err := cavageAlgorithm(verifier.alg)
+	if err != nil {
+		return err
+	}
+	if sig.algorithm != "" && sig.algorithm != algorithm && sig.algorithm != "hs2019" {
+		return fmt.Errorf("%w: \"%s\"", ErrAlgNotAllowed, sig.algorithm)
+	}
+	config := verifier.config
+	if len(config.allowedAlgs) > 0 {
+		allowed := false
+		for _, a := range config.allowedAlgs {
+			allowed = allowed || a == verifier.alg
+		}
+		if !allowed {
+			return fmt.Errorf("%w: \"%s\"", ErrAlgNotAllowed, verifier.alg)
+		}
+	}
+	if config.verifyKeyID && !config.acceptsKeyID(verifier.keyID, sig.keyID) {
+		return fmt.Errorf("%w \"%s\"", ErrKeyIDMismatch, sig.keyID)
+	}
+	if err = applyPolicyKeySize(verifier, *config); err != nil {
+		return err
+	}
+	keys, err := verifier.signatureKeys(req.Context(), sig.keyID, "")
+	if err != nil {
+		return err
+	}
+	if err = sig.checkFields(verifier.fields); err != nil {
+		return err
+	}
+	if err = sig.checkFields(config.minCoverage); err != nil {
+		return err
+	}
+	if config.expectedTag != "" {
+		return fmt.Errorf("\"tag\" parameter is not \"%s\"", config.expectedTag)
+	}
+	if config.requireNonce {
+		return fmt.Errorf("missing \"nonce\" parameter")
+	}
+	if err = sig.checkTimes(req, config); err != nil {
+		return err
+	}
+	input, err := sig.signingString(req)
+	if err != nil {
+		return err
+	}
+	w, finish := newKeysVerifyingWriter(keys)
+	_, _ = w.Write([]byte(input)) // hash and buffer writes never fail
+	verified, err := finish(sig.signature)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrBadSignature
+	}
+	if sig.covers("digest") && !config.skipDigest {
+		if err = verifyLegacyDigest(req); err != nil {
+			return err
+		}
+	}
+	if config.replayCache != nil {
+		nonce := base64.StdEncoding.EncodeToString(sig.signature)
+		created, _ := sig.createdTime(req)
+		if config.replayCache.Seen(verifier.keyID, nonce, created) {
+			return &ReplayError{KeyID: verifier.keyID, Nonce: nonce}
+		}
+	}
+	return nil
+}
+
+func parseCavageRequest(req *http.Request) (*cavageSignature, error) {
+	value := req.Header.Get("Signature")
+	if value == "" {
+		auth := req.Header.Get("Authorization")
+		if len(auth) > len("Signature ") && strings.EqualFold(auth[:len("Signature ")], "Signature ") {
+			value = auth[len("Signature "):]
+		}
+	}
+	if value == "" {
+		return nil, fmt.Errorf("missing Cavage signature")
+	}
+	return parseCavageSignature(value)
+}
+
+// parseCavageSignature parses a comma-separated list of name="value" parameters. Created and expires may be unquoted.
+func parseCavageSignature(s string) (*cavageSignature, error) {
+	sig := &cavageSignature{}
+	seen := map[string]bool{}
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed Cavage signature parameter in \"%s\"", s)
+		}
+		name, rest := strings.TrimSpace(s[:eq]), s[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated Cavage signature parameter \"%s\"", name)
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate Cavage signature parameter \"%s\"", name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "keyId":
+			sig.keyID = value
+		case "algorithm":
+			sig.algorithm = strings.ToLower(value)
+		case "headers":
+			sig.headers = strings.Fields(strings.ToLower(value))
+		case "created":
+			sig.created, err = strconv.ParseInt(value, 10, 64)
+		case "expires":
+			sig.expires, err = strconv.ParseInt(strings.SplitN(value, ".", 2)[0], 10, 64) // may be a decimal
+		case "signature":
+			sig.signature, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed Cavage signature parameter \"%s\": %w", name, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return nil, fmt.Errorf("malformed Cavage signature after parameter \"%s\"", name)
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	if sig.keyID == "" {
+		return nil, fmt.Errorf("missing Cavage \"keyId\" parameter")
+	}
+	if sig.signature == nil {
+		return nil, fmt.Errorf("missing Cavage \"signature\" parameter")
+	}
+	if !seen["headers"] { // the draft's default, though older versions defaulted to "date"
+		sig.headers = []string{"(created)"}
+	}
+	return sig, nil
+}
+
+func (sig cavageSignature) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "keyId=\"%s\",algorithm=\"%s\"", sig.keyID, sig.algorithm)
+	if sig.created != 0 {
+		fmt.Fprintf(&b, ",created=%d", sig.created)
+	}
+	if sig.expires != 0 {
+		fmt.Fprintf(&b, ",expires=%d", sig.expires)
+	}
+	fmt.Fprintf(&b, ",headers=\"%s\",signature=\"%s\"", strings.Join(sig.headers, " "),
+		base64.StdEncoding.EncodeToString(sig.signature))
+	return b.String()
+}
+
+func (sig cavageSignature) covers(header string) bool {
+	for _, h := range sig.headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// signingString generates the Cavage equivalent of the signature base
+func (sig cavageSignature) signingString(req *http.Request) (string, error) {
+	var lines []string
+	for _, h := range sig.headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "(created)":
+			if sig.created == 0 {
+				return "", fmt.Errorf("\"(created)\" is covered, but the \"created\" parameter is missing")
+			}
+			value = strconv.FormatInt(sig.created, 10)
+		case "(expires)":
+			if sig.expires == 0 {
+				return "", fmt.Errorf("\"(expires)\" is covered, but the \"expires\" parameter is missing")
+			}
+			value = strconv.FormatInt(sig.expires, 10)
+		case "host":
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		default:
+			vals, found := req.Header[http.CanonicalHeaderKey(h)]
+			if !found {
+				return "", fmt.Errorf("header \"%s\" not found", h)
+			}
+			value = foldFields(vals)
+		}
+		lines = append(lines, h+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// cavageCoverage maps the derived components that a Cavage signature can cover to the pseudo-header or header
+// that covers them
+var cavageCoverage = map[string]string{
+	"@method":         "(request-target)",
+	"@path":           "(request-target)",
+	"@query":          "(request-target)",
+	"@query-param":    "(request-target)",
+	"@request-target": "(request-target)",
+	"@authority":      "host",
+}
+
+// checkFields ensures that the signature covers the required fields
+func (sig cavageSignature) checkFields(required Fields) error {
+	var missing []string
+	for _, f := range required.f {
+		covering := f.name
+		if strings.HasPrefix(f.name, "@") {
+			covering = cavageCoverage[f.name]
+		}
+		if covering == "" || !sig.covers(covering) {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// signedCreated returns the "created" parameter if the signature covers it as "(created)", and otherwise 0,
+// since an uncovered parameter can be changed by anyone
+func (sig cavageSignature) signedCreated() int64 {
+	if !sig.covers("(created)") {
+		return 0
+	}
+	return sig.created
+}
+
+// signedExpires is the same as signedCreated, for the "expires" parameter and "(expires)"
+func (sig cavageSignature) signedExpires() int64 {
+	if !sig.covers("(expires)") {
+		return 0
+	}
+	return sig.expires
+}
+
+// checkTimes applies the freshness and expiration policy, to the covered "created" parameter or else
+// the covered Date header
+func (sig cavageSignature) checkTimes(req *http.Request, config *VerifyConfig) error {
+	now := time.Now()
+	if config.requireCreated && sig.signedCreated() == 0 {
+		return ErrMissingCreated
+	}
+	if config.verifyCreated {
+		created, err := sig.createdTime(req)
+		if err != nil {
+			return err
+		}
+		if created.IsZero() {
+			return fmt.Errorf("signature covers neither \"(created)\" nor \"date\"")
+		}
+		if created.After(now.Add(config.notNewerThan)) {
+			return ErrCreatedInFuture
+		}
+		if created.Add(config.notOlderThan).Before(now) {
+			return ErrSignatureTooOld
+		}
+	}
+	expires := sig.signedExpires()
+	if config.requireExpires && expires == 0 {
+		return ErrMissingExpires
+	}
+	if config.rejectExpired && expires != 0 && now.After(time.Unix(expires, 0)) {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// createdTime returns the covered "created" parameter, or else the covered Date header, or else the zero time
+func (sig cavageSignature) createdTime(req *http.Request) (time.Time, error) {
+	switch {
+	case sig.signedCreated() != 0:
+		return time.Unix(sig.signedCreated(), 0), nil
+	case sig.covers("date"):
+		date, err := http.ParseTime(req.Header.Get("Date"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse Date header: %w", err)
+		}
+		return date, nil
+	}
+	return time.Time{}, nil
+}
+
+// verifyLegacyDigest checks the Digest header of RFC 3230, e.g. "SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=".
+// Digests with unsupported algorithms are ignored, but at least one digest must be supported.
+func verifyLegacyDigest(req *http.Request) error {
+	body, err := readBody(&req.Body, req.ContentLength)
+	if err != nil {
+		return err
+	}
+	checked := false
+	for _, d := range strings.Split(req.Header.Get("Digest"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("malformed \"digest\" header")
+		}
+		want, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return fmt.Errorf("malformed \"digest\" header: %w", err)
+		}
+		var got []byte
+		switch strings.ToUpper(parts[0]) {
+		case "SHA-256":
+			h := sha256.Sum256(body)
+			got = h[:]
+		case "SHA-512":
+			h := sha512.Sum512(body)
+			got = h[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return fmt.Errorf("%w: \"digest\" does not match the message body", ErrDigestMismatch)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no supported algorithm in \"digest\" header")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// From draft-cavage-http-signatures-10, Appendix C
+var cavagePubKey = `-----BEGIN PUBLIC KEY-----
+MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDCFENGw33yGihy92pDjZQhl0C3
+6rPJj+CvfSC8+q28hxA161QFNUd13wuCTUcq0Qd2qsBe/2hFyc2DCJJg0h1L78+6
+Z4UMR7EOcpfdUE9Hf3m/hs+FUR45uBJeDK1HSFHD8bHKD6kv8FPGfJTotc+2xjJw
+oYi+1hqp1fIekaxsyQIDAQAB
+-----END PUBLIC KEY-----
+`
+
+var cavageReq = `POST /foo?param=value&pet=dog HTTP/1.1
+Host: example.com
+Date: Sun, 05 Jan 2014 21:31:40 GMT
+Content-Type: application/json
+Digest: SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=
+Content-Length: 18
+
+{"hello": "world"}
+`
+
+func TestCavageDraftVector(t *testing.T) {
+	req := readRequest(cavageReq)
+	req.Header.Set("Signature", `keyId="Test",algorithm="rsa-sha256",headers="(request-target) host date",signature="qdx+H7PHHDZgy4y/Ahn9Tny9V3GP6YgBPyUXMmoxWtLbHpUnXS2mg2+SbrQDMCJypxBLSPQR2aAjn7ndmw2iicw3HMbe8VfEdKFYRqzic+efkb3nndiv/x1xSHDJWeSWkx3ButlYSuBskLu6kd9Fswtemr3lgdDEmn04swr2Os0="`)
+	verifier, err := NewRSAVerifierFromPEM("Test", []byte(cavagePubKey), NewVerifyConfig().SetVerifyCreated(false), *NewFields())
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyRequestCavage(*verifier, req))
+}
+
+func TestSignAndVerifyCavage(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 64)
+	tests := []struct {
+		name    string
+		headers []string
+		config  *VerifyConfig
+		fields  Fields
+		tamper  func(req *http.Request)
+		want    error
+		wantErr bool
+	}{
+		{"default headers", nil, NewVerifyConfig(), *NewFields(), nil, nil, false},
+		{"created and digest", []string{"(request-target)", "(created)", "(expires)", "digest"}, NewVerifyConfig(),
+			*NewFields(), nil, nil, false},
+		{"required fields", nil, NewVerifyConfig(), *NewFields().AddHeaders("@method", "date"), nil, nil, false},
+		{"missing required field", nil, NewVerifyConfig(), *NewFields().AddHeader("content-type"), nil, ErrMissingField, true},
+		{"authorization header", nil, NewVerifyConfig(), *NewFields(), func(req *http.Request) {
+			req.Header.Set("Authorization", "Signature "+req.Header.Get("Signature"))
+			req.Header.Del("Signature")
+		}, nil, false},
+		{"tampered path", nil, NewVerifyConfig(), *NewFields(), func(req *http.Request) {
+			req.URL.Path = "/bar"
+		}, ErrBadSignature, true},
+		{"tampered body", []string{"(request-target)", "date", "digest"}, NewVerifyConfig(), *NewFields(), func(req *http.Request) {
+			req.Body = http.NoBody
+		}, ErrDigestMismatch, true},
+		{"old date", nil, NewVerifyConfig(), *NewFields(), func(req *http.Request) {
+			req.Header.Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		}, ErrSignatureTooOld, true},
+		{"no freshness", []string{"(request-target)"}, NewVerifyConfig(), *NewFields(), nil, nil, true},
+		{"no freshness allowed", []string{"(request-target)"}, NewVerifyConfig().SetVerifyCreated(false), *NewFields(),
+			nil, nil, false},
+		{"authority covered by host", nil, NewVerifyConfig(), *NewFields().AddHeaders("@authority", "@path"), nil, nil, false},
+		{"authority not covered", []string{"(request-target)", "date"}, NewVerifyConfig(), *NewFields().AddHeader("@authority"),
+			nil, ErrMissingField, true},
+		{"scheme cannot be covered", nil, NewVerifyConfig(), *NewFields().AddHeader("@scheme"), nil, ErrMissingField, true},
+		{"target URI cannot be covered", nil, NewVerifyConfig(), *NewFields().AddHeader("@target-uri"), nil, ErrMissingField, true},
+		{"minimum coverage", []string{"(request-target)", "date"}, NewVerifyConfig().SetMinimumCoverage(Headers("@authority")),
+			*NewFields(), nil, ErrMissingField, true},
+		{"allowed algorithm", nil, NewVerifyConfig().SetAllowedAlgs([]string{"hmac-sha256"}), *NewFields(), nil, nil, false},
+		{"disallowed algorithm", nil, NewVerifyConfig().SetAllowedAlgs([]string{"ed25519"}), *NewFields(), nil, ErrAlgNotAllowed, true},
+		{"expected tag", nil, NewVerifyConfig().SetExpectedTag("app"), *NewFields(), nil, nil, true},
+		{"nonce required", nil, NewVerifyConfig().SetRequireNonce(true), *NewFields(), nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SetExpiresIn(time.Minute), *NewFields())
+			req := readRequest(httpreq1)
+			req.Header.Del("Date")
+			sig, err := SignRequestCavage(*signer, tt.headers, req)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(sig, `keyId="key1",algorithm="hmac-sha256",`), sig)
+			req.Header.Set("Signature", sig)
+			if tt.tamper != nil {
+				tt.tamper(req)
+			}
+			keyID, alg, err := CavageRequestDetails(req)
+			assert.NoError(t, err)
+			assert.Equal(t, "key1", keyID)
+			assert.Equal(t, "hmac-sha256", alg)
+			verifier, _ := NewHMACSHA256Verifier("key1", key, tt.config, tt.fields)
+			err = VerifyRequestCavage(*verifier, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				if tt.want != nil {
+					assert.ErrorIs(t, err, tt.want)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCavageUncoveredCreated(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 64)
+	signer, _ := NewHMACSHA256Signer("key1", key, nil, *NewFields())
+	verifier, _ := NewHMACSHA256Verifier("key1", key, nil, *NewFields())
+	req := readRequest(httpreq1)
+	req.Header.Set("Date", time.Now().Add(-48*time.Hour).UTC().Format(http.TimeFormat))
+	sig, err := SignRequestCavage(*signer, []string{"date"}, req)
+	assert.NoError(t, err)
+	req.Header.Set("Signature", sig)
+	assert.ErrorIs(t, VerifyRequestCavage(*verifier, req), ErrSignatureTooOld)
+
+	// the parameters are not signed, so they must not override the covered Date header
+	req.Header.Set("Signature", fmt.Sprintf("%s,created=%d", sig, time.Now().Unix()))
+	assert.ErrorIs(t, VerifyRequestCavage(*verifier, req), ErrSignatureTooOld)
+	req.Header.Set("Signature", fmt.Sprintf("%s,expires=%d", sig, time.Now().Add(-time.Hour).Unix()))
+	verifier, _ = NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false).SetRequireExpires(true), *NewFields())
+	assert.ErrorIs(t, VerifyRequestCavage(*verifier, req), ErrMissingExpires)
+}
+
+func TestCavageReplay(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 64)
+	signer, _ := NewHMACSHA256Signer("key1", key, nil, *NewFields())
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetReplayCache(NewMemoryReplayCache(time.Minute, 100)), *NewFields())
+	req := readRequest(httpreq1)
+	req.Header.Del("Date")
+	sig, err := SignRequestCavage(*signer, nil, req)
+	assert.NoError(t, err)
+	req.Header.Set("Signature", sig)
+	assert.NoError(t, VerifyRequestCavage(*verifier, req))
+	var replay *ReplayError
+	assert.ErrorAs(t, VerifyRequestCavage(*verifier, req), &replay)
+}
+
+func TestCavageUnsupportedAlgorithm(t *testing.T) {
+	priv, _, err := genP256KeyPair()
+	assert.NoError(t, err)
+	signer, _ := NewP256Signer("key1", *priv, nil, *NewFields())
+	_, err = SignRequestCavage(*signer, nil, readRequest(httpreq1))
+	assert.Error(t, err)
+}
@@ -1,88 +1,385 @@
 package httpsign
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Client represents an HTTP client that optionally signs requests and optionally verifies responses.
-// The Signer may be nil to avoid signing. Similarly, if both Verifier and fetchVerifier are nil, no verification takes place.
+// The signer is typically a Signer, but may be any MessageSigner, and similarly for the verifier.
+// The signer may be nil to avoid signing. Similarly, if both Verifier and fetchVerifier are nil, no verification takes place.
 // The fetchVerifier callback allows to generate a Verifier based on the particular response.
 // Either Verifier or fetchVerifier may be specified, but not both.
 // The client embeds an http.Client, which in most cases can be http.DefaultClient.
 type Client struct {
 	signatureName string
-	signer        *Signer
-	verifier      *Verifier
+	signer        MessageSigner
+	verifier      MessageVerifier
 	fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier)
 	client        http.Client
+	acceptSig     bool
+	verifyPolicy  ResponseVerificationPolicy
+	signTrailers  bool
+	retry         RetryPolicy
+	maxBodySize   int64
 }
 
+// RetryPolicy determines whether and how the client retries a request. Each attempt is signed anew,
+// with a fresh "created" timestamp and, if the Signer's SignConfig has a nonce generator, a fresh nonce,
+// since a replayed signature may already have expired or be rejected as a replay.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// Backoff is the delay between attempts.
+	Backoff time.Duration
+	// ShouldRetry determines whether to retry, given the result of sending the request: err is a transport
+	// error, and the response has not been verified yet. Signing and response verification errors are never
+	// retried. If nil, DefaultShouldRetry is used.
+	ShouldRetry func(res *http.Response, err error) bool
+}
+
+// DefaultShouldRetry retries on transport errors, and on the 429, 502, 503 and 504 status codes.
+func DefaultShouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ResponseVerificationPolicy determines how the client handles responses, when it has a Verifier or fetchVerifier.
+type ResponseVerificationPolicy int
+
+const (
+	// ResponseVerifyRequired fails if the response is not signed, or its signature does not verify.
+	ResponseVerifyRequired ResponseVerificationPolicy = iota
+	// ResponseVerifyIfPresent verifies the response if it carries a signature, and accepts an unsigned response.
+	// A signature that does not verify still fails.
+	ResponseVerifyIfPresent
+	// ResponseVerifySkip never verifies responses.
+	ResponseVerifySkip
+)
+
 // NewClient constructs a new client, with the flexibility of including a custom http.Client.
-func NewClient(sigName string, signer *Signer, verifier *Verifier, fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier), client http.Client) *Client {
+func NewClient(sigName string, signer MessageSigner, verifier MessageVerifier, fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier), client http.Client) *Client {
+	if isNilSigner(signer) {
+		signer = nil
+	}
+	if isNilVerifier(verifier) {
+		verifier = nil
+	}
 	return &Client{signatureName: sigName, signer: signer, verifier: verifier, fetchVerifier: fetchVerifier, client: client}
 }
 
 // NewDefaultClient constructs a new client, based on the http.DefaultClient.
-func NewDefaultClient(sigName string, signer *Signer, verifier *Verifier, fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier)) *Client {
+func NewDefaultClient(sigName string, signer MessageSigner, verifier MessageVerifier, fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier)) *Client {
 	return NewClient(sigName, signer, verifier, fetchVerifier, *http.DefaultClient)
 }
 
+// ClientOption configures a Client constructed by NewClientWithOptions.
+type ClientOption func(c *Client)
+
+// WithSignatureName sets the name (label) of the request signature, and of the expected response signature.
+func WithSignatureName(sigName string) ClientOption {
+	return func(c *Client) { c.signatureName = sigName }
+}
+
+// WithSigner sets the signer. Without it, requests are not signed.
+func WithSigner(signer MessageSigner) ClientOption {
+	return func(c *Client) {
+		if !isNilSigner(signer) {
+			c.signer = signer
+		}
+	}
+}
+
+// WithVerifier sets the response verifier. Cannot be combined with WithFetchVerifier.
+func WithVerifier(verifier MessageVerifier) ClientOption {
+	return func(c *Client) {
+		if !isNilVerifier(verifier) {
+			c.verifier = verifier
+		}
+	}
+}
+
+// WithFetchVerifier sets a callback that returns the verifier for a particular response. Cannot be combined with WithVerifier.
+func WithFetchVerifier(fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier)) ClientOption {
+	return func(c *Client) { c.fetchVerifier = fetchVerifier }
+}
+
+// WithHTTPClient sets the underlying http.Client. Default: http.DefaultClient.
+func WithHTTPClient(client http.Client) ClientOption {
+	return func(c *Client) { c.client = client }
+}
+
+// NewClientWithOptions constructs a new client from options, as an alternative to NewClient. By default,
+// the client neither signs nor verifies, and is based on the http.DefaultClient.
+func NewClientWithOptions(opts ...ClientOption) *Client {
+	c := &Client{client: *http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetAcceptSignature makes the client send an Accept-Signature header with each request, unless the request
+// already has one, describing the response signature it expects: the client's signature name, and the Verifier's
+// required fields, key ID and algorithm (see AcceptSignature). This requires the verifier to be a Verifier.
+// Default: false.
+func (c *Client) SetAcceptSignature(b bool) *Client {
+	c.acceptSig = b
+	return c
+}
+
+type signConfigKey struct{}
+
+// WithSignConfig returns a copy of ctx that carries a SignConfig. When a Client or a Transport signs a request
+// whose context carries a SignConfig, that configuration replaces the one of the client's Signer, for this
+// request only. For example, some requests can be signed with an expiration time and a nonce, and others without.
+// This requires the client's signer to be a Signer.
+func WithSignConfig(ctx context.Context, config *SignConfig) context.Context {
+	return context.WithValue(ctx, signConfigKey{}, config)
+}
+
+// requestSigner returns the client's signer, with its configuration overridden by the request's context, if set
+func (c *Client) requestSigner(req *http.Request) (MessageSigner, error) {
+	config, ok := req.Context().Value(signConfigKey{}).(*SignConfig)
+	if !ok || config == nil {
+		return c.signer, nil
+	}
+	s := asSigner(c.signer)
+	if s == nil {
+		return nil, fmt.Errorf("a per-request SignConfig requires a Signer")
+	}
+	override := *s
+	override.config = config.Clone()
+	return &override, nil
+}
+
+// SetSignInTrailers signs requests that have a body in trailers, so that the body is streamed rather than
+// buffered, see SignRequestInTrailers. Requests without a body are signed in headers. Default: false.
+func (c *Client) SetSignInTrailers(b bool) *Client {
+	c.signTrailers = b
+	return c
+}
+
+// SetRetryPolicy makes the client retry failed requests, re-signing each attempt. A request with a body
+// can only be retried if it has a GetBody function, as set by http.NewRequest for common body types.
+// The original request is not modified. Whether requests with non-idempotent methods such as POST are
+// retried is up to the caller, through RetryPolicy.ShouldRetry: a request that failed in transit, or was
+// answered with a retryable status, may still have been processed by the server. Default: no retries.
+func (c *Client) SetRetryPolicy(p RetryPolicy) *Client {
+	c.retry = p
+	return c
+}
+
+// SetMaxBodySize limits the size of request and response bodies that the client reads into memory, to compute
+// or verify a digest. A larger body fails signing or verification with a BodyTooLargeError. Bodies that are
+// not buffered are not limited. Default: 0 (no limit).
+func (c *Client) SetMaxBodySize(n int64) *Client {
+	c.maxBodySize = n
+	return c
+}
+
+// limitBody makes reading the body fail once it exceeds the client's limit. The returned function
+// restores the original body, unless it has been read and replaced by a buffered copy.
+func (c *Client) limitBody(body *io.ReadCloser) (restore func()) {
+	if c.maxBodySize <= 0 || *body == nil || *body == http.NoBody {
+		return func() {}
+	}
+	orig := *body
+	limited := &limitedBody{ReadCloser: orig, remaining: c.maxBodySize, limit: c.maxBodySize}
+	*body = limited
+	return func() {
+		if *body == io.ReadCloser(limited) {
+			*body = orig
+		}
+	}
+}
+
+// limitedBody fails with a BodyTooLargeError after more than limit bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		return 0, &BodyTooLargeError{Limit: b.limit}
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// SetVerificationPolicy determines whether responses must be signed. Default: ResponseVerifyRequired.
+func (c *Client) SetVerificationPolicy(p ResponseVerificationPolicy) *Client {
+	c.verifyPolicy = p
+	return c
+}
+
+// shouldVerify determines whether the response is to be verified, according to the client's policy
+func (c *Client) shouldVerify(res *http.Response) bool {
+	switch c.verifyPolicy {
+	case ResponseVerifySkip:
+		return false
+	case ResponseVerifyIfPresent:
+		_, inTrailers := res.Trailer["Signature-Input"]
+		return res.Header.Get("Signature-Input") != "" || inTrailers
+	}
+	return true
+}
+
 func validateClient(c *Client) error {
 	if c == nil {
 		return fmt.Errorf("nil client")
 	}
-	if c.verifier != nil && c.fetchVerifier != nil {
+	if !isNilVerifier(c.verifier) && c.fetchVerifier != nil {
 		return fmt.Errorf("at most one of \"verifier\" and \"fetchVerifier\" must be set")
 	}
 	return nil
 }
 
 // Do sends an http.Request, with optional signing and/or verification. Errors may be produced by any of
-// these operations.
+// these operations. The request may use any method, including PATCH or a custom one, and may carry
+// any headers and body; the convenience methods below are all wrappers for Do.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	if err := validateClient(c); err != nil {
-		return nil, err
+	if c != nil && c.retry.MaxAttempts > 1 {
+		return c.doWithRetries(req)
 	}
-	if c.signer != nil {
-		sigInput, sig, err := SignRequest(c.signatureName, *c.signer, req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to sign request: %v", err)
+	return c.roundTrip(req, c.client.Do)
+}
+
+// doWithRetries signs and sends a copy of the request for each attempt
+func (c *Client) doWithRetries(req *http.Request) (*http.Response, error) {
+	shouldRetry := c.retry.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = DefaultShouldRetry
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	for attempt := 1; ; attempt++ {
+		r := req.Clone(req.Context())
+		if attempt > 1 && hasBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("cannot retry request: %w", err)
+			}
+			r.Body = body
+		}
+		if err := c.signRequest(r); err != nil {
+			return nil, err
+		}
+		res, err := c.client.Do(r)
+		if attempt >= c.retry.MaxAttempts || (hasBody && req.GetBody == nil) || !shouldRetry(res, err) {
+			if err != nil {
+				return res, err
+			}
+			return c.checkResponse(res, r)
+		}
+		if res != nil {
+			_ = res.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(c.retry.Backoff):
 		}
-		req.Header.Add("Signature", sig)
-		req.Header.Add("Signature-Input", sigInput)
 	}
+}
 
-	// Send the request, receive response
-	res, err := c.client.Do(req)
+// roundTrip signs the request, sends it with send and verifies the response
+func (c *Client) roundTrip(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if err := c.signRequest(req); err != nil {
+		return nil, err
+	}
+	res, err := send(req)
 	if err != nil {
 		return res, err
 	}
+	return c.checkResponse(res, req)
+}
 
-	if c.verifier != nil {
-		err := VerifyResponse(c.signatureName, *c.verifier, res)
+// signRequest adds the Accept-Signature header and the signature to the request, as configured
+func (c *Client) signRequest(req *http.Request) error {
+	if err := validateClient(c); err != nil {
+		return err
+	}
+	if c.acceptSig && req.Header.Get("Accept-Signature") == "" {
+		v := asVerifier(c.verifier)
+		if v == nil {
+			return fmt.Errorf("Accept-Signature requires a Verifier")
+		}
+		accept, err := AcceptSignature(c.signatureName, *v)
 		if err != nil {
-			return nil, err
+			return err
 		}
-	} else if c.fetchVerifier != nil {
-		sigName, verifier := c.fetchVerifier(res, req)
+		req.Header.Set("Accept-Signature", accept)
+	}
+	if !isNilSigner(c.signer) {
+		signer, err := c.requestSigner(req)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if verifier == nil {
-			return nil, fmt.Errorf("fetchVerifier returned a nil verifier")
+		if c.signTrailers && req.Body != nil && req.Body != http.NoBody {
+			if err = SignRequestInTrailers(c.signatureName, signer, req); err != nil {
+				return fmt.Errorf("failed to sign request: %w", err)
+			}
+		} else {
+			restore := c.limitBody(&req.Body)
+			sigInput, sig, err := SignRequest(c.signatureName, signer, req)
+			restore()
+			if err != nil {
+				return fmt.Errorf("failed to sign request: %w", err)
+			}
+			if err = AddSignature(req.Header, sigInput, sig); err != nil {
+				return fmt.Errorf("failed to sign request: %w", err)
+			}
 		}
-		err := VerifyResponse(sigName, *verifier, res)
-		if err != nil {
-			return nil, err
+	}
+	return nil
+}
+
+// checkResponse verifies the response as configured, and closes it if it fails
+func (c *Client) checkResponse(res *http.Response, req *http.Request) (*http.Response, error) {
+	if !c.shouldVerify(res) {
+		return res, nil
+	}
+	defer c.limitBody(&res.Body)()
+	if err := c.verifyResponse(res, req); err != nil {
+		if res.Body != nil {
+			_ = res.Body.Close() // the response is not returned, so the caller cannot close it
 		}
+		return nil, err
 	}
 	return res, nil
 }
 
+func (c *Client) verifyResponse(res *http.Response, req *http.Request) error {
+	if !isNilVerifier(c.verifier) {
+		return VerifyResponse(c.signatureName, c.verifier, res)
+	}
+	if c.fetchVerifier != nil {
+		sigName, verifier := c.fetchVerifier(res, req)
+		if verifier == nil {
+			return fmt.Errorf("fetchVerifier returned a nil verifier")
+		}
+		return VerifyResponse(sigName, *verifier, res)
+	}
+	return nil
+}
+
 // Get sends an HTTP GET, a wrapper for Do.
 func (c *Client) Get(url string) (res *http.Response, err error) {
 	req, err := http.NewRequest("GET", url, nil)
@@ -103,15 +400,38 @@ func (c *Client) Head(url string) (res *http.Response, err error) {
 
 // Post sends an HTTP POST, a wrapper for Do.
 func (c *Client) Post(url, contentType string, body io.Reader) (res *http.Response, err error) {
-	req, err := http.NewRequest("POST", url, body)
+	return c.send("POST", url, contentType, body)
+}
+
+// PostForm sends an HTTP POST, with data keys and values URL-encoded as the request body.
+func (c *Client) PostForm(url string, data url.Values) (resp *http.Response, err error) {
+	return c.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// Put sends an HTTP PUT, a wrapper for Do.
+func (c *Client) Put(url, contentType string, body io.Reader) (res *http.Response, err error) {
+	return c.send("PUT", url, contentType, body)
+}
+
+// Patch sends an HTTP PATCH, a wrapper for Do.
+func (c *Client) Patch(url, contentType string, body io.Reader) (res *http.Response, err error) {
+	return c.send("PATCH", url, contentType, body)
+}
+
+// Delete sends an HTTP DELETE, a wrapper for Do.
+func (c *Client) Delete(url string) (res *http.Response, err error) {
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", contentType)
 	return c.Do(req)
 }
 
-// PostForm sends an HTTP POST, with data keys and values URL-encoded as the request body.
-func (c *Client) PostForm(url string, data url.Values) (resp *http.Response, err error) {
-	return c.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+func (c *Client) send(method, url, contentType string, body io.Reader) (res *http.Response, err error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
 }
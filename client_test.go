@@ -2,12 +2,17 @@ package httpsign
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestClient_Get(t *testing.T) {
@@ -236,3 +241,343 @@ func TestClient_Head(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Do(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false),
+			Headers("@method", "content-type", "x-custom"))
+		return "sig1", verifier
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Method)
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchVerifier(fetchVerifier)))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@method", "content-type", "x-custom"))
+	c := NewDefaultClient("sig1", signer, nil, nil)
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+	}{
+		{"PATCH", "PATCH", http.StatusOK},
+		{"custom method", "PURGE", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL, bytes.NewReader([]byte(`{"a": 1}`)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Custom", "value")
+			res, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer func() { _ = res.Body.Close() }()
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("Do() status = %v, want %v", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClient_Verbs(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+		if r.Header.Get("Signature-Input") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), NewSignConfig(), Headers("@method"))
+	c := NewDefaultClient("sig1", signer, nil, nil)
+	tests := []struct {
+		name            string
+		send            func() (*http.Response, error)
+		wantMethod      string
+		wantContentType string
+	}{
+		{"PUT", func() (*http.Response, error) { return c.Put(ts.URL, "text/plain", bytes.NewReader([]byte("x"))) }, "PUT", "text/plain"},
+		{"PATCH", func() (*http.Response, error) {
+			return c.Patch(ts.URL, "application/merge-patch+json", bytes.NewReader([]byte("{}")))
+		}, "PATCH", "application/merge-patch+json"},
+		{"DELETE", func() (*http.Response, error) { return c.Delete(ts.URL) }, "DELETE", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.send()
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			_ = res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("status = %v, request was not signed", res.StatusCode)
+			}
+			if got := res.Header.Get("X-Method"); got != tt.wantMethod {
+				t.Errorf("method = %v, want %v", got, tt.wantMethod)
+			}
+			if got := res.Header.Get("X-Content-Type"); got != tt.wantContentType {
+				t.Errorf("content type = %v, want %v", got, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestClient_WithSignConfig(t *testing.T) {
+	var sigInput string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		sigInput = r.Header.Get("Signature-Input")
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), NewSignConfig().SignCreated(false), Headers("@method"))
+	c := NewDefaultClient("sig1", signer, nil, nil)
+	tests := []struct {
+		name   string
+		config *SignConfig
+		want   string
+	}{
+		{"client config", nil, `sig1=("@method");alg="hmac-sha256";keyid="key1"`},
+		{"override", NewSignConfig().SignCreated(false).SetExpires(999).SetNonce("abc"),
+			`sig1=("@method");expires=999;nonce="abc";alg="hmac-sha256";keyid="key1"`},
+		{"back to client config", nil, `sig1=("@method");alg="hmac-sha256";keyid="key1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.config != nil {
+				ctx = WithSignConfig(ctx, tt.config)
+			}
+			req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+			res, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			_ = res.Body.Close()
+			if sigInput != tt.want {
+				t.Errorf("Signature-Input = %v, want %v", sigInput, tt.want)
+			}
+		})
+	}
+
+	remote := NewDefaultClient("sig1", &countingSigner{Signer: *signer}, nil, nil)
+	req, _ := http.NewRequestWithContext(WithSignConfig(context.Background(), NewSignConfig()), "GET", ts.URL, nil)
+	if _, err := remote.Do(req); err == nil {
+		t.Errorf("Do() should fail for a MessageSigner that is not a Signer")
+	}
+}
+
+func TestClient_VerificationPolicy(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@status"))
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello")
+	}
+	signed := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchSigner(fetchSigner)))
+	defer signed.Close()
+	unsigned := httptest.NewServer(http.HandlerFunc(handler))
+	defer unsigned.Close()
+
+	verifier, _ := NewHMACSHA256Verifier("key1", key, nil, Headers("@status"))
+	wrongVerifier, _ := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{2}, 64), nil, Headers("@status"))
+	tests := []struct {
+		name     string
+		policy   ResponseVerificationPolicy
+		verifier *Verifier
+		url      string
+		wantErr  bool
+	}{
+		{"required, signed", ResponseVerifyRequired, verifier, signed.URL, false},
+		{"required, unsigned", ResponseVerifyRequired, verifier, unsigned.URL, true},
+		{"required, bad signature", ResponseVerifyRequired, wrongVerifier, signed.URL, true},
+		{"if present, signed", ResponseVerifyIfPresent, verifier, signed.URL, false},
+		{"if present, unsigned", ResponseVerifyIfPresent, verifier, unsigned.URL, false},
+		{"if present, bad signature", ResponseVerifyIfPresent, wrongVerifier, signed.URL, true},
+		{"skip, unsigned", ResponseVerifySkip, verifier, unsigned.URL, false},
+		{"skip, bad signature", ResponseVerifySkip, wrongVerifier, signed.URL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultClient("sig1", nil, tt.verifier, nil).SetVerificationPolicy(tt.policy)
+			res, err := c.Get(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != nil {
+				_ = res.Body.Close()
+			}
+		})
+	}
+}
+
+func TestClient_RetryPolicy(t *testing.T) {
+	var inputs, bodies []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		inputs = append(inputs, r.Header.Get("Signature-Input"))
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(inputs) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	n := 0
+	nonces := func() (string, error) {
+		n++
+		return fmt.Sprintf("nonce-%d", n), nil
+	}
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64),
+		NewSignConfig().SignCreated(false).SetNonceGenerator(nonces), Headers("@method"))
+	c := NewDefaultClient("sig1", signer, nil, nil).SetRetryPolicy(RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond})
+	req, _ := http.NewRequest("PUT", ts.URL, strings.NewReader("payload"))
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %v, want 200", res.StatusCode)
+	}
+	wantInputs := []string{
+		`sig1=("@method");nonce="nonce-1";alg="hmac-sha256";keyid="key1"`,
+		`sig1=("@method");nonce="nonce-2";alg="hmac-sha256";keyid="key1"`,
+		`sig1=("@method");nonce="nonce-3";alg="hmac-sha256";keyid="key1"`,
+	}
+	if !reflect.DeepEqual(inputs, wantInputs) {
+		t.Errorf("Signature-Input = %v, want %v", inputs, wantInputs)
+	}
+	if !reflect.DeepEqual(bodies, []string{"payload", "payload", "payload"}) {
+		t.Errorf("bodies = %v", bodies)
+	}
+	if req.Header.Get("Signature") != "" {
+		t.Errorf("the original request should not be modified")
+	}
+
+	inputs = nil
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	res, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || len(inputs) != 2 {
+		t.Errorf("status = %v after %d attempts, want 503 after 2", res.StatusCode, len(inputs))
+	}
+}
+
+func TestClient_RetryPolicyErrors(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++ // the response is not signed
+	}))
+	defer ts.Close()
+	key := bytes.Repeat([]byte{1}, 64)
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	verifier, _ := NewHMACSHA256Verifier("key1", key, nil, Headers("@status"))
+	c := NewDefaultClient("sig1", nil, verifier, nil).SetRetryPolicy(policy)
+	_, err := c.Post(ts.URL, "text/plain", strings.NewReader("payload"))
+	if err == nil || attempts != 1 {
+		t.Errorf("error = %v after %d attempts, want a verification error after 1", err, attempts)
+	}
+
+	attempts = 0
+	signer, _ := NewHMACSHA256Signer("key1", key, nil, Headers("x-missing"))
+	c = NewDefaultClient("sig1", signer, nil, nil).SetRetryPolicy(policy)
+	_, err = c.Get(ts.URL)
+	if err == nil || attempts != 0 {
+		t.Errorf("error = %v after %d attempts, want a signing error before sending", err, attempts)
+	}
+}
+func TestNewClientWithOptions(t *testing.T) {
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), NewSignConfig(), Headers("@method"))
+	verifier, _ := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{1}, 64), NewVerifyConfig(), Headers("@status"))
+	fetchVerifier := func(res *http.Response, req *http.Request) (string, *Verifier) { return "sig1", verifier }
+	httpClient := http.Client{Timeout: time.Second}
+
+	c := NewClientWithOptions(WithSignatureName("sig1"), WithSigner(signer), WithVerifier(verifier), WithHTTPClient(httpClient))
+	want := NewClient("sig1", signer, verifier, nil, httpClient)
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("NewClientWithOptions() = %v, want %v", c, want)
+	}
+
+	var nilSigner *Signer
+	c = NewClientWithOptions(WithSigner(nilSigner))
+	if c.signer != nil || c.client.Timeout != 0 {
+		t.Errorf("NewClientWithOptions() should ignore a nil signer and use the default client")
+	}
+
+	c = NewClientWithOptions(WithVerifier(verifier), WithFetchVerifier(fetchVerifier))
+	if _, err := c.Get("http://localhost"); err == nil {
+		t.Errorf("Get() should fail with both a verifier and fetchVerifier")
+	}
+}
+
+func TestClient_MaxBodySize(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@status", "content-digest"))
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		body := bytes.Repeat([]byte("a"), 100)
+		d, _ := digestBytes(DigestSHA256, body)
+		digest, _ := digestValue(DigestSHA256, d)
+		w.Header().Set("Content-Digest", digest)
+		_, _ = w.Write(body)
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchSigner(fetchSigner)))
+	defer ts.Close()
+
+	digestSigner, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@method", "content-digest"))
+	plainSigner, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@method"))
+	digestVerifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig(), Headers("@status", "content-digest"))
+	tests := []struct {
+		name     string
+		signer   *Signer
+		verifier *Verifier
+		reqSize  int
+		limit    int64
+		wantErr  bool
+	}{
+		{"within limits", digestSigner, digestVerifier, 100, 100, false},
+		{"request too large", digestSigner, nil, 101, 100, true},
+		{"request not buffered", plainSigner, nil, 101, 100, false},
+		{"response too large", plainSigner, digestVerifier, 10, 99, true},
+		{"no limit", digestSigner, digestVerifier, 1000, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultClient("sig1", tt.signer, tt.verifier, nil).SetMaxBodySize(tt.limit)
+			res, err := c.Post(ts.URL, "text/plain", bytes.NewReader(bytes.Repeat([]byte("b"), tt.reqSize)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var tooLarge *BodyTooLargeError
+				if !errors.As(err, &tooLarge) || tooLarge.Limit != tt.limit {
+					t.Errorf("Post() error = %v, want a BodyTooLargeError", err)
+				}
+				return
+			}
+			b, _ := io.ReadAll(res.Body)
+			if len(b) != 100 {
+				t.Errorf("response body has %d bytes, want 100", len(b))
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Command httpsign signs and verifies HTTP messages from the command line, and prints their signature base,
+// to help debug interoperability with other implementations. Messages are read in HTTP/1.1 wire format
+// from a file, or from the standard input if no file is given.
+//
+// Usage:
+//
+//	httpsign sign -alg ed25519 -key private.pem -keyid my-key -fields @method,@path,content-type [file]
+//	httpsign verify -alg ed25519 -key public.pem -keyid my-key [file]
+//	httpsign base [file]
+//
+// The sign command prints the Signature-Input and Signature headers to add to the message. The verify command
+// exits with status 1 if the signature does not verify. The base command prints the signature base of an
+// existing signature without verifying it. With -v, sign and verify also print the signature base to
+// the standard error.
+//
+// Keys are PEM-encoded, except for hmac-sha256, whose key file contains the base64-encoded secret.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/yaronf/httpsign"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage: httpsign sign|verify|base [flags] [file]
+Run "httpsign <command> -h" for the flags of a command.
+`
+
+// Exit codes
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+type options struct {
+	alg, keyFile, keyID, label, fields, scheme string
+	response, verbose                          bool
+	maxAge                                     time.Duration
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	command := args[0]
+	fs := flag.NewFlagSet("httpsign "+command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var o options
+	fs.StringVar(&o.label, "label", "sig1", "signature label")
+	fs.BoolVar(&o.response, "response", false, "the message is a response")
+	fs.StringVar(&o.scheme, "scheme", "", "scheme of the request, e.g. https (default: http)")
+	if command == "sign" || command == "verify" {
+		fs.StringVar(&o.alg, "alg", "", "algorithm: hmac-sha256, rsa-v1_5-sha256, rsa-pss-sha512, "+
+			"ecdsa-p256-sha256, ecdsa-p384-sha384 or ed25519")
+		fs.StringVar(&o.keyFile, "key", "", "key file, private key to sign or public key to verify")
+		fs.StringVar(&o.keyID, "keyid", "", "key ID")
+		fs.BoolVar(&o.verbose, "v", false, "print the signature base to the standard error")
+	}
+	switch command {
+	case "sign":
+		fs.StringVar(&o.fields, "fields", "@method,@path,@authority",
+			"comma-separated components to sign, e.g. @method,content-type,@query-param;name=id")
+	case "verify":
+		fs.StringVar(&o.fields, "fields", "", "comma-separated components that the signature must cover")
+		fs.DurationVar(&o.maxAge, "max-age", 0, "maximum age of the signature (default: the age is not checked)")
+	case "base":
+	default:
+		_, _ = fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	in := stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			_, _ = fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+	var err error
+	switch command {
+	case "sign":
+		err = sign(o, in, stdout, stderr)
+	case "verify":
+		err = verify(o, in, stdout, stderr)
+	case "base":
+		err = base(o, in, stdout)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// message is a request or a response read from the input
+type message struct {
+	req *http.Request
+	res *http.Response
+}
+
+func readMessage(o options, in io.Reader) (message, error) {
+	r := bufio.NewReader(in)
+	if o.response {
+		res, err := http.ReadResponse(r, nil)
+		if err != nil {
+			return message{}, fmt.Errorf("cannot read response: %w", err)
+		}
+		return message{res: res}, nil
+	}
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return message{}, fmt.Errorf("cannot read request: %w", err)
+	}
+	return message{req: req}, nil
+}
+
+func sign(o options, in io.Reader, stdout, stderr io.Writer) error {
+	msg, err := readMessage(o, in)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFields(o.fields)
+	if err != nil {
+		return err
+	}
+	config := httpsign.NewSignConfig().SetScheme(o.scheme)
+	if o.verbose {
+		config.SetSignatureBaseHook(printBase(stderr))
+	}
+	key, err := os.ReadFile(o.keyFile)
+	if err != nil {
+		return err
+	}
+	signer, err := newSigner(o.alg, o.keyID, key, config, fields)
+	if err != nil {
+		return err
+	}
+	var signatureInput, signature string
+	if msg.res != nil {
+		signatureInput, signature, err = httpsign.SignResponse(o.label, *signer, msg.res)
+	} else {
+		signatureInput, signature, err = httpsign.SignRequest(o.label, *signer, msg.req)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "Signature-Input: %s\nSignature: %s\n", signatureInput, signature)
+	return err
+}
+
+func verify(o options, in io.Reader, stdout, stderr io.Writer) error {
+	msg, err := readMessage(o, in)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFields(o.fields)
+	if err != nil {
+		return err
+	}
+	config := httpsign.NewVerifyConfig().SetScheme(o.scheme).SetVerifyCreated(o.maxAge != 0).SetNotOlderThan(o.maxAge)
+	if o.verbose {
+		config.SetSignatureBaseHook(printBase(stderr))
+	}
+	key, err := os.ReadFile(o.keyFile)
+	if err != nil {
+		return err
+	}
+	verifier, err := newVerifier(o.alg, o.keyID, key, config, fields)
+	if err != nil {
+		return err
+	}
+	if msg.res != nil {
+		err = httpsign.VerifyResponse(o.label, *verifier, msg.res)
+	} else {
+		err = httpsign.VerifyRequest(o.label, *verifier, msg.req)
+	}
+	if err != nil {
+		return fmt.Errorf("signature \"%s\" does not verify: %w", o.label, err)
+	}
+	_, err = fmt.Fprintf(stdout, "signature \"%s\" verified\n", o.label)
+	return err
+}
+
+// base prints the signature base by verifying the signature with a throwaway key, and relaxing the
+// verification policy so that the base is generated for any well-formed signature
+func base(o options, in io.Reader, stdout io.Writer) error {
+	msg, err := readMessage(o, in)
+	if err != nil {
+		return err
+	}
+	var signatureBase string
+	found := false
+	config := httpsign.NewVerifyConfig().SetScheme(o.scheme).SetVerifyCreated(false).SetRejectExpired(false).
+		SetVerifyKeyID(false).SetVerifyDigest(false).
+		SetSignatureBaseHook(func(_, b string) { signatureBase, found = b, true })
+	verifier, err := httpsign.NewHMACSHA256Verifier("", bytes.Repeat([]byte{0}, 64), config, *httpsign.NewFields())
+	if err != nil {
+		return err
+	}
+	if msg.res != nil {
+		err = httpsign.VerifyResponse(o.label, *verifier, msg.res)
+	} else {
+		err = httpsign.VerifyRequest(o.label, *verifier, msg.req)
+	}
+	if !found {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, signatureBase)
+	return err
+}
+
+func printBase(w io.Writer) httpsign.SignatureBaseHook {
+	return func(label, signatureBase string) {
+		_, _ = fmt.Fprintf(w, "Signature base of \"%s\":\n%s\n", label, signatureBase)
+	}
+}
+
+// parseFields parses a comma-separated list of components. Query parameters are written as
+// @query-param;name=<name>, and dictionary members as <header>;key=<key>.
+func parseFields(s string) (httpsign.Fields, error) {
+	fields := httpsign.NewFields()
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		name, param, hasParam := cut(c, ";")
+		switch {
+		case !hasParam:
+			fields.AddHeader(name)
+		case name == "@query-param" && strings.HasPrefix(param, "name="):
+			fields.AddQueryParam(strings.TrimPrefix(param, "name="))
+		case strings.HasPrefix(param, "key="):
+			fields.AddDictHeader(name, strings.TrimPrefix(param, "key="))
+		default:
+			return httpsign.Fields{}, fmt.Errorf("unsupported component \"%s\"", c)
+		}
+	}
+	return *fields, nil
+}
+
+// cut is strings.Cut, which requires Go 1.18
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+func newSigner(alg, keyID string, key []byte, config *httpsign.SignConfig, fields httpsign.Fields) (*httpsign.Signer, error) {
+	switch alg {
+	case "hmac-sha256":
+		secret, err := decodeSecret(key)
+		if err != nil {
+			return nil, err
+		}
+		return httpsign.NewHMACSHA256Signer(keyID, secret, config, fields)
+	case "rsa-v1_5-sha256":
+		return httpsign.NewRSASignerFromPEM(keyID, key, config, fields)
+	case "rsa-pss-sha512":
+		return httpsign.NewRSAPSSSignerFromPEM(keyID, key, config, fields)
+	case "ecdsa-p256-sha256":
+		return httpsign.NewP256SignerFromPEM(keyID, key, config, fields)
+	case "ecdsa-p384-sha384":
+		return httpsign.NewP384SignerFromPEM(keyID, key, config, fields)
+	case "ed25519":
+		return httpsign.NewEd25519SignerFromPEM(keyID, key, config, fields)
+	}
+	return nil, fmt.Errorf("unknown algorithm \"%s\"", alg)
+}
+
+func newVerifier(alg, keyID string, key []byte, config *httpsign.VerifyConfig, fields httpsign.Fields) (*httpsign.Verifier, error) {
+	switch alg {
+	case "hmac-sha256":
+		secret, err := decodeSecret(key)
+		if err != nil {
+			return nil, err
+		}
+		return httpsign.NewHMACSHA256Verifier(keyID, secret, config, fields)
+	case "rsa-v1_5-sha256":
+		return httpsign.NewRSAVerifierFromPEM(keyID, key, config, fields)
+	case "rsa-pss-sha512":
+		return httpsign.NewRSAPSSVerifierFromPEM(keyID, key, config, fields)
+	case "ecdsa-p256-sha256":
+		return httpsign.NewP256VerifierFromPEM(keyID, key, config, fields)
+	case "ecdsa-p384-sha384":
+		return httpsign.NewP384VerifierFromPEM(keyID, key, config, fields)
+	case "ed25519":
+		return httpsign.NewEd25519VerifierFromPEM(keyID, key, config, fields)
+	}
+	return nil, fmt.Errorf("unknown algorithm \"%s\"", alg)
+}
+
+func decodeSecret(key []byte) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode HMAC key, it must be base64-encoded: %w", err)
+	}
+	return secret, nil
+}
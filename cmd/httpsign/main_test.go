@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign/conformance"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const request = "POST /foo?param=Value&Pet=dog HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Date: Tue, 20 Apr 2021 02:07:55 GMT\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 18\r\n" +
+	"\r\n" +
+	"{\"hello\": \"world\"}"
+
+const response = "HTTP/1.1 200 OK\r\n" +
+	"Date: Tue, 20 Apr 2021 02:07:56 GMT\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 23\r\n" +
+	"\r\n" +
+	"{\"message\": \"good dog\"}"
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func runCommand(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// addHeaders inserts the headers printed by the sign command into a message
+func addHeaders(msg, headers string) string {
+	i := strings.Index(msg, "\r\n\r\n")
+	return msg[:i+2] + strings.ReplaceAll(headers, "\n", "\r\n") + msg[i+2:]
+}
+
+func TestSignVerify(t *testing.T) {
+	privFile := writeFile(t, "priv.pem", conformance.Ed25519PrivateKey)
+	pubFile := writeFile(t, "pub.pem", conformance.Ed25519PublicKey)
+
+	code, headers, stderr := runCommand(request, "sign", "-alg", "ed25519", "-key", privFile, "-keyid", "test-key",
+		"-fields", "@method,@path,@query-param;name=Pet,content-type")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasPrefix(headers, "Signature-Input: sig1=(\"@method\" \"@path\" \"@query-param\";name=\"Pet\" \"content-type\")"))
+	signed := addHeaders(request, headers)
+
+	code, stdout, stderr := runCommand(signed, "verify", "-alg", "ed25519", "-key", pubFile, "-keyid", "test-key",
+		"-fields", "content-type", "-max-age", "1m", "-v")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "signature \"sig1\" verified\n", stdout)
+	assert.Contains(t, stderr, "\"@query-param\";name=\"Pet\": dog\n")
+
+	signedFile := writeFile(t, "signed.txt", signed)
+	code, _, stderr = runCommand("", "verify", "-alg", "ed25519", "-key", pubFile, "-keyid", "other-key", signedFile)
+	assert.Equal(t, exitFailed, code)
+	assert.Contains(t, stderr, "does not verify")
+
+	tampered := strings.Replace(signed, "application/json", "text/plain", 1)
+	code, _, _ = runCommand(tampered, "verify", "-alg", "ed25519", "-key", pubFile, "-keyid", "test-key")
+	assert.Equal(t, exitFailed, code)
+}
+
+func TestSignVerifyResponseHMAC(t *testing.T) {
+	keyFile := writeFile(t, "secret", conformance.SharedSecret+"\n")
+
+	code, headers, stderr := runCommand(response, "sign", "-response", "-label", "sig-b", "-alg", "hmac-sha256",
+		"-key", keyFile, "-keyid", "test-shared-secret", "-fields", "@status,content-type")
+	assert.Equal(t, exitOK, code, stderr)
+	signed := addHeaders(response, headers)
+
+	code, _, stderr = runCommand(signed, "verify", "-response", "-label", "sig-b", "-alg", "hmac-sha256",
+		"-key", keyFile, "-keyid", "test-shared-secret")
+	assert.Equal(t, exitOK, code, stderr)
+
+	code, _, _ = runCommand(signed, "verify", "-response", "-alg", "hmac-sha256",
+		"-key", keyFile, "-keyid", "test-shared-secret")
+	assert.Equal(t, exitFailed, code, "wrong label")
+}
+
+func TestBase(t *testing.T) {
+	for _, v := range conformance.Vectors() {
+		if v.Name != "B.2.1" {
+			continue
+		}
+		args := []string{"base", "-label", v.Label}
+		if v.Response {
+			args = append(args, "-response")
+		}
+		code, stdout, stderr := runCommand(v.Message, args...)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Equal(t, "\"@signature-params\": ();created=1618884473;keyid=\"test-key-rsa-pss\";nonce=\"b3k2pp5k7z-50gnwp.yemd\"\n", stdout)
+	}
+
+	code, _, stderr := runCommand(request, "base")
+	assert.Equal(t, exitFailed, code, "unsigned message")
+	assert.NotEmpty(t, stderr)
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: nil},
+		{name: "unknown command", args: []string{"frobnicate"}},
+		{name: "bad flag", args: []string{"base", "-key", "foo"}},
+		{name: "too many files", args: []string{"base", "a", "b"}},
+		{name: "missing file", args: []string{"base", "/nonexistent/message"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCommand("", tt.args...)
+			assert.Equal(t, exitUsage, code)
+			assert.NotEmpty(t, stderr)
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package httpsign
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 )
@@ -15,21 +16,100 @@ type SignConfig struct {
 	signCreated     bool
 	fakeCreated     int64
 	expires         int64
+	expiresIn       time.Duration
 	nonce           string
+	nonceGen        func() (string, error)
+	tag             string
 	requestResponse *requestResponse
+	maxLabelLength  int
+	unsafeValues    UnsafeValuePolicy
+	derivation      derivation
+	labelConflict   LabelConflictPolicy
+	digestAlgorithm string
+	representation  RepresentationFunc
+	baseHook        SignatureBaseHook
+	metrics         Metrics
+	tracer          Tracer
 }
 
+// UnsafeValuePolicy determines how the signer handles covered header values that contain
+// non-ASCII or control characters, which different implementations may canonicalize differently.
+type UnsafeValuePolicy int
+
+const (
+	// UnsafeValueAllow signs the values as they are, leaving their canonicalization to the peer.
+	UnsafeValueAllow UnsafeValuePolicy = iota
+	// UnsafeValueReject fails signing with an UnsafeHeaderValueError.
+	UnsafeValueReject
+	// UnsafeValueWrap covers the header as a byte sequence (the "bs" parameter), which is unambiguous.
+	UnsafeValueWrap
+	// UnsafeValueSanitize replaces each offending byte with "?", both in the signature input and in
+	// the message itself, so that the peer sees exactly the signed value.
+	UnsafeValueSanitize
+)
+
+// LabelConflictPolicy determines what the signer does when the message already carries a signature
+// with the requested label.
+type LabelConflictPolicy int
+
+const (
+	// LabelConflictFail fails signing with a LabelConflictError.
+	LabelConflictFail LabelConflictPolicy = iota
+	// LabelConflictRename picks the next free label, by incrementing a numeric suffix: "sig1" becomes "sig2",
+	// "sig" becomes "sig2". The label actually used is the one in the returned header values.
+	LabelConflictRename
+)
+
+// DefaultMaxLabelLength is the default limit on the length of signature labels (signature names).
+const DefaultMaxLabelLength = 64
+
 // NewSignConfig generates a default configuration.
 func NewSignConfig() *SignConfig {
 	return &SignConfig{
-		signAlg:     true,
-		signCreated: true,
-		fakeCreated: 0,
-		expires:     0,
-		nonce:       "",
+		signAlg:         true,
+		signCreated:     true,
+		fakeCreated:     0,
+		expires:         0,
+		nonce:           "",
+		maxLabelLength:  DefaultMaxLabelLength,
+		unsafeValues:    UnsafeValueAllow,
+		digestAlgorithm: DigestSHA256,
 	}
 }
 
+// Clone returns a copy of the configuration, which may be modified without affecting the original.
+// Cloning a nil configuration returns the default configuration.
+func (c *SignConfig) Clone() *SignConfig {
+	if c == nil {
+		return NewSignConfig()
+	}
+	clone := *c
+	return &clone
+}
+
+// SetLabelConflictPolicy determines how to handle a signature label that is already in use
+// on the message. Default: LabelConflictFail.
+func (c *SignConfig) SetLabelConflictPolicy(p LabelConflictPolicy) *SignConfig {
+	c.labelConflict = p
+	return c
+}
+
+// SetDigestAlgorithm sets the algorithm used to generate the Content-Digest and Repr-Digest headers, DigestSHA256 or DigestSHA512.
+// A header is generated from the message body when it is covered by the signature
+// and the message does not already have the header. Default: DigestSHA256.
+func (c *SignConfig) SetDigestAlgorithm(alg string) *SignConfig {
+	c.digestAlgorithm = alg
+	return c
+}
+
+// SetRepresentation sets a function that returns the selected representation data, used to generate
+// the Repr-Digest header when "repr-digest" is covered by the signature and the message does not already have the header.
+// By default, the representation is the message body, unless the body is encoded or partial.
+func (c *SignConfig) SetRepresentation(f RepresentationFunc) *SignConfig {
+	c.representation = f
+	return c
+}
+
 // SignAlg indicates that an "alg" signature parameters must be generated and signed (default: true).
 func (c *SignConfig) SignAlg(b bool) *SignConfig {
 	c.signAlg = b
@@ -42,9 +122,9 @@ func (c *SignConfig) SignCreated(b bool) *SignConfig {
 	return c
 }
 
-// setFakeCreated indicates that the specified Unix timestamp must be used instead of the current time
-// (default: 0, meaning use current time). Only used for testing.
-func (c *SignConfig) setFakeCreated(ts int64) *SignConfig {
+// SetCreated indicates that the specified Unix timestamp must be used for the "created" parameter instead
+// of the current time, e.g. to reproduce test vectors (default: 0, meaning use current time).
+func (c *SignConfig) SetCreated(ts int64) *SignConfig {
 	c.fakeCreated = ts
 	return c
 }
@@ -56,6 +136,13 @@ func (c *SignConfig) SetExpires(expires int64) *SignConfig {
 	return c
 }
 
+// SetExpiresIn adds an "expires" parameter, computed at signing time as the creation time plus d,
+// and takes precedence over SetExpires. Default: 0 (do not add the parameter).
+func (c *SignConfig) SetExpiresIn(d time.Duration) *SignConfig {
+	c.expiresIn = d
+	return c
+}
+
 // SetNonce adds a "nonce" string parameter whose content should be unique per signed message.
 // Default: empty string (do not add the parameter).
 func (c *SignConfig) SetNonce(nonce string) *SignConfig {
@@ -63,25 +150,190 @@ func (c *SignConfig) SetNonce(nonce string) *SignConfig {
 	return c
 }
 
+// SetNonceGenerator generates a new "nonce" parameter each time a message is signed, e.g. when a request
+// is re-signed for a retry, and takes precedence over SetNonce. Default: nil.
+func (c *SignConfig) SetNonceGenerator(f func() (string, error)) *SignConfig {
+	c.nonceGen = f
+	return c
+}
+
+// SetAutoNonce generates a cryptographically random "nonce" parameter for each signed message, see RandomNonce.
+// Default: false.
+func (c *SignConfig) SetAutoNonce(b bool) *SignConfig {
+	if b {
+		c.nonceGen = RandomNonce
+	} else {
+		c.nonceGen = nil
+	}
+	return c
+}
+
+// RandomNonce returns 128 random bits, base64url-encoded without padding.
+func RandomNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SetTag adds a "tag" string parameter, identifying the application or protocol the signature is
+// intended for. Default: empty string (do not add the parameter).
+func (c *SignConfig) SetTag(tag string) *SignConfig {
+	c.tag = tag
+	return c
+}
+
 // SetRequestResponse allows the server to indicate the signature name and signature that
 // it had received in a client's request and include them in the signature input of the response.
+// This is the draft's "@request-response" mechanism; Fields.AddRequestDictHeader("signature", name) is the RFC 9421 equivalent.
 func (c *SignConfig) SetRequestResponse(name, signature string) *SignConfig {
 	c.requestResponse = &requestResponse{name, signature}
 	return c
 }
 
+// SetMaxLabelLength limits the length of the signature label (signature name).
+// Default: DefaultMaxLabelLength.
+func (c *SignConfig) SetMaxLabelLength(n int) *SignConfig {
+	c.maxLabelLength = n
+	return c
+}
+
+// SetUnsafeValuePolicy determines what to do when a covered header value contains non-ASCII
+// or control characters. Default: UnsafeValueAllow, which signs such values as they are.
+func (c *SignConfig) SetUnsafeValuePolicy(p UnsafeValuePolicy) *SignConfig {
+	c.unsafeValues = p
+	return c
+}
+
+// SetCanonicalization determines how header values are canonicalized in the signature base.
+// The verifier must use the same mode. Default: CanonicalizeStrict.
+func (c *SignConfig) SetCanonicalization(mode CanonicalizationMode) *SignConfig {
+	c.derivation.canonicalization = mode
+	return c
+}
+
+// SetTrustedProxies determines which forwarding headers are believed when deriving the "@scheme" and "@authority"
+// derived components (and "@target-uri") of a request. This is needed to sign a response that covers these
+// components of the request ("req"). SetScheme and SetAuthority take precedence. Default: nil, trusting no proxy.
+func (c *SignConfig) SetTrustedProxies(t *TrustedProxies) *SignConfig {
+	c.derivation.trustedProxies = t
+	return c
+}
+
+// SetRejectDuplicateHeaders fails signing with a DuplicateHeaderError if a covered header that is defined to have
+// a single value, e.g. Content-Type or Date, appears on more than one field line. Otherwise, the field lines are
+// combined with ", ", as for any other header. Default: false.
+func (c *SignConfig) SetRejectDuplicateHeaders(reject bool) *SignConfig {
+	c.derivation.rejectDuplicates = reject
+	return c
+}
+
+// SetScheme pins the value of the "@scheme" derived component (and the scheme of "@target-uri"),
+// regardless of how the request was received. This is useful behind a TLS-terminating load balancer.
+// Default: empty string, meaning the scheme is derived from the request.
+func (c *SignConfig) SetScheme(scheme string) *SignConfig {
+	c.derivation.scheme = scheme
+	return c
+}
+
+// SetAuthority pins the value of the "@authority" derived component (and the authority of "@target-uri"),
+// e.g. to the service's public hostname. Default: empty string, meaning the authority is derived from the request.
+func (c *SignConfig) SetAuthority(authority string) *SignConfig {
+	c.derivation.authority = authority
+	return c
+}
+
+// SignatureBaseHook receives the exact signature base (signature input) that was signed or verified
+// for the signature with the given label, e.g. to diff it against the output of another implementation.
+// The signature base may include sensitive header values.
+type SignatureBaseHook func(label, signatureBase string)
+
+// SetSignatureBaseHook sets a hook that is called with the signature base of each message that is signed.
+// Default: nil, meaning no hook.
+func (c *SignConfig) SetSignatureBaseHook(hook SignatureBaseHook) *SignConfig {
+	c.baseHook = hook
+	return c
+}
+
+// SetMetrics sets the instrumentation that is notified of each signing operation. Default: nil, meaning none.
+func (c *SignConfig) SetMetrics(m Metrics) *SignConfig {
+	c.metrics = m
+	return c
+}
+
+// SetTracer sets the tracer that creates spans around signing and digest computation. Default: nil, meaning none.
+func (c *SignConfig) SetTracer(t Tracer) *SignConfig {
+	c.tracer = t
+	return c
+}
+
 // VerifyConfig contains additional configuration for the verifier.
 type VerifyConfig struct {
 	verifyCreated   bool
 	notNewerThan    time.Duration
 	notOlderThan    time.Duration
 	allowedAlgs     []string
+	minRSABits      int
+	minHMACKeyLen   int
 	rejectExpired   bool
 	requestResponse *requestResponse
 	verifyKeyID     bool
+	allowedKeyIDs   []string
+	keyFetcher      KeyFetcher
+	minCoverage     Fields
 	dateWithin      time.Duration
+	parsingMode     ParsingMode
+	maxLabelLength  int
+	derivation      derivation
+	requireRange    bool
+	requireStatus   bool
+	timeouts        Timeouts
+	skipDigest      bool
+	representation  RepresentationFunc
+	replayCache     ReplayCache
+	requireNonce    bool
+	requireCreated  bool
+	requireExpires  bool
+	expectedTag     string
+	maxTrailerBody  int64
+	report          *VerificationReport // set only for a single verification, see VerifyRequestWithReport
+	baseHook        SignatureBaseHook
+	metrics         Metrics
+	tracer          Tracer
+	logger          Logger
 }
 
+// CanonicalizationMode determines how header values are canonicalized in the signature base.
+type CanonicalizationMode int
+
+const (
+	// CanonicalizeStrict follows RFC 9421, Section 2.1: leading and trailing whitespace is removed from each
+	// field line, obsolete line folding is replaced by a single space, and the field lines are combined with ", ",
+	// including empty ones.
+	CanonicalizeStrict CanonicalizationMode = iota
+	// CanonicalizeLenient also tolerates common changes made by intermediaries: runs of whitespace within
+	// a value are collapsed to a single space, the whitespace around commas is normalized to ", ", and empty field
+	// lines are dropped. Such changes then do not break the signature, but they are not covered by it either,
+	// so this is meant for interoperating with peers behind such intermediaries.
+	CanonicalizeLenient
+)
+
+// ParsingMode determines how strictly the Signature-Input and Signature headers are parsed during verification.
+type ParsingMode int
+
+const (
+	// ParseDefault uses the structured field parser as-is.
+	ParseDefault ParsingMode = iota
+	// ParseStrict enforces the RFC 8941 grammar exactly (token charset, integer ranges,
+	// byte sequence padding) and reports the position of the first violation.
+	ParseStrict
+	// ParseLenient repairs common peer bugs (extra whitespace, uppercase labels, unpadded base64)
+	// before parsing, and logs whatever had to be tolerated, see VerifyConfig.SetLogger. It is meant as a temporary measure
+	// while a peer fixes its implementation.
+	ParseLenient
+)
+
 // SetNotNewerThan sets the window for messages that appear to be newer than the current time,
 // which can only happen if clocks are out of sync. Default: 1,000 ms.
 func (v *VerifyConfig) SetNotNewerThan(notNewerThan time.Duration) *VerifyConfig {
@@ -103,6 +355,20 @@ func (v *VerifyConfig) SetVerifyCreated(verifyCreated bool) *VerifyConfig {
 	return v
 }
 
+// SetMinRSAKeyBits refuses RSA keys whose modulus is shorter than bits, e.g. 2048, even if the signature is valid.
+// This is useful when verifiers are created from keys supplied by peers, e.g. from a JWKS. Default: 0, no minimum.
+func (v *VerifyConfig) SetMinRSAKeyBits(bits int) *VerifyConfig {
+	v.minRSABits = bits
+	return v
+}
+
+// SetMinHMACKeyLength refuses HMAC keys shorter than length bytes, even if the signature is valid.
+// The HMAC verifier already requires 64 bytes, so this only matters for a higher minimum. Default: 0, no minimum.
+func (v *VerifyConfig) SetMinHMACKeyLength(length int) *VerifyConfig {
+	v.minHMACKeyLen = length
+	return v
+}
+
 // SetRejectExpired indicates that expired messages (according to the "expires" parameter) must fail verification.
 // Default: true.
 func (v *VerifyConfig) SetRejectExpired(rejectExpired bool) *VerifyConfig {
@@ -114,7 +380,7 @@ func (v *VerifyConfig) SetRejectExpired(rejectExpired bool) *VerifyConfig {
 // This is useful if the actual algorithm used in verification is taken from the message - not a recommended practice.
 // Default: an empty list, signifying all values are accepted.
 func (v *VerifyConfig) SetAllowedAlgs(allowedAlgs []string) *VerifyConfig {
-	v.allowedAlgs = allowedAlgs
+	v.allowedAlgs = append([]string{}, allowedAlgs...)
 	return v
 }
 
@@ -130,13 +396,56 @@ func (v *VerifyConfig) SetRequestResponse(name, signature string) *VerifyConfig
 }
 
 // SetVerifyKeyID defines how to verify the keyid parameter, if one exists. If this value is set,
-// the signature verifies only if the value is the same as was specified in the Verifier structure.
-// Default: true.
+// the signature verifies only if the value is the same as was specified in the Verifier structure,
+// or one of those set with SetAllowedKeyIDs. Default: true.
 func (v *VerifyConfig) SetVerifyKeyID(verify bool) *VerifyConfig {
 	v.verifyKeyID = verify
 	return v
 }
 
+// SetMinimumCoverage requires the signature to cover at least the given components, and allows it to cover more.
+// Unlike the fields of the Verifier, which must be covered with the same identifiers, a component is also
+// considered covered by a component that includes its value: a dictionary member by the whole header,
+// a header by its structured field ("sf") or byte sequence ("bs") form, and "@path", "@query", "@query-param",
+// "@authority" and "@scheme" by "@target-uri". Default: none.
+func (v *VerifyConfig) SetMinimumCoverage(fields Fields) *VerifyConfig {
+	v.minCoverage = Fields{f: append([]field(nil), fields.f...)}
+	return v
+}
+
+// SetAllowedKeyIDs defines the allowed values of the "keyid" parameter, which are then accepted instead of the key ID
+// of the Verifier structure. This allows a single verification policy to accept any key of a known partner,
+// e.g. both the old and the new key during a key rollover. A signature whose key ID is allowed but is not the
+// Verifier's own is verified with the keys returned for that key ID by the fetcher set with SetKeyFetcher,
+// never with the Verifier's key, and it fails if there is no such fetcher. The policy itself, including the
+// required fields, is the Verifier's. It only applies if SetVerifyKeyID is true.
+// Default: an empty list, meaning only the Verifier's key ID is accepted.
+func (v *VerifyConfig) SetAllowedKeyIDs(keyIDs []string) *VerifyConfig {
+	v.allowedKeyIDs = append([]string(nil), keyIDs...)
+	return v
+}
+
+// SetKeyFetcher defines how to locate the keys for the key IDs allowed with SetAllowedKeyIDs, other than the
+// Verifier's own. The fetcher must return a *Verifier or a *KeySet, e.g. a KeySet of the partner's keys,
+// and only their keys are used. Default: none.
+func (v *VerifyConfig) SetKeyFetcher(fetcher KeyFetcher) *VerifyConfig {
+	v.keyFetcher = fetcher
+	return v
+}
+
+// acceptsKeyID returns true if keyID is acceptable, given the verifier's own key ID
+func (v *VerifyConfig) acceptsKeyID(verifierKeyID, keyID string) bool {
+	if len(v.allowedKeyIDs) == 0 {
+		return keyID == verifierKeyID
+	}
+	for _, k := range v.allowedKeyIDs {
+		if k == keyID {
+			return true
+		}
+	}
+	return false
+}
+
 // SetVerifyDateWithin indicates that the Date header should be verified if it exists, and its value
 // must be within a certain time duration (positive or negative) of the Created signature parameter.
 // This verification is only available if the Created field itself is verified.
@@ -146,17 +455,201 @@ func (v *VerifyConfig) SetVerifyDateWithin(d time.Duration) *VerifyConfig {
 	return v
 }
 
+// SetParsingMode determines how strictly signature headers are parsed. Strict parsing
+// is recommended for security-sensitive deployments. Default: ParseDefault.
+func (v *VerifyConfig) SetParsingMode(mode ParsingMode) *VerifyConfig {
+	v.parsingMode = mode
+	return v
+}
+
+// SetMaxLabelLength limits the length of the signature label (signature name) that is verified.
+// Default: DefaultMaxLabelLength.
+func (v *VerifyConfig) SetMaxLabelLength(n int) *VerifyConfig {
+	v.maxLabelLength = n
+	return v
+}
+
+// SetScheme pins the value of the "@scheme" derived component (and the scheme of "@target-uri"),
+// regardless of what the local listener saw. This is useful for services deployed behind a TLS-terminating
+// load balancer, where the client's scheme cannot be derived from the request.
+// Default: empty string, meaning the scheme is derived from the request.
+func (v *VerifyConfig) SetScheme(scheme string) *VerifyConfig {
+	v.derivation.scheme = scheme
+	return v
+}
+
+// SetAuthority pins the value of the "@authority" derived component (and the authority of "@target-uri"),
+// typically to the service's public hostname. Default: empty string, meaning the authority is derived from the request.
+func (v *VerifyConfig) SetAuthority(authority string) *VerifyConfig {
+	v.derivation.authority = authority
+	return v
+}
+
+// SetCanonicalization determines how header values are canonicalized in the signature base.
+// The signer must use the same mode. Default: CanonicalizeStrict.
+func (v *VerifyConfig) SetCanonicalization(mode CanonicalizationMode) *VerifyConfig {
+	v.derivation.canonicalization = mode
+	return v
+}
+
+// SetTrustedProxies determines which forwarding headers are believed when deriving the "@scheme" and "@authority"
+// derived components (and "@target-uri"), for a server behind a reverse proxy or load balancer, where the request's
+// Host and TLS fields describe the connection from the proxy rather than the client's request.
+// SetScheme and SetAuthority take precedence. Default: nil, trusting no proxy.
+func (v *VerifyConfig) SetTrustedProxies(t *TrustedProxies) *VerifyConfig {
+	v.derivation.trustedProxies = t
+	return v
+}
+
+// SetRejectDuplicateHeaders fails verification with a DuplicateHeaderError if a covered header that is defined to
+// have a single value, e.g. Content-Type or Date, appears on more than one field line, which may indicate that
+// a header was injected. Otherwise, the field lines are combined with ", ". Default: false.
+func (v *VerifyConfig) SetRejectDuplicateHeaders(reject bool) *VerifyConfig {
+	v.derivation.rejectDuplicates = reject
+	return v
+}
+
+// SetRequireRangeCoverage requires the signature to cover the range-related headers that are present in the
+// message: "range" and "if-range" for requests, "content-range" for responses. Otherwise, an attacker could
+// modify the requested or returned range without invalidating the signature. Default: false.
+func (v *VerifyConfig) SetRequireRangeCoverage(b bool) *VerifyConfig {
+	v.requireRange = b
+	return v
+}
+
+// SetRequireStatusCoverage requires the signature on a response to cover "@status". Otherwise, an attacker could
+// replace the status code, e.g. turn an error into a success, without invalidating the signature. Default: false.
+func (v *VerifyConfig) SetRequireStatusCoverage(b bool) *VerifyConfig {
+	v.requireStatus = b
+	return v
+}
+
+// SetTimeouts bounds the time spent on verification. When verifying a request, the deadline of the request's
+// context also applies. Default: no timeouts.
+func (v *VerifyConfig) SetTimeouts(t Timeouts) *VerifyConfig {
+	v.timeouts = t
+	return v
+}
+
+// SetVerifyDigest determines whether the Content-Digest and Repr-Digest headers are checked against the message body,
+// when they are covered by the signature. Otherwise, a valid signature does not protect the body.
+// The body is read in full, and replaced by a reader over the same content. Default: true.
+func (v *VerifyConfig) SetVerifyDigest(b bool) *VerifyConfig {
+	v.skipDigest = !b
+	return v
+}
+
+// SetRepresentation sets a function that returns the selected representation data, used to check
+// a covered Repr-Digest header. By default, the representation is the message body, and
+// the check is skipped if the body is encoded or partial.
+func (v *VerifyConfig) SetRepresentation(f RepresentationFunc) *VerifyConfig {
+	v.representation = f
+	return v
+}
+
+// SetVerifyTrailers allows signatures that cover trailer fields (Fields.AddTrailers), and signatures sent
+// in trailers (see SignRequestInTrailers and HandlerConfig.SetSignInTrailers). Trailers are only known once
+// the body has been read, so the body is read into memory before the signature is verified, and a body larger
+// than n bytes fails verification with a BodyTooLargeError. If n is 0 or less, such signatures fail verification
+// without reading the body. Default: 0.
+func (v *VerifyConfig) SetVerifyTrailers(n int64) *VerifyConfig {
+	v.maxTrailerBody = n
+	return v
+}
+
+// SetReplayCache records the nonce of each verified signature in the cache, and fails verification with a
+// ReplayError if the nonce was already seen for the same key. Signatures without a nonce are not affected.
+// Default: nil.
+func (v *VerifyConfig) SetReplayCache(c ReplayCache) *VerifyConfig {
+	v.replayCache = c
+	return v
+}
+
+// SetRequireNonce indicates that signatures without a "nonce" parameter must fail verification,
+// typically together with SetReplayCache. Default: false.
+func (v *VerifyConfig) SetRequireNonce(b bool) *VerifyConfig {
+	v.requireNonce = b
+	return v
+}
+
+// SetRequireCreated indicates that signatures without a "created" parameter must fail verification, even if
+// the time window is not checked (SetVerifyCreated(false)), so that undated signatures are never accepted.
+// Default: false.
+func (v *VerifyConfig) SetRequireCreated(b bool) *VerifyConfig {
+	v.requireCreated = b
+	return v
+}
+
+// SetRequireExpires indicates that signatures without an "expires" parameter must fail verification, so that
+// only signatures of bounded validity are accepted. Whether the deadline has passed is checked separately,
+// see SetRejectExpired. Default: false.
+func (v *VerifyConfig) SetRequireExpires(b bool) *VerifyConfig {
+	v.requireExpires = b
+	return v
+}
+
+// SetExpectedTag indicates that only signatures whose "tag" parameter equals tag are accepted, so that
+// a signature created for one application or protocol cannot be used for another. Default: empty string,
+// meaning any tag or none.
+func (v *VerifyConfig) SetExpectedTag(tag string) *VerifyConfig {
+	v.expectedTag = tag
+	return v
+}
+
+// SetSignatureBaseHook sets a hook that is called with the signature base of each message that is verified,
+// before the signature is checked, so that it is also called when verification fails. It is not called
+// if the message is rejected before its signature base is generated, e.g. by the verification policy.
+// Default: nil, meaning no hook.
+func (v *VerifyConfig) SetSignatureBaseHook(hook SignatureBaseHook) *VerifyConfig {
+	v.baseHook = hook
+	return v
+}
+
+// SetMetrics sets the instrumentation that is notified of each verification. Default: nil, meaning none.
+func (v *VerifyConfig) SetMetrics(m Metrics) *VerifyConfig {
+	v.metrics = m
+	return v
+}
+
+// SetTracer sets the tracer that creates spans around verification and digest checks. Default: nil, meaning none.
+func (v *VerifyConfig) SetTracer(t Tracer) *VerifyConfig {
+	v.tracer = t
+	return v
+}
+
+// SetLogger sets the logger for the headers repaired in lenient parsing mode.
+// Default: a logger that discards all messages.
+func (v *VerifyConfig) SetLogger(l Logger) *VerifyConfig {
+	v.logger = loggerOrNop(l)
+	return v
+}
+
 // NewVerifyConfig generates a default configuration.
 func NewVerifyConfig() *VerifyConfig {
 	return &VerifyConfig{
-		verifyCreated: true,
-		notNewerThan:  2 * time.Second,
-		notOlderThan:  10 * time.Second,
-		rejectExpired: true,
-		allowedAlgs:   []string{},
-		verifyKeyID:   true,
-		dateWithin:    0, // meaning no constraint
+		verifyCreated:  true,
+		notNewerThan:   2 * time.Second,
+		notOlderThan:   10 * time.Second,
+		rejectExpired:  true,
+		allowedAlgs:    []string{},
+		verifyKeyID:    true,
+		dateWithin:     0, // meaning no constraint
+		parsingMode:    ParseDefault,
+		maxLabelLength: DefaultMaxLabelLength,
+		logger:         nopLogger{},
+	}
+}
+
+// Clone returns a copy of the configuration, which may be modified without affecting the original.
+// Cloning a nil configuration returns the default configuration.
+func (v *VerifyConfig) Clone() *VerifyConfig {
+	if v == nil {
+		return NewVerifyConfig()
 	}
+	clone := *v
+	clone.allowedAlgs = append([]string{}, v.allowedAlgs...)
+	clone.allowedKeyIDs = append([]string(nil), v.allowedKeyIDs...)
+	return &clone
 }
 
 // HandlerConfig contains additional configuration for the HTTP message handler wrapper.
@@ -165,30 +658,56 @@ func NewVerifyConfig() *VerifyConfig {
 type HandlerConfig struct {
 	reqNotVerified func(w http.ResponseWriter,
 		r *http.Request, err error)
-	fetchVerifier func(r *http.Request) (sigName string, verifier *Verifier)
-	fetchSigner   func(res http.Response, r *http.Request) (sigName string, signer *Signer)
+	fetchVerifier   func(r *http.Request) (sigName string, verifier MessageVerifier)
+	selectVerifiers func(r *http.Request, sigs []SignatureDetails) map[string]Verifier
+	fetchSigner     func(res http.Response, r *http.Request) (sigName string, signer MessageSigner)
+	exemptPreflight bool
+	exemptSafe      bool
+	exemptPaths     map[string]bool
+	exemptPrefixes  []string
+	exemptMethods   map[string]bool
+	exempt          func(r *http.Request) bool
+	maxBufferedBody int64
+	timeouts        Timeouts
+	signTrailers    bool
+	honorAccept     bool
+	signingKeys     []SigningKey
+	skipStatus      bool
+	trustedProxies  *TrustedProxies
+	logger          Logger
 }
 
 // NewHandlerConfig generates a default configuration. When verification or respectively,
 // signing is required, the respective "fetch" callback must be supplied.
 func NewHandlerConfig() *HandlerConfig {
-	return &HandlerConfig{
-		reqNotVerified: defaultReqNotVerified,
-		fetchVerifier:  nil,
-		fetchSigner:    nil,
+	h := &HandlerConfig{
+		fetchVerifier: nil,
+		fetchSigner:   nil,
+		logger:        nopLogger{},
+	}
+	h.reqNotVerified = func(w http.ResponseWriter, r *http.Request, err error) {
+		defaultReqNotVerified(h.logger, w, r, err)
 	}
+	return h
 }
 
-func defaultReqNotVerified(w http.ResponseWriter, _ *http.Request, err error) {
+func defaultReqNotVerified(logger Logger, w http.ResponseWriter, _ *http.Request, err error) {
 	w.WriteHeader(http.StatusUnauthorized)
 	if err == nil { // should not happen
 		_, _ = fmt.Fprintf(w, "Unknown error")
 	} else {
-		log.Println("Could not verify request signature: " + err.Error())
+		logger.Printf("Could not verify request signature: %v", err)
 		_, _ = fmt.Fprintln(w, "Could not verify request signature") // For security reasons, do not print error
 	}
 }
 
+// SetLogger sets the logger for verification failures handled by the default ReqNotVerified callback,
+// and for responses that cannot be signed. Default: a logger that discards all messages.
+func (h *HandlerConfig) SetLogger(l Logger) *HandlerConfig {
+	h.logger = loggerOrNop(l)
+	return h
+}
+
 // SetReqNotVerified defines a callback to be called when a request fails to verify. The default
 // callback sends an unsigned 401 status code with a generic error message. For production, you
 // probably need to sign it.
@@ -198,15 +717,155 @@ func (h *HandlerConfig) SetReqNotVerified(f func(w http.ResponseWriter, r *http.
 	return h
 }
 
+// SetTrustedProxies determines which forwarding headers are believed when deriving the "@scheme" and "@authority"
+// derived components (and "@target-uri") of incoming requests, for a server behind a reverse proxy or load balancer.
+// It applies to the verifiers and signers returned by the callbacks, unless their configuration sets its own,
+// see VerifyConfig.SetTrustedProxies. Default: nil, trusting no proxy.
+func (h *HandlerConfig) SetTrustedProxies(t *TrustedProxies) *HandlerConfig {
+	h.trustedProxies = t
+	return h
+}
+
 // SetFetchVerifier defines a callback that looks at the incoming request and provides
 // a Verifier structure. In the simplest case, the signature name is a constant, and the key ID
 // and key value are fetched based on the sender's identity, which in turn is gleaned
 // from a header or query parameter. If a Verifier cannot be determined, the function should return Verifier as nil.
 func (h *HandlerConfig) SetFetchVerifier(f func(r *http.Request) (sigName string, verifier *Verifier)) *HandlerConfig {
+	if f == nil {
+		h.fetchVerifier = nil
+		return h
+	}
+	return h.SetFetchMessageVerifier(func(r *http.Request) (string, MessageVerifier) {
+		sigName, verifier := f(r)
+		if verifier == nil {
+			return sigName, nil
+		}
+		return sigName, verifier
+	})
+}
+
+// SetFetchMessageVerifier is the same as SetFetchVerifier, but the callback may return any MessageVerifier,
+// e.g. a custom implementation that wraps a Verifier.
+func (h *HandlerConfig) SetFetchMessageVerifier(f func(r *http.Request) (sigName string, verifier MessageVerifier)) *HandlerConfig {
 	h.fetchVerifier = f
 	return h
 }
 
+// SetSelectVerifiers defines a callback for requests that may carry several signatures. The callback receives
+// the details of all signatures on the request, and decides which of them must verify by returning a map from
+// signature label (or tag) to the Verifier that applies to it, as in VerifyRequestSignatures. If the callback
+// returns an empty map, the request is rejected. When set, this callback is used instead of fetchVerifier.
+func (h *HandlerConfig) SetSelectVerifiers(f func(r *http.Request, sigs []SignatureDetails) map[string]Verifier) *HandlerConfig {
+	h.selectVerifiers = f
+	return h
+}
+
+// SetExemptPreflight indicates that CORS preflight requests (OPTIONS requests with an
+// Access-Control-Request-Method header) are passed to the handler without verification,
+// since browsers cannot sign them. Default: false.
+func (h *HandlerConfig) SetExemptPreflight(b bool) *HandlerConfig {
+	h.exemptPreflight = b
+	return h
+}
+
+// SetExemptSafeMethods indicates that requests with safe methods (GET, HEAD, OPTIONS, TRACE) are passed
+// to the handler without verification, while verification is still enforced on all other methods.
+// Default: false.
+func (h *HandlerConfig) SetExemptSafeMethods(b bool) *HandlerConfig {
+	h.exemptSafe = b
+	return h
+}
+
+// SetExemptPaths lists request paths, e.g. "/healthz", that are passed to the handler without verification.
+// Paths must match exactly. Default: none.
+func (h *HandlerConfig) SetExemptPaths(paths ...string) *HandlerConfig {
+	h.exemptPaths = map[string]bool{}
+	for _, p := range paths {
+		h.exemptPaths[p] = true
+	}
+	return h
+}
+
+// SetExemptPathPrefixes lists path prefixes, e.g. "/public/", such that requests whose path starts with one of them
+// are passed to the handler without verification. Default: none.
+func (h *HandlerConfig) SetExemptPathPrefixes(prefixes ...string) *HandlerConfig {
+	h.exemptPrefixes = prefixes
+	return h
+}
+
+// SetExemptMethods lists request methods that are passed to the handler without verification. Default: none.
+func (h *HandlerConfig) SetExemptMethods(methods ...string) *HandlerConfig {
+	h.exemptMethods = map[string]bool{}
+	for _, m := range methods {
+		h.exemptMethods[m] = true
+	}
+	return h
+}
+
+// SetExempt defines a callback that determines whether a request is passed to the handler without verification,
+// in addition to the other exemption rules. Default: nil.
+func (h *HandlerConfig) SetExempt(f func(r *http.Request) bool) *HandlerConfig {
+	h.exempt = f
+	return h
+}
+
+// SetBufferBody indicates that the body of requests that are subject to verification is read in full,
+// up to maxBytes, and made available through the request's VerifiedRequest (see GetVerifiedRequest), so that
+// handlers can re-read it. Larger bodies fail verification. Default: 0, meaning the body is not buffered.
+func (h *HandlerConfig) SetBufferBody(maxBytes int64) *HandlerConfig {
+	h.maxBufferedBody = maxBytes
+	return h
+}
+
+// SetTimeouts bounds the time spent verifying incoming requests, including fetching the verifier and reading
+// the body. The context passed to the verifier callbacks (r.Context()) carries the relevant deadline.
+// Verifiers may set their own, additional timeouts with VerifyConfig.SetTimeouts. Default: no timeouts.
+func (h *HandlerConfig) SetTimeouts(t Timeouts) *HandlerConfig {
+	h.timeouts = t
+	return h
+}
+
+// SetHonorAcceptSignature shapes the response signature according to the request's Accept-Signature header, if any:
+// the first requested signature whose key ID, algorithm and tag (if specified) match the fetched Signer is used for
+// the signature label, the covered fields in addition to the Signer's own, and the "created" and "nonce"
+// parameters. The tag is never taken from the client, and "expires" is only included if the Signer sets it.
+// If there is no such request, or signing it fails, e.g. because a requested header is missing,
+// the response is signed as configured. Default: false.
+func (h *HandlerConfig) SetHonorAcceptSignature(b bool) *HandlerConfig {
+	h.honorAccept = b
+	return h
+}
+
+// SetCoverStatus makes the response signature cover the "@status" component, even if the Signer returned by
+// the fetchSigner callback does not list it, so that clients can detect a modified status code.
+// Applies only to signers of type Signer. Default: true.
+func (h *HandlerConfig) SetCoverStatus(b bool) *HandlerConfig {
+	h.skipStatus = !b
+	return h
+}
+
+// SetSigningKeys declares the keys the server can sign responses with, in order of preference. If the request
+// has an Accept-Signature header, the first requested signature (in the client's order) that one of the keys
+// can produce, and whose fields are all present in the response, is negotiated. The outcome is made available
+// to the fetchSigner callback through GetNegotiation, so that it can return a signer for the negotiated key.
+// If that signer is returned and SetHonorAcceptSignature is set, the response is signed as negotiated.
+// Default: no keys, i.e. no negotiation.
+func (h *HandlerConfig) SetSigningKeys(keys []SigningKey) *HandlerConfig {
+	h.signingKeys = keys
+	return h
+}
+
+// SetSignInTrailers sends the response's Signature-Input and Signature as trailers, so that the body
+// can be streamed rather than buffered, and the signature is computed after the last byte. The response headers
+// are sent, and the signer is fetched, when the body is first written. To protect the body, the signer
+// should cover the "content-digest" trailer (Fields.AddTrailers), which is then computed while the body is written.
+// The response must not have a Content-Length. The client's verifier must be configured with
+// VerifyConfig.SetVerifyTrailers. Default: false.
+func (h *HandlerConfig) SetSignInTrailers(b bool) *HandlerConfig {
+	h.signTrailers = b
+	return h
+}
+
 // SetFetchSigner defines a callback that looks at the incoming request and the response, just before it is sent,
 // and provides
 // a Signer structure. In the simplest case, the signature name is a constant, and the key ID
@@ -214,6 +873,22 @@ func (h *HandlerConfig) SetFetchVerifier(f func(r *http.Request) (sigName string
 // it is recommended to use the request's ctx (Context) member
 // to store this information. If a Signer cannot be determined, the function should return Signer as nil.
 func (h *HandlerConfig) SetFetchSigner(f func(res http.Response, r *http.Request) (sigName string, signer *Signer)) *HandlerConfig {
+	if f == nil {
+		h.fetchSigner = nil
+		return h
+	}
+	return h.SetFetchMessageSigner(func(res http.Response, r *http.Request) (string, MessageSigner) {
+		sigName, signer := f(res, r)
+		if signer == nil {
+			return sigName, nil
+		}
+		return sigName, signer
+	})
+}
+
+// SetFetchMessageSigner is the same as SetFetchSigner, but the callback may return any MessageSigner,
+// e.g. a client of a remote signing service.
+func (h *HandlerConfig) SetFetchMessageSigner(f func(res http.Response, r *http.Request) (sigName string, signer MessageSigner)) *HandlerConfig {
 	h.fetchSigner = f
 	return h
 }
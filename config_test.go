@@ -1,8 +1,16 @@
 package httpsign
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestConfig_SetSignCreated(t *testing.T) {
@@ -48,3 +56,392 @@ func TestConfig_SetSignCreated(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_SetAutoNonce(t *testing.T) {
+	signer, _ := NewHMACSHA256Signer("key", make([]byte, 64), NewSignConfig().SignCreated(false).SetAutoNonce(true), Headers("@method"))
+	nonce := regexp.MustCompile(`;nonce="([A-Za-z0-9_-]{22})";`)
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		req := readRequest(httpreq1)
+		sigInput, _, err := SignRequest("sig1", *signer, req)
+		if err != nil {
+			t.Fatalf("SignRequest() error = %v", err)
+		}
+		m := nonce.FindStringSubmatch(sigInput)
+		if m == nil {
+			t.Fatalf("no random nonce in %s", sigInput)
+		}
+		if seen[m[1]] {
+			t.Errorf("nonce %s was repeated", m[1])
+		}
+		seen[m[1]] = true
+	}
+
+	config := NewSignConfig().SetAutoNonce(true).SetAutoNonce(false)
+	if config.nonceGen != nil {
+		t.Errorf("SetAutoNonce(false) should remove the nonce generator")
+	}
+}
+
+func TestConfig_SetExpiresIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *SignConfig
+		want   string
+	}{
+		{"relative", NewSignConfig().SetCreated(1000).SetExpiresIn(30 * time.Second),
+			`sig1=("@method");created=1000;expires=1030;alg="hmac-sha256";keyid="key"`},
+		{"takes precedence", NewSignConfig().SetCreated(1000).SetExpires(5000).SetExpiresIn(time.Minute),
+			`sig1=("@method");created=1000;expires=1060;alg="hmac-sha256";keyid="key"`},
+		{"absolute", NewSignConfig().SetCreated(1000).SetExpires(5000),
+			`sig1=("@method");created=1000;expires=5000;alg="hmac-sha256";keyid="key"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", make([]byte, 64), tt.config, Headers("@method"))
+			sigInput, _, err := SignRequest("sig1", *signer, readRequest(httpreq1))
+			if err != nil {
+				t.Fatalf("SignRequest() error = %v", err)
+			}
+			if sigInput != tt.want {
+				t.Errorf("SignRequest() = %v, want %v", sigInput, tt.want)
+			}
+		})
+	}
+
+	// without a created parameter, the expiry is relative to the current time
+	signer, _ := NewHMACSHA256Signer("key", make([]byte, 64), NewSignConfig().SignCreated(false).SetExpiresIn(time.Hour), Headers("@method"))
+	req := readRequest(httpreq1)
+	sigInput, sig, _ := SignRequest("sig1", *signer, req)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	verifier, _ := NewHMACSHA256Verifier("key", make([]byte, 64), NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	if err := VerifyRequest("sig1", *verifier, req); err != nil {
+		t.Errorf("VerifyRequest() error = %v", err)
+	}
+}
+
+func TestConfig_Clone(t *testing.T) {
+	config := NewSignConfig().SetCreated(1000).SetTag("app")
+	clone := config.Clone()
+	if !reflect.DeepEqual(clone, config) {
+		t.Errorf("Clone() = %v, want %v", clone, config)
+	}
+	clone.SetTag("other")
+	if config.tag != "app" {
+		t.Errorf("modifying the clone modified the original")
+	}
+	if !reflect.DeepEqual((*SignConfig)(nil).Clone(), NewSignConfig()) {
+		t.Errorf("a nil SignConfig should be cloned as the default")
+	}
+
+	algs := []string{"hmac-sha256"}
+	verifyConfig := NewVerifyConfig().SetAllowedAlgs(algs)
+	algs[0] = "rsa-v1_5-sha256"
+	verifyClone := verifyConfig.Clone()
+	verifyClone.allowedAlgs[0] = "ed25519"
+	if verifyConfig.allowedAlgs[0] != "hmac-sha256" {
+		t.Errorf("allowed algorithms are shared, got %v", verifyConfig.allowedAlgs)
+	}
+	if !reflect.DeepEqual((*VerifyConfig)(nil).Clone(), NewVerifyConfig()) {
+		t.Errorf("a nil VerifyConfig should be cloned as the default")
+	}
+}
+
+func TestConfig_CopiedOnConstruction(t *testing.T) {
+	key := make([]byte, 64)
+	signConfig := NewSignConfig().SetCreated(1000)
+	signer, _ := NewHMACSHA256Signer("key", key, signConfig, Headers("@method"))
+	verifyConfig := NewVerifyConfig().SetVerifyCreated(false)
+	verifier, _ := NewHMACSHA256Verifier("key", key, verifyConfig, Headers("@method"))
+
+	// neither the signer nor the verifier may be affected by later changes to the configuration
+	signConfig.SetCreated(2000).SetTag("app")
+	verifyConfig.SetVerifyCreated(true).SetAllowedAlgs([]string{"ed25519"})
+
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	if err != nil {
+		t.Fatalf("SignRequest() error = %v", err)
+	}
+	if want := `sig1=("@method");created=1000;alg="hmac-sha256";keyid="key"`; sigInput != want {
+		t.Errorf("SignRequest() = %v, want %v", sigInput, want)
+	}
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	if err := VerifyRequest("sig1", *verifier, req); err != nil {
+		t.Errorf("VerifyRequest() error = %v", err)
+	}
+}
+
+func TestConfig_ConcurrentUse(t *testing.T) {
+	key := make([]byte, 64)
+	config := NewSignConfig().SetAutoNonce(true)
+	signer, _ := NewHMACSHA256Signer("key", key, config, Headers("@method", "@authority"))
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), Headers("@method", "@authority"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			if err == nil {
+				req.Header.Add("Signature-Input", sigInput)
+				req.Header.Add("Signature", sig)
+				err = VerifyRequest("sig1", *verifier, req)
+			}
+			errs <- err
+		}()
+	}
+	config.SetAutoNonce(false).SetTag("racy") // run with -race: must not race with the signer
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent sign and verify: %v", err)
+		}
+	}
+}
+
+func TestVerifyConfig_MinKeySize(t *testing.T) {
+	weakRSA, _ := rsa.GenerateKey(rand.Reader, 1024)
+	strongRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	weakHMAC, strongHMAC := make([]byte, 64), make([]byte, 128)
+	fields := Headers("@method")
+	policy := func() *VerifyConfig { return NewVerifyConfig().SetMinRSAKeyBits(2048).SetMinHMACKeyLength(128) }
+	rsaSigner := func(key *rsa.PrivateKey) *Signer {
+		signer, _ := NewRSASigner("key", *key, nil, fields)
+		return signer
+	}
+	rsaVerifier := func(key *rsa.PrivateKey, config *VerifyConfig) *Verifier {
+		verifier, _ := NewRSAVerifier("key", key.PublicKey, config, fields)
+		return verifier
+	}
+	hmacSigner, _ := NewHMACSHA256Signer("key", weakHMAC, nil, fields)
+	hmacVerifier, _ := NewHMACSHA256Verifier("key", weakHMAC, policy(), fields)
+	strongHMACSigner, _ := NewHMACSHA256Signer("key", strongHMAC, nil, fields)
+	strongHMACVerifier, _ := NewHMACSHA256Verifier("key", strongHMAC, policy(), fields)
+	jwsSigner, _ := NewJWSSigner("RS256", "key", weakRSA, NewSignConfig().SignAlg(false), fields)
+	jwsVerifier, _ := NewJWSVerifier("RS256", &weakRSA.PublicKey, "key", policy(), fields)
+
+	tests := []struct {
+		name     string
+		signer   *Signer
+		verifier *Verifier
+		wantErr  bool
+	}{
+		{"weak RSA, no policy", rsaSigner(weakRSA), rsaVerifier(weakRSA, nil), false},
+		{"weak RSA", rsaSigner(weakRSA), rsaVerifier(weakRSA, policy()), true},
+		{"strong RSA", rsaSigner(strongRSA), rsaVerifier(strongRSA, policy()), false},
+		{"weak HMAC", hmacSigner, hmacVerifier, true},
+		{"strong HMAC", strongHMACSigner, strongHMACVerifier, false},
+		{"weak JWS RSA", jwsSigner, jwsVerifier, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *tt.signer, req)
+			if err != nil {
+				t.Fatalf("SignRequest() error = %v", err)
+			}
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			err = VerifyRequest("sig1", *tt.verifier, req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrWeakKey) {
+				t.Errorf("VerifyRequest() error = %v, want ErrWeakKey", err)
+			}
+		})
+	}
+}
+
+func TestVerifyConfig_SetAllowedKeyIDs(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64)
+	fields := Headers("@method")
+	partner1, _ := NewHMACSHA256Verifier("partner-1", key1, nil, fields)
+	partnerKeys := NewKeySet().Add(partner1)
+	allowed := []string{"partner-1", "partner-2"}
+	tests := []struct {
+		name    string
+		keyID   string
+		key     []byte
+		config  *VerifyConfig
+		wantErr error
+	}{
+		{"verifier's key ID", "partner-2", key2, NewVerifyConfig(), nil},
+		{"other key ID", "partner-1", key1, NewVerifyConfig().SetKeyFetcher(partnerKeys), ErrKeyIDMismatch},
+		{"allowed", "partner-1", key1, NewVerifyConfig().SetAllowedKeyIDs(allowed).SetKeyFetcher(partnerKeys), nil},
+		{"allowed, verifier's key ID", "partner-2", key2, NewVerifyConfig().SetAllowedKeyIDs(allowed).SetKeyFetcher(partnerKeys), nil},
+		{"allowed, verifier's key", "partner-1", key2, NewVerifyConfig().SetAllowedKeyIDs(allowed).SetKeyFetcher(partnerKeys), ErrBadSignature},
+		{"allowed, no fetcher", "partner-1", key1, NewVerifyConfig().SetAllowedKeyIDs(allowed), errors.New("no key fetcher")},
+		{"not allowed", "partner-3", key1, NewVerifyConfig().SetAllowedKeyIDs(allowed).SetKeyFetcher(partnerKeys), ErrKeyIDMismatch},
+		{"list replaces the verifier's key ID", "partner-2", key2, NewVerifyConfig().SetAllowedKeyIDs([]string{"partner-1"}), ErrKeyIDMismatch},
+		{"not verified", "partner-3", key2, NewVerifyConfig().SetVerifyKeyID(false).SetAllowedKeyIDs([]string{"partner-1"}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer(tt.keyID, tt.key, nil, fields)
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			if err != nil {
+				t.Fatalf("SignRequest() error = %v", err)
+			}
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("partner-2", key2, tt.config, fields)
+			err = VerifyRequest("sig1", *verifier, req)
+			switch {
+			case tt.wantErr == nil:
+				if err != nil {
+					t.Errorf("VerifyRequest() error = %v", err)
+				}
+			case errors.Is(tt.wantErr, ErrKeyIDMismatch) || errors.Is(tt.wantErr, ErrBadSignature):
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("VerifyRequest() error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Errorf("VerifyRequest() error = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyConfig_SetMinimumCoverage(t *testing.T) {
+	key := make([]byte, 64)
+	signer, _ := NewHMACSHA256Signer("key", key, nil, *NewFields().Method().TargetURI().Header("content-type").
+		Header("cache-control").Header("date"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	if err != nil {
+		t.Fatalf("SignRequest() error = %v", err)
+	}
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	tests := []struct {
+		name    string
+		minimum *Fields
+		wantErr bool
+	}{
+		{"subset", NewFields().Method().Header("content-type"), false},
+		{"implied", NewFields().Authority().Path().QueryParam("pet").AddDictHeader("cache-control", "max-age"), false},
+		{"not covered", NewFields().Method().Header("digest"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetMinimumCoverage(*tt.minimum), *NewFields())
+			err := VerifyRequest("sig1", *verifier, req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrMissingField) {
+				t.Errorf("VerifyRequest() error = %v, want ErrMissingField", err)
+			}
+		})
+	}
+}
+
+func TestVerifyConfig_SetRequireCreated(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method")
+	tests := []struct {
+		name    string
+		signer  *SignConfig
+		config  *VerifyConfig
+		wantErr bool
+	}{
+		{"undated, time not checked", NewSignConfig().SignCreated(false), NewVerifyConfig().SetVerifyCreated(false), false},
+		{"undated, required", NewSignConfig().SignCreated(false), NewVerifyConfig().SetVerifyCreated(false).SetRequireCreated(true), true},
+		{"old, required", NewSignConfig().SetCreated(1000), NewVerifyConfig().SetVerifyCreated(false).SetRequireCreated(true), false},
+		{"current, required and checked", NewSignConfig(), NewVerifyConfig().SetRequireCreated(true), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", key, tt.signer, fields)
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			if err != nil {
+				t.Fatalf("SignRequest() error = %v", err)
+			}
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("key", key, tt.config, fields)
+			if err = VerifyRequest("sig1", *verifier, req); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrMissingCreated) {
+				t.Errorf("VerifyRequest() error = %v, want ErrMissingCreated", err)
+			}
+		})
+	}
+
+	// the same applies to draft-cavage signatures
+	signer, _ := NewHMACSHA256Signer("key", key, nil, *NewFields())
+	req := readRequest(httpreq1)
+	sig, err := SignRequestCavage(*signer, []string{"date"}, req)
+	if err != nil {
+		t.Fatalf("SignRequestCavage() error = %v", err)
+	}
+	req.Header.Set("Signature", sig)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false), *NewFields())
+	if err = VerifyRequestCavage(*verifier, req); err != nil {
+		t.Errorf("VerifyRequestCavage() error = %v", err)
+	}
+	verifier, _ = NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).SetRequireCreated(true), *NewFields())
+	if err = VerifyRequestCavage(*verifier, req); !errors.Is(err, ErrMissingCreated) {
+		t.Errorf("VerifyRequestCavage() error = %v, want ErrMissingCreated", err)
+	}
+}
+
+func TestVerifyConfig_SetRequireExpires(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method")
+	tests := []struct {
+		name    string
+		signer  *SignConfig
+		config  *VerifyConfig
+		wantErr error
+	}{
+		{"unbounded, not required", NewSignConfig(), NewVerifyConfig(), nil},
+		{"unbounded, required", NewSignConfig(), NewVerifyConfig().SetRequireExpires(true), ErrMissingExpires},
+		{"bounded, required", NewSignConfig().SetExpiresIn(time.Minute), NewVerifyConfig().SetRequireExpires(true), nil},
+		{"expired, required but not checked", NewSignConfig().SetExpires(1000), NewVerifyConfig().SetRequireExpires(true).SetRejectExpired(false), nil},
+		{"expired, required and checked", NewSignConfig().SetExpires(1000), NewVerifyConfig().SetRequireExpires(true), ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", key, tt.signer, fields)
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			if err != nil {
+				t.Fatalf("SignRequest() error = %v", err)
+			}
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("key", key, tt.config, fields)
+			if err = VerifyRequest("sig1", *verifier, req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the same applies to draft-cavage signatures
+	signer, _ := NewHMACSHA256Signer("key", key, nil, *NewFields())
+	req := readRequest(httpreq1)
+	sig, err := SignRequestCavage(*signer, []string{"date"}, req)
+	if err != nil {
+		t.Fatalf("SignRequestCavage() error = %v", err)
+	}
+	req.Header.Set("Signature", sig)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).SetRequireExpires(true), *NewFields())
+	if err = VerifyRequestCavage(*verifier, req); !errors.Is(err, ErrMissingExpires) {
+		t.Errorf("VerifyRequestCavage() error = %v, want ErrMissingExpires", err)
+	}
+}
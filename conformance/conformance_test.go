@@ -0,0 +1,68 @@
+package conformance
+
+import (
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	pairs := []struct {
+		name    string
+		private string
+		public  string
+	}{
+		{"rsa-pss", RSAPSSPrivateKey, RSAPSSPublicKey},
+		{"ecc-p256", ECCP256PrivateKey, ECCP256PublicKey},
+		{"ed25519", Ed25519PrivateKey, Ed25519PublicKey},
+	}
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			prv, err := httpsign.ParsePrivateKeyPEM([]byte(p.private))
+			assert.NoError(t, err)
+			pub, err := httpsign.ParsePublicKeyPEM([]byte(p.public))
+			assert.NoError(t, err)
+			k, ok := prv.(interface{ Public() crypto.PublicKey })
+			if assert.True(t, ok) {
+				assert.True(t, pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(k.Public()), "key pair mismatch")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	results := Run(Config{})
+	assert.Len(t, results, 2*len(Vectors()))
+	for _, r := range results {
+		assert.NoError(t, r.Err, r.String())
+	}
+}
+
+func TestRunWithConfig(t *testing.T) {
+	// The RFC's examples have no "alg" parameter, so they are rejected by a policy that requires one
+	results := Run(Config{
+		VerifyConfig: func(v Vector) *httpsign.VerifyConfig {
+			return httpsign.NewVerifyConfig().SetAllowedAlgs([]string{v.Alg})
+		},
+	})
+	for _, r := range results {
+		if r.Operation == OpVerify {
+			assert.Error(t, r.Err, r.String())
+		}
+	}
+
+	// Pinning the authority breaks the examples that cover it
+	results = Run(Config{
+		VerifyConfig: func(v Vector) *httpsign.VerifyConfig {
+			return httpsign.NewVerifyConfig().SetAuthority("example.org")
+		},
+	})
+	failed := map[string]bool{}
+	for _, r := range results {
+		if r.Operation == OpVerify && r.Err != nil {
+			failed[r.Vector.Name] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{"B.2.2": true, "B.2.3": true, "B.2.5": true, "B.2.6": true, "B.3": true}, failed)
+}
@@ -1,10 +1,11 @@
 package httpsign
 
 import (
-	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
@@ -13,9 +14,13 @@ import (
 	"fmt"
 	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jws"
+	"io"
 )
 
 // Signer includes a cryptographic key (typically a private key) and configuration of what needs to be signed.
+// A Signer is immutable and safe for concurrent use by multiple goroutines. It keeps its own copy of the SignConfig
+// it was created with, so that later changes to that configuration do not affect it.
+// Constructors reject fields that cannot be signed, e.g. an unknown derived component, see Fields.Validate.
 type Signer struct {
 	keyID         string
 	key           interface{}
@@ -34,9 +39,10 @@ func NewHMACSHA256Signer(keyID string, key []byte, config *SignConfig, fields Fi
 	if keyID == "" {
 		return nil, fmt.Errorf("keyID must not be empty")
 	}
-	if config == nil {
-		config = NewSignConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	return &Signer{
 		keyID:  keyID,
 		key:    key,
@@ -52,9 +58,10 @@ func NewRSASigner(keyID string, key rsa.PrivateKey, config *SignConfig, fields F
 	if keyID == "" {
 		return nil, fmt.Errorf("keyID must not be empty")
 	}
-	if config == nil {
-		config = NewSignConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	return &Signer{
 		keyID:  keyID,
 		key:    key,
@@ -70,9 +77,10 @@ func NewRSAPSSSigner(keyID string, key rsa.PrivateKey, config *SignConfig, field
 	if keyID == "" {
 		return nil, fmt.Errorf("keyID must not be empty")
 	}
-	if config == nil {
-		config = NewSignConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	return &Signer{
 		keyID:  keyID,
 		key:    key,
@@ -88,9 +96,10 @@ func NewP256Signer(keyID string, key ecdsa.PrivateKey, config *SignConfig, field
 	if keyID == "" {
 		return nil, fmt.Errorf("keyID must not be empty")
 	}
-	if config == nil {
-		config = NewSignConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	return &Signer{
 		keyID:  keyID,
 		key:    key,
@@ -100,18 +109,44 @@ func NewP256Signer(keyID string, key ecdsa.PrivateKey, config *SignConfig, field
 	}, nil
 }
 
+// NewP384Signer returns a new Signer structure. Key is an elliptic curve P-384 private key.
+// Config may be nil for a default configuration.
+func NewP384Signer(keyID string, key ecdsa.PrivateKey, config *SignConfig, fields Fields) (*Signer, error) {
+	if keyID == "" {
+		return nil, fmt.Errorf("keyID must not be empty")
+	}
+	if key.Curve != elliptic.P384() {
+		return nil, fmt.Errorf("key must be on the P-384 curve")
+	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	return &Signer{
+		keyID:  keyID,
+		key:    key,
+		alg:    "ecdsa-p384-sha384",
+		config: config,
+		fields: fields,
+	}, nil
+}
+
 // NewEd25519Signer returns a new Signer structure. Key is an EdDSA Curve 25519 private key.
 // Config may be nil for a default configuration.
 func NewEd25519Signer(keyID string, key ed25519.PrivateKey, config *SignConfig, fields Fields) (*Signer, error) {
 	if key == nil {
 		return nil, fmt.Errorf("key must not be nil")
 	}
+	if len(key) != ed25519.PrivateKeySize { // ed25519.Sign would panic
+		return nil, fmt.Errorf("key must have length %d", ed25519.PrivateKeySize)
+	}
 	if keyID == "" {
 		return nil, fmt.Errorf("keyID must not be empty")
 	}
-	if config == nil {
-		config = NewSignConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	return &Signer{
 		keyID:  keyID,
 		key:    key,
@@ -142,6 +177,9 @@ func NewJWSSigner(alg jwa.SignatureAlgorithm, keyID string, key interface{}, con
 	if alg == jwa.NoSignature {
 		return nil, fmt.Errorf("the NONE signing algorithm is expressly disallowed")
 	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
 	jwsSigner, err := jws.NewSigner(alg)
 	if err != nil {
 		return nil, err
@@ -150,57 +188,102 @@ func NewJWSSigner(alg jwa.SignatureAlgorithm, keyID string, key interface{}, con
 		keyID:         keyID,
 		key:           key,
 		alg:           "",
-		config:        config,
+		config:        config.Clone(),
 		fields:        fields,
 		foreignSigner: jwsSigner,
 	}, nil
 }
 
-func (s Signer) sign(buff []byte) ([]byte, error) {
+func (s Signer) sign(ctx context.Context, buff []byte) ([]byte, error) {
+	w, finish := s.newSigningWriter(ctx)
+	_, _ = w.Write(buff) // hash and buffer writes never fail
+	return finish()
+}
+
+// newSigningWriter returns a writer that the signature input can be streamed into, and a function
+// that computes the signature once the entire input was written. For algorithms that
+// hash their input, the input is never buffered. Ctx is passed to a ContextSigner.
+func (s Signer) newSigningWriter(ctx context.Context) (io.Writer, func() ([]byte, error)) {
 	if s.foreignSigner != nil {
-		switch signer := s.foreignSigner.(type) {
-		case jws.Signer:
-			{
+		return bufferedSigner(func(buff []byte) ([]byte, error) {
+			switch signer := s.foreignSigner.(type) {
+			case jws.Signer:
 				return signer.Sign(buff, s.key)
+			default:
+				return nil, fmt.Errorf("expected jws.Signer, got %T", s.foreignSigner)
 			}
-		default:
-			return nil, fmt.Errorf("expected jws.Signer, got %T", s.foreignSigner)
-		}
+		})
+	}
+	switch k := s.key.(type) {
+	case cryptoSigner:
+		return s.newCryptoSigningWriter(ctx, k)
+	case funcSigner:
+		return newFuncSigningWriter(k)
 	}
 	switch s.alg {
 	case "hmac-sha256":
 		mac := hmac.New(sha256.New, s.key.([]byte))
-		mac.Write(buff)
-		return mac.Sum(nil), nil
+		return mac, func() ([]byte, error) {
+			return mac.Sum(nil), nil
+		}
 	case "rsa-v1_5-sha256":
-		hashed := sha256.Sum256(buff)
-		key := s.key.(rsa.PrivateKey)
-		sig, err := rsa.SignPKCS1v15(nil, &key, crypto.SHA256, hashed[:])
-		if err != nil {
-			return nil, fmt.Errorf("RSA signature failed")
+		h := sha256.New()
+		return h, func() ([]byte, error) {
+			key := s.key.(rsa.PrivateKey)
+			sig, err := rsa.SignPKCS1v15(nil, &key, crypto.SHA256, h.Sum(nil))
+			if err != nil {
+				return nil, fmt.Errorf("RSA signature failed")
+			}
+			return sig, nil
 		}
-		return sig, nil
 	case "rsa-pss-sha512":
-		hashed := sha512.Sum512(buff)
-		key := s.key.(rsa.PrivateKey)
-		sig, err := rsa.SignPSS(rand.Reader, &key, crypto.SHA512, hashed[:], nil)
-		if err != nil {
-			return nil, fmt.Errorf("RSA-PSS signature failed")
+		h := sha512.New()
+		return h, func() ([]byte, error) {
+			key := s.key.(rsa.PrivateKey)
+			sig, err := rsa.SignPSS(rand.Reader, &key, crypto.SHA512, h.Sum(nil), nil)
+			if err != nil {
+				return nil, fmt.Errorf("RSA-PSS signature failed")
+			}
+			return sig, nil
 		}
-		return sig, nil
 	case "ecdsa-p256-sha256":
-		hashed := sha256.Sum256(buff)
-		key := s.key.(ecdsa.PrivateKey)
-		return ecdsaSignRaw(rand.Reader, &key, hashed[:])
-	case "ed25519":
-		key := s.key.(ed25519.PrivateKey)
-		return ed25519.Sign(key, buff), nil
+		h := sha256.New()
+		return h, func() ([]byte, error) {
+			key := s.key.(ecdsa.PrivateKey)
+			return ecdsaSignRaw(rand.Reader, &key, h.Sum(nil))
+		}
+	case "ecdsa-p384-sha384":
+		h := sha512.New384()
+		return h, func() ([]byte, error) {
+			key := s.key.(ecdsa.PrivateKey)
+			return ecdsaSignRaw(rand.Reader, &key, h.Sum(nil))
+		}
+	case "ed25519": // EdDSA hashes its input twice, so it cannot be streamed
+		return bufferedSigner(func(buff []byte) ([]byte, error) {
+			key := s.key.(ed25519.PrivateKey)
+			return ed25519.Sign(key, buff), nil
+		})
 	default:
-		return nil, fmt.Errorf("sign: unknown algorithm \"%s\"", s.alg)
+		return bufferedSigner(func([]byte) ([]byte, error) {
+			return nil, fmt.Errorf("sign: unknown algorithm \"%s\"", s.alg)
+		})
+	}
+}
+
+// bufferedSigner collects the signature input for algorithms that cannot be streamed. The buffer is pooled,
+// so sign must not retain its input.
+func bufferedSigner(sign func([]byte) ([]byte, error)) (io.Writer, func() ([]byte, error)) {
+	buff := getBuffer()
+	return buff, func() ([]byte, error) {
+		defer putBuffer(buff)
+		return sign(buff.Bytes())
 	}
 }
 
 // Verifier includes a cryptographic key (typically a public key) and configuration of what needs to be verified.
+// A Verifier is immutable and safe for concurrent use by multiple goroutines, provided its ReplayCache, Metrics, Tracer
+// and Logger are. It keeps its own copy of the VerifyConfig it was created with.
+// Constructors reject invalid required fields, see Fields.Validate.
 type Verifier struct {
 	keyID           string
 	key             interface{}
@@ -219,9 +302,10 @@ func NewHMACSHA256Verifier(keyID string, key []byte, config *VerifyConfig, field
 	if len(key) < 64 {
 		return nil, fmt.Errorf("key must be at least 64 bytes long")
 	}
-	if config == nil {
-		config = NewVerifyConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -237,9 +321,10 @@ func NewHMACSHA256Verifier(keyID string, key []byte, config *VerifyConfig, field
 // NewRSAVerifier generates a new Verifier for RSA signatures. Set config to nil for a default configuration.
 // Fields is the list of required headers and fields, which may be empty (but this is typically insecure).
 func NewRSAVerifier(keyID string, key rsa.PublicKey, config *VerifyConfig, fields Fields) (*Verifier, error) {
-	if config == nil {
-		config = NewVerifyConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -255,9 +340,10 @@ func NewRSAVerifier(keyID string, key rsa.PublicKey, config *VerifyConfig, field
 // NewRSAPSSVerifier generates a new Verifier for RSA-PSS signatures. Set config to nil for a default configuration.
 // Fields is the list of required headers and fields, which may be empty (but this is typically insecure).
 func NewRSAPSSVerifier(keyID string, key rsa.PublicKey, config *VerifyConfig, fields Fields) (*Verifier, error) {
-	if config == nil {
-		config = NewVerifyConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -273,9 +359,10 @@ func NewRSAPSSVerifier(keyID string, key rsa.PublicKey, config *VerifyConfig, fi
 // NewP256Verifier generates a new Verifier for ECDSA (P-256) signatures. Set config to nil for a default configuration.
 // Fields is the list of required headers and fields, which may be empty (but this is typically insecure).
 func NewP256Verifier(keyID string, key ecdsa.PublicKey, config *VerifyConfig, fields Fields) (*Verifier, error) {
-	if config == nil {
-		config = NewVerifyConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -288,15 +375,41 @@ func NewP256Verifier(keyID string, key ecdsa.PublicKey, config *VerifyConfig, fi
 	}, nil
 }
 
+// NewP384Verifier generates a new Verifier for ECDSA (P-384) signatures. Set config to nil for a default configuration.
+// Fields is the list of required headers and fields, which may be empty (but this is typically insecure).
+func NewP384Verifier(keyID string, key ecdsa.PublicKey, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	if key.Curve != elliptic.P384() {
+		return nil, fmt.Errorf("key must be on the P-384 curve")
+	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	if config.verifyKeyID && keyID == "" {
+		return nil, fmt.Errorf("keyID should not be empty")
+	}
+	return &Verifier{
+		keyID:  keyID,
+		key:    key,
+		alg:    "ecdsa-p384-sha384",
+		config: config,
+		fields: fields,
+	}, nil
+}
+
 // NewEd25519Verifier generates a new Verifier for EdDSA Curve 25519 signatures. Set config to nil for a default configuration.
 // Fields is the list of required headers and fields, which may be empty (but this is typically insecure).
 func NewEd25519Verifier(keyID string, key ed25519.PublicKey, config *VerifyConfig, fields Fields) (*Verifier, error) {
 	if key == nil {
 		return nil, fmt.Errorf("key must not be nil")
 	}
-	if config == nil {
-		config = NewVerifyConfig()
+	if len(key) != ed25519.PublicKeySize { // ed25519.Verify would panic
+		return nil, fmt.Errorf("key must have length %d", ed25519.PublicKeySize)
 	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -316,9 +429,10 @@ func NewJWSVerifier(alg jwa.SignatureAlgorithm, key interface{}, keyID string, c
 	if key == nil {
 		return nil, fmt.Errorf("key must not be nil")
 	}
-	if config == nil {
-		config = NewVerifyConfig()
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
 	}
+	config = config.Clone()
 	if config.verifyKeyID && keyID == "" {
 		return nil, fmt.Errorf("keyID should not be empty")
 	}
@@ -340,52 +454,87 @@ func NewJWSVerifier(alg jwa.SignatureAlgorithm, key interface{}, keyID string, c
 }
 
 func (v Verifier) verify(buff []byte, sig []byte) (bool, error) {
+	w, finish := v.newVerifyingWriter()
+	_, _ = w.Write(buff) // hash and buffer writes never fail
+	return finish(sig)
+}
+
+// newVerifyingWriter is the verification counterpart of newSigningWriter.
+func (v Verifier) newVerifyingWriter() (io.Writer, func(sig []byte) (bool, error)) {
 	if v.foreignVerifier != nil {
-		switch verifier := v.foreignVerifier.(type) {
-		case jws.Verifier:
-			err := verifier.Verify(buff, sig, v.key)
-			if err != nil {
-				return false, err
+		return bufferedVerifier(func(buff, sig []byte) (bool, error) {
+			switch verifier := v.foreignVerifier.(type) {
+			case jws.Verifier:
+				err := verifier.Verify(buff, sig, v.key)
+				if err != nil {
+					return false, err
+				}
+				return true, nil
+			default:
+				return false, fmt.Errorf("expected jws.Verifier, got %T", v.foreignVerifier)
 			}
-			return true, nil
-		default:
-			return false, fmt.Errorf("expected jws.Verifier, got %T", v.foreignVerifier)
-		}
+		})
 	}
 
 	switch v.alg {
 	case "hmac-sha256":
 		mac := hmac.New(sha256.New, v.key.([]byte))
-		mac.Write(buff)
-		return bytes.Equal(mac.Sum(nil), sig), nil
+		return mac, func(sig []byte) (bool, error) {
+			return hmac.Equal(mac.Sum(nil), sig), nil
+		}
 	case "rsa-v1_5-sha256":
-		hashed := sha256.Sum256(buff)
-		key := v.key.(rsa.PublicKey)
-		err := rsa.VerifyPKCS1v15(&key, crypto.SHA256, hashed[:], sig)
-		if err != nil {
-			return false, fmt.Errorf("RSA verification failed: %w", err)
+		h := sha256.New()
+		return h, func(sig []byte) (bool, error) {
+			key := v.key.(rsa.PublicKey)
+			err := rsa.VerifyPKCS1v15(&key, crypto.SHA256, h.Sum(nil), sig)
+			if err != nil {
+				return false, fmt.Errorf("RSA verification failed: %w", err)
+			}
+			return true, nil
 		}
-		return true, nil
 	case "rsa-pss-sha512":
-		hashed := sha512.Sum512(buff)
-		key := v.key.(rsa.PublicKey)
-		err := rsa.VerifyPSS(&key, crypto.SHA512, hashed[:], sig, nil)
-		if err != nil {
-			return false, fmt.Errorf("RSA-PSS verification failed: %w", err)
+		h := sha512.New()
+		return h, func(sig []byte) (bool, error) {
+			key := v.key.(rsa.PublicKey)
+			err := rsa.VerifyPSS(&key, crypto.SHA512, h.Sum(nil), sig, nil)
+			if err != nil {
+				return false, fmt.Errorf("RSA-PSS verification failed: %w", err)
+			}
+			return true, nil
 		}
-		return true, nil
 	case "ecdsa-p256-sha256":
-		hashed := sha256.Sum256(buff)
-		key := v.key.(ecdsa.PublicKey)
-		return ecdsaVerifyRaw(&key, hashed[:], sig)
-	case "ed25519":
-		key := v.key.(ed25519.PublicKey)
-		verified := ed25519.Verify(key, buff, sig)
-		if !verified {
-			return false, fmt.Errorf("failed Ed25519 verification")
+		h := sha256.New()
+		return h, func(sig []byte) (bool, error) {
+			key := v.key.(ecdsa.PublicKey)
+			return ecdsaVerifyRaw(&key, h.Sum(nil), sig)
 		}
-		return true, nil
+	case "ecdsa-p384-sha384":
+		h := sha512.New384()
+		return h, func(sig []byte) (bool, error) {
+			key := v.key.(ecdsa.PublicKey)
+			return ecdsaVerifyRaw(&key, h.Sum(nil), sig)
+		}
+	case "ed25519":
+		return bufferedVerifier(func(buff, sig []byte) (bool, error) {
+			key := v.key.(ed25519.PublicKey)
+			verified := ed25519.Verify(key, buff, sig)
+			if !verified {
+				return false, fmt.Errorf("failed Ed25519 verification")
+			}
+			return true, nil
+		})
 	default:
-		return false, fmt.Errorf("verify: unknown algorithm \"%s\"", v.alg)
+		return bufferedVerifier(func(_, _ []byte) (bool, error) {
+			return false, fmt.Errorf("verify: unknown algorithm \"%s\"", v.alg)
+		})
+	}
+}
+
+// bufferedVerifier is the verification counterpart of bufferedSigner. Verify must not retain its input.
+func bufferedVerifier(verify func(buff, sig []byte) (bool, error)) (io.Writer, func(sig []byte) (bool, error)) {
+	buff := getBuffer()
+	return buff, func(sig []byte) (bool, error) {
+		defer putBuffer(buff)
+		return verify(buff.Bytes(), sig)
 	}
 }
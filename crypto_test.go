@@ -1,6 +1,7 @@
 package httpsign
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"github.com/lestrrat-go/jwx/jwa"
@@ -114,7 +115,7 @@ func TestSigner_sign(t *testing.T) {
 				key:   tt.fields.key,
 				alg:   tt.fields.alg,
 			}
-			got, err := s.sign(tt.args.buff)
+			got, err := s.sign(context.Background(), tt.args.buff)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("sign() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -177,7 +178,7 @@ func TestForeignSigner(t *testing.T) {
 		t.Errorf("Failed to generate keypair: %v", err)
 	}
 
-	config := NewSignConfig().setFakeCreated(1618884475).SignAlg(false)
+	config := NewSignConfig().SetCreated(1618884475).SignAlg(false)
 	signatureName := "sig1"
 	fields := *NewFields().AddHeader("@method").AddHeader("date").AddHeader("content-type").AddQueryParam("pet")
 	signer, err := NewJWSSigner(jwa.ES256, "key1", priv, config, fields)
@@ -330,3 +331,44 @@ func TestNewJWSVerifier(t *testing.T) {
 		})
 	}
 }
+
+func TestSigningWriter(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to gen private key")
+	}
+	hmacKey := []byte(strings.Repeat("a", 64))
+	tests := []struct {
+		name     string
+		signer   Signer
+		verifier Verifier
+	}{
+		{
+			name:     "hmac-sha256",
+			signer:   Signer{key: hmacKey, alg: "hmac-sha256"},
+			verifier: Verifier{key: hmacKey, alg: "hmac-sha256"},
+		},
+		{
+			name:     "rsa-pss-sha512",
+			signer:   Signer{key: *privateKey, alg: "rsa-pss-sha512"},
+			verifier: Verifier{key: privateKey.PublicKey, alg: "rsa-pss-sha512"},
+		},
+	}
+	input := strings.Repeat("\"x-header\": some value\n", 1000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, finish := tt.signer.newSigningWriter(context.Background())
+			for _, line := range strings.SplitAfter(input, "\n") {
+				_, _ = w.Write([]byte(line))
+			}
+			sig, err := finish()
+			if err != nil {
+				t.Fatalf("streamed signing failed: %v", err)
+			}
+			verified, err := tt.verifier.verify([]byte(input), sig)
+			if !verified || err != nil {
+				t.Errorf("streamed signature does not verify: %v", err)
+			}
+		})
+	}
+}
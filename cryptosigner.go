@@ -0,0 +1,196 @@
+package httpsign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// cryptoSigner marks a Signer's key as an opaque crypto.Signer, rather than raw key material
+type cryptoSigner struct {
+	crypto.Signer
+}
+
+// ContextSigner is optionally implemented by a crypto.Signer passed to NewSignerFromCryptoSigner, typically one
+// that calls a remote service such as a cloud KMS. SignContext is then called instead of Sign, with the context
+// of the message being signed (http.Request.Context, or for a response, the context of its request), so that
+// the call is canceled along with the request.
+type ContextSigner interface {
+	crypto.Signer
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// SignFunc computes a signature using an opaque key, e.g. one that is accessed through PKCS#11 or a TPM.
+// The input is a digest of the signature base computed with hash, or the signature base itself if hash is zero
+// (for tokens that hash the data themselves). The signature must be in the format defined for the algorithm
+// by RFC 9421, e.g. r || s for ECDSA, which is also the output of the PKCS#11 CKM_ECDSA mechanism.
+type SignFunc func(input []byte, hash crypto.Hash) ([]byte, error)
+
+// funcSigner marks a Signer's key as a signing callback
+type funcSigner struct {
+	sign SignFunc
+	hash crypto.Hash
+}
+
+// algHashes are the hash functions of the registered asymmetric algorithms. HMAC and Ed25519 are computed over
+// the signature base itself, never over a digest of it.
+var algHashes = map[string]crypto.Hash{
+	"rsa-v1_5-sha256":   crypto.SHA256,
+	"rsa-pss-sha512":    crypto.SHA512,
+	"ecdsa-p256-sha256": crypto.SHA256,
+	"ecdsa-p384-sha384": crypto.SHA384,
+}
+
+// NewSignerFromFunc returns a new Signer structure that delegates signing to a callback, for keys that are
+// not available as a crypto.Signer. Hash selects the hash function applied to the signature base before
+// it is passed to the callback, and may be zero for no hashing. For registered algorithms, it must be either
+// zero or the algorithm's hash, and it must be zero for "ed25519" and "hmac-sha256", e.g. for a token that
+// computes an HMAC with CKM_SHA256_HMAC. Alg may also be an unregistered algorithm name, agreed upon with the peer.
+// Config may be nil for a default configuration.
+func NewSignerFromFunc(alg, keyID string, hash crypto.Hash, sign SignFunc, config *SignConfig, fields Fields) (*Signer, error) {
+	if sign == nil {
+		return nil, fmt.Errorf("sign function must not be nil")
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("keyID must not be empty")
+	}
+	if alg == "" {
+		return nil, fmt.Errorf("alg must not be empty")
+	}
+	if hash != 0 {
+		if !hash.Available() {
+			return nil, fmt.Errorf("hash function %v is not available", hash)
+		}
+		if want, ok := algHashes[alg]; (ok && hash != want) || alg == "ed25519" || alg == "hmac-sha256" {
+			return nil, fmt.Errorf("hash function %v does not match algorithm \"%s\"", hash, alg)
+		}
+	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	return &Signer{
+		keyID:  keyID,
+		key:    funcSigner{sign: sign, hash: hash},
+		alg:    alg,
+		config: config,
+		fields: fields,
+	}, nil
+}
+
+// newFuncSigningWriter is the equivalent of newSigningWriter, for a key that is a signing callback
+func newFuncSigningWriter(fs funcSigner) (io.Writer, func() ([]byte, error)) {
+	if fs.hash == 0 {
+		return bufferedSigner(func(buff []byte) ([]byte, error) {
+			return fs.sign(append([]byte(nil), buff...), 0) // the callback may retain the message
+		})
+	}
+	h := fs.hash.New()
+	return h, func() ([]byte, error) {
+		return fs.sign(h.Sum(nil), fs.hash)
+	}
+}
+
+// NewSignerFromCryptoSigner returns a new Signer structure, for a private key that is only accessible
+// through the crypto.Signer interface, such as a key held in an HSM, a TPM or a smartcard.
+// Alg is one of "rsa-v1_5-sha256", "rsa-pss-sha512", "ecdsa-p256-sha256", "ecdsa-p384-sha384" and "ed25519",
+// and must match the type of the signer's public key.
+// Config may be nil for a default configuration.
+func NewSignerFromCryptoSigner(alg, keyID string, signer crypto.Signer, config *SignConfig, fields Fields) (*Signer, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer must not be nil")
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("keyID must not be empty")
+	}
+	if err := checkCryptoSignerAlg(alg, signer.Public()); err != nil {
+		return nil, err
+	}
+	if err := fields.validateComponents(); err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	return &Signer{
+		keyID:  keyID,
+		key:    cryptoSigner{signer},
+		alg:    alg,
+		config: config,
+		fields: fields,
+	}, nil
+}
+
+func checkCryptoSignerAlg(alg string, pub crypto.PublicKey) error {
+	ok := false
+	switch alg {
+	case "rsa-v1_5-sha256", "rsa-pss-sha512":
+		_, ok = pub.(*rsa.PublicKey)
+	case "ecdsa-p256-sha256":
+		k, isECDSA := pub.(*ecdsa.PublicKey)
+		ok = isECDSA && k.Curve == elliptic.P256()
+	case "ecdsa-p384-sha384":
+		k, isECDSA := pub.(*ecdsa.PublicKey)
+		ok = isECDSA && k.Curve == elliptic.P384()
+	case "ed25519":
+		_, ok = pub.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("unsupported algorithm \"%s\"", alg)
+	}
+	if !ok {
+		return fmt.Errorf("public key of type %T does not match algorithm \"%s\"", pub, alg)
+	}
+	return nil
+}
+
+// newCryptoSigningWriter is the equivalent of newSigningWriter, for a key that is a crypto.Signer
+func (s Signer) newCryptoSigningWriter(ctx context.Context, cs cryptoSigner) (io.Writer, func() ([]byte, error)) {
+	sign := cs.Sign
+	if c, ok := cs.Signer.(ContextSigner); ok {
+		sign = func(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+			return c.SignContext(ctx, rand, digest, opts)
+		}
+	}
+	hashed := func(h hash.Hash, opts crypto.SignerOpts, post func([]byte) ([]byte, error)) (io.Writer, func() ([]byte, error)) {
+		return h, func() ([]byte, error) {
+			sig, err := sign(rand.Reader, h.Sum(nil), opts)
+			if err != nil {
+				return nil, fmt.Errorf("crypto.Signer failed: %w", err)
+			}
+			return post(sig)
+		}
+	}
+	asIs := func(sig []byte) ([]byte, error) { return sig, nil }
+	toRaw := func(sig []byte) ([]byte, error) { // crypto.Signer returns ASN.1 ECDSA signatures
+		return ecdsaASN1ToRaw(cs.Public().(*ecdsa.PublicKey).Params().Name, sig)
+	}
+	switch s.alg {
+	case "rsa-v1_5-sha256":
+		return hashed(sha256.New(), crypto.SHA256, asIs)
+	case "rsa-pss-sha512":
+		return hashed(sha512.New(), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}, asIs)
+	case "ecdsa-p256-sha256":
+		return hashed(sha256.New(), crypto.SHA256, toRaw)
+	case "ecdsa-p384-sha384":
+		return hashed(sha512.New384(), crypto.SHA384, toRaw)
+	case "ed25519": // the message is passed unhashed
+		return bufferedSigner(func(buff []byte) ([]byte, error) {
+			sig, err := sign(rand.Reader, buff, crypto.Hash(0))
+			if err != nil {
+				return nil, fmt.Errorf("crypto.Signer failed: %w", err)
+			}
+			return sig, nil
+		})
+	default:
+		return bufferedSigner(func([]byte) ([]byte, error) {
+			return nil, fmt.Errorf("sign: unknown algorithm \"%s\"", s.alg)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+//go:build softhsm
+// +build softhsm
+
+package httpsign
+
+// This integration test signs with a key generated inside a SoftHSM token, to check NewSignerFromCryptoSigner
+// against a real PKCS#11 device. To avoid a dependency on a PKCS#11 binding, the key is used through the
+// pkcs11-tool command of OpenSC. It needs softhsm2-util and pkcs11-tool, and runs with:
+//
+//	go test -tags softhsm -run TestSoftHSM .
+//
+// SOFTHSM2_MODULE is the path of the SoftHSM PKCS#11 library, by default /usr/lib/softhsm/libsofthsm2.so.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const softHSMPin = "1234"
+
+// softHSMSigner is a crypto.Signer for a P-256 key held in a SoftHSM token
+type softHSMSigner struct {
+	module string
+	dir    string
+	pub    crypto.PublicKey
+}
+
+func (s softHSMSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s softHSMSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	in, out := filepath.Join(s.dir, "digest"), filepath.Join(s.dir, "signature")
+	if err := os.WriteFile(in, digest, 0600); err != nil {
+		return nil, err
+	}
+	// The "openssl" format is the ASN.1 encoding returned by crypto.Signer implementations for ECDSA
+	if err := s.tool("--sign", "--mechanism", "ECDSA", "--signature-format", "openssl",
+		"--input-file", in, "--output-file", out); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+func (s softHSMSigner) tool(args ...string) error {
+	args = append([]string{"--module", s.module, "--token-label", "httpsign", "--login", "--pin", softHSMPin,
+		"--id", "01"}, args...)
+	return runTool("pkcs11-tool", args...)
+}
+
+func runTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+func newSoftHSMSigner(t *testing.T) softHSMSigner {
+	for _, tool := range []string{"softhsm2-util", "pkcs11-tool"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		module = "/usr/lib/softhsm/libsofthsm2.so"
+	}
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	if err := runTool("softhsm2-util", "--init-token", "--free", "--label", "httpsign",
+		"--so-pin", softHSMPin, "--pin", softHSMPin); err != nil {
+		t.Fatal(err)
+	}
+	s := softHSMSigner{module: module, dir: dir}
+	if err := s.tool("--keypairgen", "--key-type", "EC:prime256v1", "--label", "httpsign"); err != nil {
+		t.Fatal(err)
+	}
+	der := filepath.Join(dir, "public.der")
+	if err := s.tool("--read-object", "--type", "pubkey", "--output-file", der); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.pub, err = x509.ParsePKIXPublicKey(b); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSoftHSM(t *testing.T) {
+	hsm := newSoftHSMSigner(t)
+	pub, ok := hsm.pub.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected an ECDSA public key, got %T", hsm.pub)
+	}
+	fields := Headers("@method", "date", "content-type")
+	signer, err := NewSignerFromCryptoSigner("ecdsa-p256-sha256", "hsm", hsm, nil, fields)
+	assert.NoError(t, err)
+	verifier, err := NewP256Verifier("hsm", *pub, NewVerifyConfig().SetVerifyCreated(false), fields)
+	assert.NoError(t, err)
+
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	req.Header.Set("Content-Type", "text/plain")
+	assert.ErrorIs(t, VerifyRequest("sig1", *verifier, req), ErrBadSignature, "the signature covers content-type")
+}
@@ -0,0 +1,173 @@
+package httpsign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// opaqueSigner hides the concrete key type, like an HSM-backed crypto.Signer would
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestNewSignerFromCryptoSigner(t *testing.T) {
+	rsaKey, err := parseRsaPrivateKeyFromPemStr(rsaPrvKey)
+	assert.NoError(t, err)
+	p256Key, err := parseECPrivateKeyFromPemStr(p256PrvKey)
+	assert.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	fields := Headers("@method", "date", "content-type")
+	config := NewVerifyConfig().SetVerifyCreated(false)
+	rsaVerifier, _ := NewRSAVerifier("key", rsaKey.PublicKey, config, fields)
+	pssVerifier, _ := NewRSAPSSVerifier("key", rsaKey.PublicKey, config, fields)
+	p256Verifier, _ := NewP256Verifier("key", p256Key.PublicKey, config, fields)
+	p384Verifier, _ := NewP384Verifier("key", p384Key.PublicKey, config, fields)
+	edVerifier, _ := NewEd25519Verifier("key", edPub, config, fields)
+
+	tests := []struct {
+		name     string
+		alg      string
+		signer   crypto.Signer
+		verifier *Verifier
+		wantErr  bool
+	}{
+		{"RSA", "rsa-v1_5-sha256", rsaKey, rsaVerifier, false},
+		{"RSA-PSS", "rsa-pss-sha512", rsaKey, pssVerifier, false},
+		{"P-256", "ecdsa-p256-sha256", p256Key, p256Verifier, false},
+		{"P-384", "ecdsa-p384-sha384", p384Key, p384Verifier, false},
+		{"Ed25519", "ed25519", edKey, edVerifier, false},
+		{"wrong curve", "ecdsa-p384-sha384", p256Key, nil, true},
+		{"wrong key type", "ed25519", rsaKey, nil, true},
+		{"unsupported alg", "hmac-sha256", rsaKey, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewSignerFromCryptoSigner(tt.alg, "key", opaqueSigner{tt.signer}, nil, fields)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			req := readRequest(httpreq2)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature", sig)
+			req.Header.Add("Signature-Input", sigInput)
+			assert.NoError(t, VerifyRequest("sig1", *tt.verifier, req))
+		})
+	}
+}
+
+// contextSigner records the context it is called with, like a signer that calls a remote service
+type contextSigner struct {
+	crypto.Signer
+	got context.Context
+}
+
+func (s *contextSigner) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.got = ctx
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestContextSigner(t *testing.T) {
+	key, err := parseECPrivateKeyFromPemStr(p256PrvKey)
+	assert.NoError(t, err)
+	cs := &contextSigner{Signer: key}
+	signer, err := NewSignerFromCryptoSigner("ecdsa-p256-sha256", "key1", cs, nil, Headers("@method"))
+	assert.NoError(t, err)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	req := readRequest(httpreq1).WithContext(ctx)
+	_, _, err = SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	if assert.NotNil(t, cs.got) {
+		assert.Equal(t, "request", cs.got.Value(ctxKey{}), "the request context should be passed to the signer")
+	}
+
+	cs.got = nil
+	signer, err = NewSignerFromCryptoSigner("ecdsa-p256-sha256", "key1", cs, nil, Headers("@status"))
+	assert.NoError(t, err)
+	res := readResponse(httpres1)
+	res.Request = req
+	_, _, err = SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	if assert.NotNil(t, cs.got) {
+		assert.Equal(t, "request", cs.got.Value(ctxKey{}), "a response is signed with the context of its request")
+	}
+}
+
+func TestNewSignerFromFunc(t *testing.T) {
+	p256Key, err := parseECPrivateKeyFromPemStr(p256PrvKey)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	// token that signs a digest and returns r || s, like PKCS#11 CKM_ECDSA
+	ecdsaToken := func(digest []byte, hash crypto.Hash) ([]byte, error) {
+		assert.Equal(t, crypto.SHA256, hash)
+		return ecdsaSignRaw(rand.Reader, p256Key, digest)
+	}
+	// token that hashes the data itself
+	edToken := func(data []byte, hash crypto.Hash) ([]byte, error) {
+		assert.Equal(t, crypto.Hash(0), hash)
+		return ed25519.Sign(edKey, data), nil
+	}
+	// token that computes an HMAC over the data, like PKCS#11 CKM_SHA256_HMAC
+	hmacKey := make([]byte, 64)
+	hmacToken := func(data []byte, hash crypto.Hash) ([]byte, error) {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	}
+	fields := Headers("@method", "date", "content-type")
+	config := NewVerifyConfig().SetVerifyCreated(false)
+	p256Verifier, _ := NewP256Verifier("key", p256Key.PublicKey, config, fields)
+	edVerifier, _ := NewEd25519Verifier("key", edKey.Public().(ed25519.PublicKey), config, fields)
+	hmacVerifier, _ := NewHMACSHA256Verifier("key", hmacKey, config, fields)
+
+	tests := []struct {
+		name     string
+		alg      string
+		hash     crypto.Hash
+		sign     SignFunc
+		verifier *Verifier
+		wantErr  bool
+	}{
+		{"P-256 prehashed", "ecdsa-p256-sha256", crypto.SHA256, ecdsaToken, p256Verifier, false},
+		{"Ed25519 unhashed", "ed25519", 0, edToken, edVerifier, false},
+		{"hash mismatch", "ecdsa-p256-sha256", crypto.SHA384, ecdsaToken, nil, true},
+		{"hashed Ed25519", "ed25519", crypto.SHA512, edToken, nil, true},
+		{"HMAC unhashed", "hmac-sha256", 0, hmacToken, hmacVerifier, false},
+		{"hashed HMAC", "hmac-sha256", crypto.SHA256, hmacToken, nil, true},
+		{"nil callback", "ed25519", 0, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewSignerFromFunc(tt.alg, "key", tt.hash, tt.sign, nil, fields)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			req := readRequest(httpreq2)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature", sig)
+			req.Header.Add("Signature-Input", sigInput)
+			assert.NoError(t, VerifyRequest("sig1", *tt.verifier, req))
+		})
+	}
+}
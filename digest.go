@@ -0,0 +1,224 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"github.com/dunglas/httpsfv"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Digest algorithms for the Content-Digest header (RFC 9530)
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+func newDigestHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm \"%s\"", alg)
+}
+
+func digestBytes(alg string, body []byte) ([]byte, error) {
+	h, err := newDigestHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(body)
+	return h.Sum(nil), nil
+}
+
+// ContentDigest returns the value of a Content-Digest (or Repr-Digest) header for the given content,
+// e.g. "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func ContentDigest(alg string, body []byte) (string, error) {
+	d, err := digestBytes(alg, body)
+	if err != nil {
+		return "", err
+	}
+	return digestValue(alg, d)
+}
+
+func digestValue(alg string, d []byte) (string, error) {
+	dict := httpsfv.NewDictionary()
+	dict.Add(alg, httpsfv.NewItem(d))
+	return httpsfv.Marshal(dict)
+}
+
+// maxBodyPrealloc bounds the buffer allocated for a body ahead of reading it, since the declared
+// Content-Length of an incoming message cannot be trusted
+const maxBodyPrealloc = 1 << 20
+
+// readBody reads a message body in full, and replaces it with a reader over the same bytes.
+// Size is the declared length of the body, or -1 if unknown.
+func readBody(body *io.ReadCloser, size int64) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	if size < 0 || size > maxBodyPrealloc {
+		size = 0
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead)) // room to read EOF without growing
+	_, err := buf.ReadFrom(*body)
+	_ = (*body).Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read message body: %w", err)
+	}
+	b := buf.Bytes()
+	*body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// RepresentationFunc returns the selected representation data of a message, given its headers and content,
+// e.g. by decoding the content according to Content-Encoding. It is used for the Repr-Digest header,
+// and returns false if the representation is not available, e.g. for a partial response.
+type RepresentationFunc func(header http.Header, content []byte) ([]byte, bool, error)
+
+// representation returns the selected representation data. By default, it is only known when
+// the content is neither encoded nor ranged.
+func representation(f RepresentationFunc, header http.Header, content []byte) ([]byte, bool, error) {
+	if f != nil {
+		return f(header, content)
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return nil, false, nil
+	}
+	return content, true, nil
+}
+
+// addDigests generates the Content-Digest and Repr-Digest headers if the signer covers them and the message
+// does not have them. If the body was read, it is returned.
+func addDigests(ctx context.Context, config SignConfig, fields Fields, header http.Header,
+	body *io.ReadCloser, size int64) (b []byte, read bool, err error) {
+	content := fields.coversName("content-digest") && header.Get("Content-Digest") == ""
+	repr := fields.coversName("repr-digest") && header.Get("Repr-Digest") == ""
+	if !content && !repr {
+		return nil, false, nil
+	}
+	_, span := startSpan(ctx, config.tracer, SpanDigest)
+	defer func() { span.End(err) }()
+	b, err = readBody(body, size)
+	if err != nil {
+		return nil, false, err
+	}
+	alg := config.digestAlgorithm
+	if alg == "" {
+		alg = DigestSHA256
+	}
+	if content {
+		digest, err := ContentDigest(alg, b)
+		if err != nil {
+			return nil, false, err
+		}
+		header.Set("Content-Digest", digest)
+	}
+	if repr {
+		r, ok, err := representation(config.representation, header, b)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not determine the representation: %w", err)
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("cannot generate \"repr-digest\" for encoded or partial content, set the header explicitly")
+		}
+		digest, err := ContentDigest(alg, r)
+		if err != nil {
+			return nil, false, err
+		}
+		header.Set("Repr-Digest", digest)
+	}
+	return b, true, nil
+}
+
+// verifyDigests checks the covered Content-Digest and Repr-Digest headers, and Content-Digest trailer,
+// against the message body.
+// Repr-Digest is skipped if the representation is not available.
+func verifyDigests(config VerifyConfig, fields Fields, message parsedMessage) error {
+	if !coversDigest(fields) {
+		return nil
+	}
+	content := fields.coversName("content-digest")
+	contentTrailer := fields.coversTrailer("content-digest")
+	repr := fields.coversName("repr-digest")
+	if message.body == nil {
+		return fmt.Errorf("message body is not available to check the digest")
+	}
+	body, err := readBody(message.body, message.contentLength)
+	if err != nil {
+		return err
+	}
+	if content {
+		if err = verifyDigest("content-digest", message.headers, body); err != nil {
+			return err
+		}
+	}
+	if contentTrailer { // the body has been read, so the trailers are complete
+		if err = verifyDigest("content-digest", normalizeHeaderNames(message.trailers), body); err != nil {
+			return err
+		}
+	}
+	if repr {
+		header := http.Header{}
+		for k, v := range message.headers {
+			header[http.CanonicalHeaderKey(k)] = v
+		}
+		r, ok, err := representation(config.representation, header, body)
+		if err != nil {
+			return fmt.Errorf("could not determine the representation: %w", err)
+		}
+		if ok {
+			return verifyDigest("repr-digest", message.headers, r)
+		}
+	}
+	return nil
+}
+
+// coversDigest returns true if the fields include a digest header or trailer that verifyDigests would check
+func coversDigest(fields Fields) bool {
+	return fields.coversName("content-digest") || fields.coversTrailer("content-digest") || fields.coversName("repr-digest")
+}
+
+// verifyDigest checks a digest header against the data. Digests with
+// unsupported algorithms are ignored, but at least one digest must be supported.
+func verifyDigest(name string, headers http.Header, data []byte) error {
+	vals, found := headers[name]
+	if !found {
+		return fmt.Errorf("missing \"%s\" header", name)
+	}
+	dict, err := httpsfv.UnmarshalDictionary(vals)
+	if err != nil {
+		return fmt.Errorf("malformed \"%s\" header: %w", name, err)
+	}
+	checked := false
+	for _, alg := range dict.Names() {
+		member, _ := dict.Get(alg)
+		item, ok := member.(httpsfv.Item)
+		if !ok {
+			return fmt.Errorf("malformed \"%s\" header", name)
+		}
+		want, ok := item.Value.([]byte)
+		if !ok {
+			return fmt.Errorf("malformed \"%s\" header", name)
+		}
+		got, err := digestBytes(alg, data)
+		if err != nil {
+			continue // unsupported algorithm
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return fmt.Errorf("%w: \"%s\" does not match the message body", ErrDigestMismatch, name)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no supported algorithm in \"%s\" header", name)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContentDigest(t *testing.T) {
+	// RFC 9530, Sec. 2
+	d, err := ContentDigest(DigestSHA256, []byte(`{"hello": "world"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", d)
+	d, err = ContentDigest(DigestSHA512, []byte(`{"hello": "world"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:", d)
+	_, err = ContentDigest("md5", nil)
+	assert.Error(t, err)
+}
+
+func TestSignGeneratesContentDigest(t *testing.T) {
+	body := `{"hello": "world"}`
+	fields := Headers("@method", "content-digest")
+	signer, _ := NewHMACSHA256Signer("key", bytes.Repeat([]byte{1}, 64), nil, fields)
+
+	req, err := http.NewRequest("POST", "https://example.com/foo", strings.NewReader(body))
+	assert.NoError(t, err)
+	_, _, err = SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", req.Header.Get("Content-Digest"))
+	b, _ := io.ReadAll(req.Body)
+	assert.Equal(t, body, string(b), "body should be restored")
+	r, _ := req.GetBody()
+	b, _ = io.ReadAll(r)
+	assert.Equal(t, body, string(b), "body should be replayable")
+
+	req.Header.Set("Content-Digest", "sha-512=:AAAA:")
+	_, _, err = SignRequest("sig2", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha-512=:AAAA:", req.Header.Get("Content-Digest"), "existing header should be kept")
+
+	res := readResponse(httpres1)
+	res.Header.Del("Digest")
+	signer512, _ := NewHMACSHA256Signer("key", bytes.Repeat([]byte{1}, 64),
+		NewSignConfig().SetDigestAlgorithm(DigestSHA512), Headers("@status", "content-digest"))
+	_, _, err = SignResponse("sig1", *signer512, res)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Digest"), "sha-512=:"))
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	body := `{"hello": "world"}`
+	signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method", "content-digest"))
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	lax, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).SetVerifyDigest(false), Headers("@method"))
+
+	signed := func(digest string) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", strings.NewReader(body))
+		assert.NoError(t, err)
+		if digest != "" {
+			req.Header.Set("Content-Digest", digest)
+		}
+		sigInput, sig, err := SignRequest("sig1", *signer, req)
+		assert.NoError(t, err)
+		req.Header.Set("Signature-Input", sigInput)
+		req.Header.Set("Signature", sig)
+		return req
+	}
+
+	req := signed("")
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	b, _ := io.ReadAll(req.Body)
+	assert.Equal(t, body, string(b), "body should be readable after verification")
+
+	req = signed("sha-256=:AAAA:, sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	assert.Error(t, VerifyRequest("sig1", *verifier, req), "every supported digest must match")
+
+	req = signed("md5=:AAAA:, sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req), "unsupported algorithms are ignored")
+
+	req = signed("md5=:AAAA:")
+	assert.Error(t, VerifyRequest("sig1", *verifier, req), "no supported algorithm")
+
+	req = signed("")
+	req.Body = io.NopCloser(strings.NewReader(`{"hello": "there"}`))
+	assert.Error(t, VerifyRequest("sig1", *verifier, req), "body was tampered with")
+
+	req = signed("")
+	req.Body = io.NopCloser(strings.NewReader(`{"hello": "there"}`))
+	assert.NoError(t, VerifyRequest("sig1", *lax, req), "digest verification is disabled")
+}
+
+func TestReprDigest(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fields := Headers("@status", "repr-digest")
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetDigestAlgorithm(DigestSHA512), fields)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false), *NewFields())
+
+	sign := func(s *Signer, res *http.Response) error {
+		sigInput, sig, err := SignResponse("sig1", *s, res)
+		if err != nil {
+			return err
+		}
+		res.Header.Set("Signature-Input", sigInput)
+		res.Header.Set("Signature", sig)
+		return nil
+	}
+
+	res := readResponse(httpres4)
+	assert.NoError(t, sign(signer, res))
+	assert.Equal(t, "sha-512=:mEWXIS7MaLRuGgxOBdODa3xqM1XdEvxoYhvlCFJ41QJgJc4GTsPp29l5oGX69wWdXymyU0rjJuahq4l5aGgfLQ==:",
+		res.Header.Get("Repr-Digest"), "unencoded content is the representation")
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+	res.Body = io.NopCloser(strings.NewReader(`{"message": "bad dog"}`))
+	assert.Error(t, VerifyResponse("sig1", *verifier, res), "body was tampered with")
+
+	res = readResponse(httpres206)
+	assert.Error(t, sign(signer, res), "representation of partial content is unknown")
+
+	full := []byte(`{"message": "good dog"}`)
+	fullRepr := func(http.Header, []byte) ([]byte, bool, error) {
+		return full, true, nil
+	}
+	ranged, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetRepresentation(fullRepr), fields)
+	res = readResponse(httpres206)
+	res.Body = io.NopCloser(bytes.NewReader(full[:10]))
+	assert.NoError(t, sign(ranged, res))
+	d, _ := ContentDigest(DigestSHA256, full)
+	assert.Equal(t, d, res.Header.Get("Repr-Digest"))
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res), "partial content is not checked by default")
+	checking, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).SetRepresentation(fullRepr), *NewFields())
+	assert.NoError(t, VerifyResponse("sig1", *checking, res))
+	full = []byte("something else")
+	assert.Error(t, VerifyResponse("sig1", *checking, res))
+}
+
+func TestReadBody(t *testing.T) {
+	content := []byte(strings.Repeat("body ", 1000))
+	for _, size := range []int64{-1, 0, 10, int64(len(content)), int64(len(content)) + 10, 1 << 40} {
+		body := io.NopCloser(bytes.NewReader(content))
+		b, err := readBody(&body, size) // the declared size may be wrong
+		assert.NoError(t, err)
+		assert.Equal(t, content, b, "size %d", size)
+		again, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		assert.Equal(t, content, again, "body should be replaced")
+	}
+	var body io.ReadCloser = http.NoBody
+	b, err := readBody(&body, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+}
@@ -2,6 +2,7 @@ package httpsign
 
 import (
 	"crypto/ecdsa"
+	"encoding/asn1"
 	"fmt"
 	"io"
 	"math/big"
@@ -33,6 +34,29 @@ func ecdsaSignRaw(rd io.Reader, priv *ecdsa.PrivateKey, hash []byte) ([]byte, er
 	return rb, nil
 }
 
+// ecdsaASN1ToRaw converts an ASN.1 DER signature, as produced by ecdsa.SignASN1 and by crypto.Signer
+// implementations, to the raw format
+func ecdsaASN1ToRaw(curve string, der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("malformed ECDSA signature")
+	}
+	lr, ls, err := sigComponentLen(curve)
+	if err != nil {
+		return nil, err
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 8*lr || sig.S.BitLen() > 8*ls {
+		return nil, fmt.Errorf("malformed ECDSA signature")
+	}
+	raw := make([]byte, lr+ls)
+	sig.R.FillBytes(raw[:lr])
+	sig.S.FillBytes(raw[lr:])
+	return raw, nil
+}
+
 func ecdsaVerifyRaw(pub *ecdsa.PublicKey, hash []byte, sig []byte) (bool, error) {
 	if pub == nil {
 		return false, fmt.Errorf("nil public key")
@@ -58,6 +82,9 @@ func sigComponentLen(curve string) (int, int, error) {
 	case "P-256":
 		lr = 32
 		ls = 32
+	case "P-384":
+		lr = 48
+		ls = 48
 	default:
 		return 0, 0, fmt.Errorf("unknown curve \"%s\"", curve)
 	}
@@ -28,6 +28,15 @@ func Test_sigComponentLen(t *testing.T) {
 			want1:   0,
 			wantErr: true,
 		},
+		{
+			name: "P-384",
+			args: args{
+				curve: "P-384",
+			},
+			want:    48,
+			want1:   48,
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -47,7 +56,7 @@ func Test_sigComponentLen(t *testing.T) {
 }
 
 func Test_ecdsaVerifyRaw(t *testing.T) {
-	privKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	privKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
 	if err != nil {
 		t.Errorf("Failed to generate private key")
 	}
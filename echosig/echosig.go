@@ -0,0 +1,55 @@
+// Package echosig verifies requests and signs responses of servers based on Echo, with the same semantics
+// as httpsign.WrapHandler. A request that fails to verify is not passed to the next handler, and
+// a *httpsign.RequestVerificationError is returned to Echo's error handler instead.
+//
+// To avoid a dependency on Echo, the package defines a small Context interface.
+// With Echo, it is implemented by a thin wrapper around echo.Context:
+//
+//	type echoContext struct{ echo.Context }
+//
+//	func (c echoContext) Writer() http.ResponseWriter     { return c.Response().Writer }
+//	func (c echoContext) SetWriter(w http.ResponseWriter) { c.Response().Writer = w }
+//
+// and the middleware is installed with:
+//
+//	mw := echosig.Middleware(config)
+//	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+//		h := mw(func(c echosig.Context) error { return next(c.(echoContext).Context) })
+//		return func(c echo.Context) error { return h(echoContext{c}) }
+//	})
+package echosig
+
+import (
+	"github.com/yaronf/httpsign"
+	"net/http"
+)
+
+// Context is the subset of echo.Context used by this package.
+type Context interface {
+	Request() *http.Request
+	SetRequest(r *http.Request)
+	// Writer is the http.ResponseWriter that the response is written to, and SetWriter replaces it.
+	Writer() http.ResponseWriter
+	SetWriter(w http.ResponseWriter)
+}
+
+// HandlerFunc is the form of an Echo handler.
+type HandlerFunc func(c Context) error
+
+// Middleware verifies the request and signs the response, see httpsign.ErrorMiddleware.
+// The next handler sees the request and the response writer that are wrapped by httpsign,
+// and the original writer is restored when it returns.
+func Middleware(config httpsign.HandlerConfig) func(next HandlerFunc) HandlerFunc {
+	mw := httpsign.ErrorMiddleware(config)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			orig := c.Writer()
+			defer c.SetWriter(orig)
+			return mw(func(w http.ResponseWriter, r *http.Request) error {
+				c.SetRequest(r)
+				c.SetWriter(w)
+				return next(c)
+			})(orig, c.Request())
+		}
+	}
+}
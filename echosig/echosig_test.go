@@ -0,0 +1,79 @@
+package echosig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeContext holds the request and response writer the way echo.Context does
+type fakeContext struct {
+	req *http.Request
+	w   http.ResponseWriter
+}
+
+func (c *fakeContext) Request() *http.Request          { return c.req }
+func (c *fakeContext) SetRequest(r *http.Request)      { c.req = r }
+func (c *fakeContext) Writer() http.ResponseWriter     { return c.w }
+func (c *fakeContext) SetWriter(w http.ResponseWriter) { c.w = w }
+
+var key = bytes.Repeat([]byte{1}, 64)
+
+func TestMiddleware(t *testing.T) {
+	fetchVerifier := func(r *http.Request) (string, *httpsign.Verifier) {
+		verifier, _ := httpsign.NewHMACSHA256Verifier("key", key, nil, httpsign.Headers("@method"))
+		return "sig1", verifier
+	}
+	fetchSigner := func(res http.Response, r *http.Request) (string, *httpsign.Signer) {
+		signer, _ := httpsign.NewHMACSHA256Signer("key", key, nil, httpsign.Headers("@status"))
+		return "sig1", signer
+	}
+	errTeapot := errors.New("teapot")
+	var called bool
+	handler := Middleware(*httpsign.NewHandlerConfig().SetFetchVerifier(fetchVerifier).SetFetchSigner(fetchSigner))(
+		func(c Context) error {
+			called = true
+			if c.Request().URL.Path == "/fail" {
+				return errTeapot
+			}
+			_, _ = fmt.Fprintln(c.Writer(), "Hello")
+			return nil
+		})
+
+	rec := httptest.NewRecorder()
+	c := &fakeContext{req: httptest.NewRequest("GET", "/", nil), w: rec}
+	err := handler(c)
+	var verifyErr *httpsign.RequestVerificationError
+	assert.True(t, errors.As(err, &verifyErr), "unsigned request goes to the error handler")
+	assert.False(t, called)
+	assert.Equal(t, rec, c.Writer(), "the writer should be restored")
+
+	signer, _ := httpsign.NewHMACSHA256Signer("key", key, nil, httpsign.Headers("@method"))
+	verifier, _ := httpsign.NewHMACSHA256Verifier("key", key, nil, httpsign.Headers("@status"))
+	signed := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", path, nil)
+		signatureInput, signature, _ := httpsign.SignRequest("sig1", *signer, req)
+		req.Header.Set("Signature-Input", signatureInput)
+		req.Header.Set("Signature", signature)
+		return req
+	}
+
+	rec = httptest.NewRecorder()
+	c = &fakeContext{req: signed("/"), w: rec}
+	assert.NoError(t, handler(c))
+	assert.True(t, called)
+	assert.Equal(t, rec, c.Writer(), "the writer should be restored")
+	res := rec.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, httpsign.VerifyResponse("sig1", *verifier, res), "response should be signed")
+
+	rec = httptest.NewRecorder()
+	c = &fakeContext{req: signed("/fail"), w: rec}
+	assert.ErrorIs(t, handler(c), errTeapot, "handler error goes to the error handler")
+	assert.Empty(t, rec.Header().Get("Signature"))
+}
@@ -0,0 +1,45 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorHandler is an HTTP handler that returns an error, the form used by frameworks such as Echo.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request) error
+
+// RequestVerificationError is returned by ErrorMiddleware when the request fails to verify.
+type RequestVerificationError struct {
+	Err error
+}
+
+func (e *RequestVerificationError) Error() string {
+	return fmt.Sprintf("could not verify request signature: %v", e.Err)
+}
+
+func (e *RequestVerificationError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorMiddleware has the same semantics as WrapHandler, for handlers that return an error. A request that fails
+// to verify is not passed to next, and a *RequestVerificationError is returned instead of calling the
+// reqNotVerified callback, so that the framework's error handler can respond, e.g. with errors.As.
+// An error returned by next before it writes the response is returned as is, and nothing is sent;
+// such error responses are not signed.
+// For Echo, see the echosig package.
+func ErrorMiddleware(config HandlerConfig) func(next ErrorHandler) ErrorHandler {
+	return func(next ErrorHandler) ErrorHandler {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			var verifyErr error
+			c := config
+			c.reqNotVerified = func(w http.ResponseWriter, r *http.Request, err error) {
+				verifyErr = &RequestVerificationError{Err: err}
+			}
+			err := serveWrapped(w, r, c, next)
+			if verifyErr != nil {
+				return verifyErr
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httpsign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorMiddleware(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@status"))
+		return "sig1", signer
+	}
+	errTeapot := errors.New("teapot")
+	handler := func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/fail" {
+			return errTeapot
+		}
+		_, _ = fmt.Fprintln(w, "Hello")
+		return nil
+	}
+	mw := ErrorMiddleware(*NewHandlerConfig().SetFetchVerifier(fetchVerifier).SetFetchSigner(fetchSigner))
+	// a framework with a central error handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := mw(handler)(w, r)
+		var verifyErr *RequestVerificationError
+		switch {
+		case errors.As(err, &verifyErr):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, errTeapot):
+			w.WriteHeader(http.StatusTeapot)
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "verification failure goes to the error handler")
+
+	signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method"))
+	verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@status"))
+	client := NewDefaultClient("sig1", signer, verifier, nil)
+	res, err = client.Get(ts.URL)
+	assert.NoError(t, err, "response should be signed")
+	if err == nil {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	res, err = NewDefaultClient("sig1", signer, nil, nil).Get(ts.URL + "/fail")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, res.StatusCode, "handler error goes to the error handler")
+	assert.Empty(t, res.Header.Get("Signature"))
+}
@@ -0,0 +1,122 @@
+package httpsign
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Verification failures that callers may want to tell apart. The errors returned by verification wrap these,
+// often with more context, and can be tested with errors.Is.
+var (
+	// ErrBadSignature means that the signature value does not match the message and key.
+	ErrBadSignature = errors.New("bad signature, check key or signature value")
+	// ErrSignatureExpired means that the "expires" parameter is in the past.
+	ErrSignatureExpired = errors.New("expired signature")
+	// ErrCreatedInFuture means that the "created" parameter is later than the current time, beyond the allowed skew.
+	ErrCreatedInFuture = errors.New("message appears to be too new, check for clock skew")
+	// ErrSignatureTooOld means that the "created" parameter is earlier than the freshness window.
+	ErrSignatureTooOld = errors.New("message is too old, check for replay")
+	// ErrKeyIDMismatch means that the "keyid" parameter is not the verifier's key ID.
+	ErrKeyIDMismatch = errors.New("wrong keyid")
+	// ErrMissingField means that the signature does not cover one or more of the required fields.
+	ErrMissingField = errors.New("actual signature does not cover all required fields")
+	// ErrAlgNotAllowed means that the "alg" parameter is not one of the allowed algorithms.
+	ErrAlgNotAllowed = errors.New("\"alg\" parameter not allowed by policy")
+	// ErrDigestMismatch means that a covered Content-Digest or Repr-Digest does not match the message.
+	ErrDigestMismatch = errors.New("digest does not match")
+	// ErrWeakKey means that the verifier's key is shorter than the minimum allowed by policy.
+	ErrWeakKey = errors.New("key is too short for policy")
+	// ErrMissingCreated means that the "created" parameter is required, but the signature does not have it.
+	ErrMissingCreated = errors.New("missing \"created\" parameter")
+	// ErrMissingExpires means that the "expires" parameter is required, but the signature does not have it.
+	ErrMissingExpires = errors.New("missing \"expires\" parameter")
+)
+
+// UnknownComponentError is returned when a signature covers a derived component (a name starting with "@")
+// that this version of the package does not implement. Such a signature cannot be verified, but it is not
+// necessarily malformed: it may have been produced by a newer peer. Callers that verify several signatures
+// can use errors.As to detect this case and skip the signature rather than fail the whole message.
+type UnknownComponentError struct {
+	Component string
+}
+
+func (e *UnknownComponentError) Error() string {
+	return fmt.Sprintf("unknown derived component \"%s\"", e.Component)
+}
+
+// SFParseError is returned in strict parsing mode when a signature-related header does not conform
+// to the RFC 8941 structured field grammar. Offset is the position of the offending character,
+// counted in bytes from the start of the header value (multiple header lines are joined with a comma).
+type SFParseError struct {
+	Header string
+	Offset int
+	Msg    string
+}
+
+func (e *SFParseError) Error() string {
+	return fmt.Sprintf("malformed \"%s\" header at offset %d: %s", e.Header, e.Offset, e.Msg)
+}
+
+// UnsafeHeaderValueError is returned when signing a header whose value contains non-ASCII or
+// control characters, and the signer is configured to reject such values.
+type UnsafeHeaderValueError struct {
+	Header string
+}
+
+func (e *UnsafeHeaderValueError) Error() string {
+	return fmt.Sprintf("header \"%s\" contains non-ASCII or control characters", e.Header)
+}
+
+// DuplicateHeaderError is returned when a covered header that is defined to have a single value, e.g. Content-Type,
+// appears on more than one field line, and the signer or verifier is configured to reject such duplicates.
+type DuplicateHeaderError struct {
+	Header string
+}
+
+func (e *DuplicateHeaderError) Error() string {
+	return fmt.Sprintf("header \"%s\" appears more than once", e.Header)
+}
+
+// LabelConflictError is returned when signing a message that already has a signature with the same label.
+type LabelConflictError struct {
+	Label string
+}
+
+func (e *LabelConflictError) Error() string {
+	return fmt.Sprintf("message already has a signature labeled \"%s\"", e.Label)
+}
+
+// TimeoutError is returned when a verification stage does not complete in time, see Timeouts.
+// It wraps context.DeadlineExceeded or context.Canceled.
+type TimeoutError struct {
+	Stage string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("verification timed out at stage \"%s\": %v", e.Stage, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// BodyTooLargeError is returned when a message body that needs to be buffered, e.g. to compute or verify
+// a digest, is larger than the configured limit.
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("message body is larger than %d bytes", e.Limit)
+}
+
+// ReplayError is returned when a verified signature's nonce has already been seen, see VerifyConfig.SetReplayCache.
+type ReplayError struct {
+	KeyID string
+	Nonce string
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("nonce \"%s\" of key \"%s\" was already used, possible replay", e.Nonce, e.KeyID)
+}
@@ -0,0 +1,163 @@
+// Package fasthttpsig verifies requests and signs responses of servers based on fasthttp, such as Fiber.
+// Messages are converted to their net/http form, so that they are canonicalized exactly like
+// messages handled by the httpsign package.
+//
+// To avoid a dependency on fasthttp, the package defines small Request and Response interfaces.
+// With fasthttp, they are implemented by thin wrappers around fasthttp.RequestCtx:
+//
+//	type request struct{ ctx *fasthttp.RequestCtx }
+//
+//	func (r request) Method() string     { return string(r.ctx.Method()) }
+//	func (r request) RequestURI() string { return string(r.ctx.RequestURI()) }
+//	func (r request) Host() string       { return string(r.ctx.Host()) }
+//	func (r request) IsTLS() bool        { return r.ctx.IsTLS() }
+//	func (r request) Body() []byte       { return r.ctx.Request.Body() }
+//	func (r request) VisitHeaders(f func(name, value string)) {
+//		r.ctx.Request.Header.VisitAll(func(k, v []byte) { f(string(k), string(v)) })
+//	}
+//
+//	type response struct{ ctx *fasthttp.RequestCtx }
+//
+//	func (r response) StatusCode() int              { return r.ctx.Response.StatusCode() }
+//	func (r response) Body() []byte                 { return r.ctx.Response.Body() }
+//	func (r response) AddHeader(name, value string) { r.ctx.Response.Header.Add(name, value) }
+//	func (r response) VisitHeaders(f func(name, value string)) {
+//		r.ctx.Response.Header.VisitAll(func(k, v []byte) { f(string(k), string(v)) })
+//	}
+//
+// With Fiber, the wrappers are constructed from c.Context(). A handler calls VerifyRequest before
+// processing the request, and SignResponse once the response, including its body, is complete.
+package fasthttpsig
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yaronf/httpsign"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Request is the subset of a server request used by this package.
+type Request interface {
+	Method() string
+	// RequestURI is the request target as received, typically the path and query.
+	RequestURI() string
+	Host() string
+	IsTLS() bool
+	// VisitHeaders calls f for each header line.
+	VisitHeaders(f func(name, value string))
+	Body() []byte
+}
+
+// Response is the subset of a server response used by this package.
+type Response interface {
+	StatusCode() int
+	// VisitHeaders calls f for each header line.
+	VisitHeaders(f func(name, value string))
+	Body() []byte
+	AddHeader(name, value string)
+}
+
+// HTTPRequest converts a request to its net/http form.
+func HTTPRequest(req Request) (*http.Request, error) {
+	if req == nil {
+		return nil, fmt.Errorf("nil request")
+	}
+	u, err := url.ParseRequestURI(req.RequestURI())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse request URI: %w", err)
+	}
+	u.Host = req.Host()
+	u.Scheme = "http"
+	if req.IsTLS() {
+		u.Scheme = "https"
+	}
+	body := req.Body()
+	r := &http.Request{
+		Method:        req.Method(),
+		URL:           u,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        visitHeaders(req.VisitHeaders),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Host:          req.Host(),
+		RequestURI:    req.RequestURI(),
+	}
+	r.Header.Del("Host") // net/http keeps it in Host
+	return r, nil
+}
+
+// HTTPResponse converts a response to its net/http form. The request may be nil.
+func HTTPResponse(res Response, req Request) (*http.Response, error) {
+	if res == nil {
+		return nil, fmt.Errorf("nil response")
+	}
+	var r *http.Request
+	if req != nil {
+		var err error
+		if r, err = HTTPRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	body := res.Body()
+	return &http.Response{
+		Status:        strconv.Itoa(res.StatusCode()),
+		StatusCode:    res.StatusCode(),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        visitHeaders(res.VisitHeaders),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+func visitHeaders(visit func(f func(name, value string))) http.Header {
+	h := http.Header{}
+	visit(func(name, value string) {
+		h.Add(name, value)
+	})
+	return h
+}
+
+// VerifyRequest verifies a request, see httpsign.VerifyRequest.
+func VerifyRequest(signatureName string, verifier httpsign.MessageVerifier, req Request) error {
+	r, err := HTTPRequest(req)
+	if err != nil {
+		return err
+	}
+	return httpsign.VerifyRequest(signatureName, verifier, r)
+}
+
+// SignResponse signs a response, and adds the Signature-Input and Signature headers, as well as any header
+// generated for the signature, such as Content-Digest. The request may be nil, unless the signature
+// covers request components.
+func SignResponse(signatureName string, signer httpsign.MessageSigner, res Response, req Request) error {
+	r, err := HTTPResponse(res, req)
+	if err != nil {
+		return err
+	}
+	orig := r.Header.Clone()
+	signatureInput, signature, err := httpsign.SignResponse(signatureName, signer, r)
+	if err != nil {
+		return err
+	}
+	for name, values := range r.Header {
+		if _, found := orig[name]; !found {
+			for _, v := range values {
+				res.AddHeader(name, v)
+			}
+		}
+	}
+	if err = httpsign.AddSignature(r.Header, signatureInput, signature); err != nil {
+		return err
+	}
+	res.AddHeader("Signature-Input", signatureInput)
+	res.AddHeader("Signature", signature)
+	return nil
+}
@@ -0,0 +1,95 @@
+package fasthttpsig
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeRequest and fakeResponse hold messages the way fasthttp does
+type fakeRequest struct {
+	method, uri, host string
+	tls               bool
+	headers           [][2]string
+	body              []byte
+}
+
+func (r *fakeRequest) Method() string     { return r.method }
+func (r *fakeRequest) RequestURI() string { return r.uri }
+func (r *fakeRequest) Host() string       { return r.host }
+func (r *fakeRequest) IsTLS() bool        { return r.tls }
+func (r *fakeRequest) Body() []byte       { return r.body }
+func (r *fakeRequest) VisitHeaders(f func(name, value string)) {
+	for _, h := range r.headers {
+		f(h[0], h[1])
+	}
+}
+
+type fakeResponse struct {
+	status  int
+	headers [][2]string
+	body    []byte
+}
+
+func (r *fakeResponse) StatusCode() int { return r.status }
+func (r *fakeResponse) Body() []byte    { return r.body }
+func (r *fakeResponse) AddHeader(name, value string) {
+	r.headers = append(r.headers, [2]string{name, value})
+}
+func (r *fakeResponse) VisitHeaders(f func(name, value string)) {
+	for _, h := range r.headers {
+		f(h[0], h[1])
+	}
+}
+
+var key = bytes.Repeat([]byte{1}, 64)
+
+func TestVerifyRequest(t *testing.T) {
+	fields := *httpsign.NewFields().AddHeaders("@method", "@target-uri", "@authority", "content-type", "content-digest").AddQueryParam("id")
+	signer, _ := httpsign.NewHMACSHA256Signer("key", key, nil, fields)
+	verifier, _ := httpsign.NewHMACSHA256Verifier("key", key, nil, fields)
+
+	// sign with net/http, verify the same message as received by fasthttp
+	req, _ := http.NewRequest("POST", "https://example.com/foo?id=1&x=y", strings.NewReader(`{"a": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	signatureInput, signature, err := httpsign.SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	received := &fakeRequest{method: "POST", uri: "/foo?id=1&x=y", host: "example.com", tls: true,
+		headers: [][2]string{{"Host", "example.com"}, {"Content-Type", "application/json"},
+			{"Content-Digest", req.Header.Get("Content-Digest")},
+			{"Signature-Input", signatureInput}, {"Signature", signature}},
+		body: []byte(`{"a": 1}`)}
+	assert.NoError(t, VerifyRequest("sig1", verifier, received))
+
+	received.tls = false
+	assert.Error(t, VerifyRequest("sig1", verifier, received), "scheme changed")
+	received.tls = true
+	received.body = []byte(`{"a": 2}`)
+	assert.Error(t, VerifyRequest("sig1", verifier, received), "body changed")
+}
+
+func TestSignResponse(t *testing.T) {
+	fields := *httpsign.NewFields().AddHeaders("@status", "content-type", "content-digest").AddRequestHeaders("@method")
+	signer, _ := httpsign.NewHMACSHA256Signer("key", key, nil, fields)
+	verifier, _ := httpsign.NewHMACSHA256Verifier("key", key, nil, fields)
+
+	req := &fakeRequest{method: "GET", uri: "/foo", host: "example.com"}
+	res := &fakeResponse{status: 200, headers: [][2]string{{"Content-Type", "text/plain"}}, body: []byte("hello")}
+	assert.NoError(t, SignResponse("sig1", signer, res, req))
+
+	// the response as received by a net/http client
+	received := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(res.body))}
+	for _, h := range res.headers {
+		received.Header.Add(h[0], h[1])
+	}
+	received.Request, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	assert.NotEmpty(t, received.Header.Get("Content-Digest"), "generated header should be added")
+	assert.NoError(t, httpsign.VerifyResponse("sig1", *verifier, received))
+
+	assert.Error(t, SignResponse("sig1", signer, res, req), "label already in use")
+	assert.Error(t, SignResponse("sig1", signer, res, nil), "request components are not available")
+}
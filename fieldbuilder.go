@@ -0,0 +1,149 @@
+package httpsign
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The methods below build a list of fields one component at a time, so that derived component names
+// need not be spelled out, e.g. NewFields().Method().Authority().Path().Header("content-type").Build().
+
+// Method covers the "@method" derived component.
+func (fs *Fields) Method() *Fields {
+	return fs.AddHeader("@method")
+}
+
+// TargetURI covers the "@target-uri" derived component.
+func (fs *Fields) TargetURI() *Fields {
+	return fs.AddHeader("@target-uri")
+}
+
+// Authority covers the "@authority" derived component.
+func (fs *Fields) Authority() *Fields {
+	return fs.AddHeader("@authority")
+}
+
+// Scheme covers the "@scheme" derived component.
+func (fs *Fields) Scheme() *Fields {
+	return fs.AddHeader("@scheme")
+}
+
+// RequestTarget covers the "@request-target" derived component.
+func (fs *Fields) RequestTarget() *Fields {
+	return fs.AddHeader("@request-target")
+}
+
+// Path covers the "@path" derived component.
+func (fs *Fields) Path() *Fields {
+	return fs.AddHeader("@path")
+}
+
+// Query covers the "@query" derived component, i.e. the whole query string.
+func (fs *Fields) Query() *Fields {
+	return fs.AddHeader("@query")
+}
+
+// Status covers the "@status" derived component of a response.
+func (fs *Fields) Status() *Fields {
+	return fs.AddHeader("@status")
+}
+
+// Header covers a header, e.g. "content-type". It is the same as AddHeader.
+func (fs *Fields) Header(hdr string) *Fields {
+	return fs.AddHeader(hdr)
+}
+
+// QueryParam covers a single query parameter. It is the same as AddQueryParam.
+func (fs *Fields) QueryParam(qp string) *Fields {
+	return fs.AddQueryParam(qp)
+}
+
+// Build validates the list of fields and returns it, see Validate.
+func (fs *Fields) Build() (Fields, error) {
+	if err := fs.Validate(); err != nil {
+		return Fields{}, err
+	}
+	return *fs, nil
+}
+
+// Validate checks that each field is a known derived component or a valid header name, with parameters
+// that apply to it, and that no component is covered twice.
+func (fs Fields) Validate() error {
+	if err := fs.validateComponents(); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i := range fs.f {
+		id := fs.f[i].String()
+		if seen[id] {
+			return fmt.Errorf("component %s is covered more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// validateComponents is the part of Validate that Signer and Verifier constructors enforce. Repeated
+// components are tolerated there, as they always were.
+func (fs Fields) validateComponents() error {
+	for i := range fs.f {
+		if err := fs.f[i].validate(); err != nil {
+			return fmt.Errorf("invalid component %s: %w", fs.f[i].String(), err)
+		}
+	}
+	return nil
+}
+
+func (f *field) validate() error {
+	if !strings.HasPrefix(f.name, "@") {
+		if f.name == "" {
+			return fmt.Errorf("empty header name")
+		}
+		for i := 0; i < len(f.name); i++ {
+			if !isTChar(f.name[i]) {
+				return fmt.Errorf("header name contains %q", f.name[i])
+			}
+		}
+		if strings.ToLower(f.name) != f.name {
+			return fmt.Errorf("header name must be lowercase")
+		}
+		if f.flagName == "name" {
+			return fmt.Errorf("\"name\" only applies to \"@query-param\"")
+		}
+		if f.flagName == "key" && !isDictionaryKey(f.flagValue) {
+			return fmt.Errorf("\"%s\" is not a valid dictionary key", f.flagValue)
+		}
+		return nil
+	}
+	if !isKnownDerivedComponent(f.name) {
+		return &UnknownComponentError{Component: f.name}
+	}
+	if f.tr {
+		return fmt.Errorf("a derived component cannot be a trailer")
+	}
+	if f.name == "@status" && f.req {
+		return fmt.Errorf("a request has no status")
+	}
+	if f.name == "@query-param" {
+		if f.flagName != "name" {
+			return fmt.Errorf("\"@query-param\" requires a \"name\" parameter")
+		}
+		return nil
+	}
+	if f.flagName != "" {
+		return fmt.Errorf("\"%s\" does not apply to a derived component", f.flagName)
+	}
+	return nil
+}
+
+func isDictionaryKey(key string) bool {
+	if key == "" || !(isLCAlpha(key[0]) || key[0] == '*') {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
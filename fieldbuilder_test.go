@@ -0,0 +1,95 @@
+package httpsign
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFields_Build(t *testing.T) {
+	fields, err := NewFields().Method().TargetURI().Authority().Scheme().RequestTarget().Path().Query().
+		Header("Content-Type").QueryParam("id").Build()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path",
+		"@query", "content-type", `@query-param;name="id"`}, fields.Components())
+
+	fields, err = NewFields().Status().Header("content-digest").AddRequestHeaders("@method").Build()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@status", "content-digest", "@method;req"}, fields.Components())
+}
+
+func TestFields_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  *Fields
+		wantErr string
+	}{
+		{"empty list", NewFields(), ""},
+		{"dictionary header", NewFields().AddDictHeader("cache-control", "max-age"), ""},
+		{"structured field", NewFields().AddStructuredField("priority"), ""},
+		{"trailer", NewFields().AddTrailers("expires"), ""},
+		{"same header, different parameters", NewFields().Header("signature").AddRequestHeaders("signature"), ""},
+		{"unknown derived component", NewFields().Header("@foo"), `invalid component @foo: unknown derived component "@foo"`},
+		{"empty header name", NewFields().Header(""), "invalid component : empty header name"},
+		{"invalid header name", NewFields().Header("content type"), `invalid component content type: header name contains ' '`},
+		{"duplicate", NewFields().Method().Path().Method(), "component @method is covered more than once"},
+		{"duplicate query param", NewFields().QueryParam("a").QueryParam("a"), `component @query-param;name="a" is covered more than once`},
+		{"derived trailer", NewFields().AddTrailers("@path"), "invalid component @path;tr: a derived component cannot be a trailer"},
+		{"query param without a name", NewFields().Header("@query-param"), `invalid component @query-param: "@query-param" requires a "name" parameter`},
+		{"uppercase header", &Fields{f: []field{{name: "Content-Type"}}}, "invalid component Content-Type: header name must be lowercase"},
+		{"request status", NewFields().AddRequestHeaders("@status"), "invalid component @status;req: a request has no status"},
+		{"invalid dictionary key", NewFields().AddDictHeader("cache-control", "Max-Age"), `invalid component cache-control;key="Max-Age": "Max-Age" is not a valid dictionary key`},
+		{"empty dictionary key", NewFields().AddDictHeader("cache-control", ""), `invalid component cache-control;key="": "" is not a valid dictionary key`},
+		{"dictionary derived component", NewFields().AddDictHeader("@method", "a"), `invalid component @method;key="a": "key" does not apply to a derived component`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fields.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			_, err = tt.fields.Build()
+			assert.Error(t, err)
+		})
+	}
+
+	var unknown *UnknownComponentError
+	assert.True(t, errors.As(NewFields().Header("@foo").Validate(), &unknown))
+}
+
+func TestFields_BuildSignVerify(t *testing.T) {
+	fields, err := NewFields().Method().Authority().Path().Header("content-type").QueryParam("param").Build()
+	assert.NoError(t, err)
+	assert.Equal(t, NewFields().AddHeaders("@method", "@authority", "@path", "content-type").AddQueryParam("param").Components(),
+		fields.Components())
+
+	key := make([]byte, 64)
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), fields)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), fields)
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+}
+
+func TestFields_ValidatedOnConstruction(t *testing.T) {
+	key := make([]byte, 64)
+	invalid := *NewFields().Method().Header("@future-component")
+	_, err := NewHMACSHA256Signer("key", key, nil, invalid)
+	assert.EqualError(t, err, `invalid component @future-component: unknown derived component "@future-component"`)
+	_, err = NewHMACSHA256Verifier("key", key, nil, invalid)
+	assert.Error(t, err)
+	priv, pub, _ := genP256KeyPair()
+	_, err = NewP256Signer("key", *priv, nil, *NewFields().AddDictHeader("cache-control", ""))
+	assert.Error(t, err)
+	_, err = NewP256Verifier("key", *pub, nil, *NewFields().AddTrailers("@path"))
+	assert.Error(t, err)
+
+	// repeated components are accepted by the constructors, though not by Validate
+	_, err = NewHMACSHA256Signer("key", key, nil, Headers("@method", "@method"))
+	assert.NoError(t, err)
+}
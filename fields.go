@@ -1,30 +1,49 @@
 package httpsign
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/dunglas/httpsfv"
 	"strings"
 )
 
 // Fields is a list of fields to be signed or verified. To initialize, use Headers or for more complex
-// cases, NewFields followed by a chain of Add... methods.
+// cases, NewFields followed by a chain of Add... methods, or of the component methods such as Method and Header,
+// ending with Build.
 type Fields struct {
 	f []field
 }
 
-// The SFV representation of a field is name;flagName="flagValue"
+// The SFV representation of a field is name;req;tr;flagName="flagValue"
 // Note that this is a subset of SFV, we only support string-valued params, and only one param
-// per field for now.
+// per field other than "req" and "tr".
 type field struct {
 	name                string
 	flagName, flagValue string
+	req                 bool // the component is taken from the request, when signing a response
+	tr                  bool // the component is a trailer field
+}
+
+// Some flags are boolean: they are either present (with a value of true) or absent
+func isBooleanFlag(flagName string) bool {
+	return flagName == "sf" || flagName == "bs"
 }
 
 func (f *field) String() string {
+	name := f.name
+	if f.req {
+		name += ";req"
+	}
+	if f.tr {
+		name += ";tr"
+	}
 	if f.flagName == "" {
-		return f.name
+		return name
+	}
+	if isBooleanFlag(f.flagName) {
+		return fmt.Sprintf("%s;%s", name, f.flagName)
 	}
-	return fmt.Sprintf("%s;%s=\"%s\"", f.name, f.flagName, f.flagValue)
+	return fmt.Sprintf("%s;%s=\"%s\"", name, f.flagName, f.flagValue)
 }
 
 // Headers is a simple way to generate a Fields list, where only simple header names and derived headers
@@ -50,7 +69,7 @@ func NewFields() *Fields {
 
 func fromHeaderName(hdr string) *field {
 	h := strings.ToLower(hdr)
-	f := field{h, "", ""}
+	f := field{name: h}
 	return &f
 }
 
@@ -62,12 +81,13 @@ func (fs *Fields) AddHeader(hdr string) *Fields {
 }
 
 func fromQueryParam(qp string) *field {
-	q := strings.ToLower(qp)
-	f := field{"@query-params", "name", q}
+	f := field{name: "@query-param", flagName: "name", flagValue: encodeQueryParam(qp)}
 	return &f
 }
 
-// AddQueryParam indicates a request for a specific query parameter to be signed.
+// AddQueryParam indicates a request for a specific query parameter to be signed, using the "@query-param"
+// component. The name is case-sensitive and is given unencoded, e.g. "façade", and other parameters
+// remain unsigned.
 func (fs *Fields) AddQueryParam(qp string) *Fields {
 	f := fromQueryParam(qp)
 	fs.f = append(fs.f, *f)
@@ -76,11 +96,12 @@ func (fs *Fields) AddQueryParam(qp string) *Fields {
 
 func fromDictHeader(hdr, key string) *field {
 	h := strings.ToLower(hdr)
-	f := field{h, "key", key}
+	f := field{name: h, flagName: "key", flagValue: key}
 	return &f
 }
 
-// AddDictHeader indicates that out of a header structured as a dictionary, a specific key value is signed/verified.
+// AddDictHeader indicates that out of a header structured as a dictionary, a specific key value is signed/verified,
+// e.g. a single directive of "Cache-Control". Other members may change without invalidating the signature.
 func (fs *Fields) AddDictHeader(hdr, key string) *Fields {
 	f := fromDictHeader(hdr, key)
 	fs.f = append(fs.f, *f)
@@ -89,20 +110,95 @@ func (fs *Fields) AddDictHeader(hdr, key string) *Fields {
 
 func fromStructuredField(hdr string) *field {
 	h := strings.ToLower(hdr)
-	f := field{h, "sf", ""}
+	f := field{name: h, flagName: "sf"}
 	return &f
 }
 
-// AddStructuredField indicates that a header should be interpreted as a structured field, per RFC 8941.
+// AddStructuredField indicates that a header should be interpreted as a structured field, per RFC 8941,
+// and signed in its strict re-serialization, so that reformatting by intermediaries does not break the signature.
+// The type of registered fields, e.g. "priority", is known; otherwise it is inferred from the value.
 func (fs *Fields) AddStructuredField(hdr string) *Fields {
 	f := fromStructuredField(hdr)
 	fs.f = append(fs.f, *f)
 	return fs
 }
 
+func fromByteSequenceHeader(hdr string) *field {
+	h := strings.ToLower(hdr)
+	f := field{name: h, flagName: "bs"}
+	return &f
+}
+
+// AddByteSequenceHeader indicates that each value of a header is wrapped as a byte sequence before it is signed.
+// Unlike AddHeader, the signature then breaks if values are combined or split differently, and non-ASCII values
+// are signed unambiguously. A "set-cookie" header covered with AddHeader is signed this way if it has more than
+// one value, since cookies cannot be safely combined.
+func (fs *Fields) AddByteSequenceHeader(hdr string) *Fields {
+	f := fromByteSequenceHeader(hdr)
+	fs.f = append(fs.f, *f)
+	return fs
+}
+
+// AddRequestHeaders adds a list of simple or derived header names of the request, e.g. "@method", when signing
+// or verifying a response (the "req" parameter). The request is taken from the response's Request field.
+func (fs *Fields) AddRequestHeaders(hs ...string) *Fields {
+	for _, h := range hs {
+		f := fromHeaderName(h)
+		f.req = true
+		fs.f = append(fs.f, *f)
+	}
+	return fs
+}
+
+// AddRequestDictHeader is the same as AddDictHeader, for a header of the request when signing a response.
+// Covering "signature" with the request's signature label binds the response to the signed request.
+func (fs *Fields) AddRequestDictHeader(hdr, key string) *Fields {
+	f := fromDictHeader(hdr, key)
+	f.req = true
+	fs.f = append(fs.f, *f)
+	return fs
+}
+
+// AddRequestQueryParam is the same as AddQueryParam, for a query parameter of the request when signing a response.
+func (fs *Fields) AddRequestQueryParam(qp string) *Fields {
+	f := fromQueryParam(qp)
+	f.req = true
+	fs.f = append(fs.f, *f)
+	return fs
+}
+
+// AddTrailers adds a list of trailer field names (the "tr" parameter). Trailers are only available once the body
+// is read in full, so when verifying, the body is read and replaced by a reader over the same content.
+// Verification of trailers must be enabled with VerifyConfig.SetVerifyTrailers.
+// When signing, the trailer values must already be set, e.g. in http.Request.Trailer.
+func (fs *Fields) AddTrailers(trs ...string) *Fields {
+	for _, tr := range trs {
+		f := fromHeaderName(tr)
+		f.tr = true
+		fs.f = append(fs.f, *f)
+	}
+	return fs
+}
+
+// Components returns the covered components in order, each as its name followed by any parameters,
+// e.g. "@method", "content-type;req" or "signature;key=\"sig1\"".
+func (fs Fields) Components() []string {
+	var cs []string
+	for i := range fs.f {
+		cs = append(cs, fs.f[i].String())
+	}
+	return cs
+}
+
 func (f field) toItem() httpsfv.Item {
 	p := httpsfv.NewParams()
-	if f.flagName == "sf" { //special case
+	if f.req {
+		p.Add("req", true)
+	}
+	if f.tr {
+		p.Add("tr", true)
+	}
+	if isBooleanFlag(f.flagName) { //special case
 		p.Add(f.flagName, true)
 	} else if f.flagName != "" {
 		p.Add(f.flagName, f.flagValue)
@@ -114,22 +210,39 @@ func (f field) toItem() httpsfv.Item {
 	return i
 }
 
-func (f field) asSignatureInput() (string, error) {
-	s, err := httpsfv.Marshal(f.toItem())
-	return s, err
+// writeIdentifier writes the component identifier, i.e. the field serialized as an Item, in the same form as toItem
+func (f field) writeIdentifier(b *bytes.Buffer) error {
+	if err := writeSFString(b, f.name); err != nil {
+		return err
+	}
+	if f.req {
+		b.WriteString(";req")
+	}
+	if f.tr {
+		b.WriteString(";tr")
+	}
+	if isBooleanFlag(f.flagName) {
+		b.WriteByte(';')
+		b.WriteString(f.flagName)
+	} else if f.flagName != "" {
+		return writeSFStringParam(b, f.flagName, f.flagValue)
+	}
+	return nil
 }
 
-func (fs *Fields) asSignatureInput(p *httpsfv.Params) (string, error) {
-	il := httpsfv.InnerList{
-		Items:  []httpsfv.Item{},
-		Params: httpsfv.NewParams(),
-	}
-	for _, f := range fs.f {
-		il.Items = append(il.Items, f.toItem())
+// writeInnerList writes the component identifiers as an Inner List, without parameters
+func (fs *Fields) writeInnerList(b *bytes.Buffer) error {
+	b.WriteByte('(')
+	for i, f := range fs.f {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if err := f.writeIdentifier(b); err != nil {
+			return err
+		}
 	}
-	il.Params = p
-	s, err := httpsfv.Marshal(il)
-	return s, err
+	b.WriteByte(')')
+	return nil
 }
 
 //  contains verifies that all required fields are in the given list of fields (yes, this is O(n^2)).
@@ -137,7 +250,7 @@ func (fs *Fields) contains(requiredFields *Fields) bool {
 outer:
 	for _, f1 := range requiredFields.f {
 		for _, f2 := range fs.f {
-			if f1 == f2 {
+			if f1 == f2 || f2.wraps(f1) {
 				continue outer
 			}
 		}
@@ -145,3 +258,77 @@ outer:
 	}
 	return true
 }
+
+// wraps returns true if f covers a header as a byte sequence, and plain covers the same header without parameters:
+// this is how a non-combinable header is signed if it has more than one field line, and how a header with
+// unsafe values is signed with UnsafeValueWrap. Either way, the byte sequence covers every field line.
+func (f field) wraps(plain field) bool {
+	return f.flagName == "bs" && plain.flagName == "" && !strings.HasPrefix(f.name, "@") &&
+		f.name == plain.name && f.req == plain.req && f.tr == plain.tr
+}
+
+// derivedSupersets lists the derived components whose value includes that of another component
+var derivedSupersets = map[string][]string{
+	"@scheme":      {"@target-uri"},
+	"@authority":   {"@target-uri"},
+	"@path":        {"@target-uri", "@request-target"},
+	"@query":       {"@target-uri", "@request-target"},
+	"@query-param": {"@query", "@target-uri", "@request-target"},
+}
+
+// implies returns true if covering f also covers the value of required: f is the same component, the whole
+// header of which required is a dictionary member, the same header in another serialization (sf or bs),
+// or a derived component that includes the value of required, e.g. "@target-uri" includes "@path".
+func (f field) implies(required field) bool {
+	if f == required {
+		return true
+	}
+	if f.req != required.req || f.tr != required.tr {
+		return false
+	}
+	if strings.HasPrefix(required.name, "@") {
+		for _, name := range derivedSupersets[required.name] {
+			if f.name == name {
+				return true
+			}
+		}
+		return false
+	}
+	wholeField := f.flagName == "" || isBooleanFlag(f.flagName)
+	return f.name == required.name && wholeField
+}
+
+// missingCoverage lists the required components that none of the covered fields implies
+func missingCoverage(covered, required Fields) []string {
+	var missing []string
+outer:
+	for _, r := range required.f {
+		for _, f := range covered.f {
+			if f.implies(r) {
+				continue outer
+			}
+		}
+		missing = append(missing, r.String())
+	}
+	return missing
+}
+
+// coversTrailer returns true if the named trailer field is in the list, with any parameters
+func (fs *Fields) coversTrailer(name string) bool {
+	for _, f := range fs.f {
+		if f.name == name && f.tr && !f.req {
+			return true
+		}
+	}
+	return false
+}
+
+// coversName returns true if the named component of the message itself is in the list, with any parameters
+func (fs *Fields) coversName(name string) bool {
+	for _, f := range fs.f {
+		if f.name == name && !f.req && !f.tr {
+			return true
+		}
+	}
+	return false
+}
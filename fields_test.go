@@ -1,27 +1,22 @@
 package httpsign
 
 import (
+	"bytes"
 	"github.com/dunglas/httpsfv"
+	"reflect"
 	"testing"
 )
 
-func TestFields_asSignatureInput(t *testing.T) {
-	type args struct {
-		p *httpsfv.Params
-	}
+func TestFields_writeInnerList(t *testing.T) {
 	tests := []struct {
 		name    string
 		fs      Fields
-		args    args
 		want    string
 		wantErr bool
 	}{
 		{
-			name: "Just headers",
-			fs:   Headers("hdr1", "hdr2", "@Hdr3"),
-			args: args{
-				p: httpsfv.NewParams(),
-			},
+			name:    "Just headers",
+			fs:      Headers("hdr1", "hdr2", "@Hdr3"),
 			want:    `("hdr1" "hdr2" "@hdr3")`,
 			wantErr: false,
 		},
@@ -33,22 +28,94 @@ func TestFields_asSignatureInput(t *testing.T) {
 				f.AddQueryParam("qparamname")
 				return *f
 			}(),
-			args: args{
-				p: httpsfv.NewParams(),
-			},
-			want:    `("hdr-name" "@query-params";name="qparamname")`,
+			want:    `("hdr-name" "@query-param";name="qparamname")`,
+			wantErr: false,
+		},
+		{
+			name:    "Flags",
+			fs:      *NewFields().AddStructuredField("priority").AddRequestDictHeader("x-dict", "a\\b").AddTrailers("x-tr"),
+			want:    `("priority";sf "x-dict";req;key="a\\b" "x-tr";tr)`,
+			wantErr: false,
+		},
+		{
+			name:    "Empty",
+			fs:      *NewFields(),
+			want:    `()`,
 			wantErr: false,
 		},
+		{
+			name:    "Bad name",
+			fs:      Headers("hdr\n"),
+			wantErr: true,
+		},
+		{
+			name:    "Bad flag",
+			fs:      Fields{f: []field{{name: "hdr", flagName: "Key", flagValue: "v"}}},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.fs.asSignatureInput(tt.args.p)
+			var b bytes.Buffer
+			err := tt.fs.writeInnerList(&b)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("asSignatureInput() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("writeInnerList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("asSignatureInput() got = %v, want %v", got, tt.want)
+			if got := b.String(); err == nil && got != tt.want {
+				t.Errorf("writeInnerList() got = %v, want %v", got, tt.want)
+			}
+			for _, f := range tt.fs.f { // must be identical to the httpsfv serialization
+				b.Reset()
+				err := f.writeIdentifier(&b)
+				want, wantErr := httpsfv.Marshal(f.toItem())
+				if (err != nil) != (wantErr != nil) || (err == nil && b.String() != want) {
+					t.Errorf("writeIdentifier() = %v, %v, httpsfv: %v, %v", b.String(), err, want, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldsComponents(t *testing.T) {
+	fields := *NewFields().AddHeaders("@status", "content-type").AddDictHeader("signature", "sig1").
+		AddStructuredField("priority").AddRequestHeaders("@method").AddTrailers("content-digest")
+	want := []string{"@status", "content-type", `signature;key="sig1"`, "priority;sf", "@method;req", "content-digest;tr"}
+	if got := fields.Components(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Components() = %v, want %v", got, want)
+	}
+	if got := NewFields().Components(); got != nil {
+		t.Errorf("Components() = %v, want nil", got)
+	}
+}
+
+func TestFields_implies(t *testing.T) {
+	tests := []struct {
+		name     string
+		covered  *Fields
+		required *Fields
+		want     []string
+	}{
+		{"same", NewFields().Method().Path(), NewFields().Path(), nil},
+		{"superset", NewFields().Method().Path().Header("content-type").Header("date"), NewFields().Method().Header("date"), nil},
+		{"missing", NewFields().Method(), NewFields().Method().Path().Header("date"), []string{"@path", "date"}},
+		{"target uri", NewFields().TargetURI(), NewFields().Scheme().Authority().Path().Query().QueryParam("id"), nil},
+		{"request target", NewFields().RequestTarget(), NewFields().Path().Query().Authority(), []string{"@authority"}},
+		{"query", NewFields().Query(), NewFields().QueryParam("id").Path(), []string{"@path"}},
+		{"dictionary member", NewFields().Header("cache-control"), NewFields().AddDictHeader("cache-control", "max-age"), nil},
+		{"other member", NewFields().AddDictHeader("cache-control", "no-cache"),
+			NewFields().AddDictHeader("cache-control", "max-age"), []string{`cache-control;key="max-age"`}},
+		{"member does not cover the header", NewFields().AddDictHeader("cache-control", "max-age"),
+			NewFields().Header("cache-control"), []string{"cache-control"}},
+		{"structured field", NewFields().AddStructuredField("priority"), NewFields().Header("priority"), nil},
+		{"byte sequence", NewFields().AddByteSequenceHeader("x-data"), NewFields().Header("x-data"), nil},
+		{"request component", NewFields().Header("date"), NewFields().AddRequestHeaders("date"), []string{"date;req"}},
+		{"request superset", NewFields().AddRequestHeaders("@target-uri"), NewFields().AddRequestHeaders("@path"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingCoverage(*tt.covered, *tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingCoverage() = %v, want %v", got, tt.want)
 			}
 		})
 	}
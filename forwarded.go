@@ -0,0 +1,163 @@
+package httpsign
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ForwardedHeaders determines which headers, set by a reverse proxy, describe the request as the client sent it.
+// Behind a TLS-terminating load balancer, the request's Host and TLS fields describe the connection from the proxy,
+// so "@authority", "@scheme" and "@target-uri" would not match what the client signed.
+type ForwardedHeaders int
+
+const (
+	// ForwardedNone ignores forwarding headers.
+	ForwardedNone ForwardedHeaders = iota
+	// ForwardedXForwarded uses the X-Forwarded-Host and X-Forwarded-Proto headers.
+	ForwardedXForwarded
+	// ForwardedRFC7239 uses the "host" and "proto" parameters of the Forwarded header (RFC 7239).
+	ForwardedRFC7239
+)
+
+// TrustedProxies determines which reverse proxies are trusted, and which of the forwarding headers they set are
+// believed, when reconstructing the "@scheme", "@authority" and "@target-uri" derived components of a request.
+// It is immutable, so that it may be shared by the configurations of a handler, its verifiers and its signers.
+// A nil *TrustedProxies trusts no proxy, which is the default.
+type TrustedProxies struct {
+	headers ForwardedHeaders
+	proxies []*net.IPNet
+}
+
+// NewTrustedProxies believes the forwarding headers h of requests received directly (per their RemoteAddr) from
+// one of the trusted proxies, each an IP address or a CIDR network such as "10.0.0.0/8". Other clients could
+// set the same headers. Invalid entries are ignored, so that they are never trusted.
+func NewTrustedProxies(h ForwardedHeaders, proxies ...string) *TrustedProxies {
+	t := &TrustedProxies{headers: h}
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				t.proxies = append(t.proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			t.proxies = append(t.proxies, n)
+		}
+	}
+	return t
+}
+
+// trusts returns true if the request was received directly from a trusted proxy
+func (t *TrustedProxies) trusts(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded returns the scheme and authority of the request as the client sent it, according to the headers
+// set by a trusted proxy. Either may be empty if it is unknown. If the request passed through several proxies,
+// the values added by the last one, i.e. the trusted proxy, are used.
+func (t *TrustedProxies) forwarded(req *http.Request) (scheme, authority string) {
+	if t == nil || t.headers == ForwardedNone || !t.trusts(req) {
+		return "", ""
+	}
+	switch t.headers {
+	case ForwardedXForwarded:
+		scheme = lastListMember(req.Header.Values("X-Forwarded-Proto"))
+		authority = lastListMember(req.Header.Values("X-Forwarded-Host"))
+	case ForwardedRFC7239:
+		elements := splitQuoted(strings.Join(req.Header.Values("Forwarded"), ","), ',')
+		if len(elements) > 0 {
+			for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+				eq := strings.IndexByte(pair, '=')
+				if eq < 0 {
+					continue
+				}
+				name, value := strings.ToLower(strings.TrimSpace(pair[:eq])), unquote(strings.TrimSpace(pair[eq+1:]))
+				switch name {
+				case "proto":
+					scheme = value
+				case "host":
+					authority = value
+				}
+			}
+		}
+	}
+	scheme = strings.ToLower(scheme)
+	if !isValidScheme(scheme) {
+		scheme = ""
+	}
+	if strings.ContainsAny(authority, " \t/?#@\\") {
+		authority = ""
+	}
+	return scheme, authority
+}
+
+func lastListMember(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	members := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(members[len(members)-1])
+}
+
+// splitQuoted splits s on sep, except within quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isValidScheme(scheme string) bool {
+	if scheme == "" || !isLCAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		c := scheme[i]
+		if !isLCAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
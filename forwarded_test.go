@@ -0,0 +1,120 @@
+package httpsign
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        *VerifyConfig
+		remoteAddr    string
+		header        map[string][]string
+		wantScheme    string
+		wantAuthority string
+	}{
+		{"none", NewVerifyConfig(), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}},
+			"http", "backend:8080"},
+		{"x-forwarded", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.0/8")), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}},
+			"https", "api.example.com"},
+		{"untrusted proxy", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.0/8")), "192.0.2.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}},
+			"http", "backend:8080"},
+		{"single address", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "192.0.2.1")), "192.0.2.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}}, "https", "backend:8080"},
+		{"ipv6", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "2001:db8::/32")), "[2001:db8::1]:1234",
+			map[string][]string{"X-Forwarded-Host": {"api.example.com"}}, "http", "api.example.com"},
+		{"invalid proxy", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "not-an-address")), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Host": {"api.example.com"}}, "http", "backend:8080"},
+		{"several proxies", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.1")), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"http, https"}, "X-Forwarded-Host": {"evil.example", "api.example.com"}},
+			"https", "api.example.com"},
+		{"other headers ignored", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedRFC7239, "10.0.0.1")), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}}, "http", "backend:8080"},
+		{"forwarded", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedRFC7239, "10.0.0.1")), "10.0.0.1:1234",
+			map[string][]string{"Forwarded": {`for=192.0.2.60;Proto=HTTPS;host="api.example.com:8443"`}},
+			"https", "api.example.com:8443"},
+		{"forwarded, several elements", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedRFC7239, "10.0.0.1")), "10.0.0.1:1234",
+			map[string][]string{"Forwarded": {`host=evil.example;proto=http`, `for="[2001:db8::1]";proto=https;host=api.example.com`}},
+			"https", "api.example.com"},
+		{"invalid values", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.1")), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"ht tp"}, "X-Forwarded-Host": {"api.example.com/path"}},
+			"http", "backend:8080"},
+		{"pinned values take precedence", NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.1")).
+			SetAuthority("pinned.example.com"), "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}},
+			"https", "pinned.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := readRequest("GET /foo?a=b HTTP/1.1\nHost: backend:8080\n\n")
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			message, err := parseRequest(req, &tt.config.derivation)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantScheme, message.derived["@scheme"])
+			assert.Equal(t, tt.wantAuthority, message.derived["@authority"])
+			assert.Equal(t, tt.wantScheme+"://"+tt.wantAuthority+"/foo?a=b", message.derived["@target-uri"])
+		})
+	}
+}
+
+func TestForwardedHeaders_Verify(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method", "@target-uri")
+	signer, _ := NewHMACSHA256Signer("key", key, nil, fields)
+	sent, _ := http.NewRequest("GET", "https://api.example.com/foo", nil)
+	sigInput, sig, err := SignRequest("sig1", *signer, sent)
+	assert.NoError(t, err)
+
+	// the request as received by the server, behind a TLS-terminating proxy
+	received := readRequest("GET /foo HTTP/1.1\nHost: backend:8080\nX-Forwarded-Proto: https\nX-Forwarded-Host: api.example.com\n\n")
+	received.RemoteAddr = "10.1.2.3:4567"
+	received.Header.Add("Signature-Input", sigInput)
+	received.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), fields)
+	assert.Error(t, VerifyRequest("sig1", *verifier, received))
+	verifier, _ = NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "10.0.0.0/8")), fields)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, received))
+}
+
+func TestTrustedProxies_Handler(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method", "@target-uri")
+	signer, _ := NewHMACSHA256Signer("key", key, nil, fields)
+	sent, _ := http.NewRequest("GET", "https://api.example.com/foo", nil)
+	sigInput, sig, err := SignRequest("sig1", *signer, sent)
+	assert.NoError(t, err)
+	received := func() *http.Request {
+		req := readRequest("GET /foo HTTP/1.1\nHost: backend:8080\nForwarded: for=192.0.2.60;proto=https;host=api.example.com\n\n")
+		req.RemoteAddr = "10.1.2.3:4567"
+		req.Header.Add("Signature-Input", sigInput)
+		req.Header.Add("Signature", sig)
+		return req
+	}
+	serve := func(verifierConfig *VerifyConfig, handlerProxies *TrustedProxies) int {
+		verifier, _ := NewHMACSHA256Verifier("key", key, verifierConfig, fields)
+		config := NewHandlerConfig().SetTrustedProxies(handlerProxies).
+			SetFetchVerifier(func(r *http.Request) (string, *Verifier) { return "sig1", verifier })
+		h := WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), *config)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, received())
+		return rec.Code
+	}
+	proxies := NewTrustedProxies(ForwardedRFC7239, "10.0.0.0/8")
+	assert.Equal(t, http.StatusUnauthorized, serve(NewVerifyConfig(), nil), "no proxy is trusted by default")
+	assert.Equal(t, http.StatusOK, serve(NewVerifyConfig(), proxies))
+	assert.Equal(t, http.StatusOK, serve(NewVerifyConfig().SetTrustedProxies(proxies), nil))
+	assert.Equal(t, http.StatusUnauthorized, serve(NewVerifyConfig().SetTrustedProxies(NewTrustedProxies(ForwardedNone)), proxies),
+		"the verifier's own configuration takes precedence")
+	assert.Equal(t, http.StatusUnauthorized, serve(NewVerifyConfig(), NewTrustedProxies(ForwardedXForwarded, "10.0.0.0/8")),
+		"other forwarding headers are not believed")
+}
@@ -0,0 +1,166 @@
+//go:build go1.18
+// +build go1.18
+
+package httpsign
+
+import (
+	"bytes"
+	"github.com/dunglas/httpsfv"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets for the parsing of untrusted headers. Run with e.g. go test -fuzz=FuzzParseSignatureInput.
+// The seed corpus also runs as part of the normal tests.
+
+var fuzzSignatureInputs = []string{
+	`("@method" "@path" "@authority");created=1618884475;keyid="test-key-rsa-pss"`,
+	`("@query-param";name="Pet" "example-dict";key="a" "example-dict";sf "x";bs "y";req;tr);alg="ed25519"`,
+	`();created=1618884473;nonce="b3k2pp5k7z-50gnwp.yemd"`,
+	`("@status" "content-type";req)`,
+	`("a";key="b";name="c")`,
+	`"not an inner list"`,
+	`(`,
+}
+
+func FuzzParseSignatureInput(f *testing.F) {
+	for _, s := range fuzzSignatureInputs {
+		f.Add(s, "sig1")
+	}
+	f.Fuzz(func(t *testing.T, input, label string) {
+		psi, err := parseSignatureInput(input, label)
+		if err != nil {
+			return
+		}
+		// Whatever parses must serialize, and parse back to the same components
+		var b bytes.Buffer
+		if psi.fields.writeInnerList(&b) != nil {
+			return // e.g. a component name that is not a valid sf-string
+		}
+		s := b.String()
+		again, err := parseSignatureInput(s, label)
+		if err != nil {
+			t.Fatalf("cannot parse serialized input %q (from %q): %v", s, input, err)
+		}
+		if !again.fields.contains(&psi.fields) || !psi.fields.contains(&again.fields) {
+			t.Fatalf("components changed: %v, %v", psi.fields.Components(), again.fields.Components())
+		}
+	})
+}
+
+func FuzzSignatureHeaders(f *testing.F) {
+	for _, s := range fuzzSignatureInputs {
+		f.Add("sig1="+s, "sig1=:dGVzdA==:", "sig1")
+	}
+	f.Add("sig1=(), sig2=(\"@method\")", "sig1=:AA==:, sig2=:AA==:", "sig2")
+	f.Fuzz(func(t *testing.T, signatureInput, signature, label string) {
+		req, err := http.NewRequest("POST", "https://example.com/foo?param=Value&Pet=dog", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Signature-Input", signatureInput)
+		req.Header.Set("Signature", signature)
+		req.Header.Set("Example-Dict", "a=1, b=2;x=1;y=2, c=(a b c)")
+		_, _ = RequestSignatures(req)
+		_, _, _ = RequestDetails(label, req)
+		for _, mode := range []ParsingMode{ParseDefault, ParseStrict, ParseLenient} {
+			verifier, err := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{1}, 64),
+				NewVerifyConfig().SetVerifyCreated(false).SetVerifyKeyID(false).SetParsingMode(mode), *NewFields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = VerifyRequest(label, *verifier, req); err == nil {
+				t.Fatalf("forged signature verified: %q, %q", signatureInput, signature)
+			}
+		}
+		h := req.Header.Clone()
+		_ = RemoveSignature(h, label)
+	})
+}
+
+func FuzzStrictDictionary(f *testing.F) {
+	for _, s := range fuzzSignatureInputs {
+		f.Add("sig1=" + s)
+	}
+	f.Add(`a=?1, b=-12.345;p, c=:AQI=:, d=tok/en*, e`)
+	f.Fuzz(func(t *testing.T, s string) {
+		if validateStrictDictionary("x", []string{s}) != nil {
+			return
+		}
+		if _, err := httpsfv.UnmarshalDictionary([]string{s}); err != nil {
+			t.Fatalf("strictly valid dictionary %q rejected by httpsfv: %v", s, err)
+		}
+	})
+}
+
+func FuzzTolerateDictionary(f *testing.F) {
+	for _, s := range fuzzSignatureInputs {
+		f.Add("Sig1 = " + s)
+	}
+	f.Add(`sig1=:dGVzdA:, SIG2=("a"  "b")`)
+	f.Fuzz(func(t *testing.T, s string) {
+		repaired, notes := tolerateDictionary([]string{s})
+		if len(notes) == 0 && repaired != s {
+			if _, err := httpsfv.UnmarshalDictionary([]string{s}); err == nil {
+				// a valid dictionary may be re-spaced, but must keep its meaning
+				d1, _ := httpsfv.UnmarshalDictionary([]string{s})
+				d2, err := httpsfv.UnmarshalDictionary([]string{repaired})
+				if err != nil {
+					t.Fatalf("valid dictionary %q broken into %q: %v", s, repaired, err)
+				}
+				m1, _ := httpsfv.Marshal(d1)
+				m2, _ := httpsfv.Marshal(d2)
+				if m1 != m2 {
+					t.Fatalf("valid dictionary %q changed to %q", s, repaired)
+				}
+			}
+		}
+	})
+}
+
+func FuzzComponentIdentifiers(f *testing.F) {
+	f.Add("@query-param", "name", "Pet")
+	f.Add("example-dict", "key", "a")
+	f.Add("example-dict", "sf", "")
+	f.Add("@method", "", "")
+	f.Add("x-bin", "bs", "")
+	f.Fuzz(func(t *testing.T, name, flagName, flagValue string) {
+		req, err := http.NewRequest("GET", "https://example.com/foo?param=Value&Pet=dog&a=", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Example-Dict", "a=1, b=2;x=1;y=2, c=(a b c)")
+		req.Header.Set("X-Bin", "one, two")
+		fields := Fields{f: []field{{name: name, flagName: flagName, flagValue: flagValue}}}
+		_, _ = RequestSignatureInput(req, fields, "()")
+		signer, err := NewHMACSHA256Signer("key", bytes.Repeat([]byte{1}, 64), NewSignConfig(), fields)
+		if err != nil {
+			return
+		}
+		_, _, _ = SignRequest("sig1", *signer, req)
+	})
+}
+
+func FuzzCanonicalSignatureInput(f *testing.F) {
+	for _, s := range fuzzSignatureInputs {
+		f.Add(s)
+	}
+	f.Add(`("a" "b";req;key="x");created=-1;expires=0;flag`)
+	f.Add(`("a"  "b");created=01`)
+	f.Fuzz(func(t *testing.T, input string) {
+		message := parsedMessage{headers: http.Header{"signature-input": {"sig1=" + input}}}
+		fast, ok := message.fastSignatureInput("sig1")
+		if !ok {
+			return
+		}
+		slow, err := parseSignatureInput(input, "sig1")
+		if err != nil {
+			t.Fatalf("%q parsed only by the fast path: %v", input, err)
+		}
+		if !reflect.DeepEqual(fast, slow) {
+			t.Fatalf("%q parsed differently: %+v, %+v", input, fast, slow)
+		}
+	})
+}
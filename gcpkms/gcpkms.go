@@ -0,0 +1,153 @@
+// Package gcpkms signs HTTP messages with asymmetric keys held in Google Cloud KMS.
+// Cloud KMS signs a pre-computed digest of the signature base, using the hash function
+// that matches the key version's algorithm.
+//
+// To avoid a dependency on the Cloud KMS client library, the package defines the small API interface.
+// With cloud.google.com/go/kms, it is implemented by a thin wrapper around KeyManagementClient:
+//
+//	func (c myClient) AsymmetricSign(ctx context.Context, name string, hash crypto.Hash, digest []byte) ([]byte, error) {
+//		d := &kmspb.Digest{}
+//		switch hash {
+//		case crypto.SHA256:
+//			d.Digest = &kmspb.Digest_Sha256{Sha256: digest}
+//		case crypto.SHA384:
+//			d.Digest = &kmspb.Digest_Sha384{Sha384: digest}
+//		case crypto.SHA512:
+//			d.Digest = &kmspb.Digest_Sha512{Sha512: digest}
+//		}
+//		res, err := c.kms.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{Name: name, Digest: d})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return res.Signature, nil
+//	}
+//
+//	func (c myClient) GetPublicKey(ctx context.Context, name string) (string, error) {
+//		res, err := c.kms.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: name})
+//		if err != nil {
+//			return "", err
+//		}
+//		return res.Pem, nil
+//	}
+package gcpkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/yaronf/httpsign"
+	"io"
+	"time"
+)
+
+// API is the subset of the Cloud KMS API used by this package.
+type API interface {
+	// AsymmetricSign signs a digest, computed with the given hash function, using a key version.
+	AsymmetricSign(ctx context.Context, keyVersionName string, hash crypto.Hash, digest []byte) ([]byte, error)
+	// GetPublicKey returns the PEM-encoded public key of a key version.
+	GetPublicKey(ctx context.Context, keyVersionName string) (string, error)
+}
+
+// Backoff decides whether a failed KMS call is retried. It is called with the number of attempts
+// made so far and the last error, and returns the delay before the next attempt, or false to give up.
+type Backoff func(attempt int, err error) (time.Duration, bool)
+
+// ExponentialBackoff returns a Backoff that makes up to maxAttempts attempts, starting with the initial delay
+// and doubling it each time. If retryable is not nil, only errors for which it returns true are retried.
+func ExponentialBackoff(maxAttempts int, initial time.Duration, retryable func(error) bool) Backoff {
+	return func(attempt int, err error) (time.Duration, bool) {
+		if attempt >= maxAttempts || (retryable != nil && !retryable(err)) {
+			return 0, false
+		}
+		return initial << (attempt - 1), true
+	}
+}
+
+// hashes maps HTTP signature algorithms to the digest that KMS expects
+var hashes = map[string]crypto.Hash{
+	"rsa-v1_5-sha256":   crypto.SHA256,
+	"rsa-pss-sha512":    crypto.SHA512,
+	"ecdsa-p256-sha256": crypto.SHA256,
+	"ecdsa-p384-sha384": crypto.SHA384,
+}
+
+// NewSigner returns a Signer whose signatures are computed by Cloud KMS. The key version's resource name
+// (projects/.../cryptoKeyVersions/N) is used as the "keyid" signature parameter. Alg is the HTTP signature
+// algorithm, which must match the key version's algorithm. The public key is fetched once, using ctx.
+// Each signature is computed with the context of the message being signed, see httpsign.ContextSigner, and
+// retries stop when it is done. Backoff may be nil, in which case failed calls are not retried.
+// Config may be nil for a default configuration.
+func NewSigner(ctx context.Context, api API, keyVersionName, alg string, backoff Backoff,
+	config *httpsign.SignConfig, fields httpsign.Fields) (*httpsign.Signer, error) {
+	if api == nil {
+		return nil, fmt.Errorf("nil KMS API")
+	}
+	hash, ok := hashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("algorithm \"%s\" is not supported by Cloud KMS", alg)
+	}
+	s := &kmsSigner{api: api, name: keyVersionName, hash: hash, backoff: backoff}
+	var pemKey string
+	err := s.retry(ctx, func() (err error) {
+		pemKey, err = api.GetPublicKey(ctx, keyVersionName)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch public key for \"%s\": %w", keyVersionName, err)
+	}
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, fmt.Errorf("malformed public key for \"%s\"", keyVersionName)
+	}
+	s.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key for \"%s\": %w", keyVersionName, err)
+	}
+	return httpsign.NewSignerFromCryptoSigner(alg, keyVersionName, s, config, fields)
+}
+
+// kmsSigner is a crypto.Signer backed by a KMS key version
+type kmsSigner struct {
+	api     API
+	name    string
+	hash    crypto.Hash
+	backoff Backoff
+	public  crypto.PublicKey
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+func (s *kmsSigner) SignContext(ctx context.Context, _ io.Reader, digest []byte, _ crypto.SignerOpts) (sig []byte, err error) {
+	err = s.retry(ctx, func() (err error) {
+		sig, err = s.api.AsymmetricSign(ctx, s.name, s.hash, digest)
+		return
+	})
+	return
+}
+
+// retry calls f until it succeeds, or the backoff policy gives up
+func (s *kmsSigner) retry(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || s.backoff == nil {
+			return err
+		}
+		delay, again := s.backoff(attempt, err)
+		if !again {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
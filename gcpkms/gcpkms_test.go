@@ -0,0 +1,100 @@
+package gcpkms
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKMS signs with a local key, failing the first few calls
+type fakeKMS struct {
+	key      *ecdsa.PrivateKey
+	failures int
+	calls    int
+	gotHash  crypto.Hash
+}
+
+func (f *fakeKMS) AsymmetricSign(_ context.Context, _ string, hash crypto.Hash, digest []byte) ([]byte, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, fmt.Errorf("unavailable")
+	}
+	f.gotHash = hash
+	return ecdsa.SignASN1(rand.Reader, f.key, digest)
+}
+
+func (f *fakeKMS) GetPublicKey(context.Context, string) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func TestNewSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	name := "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	fields := httpsign.Headers("@method", "@path")
+	verifier, _ := httpsign.NewP384Verifier(name, key.PublicKey, nil, fields)
+
+	tests := []struct {
+		name     string
+		failures int
+		backoff  Backoff
+		wantErr  bool
+	}{
+		{"no failures", 0, nil, false},
+		{"failure without retries", 1, nil, true},
+		{"failures with retries", 2, ExponentialBackoff(3, time.Millisecond, nil), false},
+		{"too many failures", 3, ExponentialBackoff(3, time.Millisecond, nil), true},
+		{"non-retryable failure", 1, ExponentialBackoff(3, time.Millisecond, func(error) bool { return false }), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kms := &fakeKMS{key: key, failures: tt.failures}
+			signer, err := NewSigner(context.Background(), kms, name, "ecdsa-p384-sha384", tt.backoff, nil, fields)
+			assert.NoError(t, err)
+			req, _ := http.ReadRequest(bufio.NewReader(strings.NewReader("GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+			sigInput, sig, err := httpsign.SignRequest("sig1", *signer, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, crypto.SHA384, kms.gotHash)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			assert.NoError(t, httpsign.VerifyRequest("sig1", *verifier, req))
+		})
+	}
+}
+
+func TestSignCanceled(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	kms := &fakeKMS{key: key, failures: 10}
+	name := "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	signer, err := NewSigner(context.Background(), kms, name, "ecdsa-p384-sha384", ExponentialBackoff(10, time.Hour, nil),
+		nil, httpsign.Headers("@method"))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://example.com/foo", nil)
+	_, _, err = httpsign.SignRequest("sig1", *signer, req)
+	assert.Error(t, err, "the retries should stop with the request")
+	assert.Equal(t, 1, kms.calls)
+}
@@ -0,0 +1,105 @@
+// Package grpcgateway verifies the signatures of JSON/HTTP requests served by grpc-gateway, and forwards
+// the verified identity to the gRPC service as metadata.
+//
+// To avoid a dependency on grpc-gateway and gRPC, metadata is represented as map[string][]string, the underlying
+// type of metadata.MD. The gateway's ServeMux is wrapped by Handler, and Metadata is registered as a ServeMuxOption:
+//
+//	mux := runtime.NewServeMux(runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+//		return grpcgateway.Metadata(ctx, r)
+//	}))
+//	// register the services on mux, then
+//	http.ListenAndServe(":8080", grpcgateway.Handler(mux, *config))
+//
+// The gRPC service reads the identity with FromMetadata, e.g. with metadata.FromIncomingContext.
+package grpcgateway
+
+import (
+	"context"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Metadata keys. gRPC metadata keys are lowercase.
+const (
+	KeyLabel      = "x-httpsign-label"
+	KeyKeyID      = "x-httpsign-keyid"
+	KeyAlg        = "x-httpsign-alg"
+	KeyTag        = "x-httpsign-tag"
+	KeyCreated    = "x-httpsign-created"
+	KeyExpires    = "x-httpsign-expires"
+	KeyComponents = "x-httpsign-components"
+)
+
+// headerPrefix is the prefix of the HTTP headers that grpc-gateway forwards as metadata by default
+const headerPrefix = "Grpc-Metadata-"
+
+// Handler verifies requests and signs responses like httpsign.WrapHandler. In addition, it removes any
+// incoming header that grpc-gateway would forward as one of this package's metadata keys,
+// so that a client cannot forge the verified identity.
+func Handler(mux http.Handler, config httpsign.HandlerConfig) http.Handler {
+	return httpsign.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(headerPrefix)+"x-httpsign-") {
+				r.Header.Del(name)
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}), config)
+}
+
+// Metadata returns the verified signature of the request as gRPC metadata, or nil if the request was not verified.
+// Its signature matches the callback of the grpc-gateway WithMetadata option.
+func Metadata(_ context.Context, r *http.Request) map[string][]string {
+	vr := httpsign.GetVerifiedRequest(r)
+	if vr == nil {
+		return nil
+	}
+	sig := vr.Signature()
+	md := map[string][]string{
+		KeyLabel:      {sig.Label},
+		KeyComponents: sig.Fields.Components(),
+	}
+	for k, v := range map[string]string{KeyKeyID: sig.KeyID, KeyAlg: sig.Alg, KeyTag: sig.Tag} {
+		if v != "" {
+			md[k] = []string{v}
+		}
+	}
+	for k, v := range map[string]int64{KeyCreated: sig.Created, KeyExpires: sig.Expires} {
+		if v != 0 {
+			md[k] = []string{strconv.FormatInt(v, 10)}
+		}
+	}
+	return md
+}
+
+// Identity is the verified signature, as forwarded to the gRPC service.
+type Identity struct {
+	Label      string
+	KeyID      string
+	Alg        string
+	Tag        string
+	Created    int64
+	Expires    int64
+	Components []string
+}
+
+// FromMetadata returns the verified signature forwarded by Metadata. Returns false if the request
+// was not verified.
+func FromMetadata(md map[string][]string) (Identity, bool) {
+	first := func(key string) string {
+		if vv := md[key]; len(vv) > 0 {
+			return vv[0]
+		}
+		return ""
+	}
+	if first(KeyLabel) == "" {
+		return Identity{}, false
+	}
+	id := Identity{Label: first(KeyLabel), KeyID: first(KeyKeyID), Alg: first(KeyAlg), Tag: first(KeyTag),
+		Components: md[KeyComponents]}
+	id.Created, _ = strconv.ParseInt(first(KeyCreated), 10, 64)
+	id.Expires, _ = strconv.ParseInt(first(KeyExpires), 10, 64)
+	return id, true
+}
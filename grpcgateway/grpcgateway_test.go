@@ -0,0 +1,71 @@
+package grpcgateway
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeGateway forwards metadata the way grpc-gateway does: from Grpc-Metadata- headers, and from the WithMetadata callback
+func fakeGateway(received *map[string][]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md := map[string][]string{}
+		for name, values := range r.Header {
+			if strings.HasPrefix(name, headerPrefix) {
+				key := strings.ToLower(strings.TrimPrefix(name, headerPrefix))
+				md[key] = append(md[key], values...)
+			}
+		}
+		for k, v := range Metadata(r.Context(), r) {
+			md[k] = append(md[k], v...)
+		}
+		*received = md
+	})
+}
+
+func TestHandler(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *httpsign.Verifier) {
+		verifier, _ := httpsign.NewHMACSHA256Verifier("client-1", key, nil, httpsign.Headers("@method", "@path"))
+		return "sig1", verifier
+	}
+	var received map[string][]string
+	ts := httptest.NewServer(Handler(fakeGateway(&received), *httpsign.NewHandlerConfig().SetFetchVerifier(fetchVerifier)))
+	defer ts.Close()
+
+	signer, _ := httpsign.NewHMACSHA256Signer("client-1", key, httpsign.NewSignConfig().SetTag("app"), httpsign.Headers("@method", "@path"))
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/items", nil)
+	req.Header.Set("Grpc-Metadata-X-Httpsign-Keyid", "admin") // forged
+	res, err := httpsign.NewDefaultClient("sig1", signer, nil, nil).Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	id, ok := FromMetadata(received)
+	assert.True(t, ok)
+	assert.Equal(t, "sig1", id.Label)
+	assert.Equal(t, "client-1", id.KeyID)
+	assert.Equal(t, "hmac-sha256", id.Alg)
+	assert.Equal(t, "app", id.Tag)
+	assert.NotZero(t, id.Created)
+	assert.Zero(t, id.Expires)
+	assert.Equal(t, []string{"@method", "@path"}, id.Components)
+	assert.Equal(t, []string{"client-1"}, received[KeyKeyID], "forged header should be removed")
+
+	res, err = http.Get(ts.URL + "/v1/items")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestFromMetadata(t *testing.T) {
+	_, ok := FromMetadata(nil)
+	assert.False(t, ok)
+	_, ok = FromMetadata(map[string][]string{KeyKeyID: {"k"}})
+	assert.False(t, ok, "label is required")
+	id, ok := FromMetadata(map[string][]string{KeyLabel: {"sig1"}, KeyExpires: {"123"}})
+	assert.True(t, ok)
+	assert.Equal(t, Identity{Label: "sig1", Expires: 123}, id)
+}
@@ -1,10 +1,11 @@
 package httpsign
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,36 +15,211 @@ import (
 // it should be created explicitly.
 func WrapHandler(h http.Handler, config HandlerConfig) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if config.fetchVerifier != nil {
-			if !verifyServerRequest(w, r, config) {
-				return
-			}
+		_ = serveWrapped(w, r, config, func(w http.ResponseWriter, r *http.Request) error {
+			h.ServeHTTP(w, r)
+			return nil
+		})
+	})
+}
+
+// serveWrapped verifies the request, calls next and signs the response. If next fails before
+// it writes the response, its error is returned and nothing is sent, so that the caller can respond.
+func serveWrapped(w http.ResponseWriter, r *http.Request, config HandlerConfig, next ErrorHandler) error {
+	if (config.fetchVerifier != nil || config.selectVerifiers != nil) && !isExempt(r, config) {
+		vr, ok := verifyServerRequest(w, r, config)
+		if !ok {
+			return nil
+		}
+		r = r.WithContext(context.WithValue(r.Context(), verifiedRequestKey{}, vr))
+	}
+	wrapped := newWrappedResponseWriter(w, r, config) // and this includes response signature
+	err := next(wrapped, r)
+	if err != nil && !wrapped.wroteHeader && !wrapped.wroteBody {
+		return err
+	}
+	if !wrapped.wroteBody { // Body-less responses are rare but possible
+		if !wrapped.wroteHeader {
+			wrapped.status = http.StatusOK
 		}
-		wrapped := newWrappedResponseWriter(w, r, config) // and this includes response signature
-		h.ServeHTTP(wrapped, r)
-		if !wrapped.wroteBody { // Body-less responses are rare but possible
-			if config.fetchSigner != nil {
-				_ = signServerResponse(wrapped, r, config) // failures are handled by call
+		if config.fetchSigner != nil {
+			if !signServerResponse(wrapped, r, config) {
+				return err // failures are handled by call
 			}
 		}
+		wrapped.ResponseWriter.WriteHeader(wrapped.status)
+	}
+	if wrapped.trailers != nil {
+		wrapped.finishTrailers()
+	}
+	return err
+}
 
-	})
+// Middleware returns the same wrapper as WrapHandler, in the conventional form of a middleware constructor,
+// so that it can be composed with middleware stacks such as chi or alice.
+func Middleware(config HandlerConfig) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return WrapHandler(h, config)
+	}
+}
+
+// SelectMiddleware is a Middleware whose HandlerConfig is chosen per request by selectConfig, so that a single
+// middleware can apply different key fetchers or required fields to different routes. If selectConfig returns nil,
+// the request is passed to the handler as is. With chi, route groups can simply Use different Middleware
+// instances, and SelectMiddleware can be used where the config depends on the matched route pattern:
+//
+//	r.Use(httpsign.SelectMiddleware(func(r *http.Request) *httpsign.HandlerConfig {
+//		return configs[chi.RouteContext(r.Context()).RoutePattern()]
+//	}))
+func SelectMiddleware(selectConfig func(r *http.Request) *HandlerConfig) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config := selectConfig(r)
+			if config == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			WrapHandler(h, *config).ServeHTTP(w, r)
+		})
+	}
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isExempt determines whether the request is exempt from verification
+func isExempt(r *http.Request, config HandlerConfig) bool {
+	if (config.exemptPreflight && isPreflight(r)) || (config.exemptSafe && isSafeMethod(r.Method)) {
+		return true
+	}
+	if config.exemptMethods[r.Method] || config.exemptPaths[r.URL.Path] {
+		return true
+	}
+	for _, prefix := range config.exemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return config.exempt != nil && config.exempt(r)
 }
 
 // This error case is not optional, as it's always a server bug
-func sigFailed(w http.ResponseWriter, _ *http.Request, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
-	log.Printf("Failed to sign response: %v\n", err)
-	_, _ = fmt.Fprintln(w, "Failed to sign response.") // For security reasons, error is not printed
+func (w *wrappedResponseWriter) sigFailed(err error) {
+	w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+	loggerOrNop(w.config.logger).Printf("Failed to sign response: %v", err)
+	_, _ = fmt.Fprintln(w.ResponseWriter, "Failed to sign response.") // For security reasons, error is not printed
 }
 
 // This needs to happen exactly at the point when the response headers (other than status!) had been written,
 // but not yet the body, so that signature headers can be added.
 func signServerResponse(wrapped *wrappedResponseWriter, r *http.Request, config HandlerConfig) (success bool) {
+	response := serverResponse(wrapped, r)
+	if config.fetchSigner == nil {
+		wrapped.sigFailed(fmt.Errorf("could not fetch a Signer"))
+		return false
+	}
+	candidates, err := responseSigners(response, r, config)
+	if err != nil {
+		wrapped.sigFailed(err)
+		return false
+	}
+	for i, c := range candidates {
+		signatureInput, signature, err := SignResponse(c.sigName, c.signer, &response)
+		if err == nil {
+			err = AddSignature(wrapped.Header(), signatureInput, signature)
+		}
+		if err == nil {
+			return true
+		}
+		if i == len(candidates)-1 { // otherwise, fall back to the configured signature
+			wrapped.sigFailed(fmt.Errorf("failed to sign the response: %w", err))
+		}
+	}
+	return false
+}
+
+// namedSigner is a signer of the response, with the name of its signature
+type namedSigner struct {
+	sigName string
+	signer  MessageSigner
+}
+
+// responseSigners fetches the signer of the response and prepares it, the same way whether the signature
+// is sent in headers or in trailers: the fetchSigner callback sees the negotiation, if any, and the signer
+// uses the handler's trusted proxies and covers "@status". If SetHonorAcceptSignature is set and the request
+// asks for a signature the signer can produce, that signature comes first, followed by the configured one
+// as a fallback.
+func responseSigners(response http.Response, r *http.Request, config HandlerConfig) ([]namedSigner, error) {
+	negotiated, found := negotiateResponse(&response, config.signingKeys)
+	sigName, signer := config.fetchSigner(response, withNegotiation(r, negotiated, found))
+	if isNilSigner(signer) {
+		return nil, fmt.Errorf("could not fetch a Signer, check key ID")
+	}
+	signer = coverStatus(signerWithTrustedProxies(signer, config.trustedProxies), config)
+	configured := namedSigner{sigName: sigName, signer: signer}
+	if !config.honorAccept {
+		return []namedSigner{configured}, nil
+	}
+	label, shaped, ok := acceptedSigner(r, signer)
+	if s := asSigner(signer); found && s != nil && s.keyID == negotiated.Key.KeyID && s.alg == negotiated.Key.Alg {
+		label, shaped, ok = negotiated.Accepted.Label, shapeSigner(s, negotiated.Accepted), true
+	}
+	if !ok {
+		return []namedSigner{configured}, nil
+	}
+	return []namedSigner{{sigName: label, signer: shaped}, configured}, nil
+}
+
+// coverStatus adds "@status" to the fields of the signer, unless configured otherwise
+func coverStatus(signer MessageSigner, config HandlerConfig) MessageSigner {
+	s := asSigner(signer)
+	if config.skipStatus || s == nil || s.fields.coversName("@status") {
+		return signer
+	}
+	withStatus := *s
+	withStatus.fields = Fields{f: append(append([]field(nil), s.fields.f...), field{name: "@status"})}
+	return &withStatus
+}
+
+// verifierWithTrustedProxies returns a copy of the verifier that uses the handler's trusted proxies,
+// unless the verifier's configuration sets its own
+func verifierWithTrustedProxies(verifier MessageVerifier, t *TrustedProxies) MessageVerifier {
+	v := asVerifier(verifier)
+	if t == nil || v == nil || (v.config != nil && v.config.derivation.trustedProxies != nil) {
+		return verifier
+	}
+	withProxies := *v
+	withProxies.config = v.config.Clone()
+	withProxies.config.derivation.trustedProxies = t
+	return &withProxies
+}
+
+// signerWithTrustedProxies is the same as verifierWithTrustedProxies, for the signer of the response
+func signerWithTrustedProxies(signer MessageSigner, t *TrustedProxies) MessageSigner {
+	s := asSigner(signer)
+	if t == nil || s == nil || (s.config != nil && s.config.derivation.trustedProxies != nil) {
+		return signer
+	}
+	withProxies := *s
+	withProxies.config = s.config.Clone()
+	withProxies.config.derivation.trustedProxies = t
+	return &withProxies
+}
+
+// serverResponse returns the response as it is about to be sent, adding a Date header if needed
+func serverResponse(wrapped *wrappedResponseWriter, r *http.Request) http.Response {
 	if wrapped.Header().Get("Date") == "" {
 		wrapped.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
 	}
-	response := http.Response{
+	return http.Response{
 		Status:           strconv.Itoa(wrapped.status),
 		StatusCode:       wrapped.status,
 		Proto:            r.Proto,
@@ -59,23 +235,6 @@ func signServerResponse(wrapped *wrappedResponseWriter, r *http.Request, config
 		Request:          r,
 		TLS:              nil,
 	}
-	if config.fetchSigner == nil {
-		sigFailed(wrapped.ResponseWriter, r, fmt.Errorf("could not fetch a Signer"))
-		return false
-	}
-	sigName, signer := config.fetchSigner(response, r)
-	if signer == nil {
-		sigFailed(wrapped.ResponseWriter, r, fmt.Errorf("could not fetch a Signer, check key ID"))
-		return false
-	}
-	signatureInput, signature, err := SignResponse(sigName, *signer, &response)
-	if err != nil {
-		sigFailed(wrapped.ResponseWriter, r, fmt.Errorf("failed to sign the response: %w", err))
-		return false
-	}
-	wrapped.Header().Add("Signature-Input", signatureInput)
-	wrapped.Header().Add("Signature", signature)
-	return true
 }
 
 type wrappedResponseWriter struct {
@@ -86,6 +245,7 @@ type wrappedResponseWriter struct {
 	ignoreWrites bool
 	config       HandlerConfig
 	r            *http.Request
+	trailers     *trailerSigner // non-nil if the signature is sent in trailers
 }
 
 func newWrappedResponseWriter(w http.ResponseWriter, r *http.Request, config HandlerConfig) *wrappedResponseWriter {
@@ -95,21 +255,29 @@ func newWrappedResponseWriter(w http.ResponseWriter, r *http.Request, config Han
 func (w *wrappedResponseWriter) Write(p []byte) (n int, err error) {
 	if !w.wroteBody {
 		w.wroteBody = true
+		if !w.wroteHeader { // the status must be known before it is signed
+			w.status = http.StatusOK
+			w.wroteHeader = true
+		}
 		if w.config.fetchSigner != nil {
-			if !signServerResponse(w, w.r, w.config) {
+			var signed bool
+			if w.config.signTrailers {
+				signed = w.startTrailers()
+			} else {
+				signed = signServerResponse(w, w.r, w.config)
+			}
+			if !signed {
 				w.ignoreWrites = true
 				return 0, fmt.Errorf("failed to sign response headers")
 			}
 		}
-		if !w.wroteHeader {
-			w.ResponseWriter.WriteHeader(http.StatusOK)
-		} else {
-			w.ResponseWriter.WriteHeader(w.status)
-		}
-		w.wroteHeader = true
+		w.ResponseWriter.WriteHeader(w.status)
 	}
 	w.wroteBody = true
 	if !w.ignoreWrites {
+		if w.trailers != nil && w.trailers.digest != nil {
+			w.trailers.digest.Write(p)
+		}
 		return w.ResponseWriter.Write(p)
 	}
 	return len(p), nil // write is silently ignored
@@ -120,20 +288,106 @@ func (w *wrappedResponseWriter) WriteHeader(code int) {
 	w.wroteHeader = true
 }
 
-func verifyServerRequest(w http.ResponseWriter, r *http.Request, config HandlerConfig) bool {
-	if config.fetchVerifier == nil {
-		config.reqNotVerified(w, r, fmt.Errorf("could not fetch a Verifier"))
-		return false
+// Flush sends the response headers, if they were not sent yet, and any buffered data, e.g. for server-sent events.
+func (w *wrappedResponseWriter) Flush() {
+	if !w.wroteBody {
+		_, _ = w.Write(nil)
 	}
-	sigName, verifier := config.fetchVerifier(r)
-	if verifier == nil {
-		config.reqNotVerified(w, r, fmt.Errorf("could not fetch a Verifier, check key ID"))
-		return false
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// verifyServerRequest returns the verification result, and whether verification was successful.
+// On failure, the reqNotVerified callback has already been called.
+func verifyServerRequest(w http.ResponseWriter, r *http.Request, config HandlerConfig) (*VerifiedRequest, bool) {
+	t := config.timeouts
+	ctx, cancel := t.withTotal(r.Context())
+	defer cancel()
+	vr := &VerifiedRequest{}
+	var err error
+	if config.maxBufferedBody > 0 {
+		rb := r.WithContext(ctx)
+		err = runStage(ctx, StageBody, t.Body, func(context.Context) error {
+			return vr.bufferBody(rb, config.maxBufferedBody)
+		})
+		publishBody(&r.Body, &rb.Body, err)
+	}
+	if err == nil {
+		rv := r.WithContext(ctx)
+		vr.signatures, err = verifyServerSignatures(rv, config)
+		publishBody(&r.Body, &rv.Body, err) // verification may have replaced the body
 	}
-	err := VerifyRequest(sigName, *verifier, r)
 	if err != nil {
 		config.reqNotVerified(w, r, err)
-		return false
+		return nil, false
+	}
+	return vr, true
+}
+
+// verifyServerSignatures returns the details of the verified signatures
+func verifyServerSignatures(r *http.Request, config HandlerConfig) ([]SignatureDetails, error) {
+	if config.selectVerifiers != nil {
+		return verifyServerSelectedSignatures(r, config)
+	}
+	if config.fetchVerifier == nil {
+		return nil, fmt.Errorf("could not fetch a Verifier")
+	}
+	var sigName string
+	var verifier MessageVerifier
+	err := runStage(r.Context(), StageKeyResolution, config.timeouts.KeyResolution, func(ctx context.Context) error {
+		sigName, verifier = config.fetchVerifier(r.WithContext(ctx))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if isNilVerifier(verifier) {
+		return nil, fmt.Errorf("could not fetch a Verifier, check key ID")
+	}
+	verifier = verifierWithTrustedProxies(verifier, config.trustedProxies)
+	err = runStage(r.Context(), StageCrypto, config.timeouts.Crypto, func(context.Context) error {
+		return VerifyRequest(sigName, verifier, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sigs, _ := RequestSignatures(r) // cannot fail, the request was just verified
+	return selectSignatures(sigs, []string{sigName}), nil
+}
+
+func verifyServerSelectedSignatures(r *http.Request, config HandlerConfig) ([]SignatureDetails, error) {
+	var sigs []SignatureDetails
+	err := runStage(r.Context(), StageParse, config.timeouts.Parse, func(context.Context) (err error) {
+		sigs, err = RequestSignatures(r)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	var verifiers map[string]Verifier
+	err = runStage(r.Context(), StageKeyResolution, config.timeouts.KeyResolution, func(ctx context.Context) error {
+		verifiers = config.selectVerifiers(r.WithContext(ctx), sigs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("no signature was selected for verification")
+	}
+	verifiers, err = resolveLabels(r, verifiers)
+	if err != nil {
+		return nil, err
+	}
+	for label, v := range verifiers {
+		verifiers[label] = *asVerifier(verifierWithTrustedProxies(v, config.trustedProxies))
+	}
+	err = runStage(r.Context(), StageCrypto, config.timeouts.Crypto, func(context.Context) error {
+		return VerifyRequestSignatures(verifiers, r)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return true
+	return selectSignatures(sigs, sortedKeys(verifiers)), nil
 }
@@ -8,7 +8,9 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_WrapHandler(t *testing.T) {
@@ -194,3 +196,459 @@ func TestWrapHandlerServerFails(t *testing.T) { // non-default verify handler
 
 	assert.Equal(t, res.StatusCode, 599, "Verification did not fail?")
 }
+
+func TestWrapHandlerSelectVerifiers(t *testing.T) {
+	clientVerifier, _ := NewHMACSHA256Verifier("client-key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+	gatewayVerifier, _ := NewHMACSHA256Verifier("gateway-key", bytes.Repeat([]byte{2}, 64), nil, Headers("@method"))
+	selectVerifiers := func(r *http.Request, sigs []SignatureDetails) map[string]Verifier {
+		verifiers := map[string]Verifier{}
+		for _, s := range sigs {
+			switch s.KeyID {
+			case "client-key":
+				verifiers[s.Label] = *clientVerifier
+			case "gateway-key":
+				verifiers[s.Label] = *gatewayVerifier
+			}
+		}
+		return verifiers
+	}
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = fmt.Fprintln(w, "Hello, client")
+	}
+	config := NewHandlerConfig().SetSelectVerifiers(selectVerifiers)
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+	defer ts.Close()
+
+	send := func(gatewayKey byte) int {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		assert.NoError(t, err)
+		clientSigner, _ := NewHMACSHA256Signer("client-key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+		sigInput, sig, err := SignRequest("sig1", *clientSigner, req)
+		assert.NoError(t, err)
+		assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+		gatewaySigner, _ := NewHMACSHA256Signer("gateway-key", bytes.Repeat([]byte{gatewayKey}, 64), nil, Headers("@method"))
+		sigInput, sig, err = SignRequest("gw", *gatewaySigner, req)
+		assert.NoError(t, err)
+		assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, 200, send(2), "both signatures should verify")
+	assert.Equal(t, http.StatusUnauthorized, send(3), "gateway signature should fail")
+}
+
+func TestWrapHandlerExemptions(t *testing.T) {
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+	tests := []struct {
+		name       string
+		config     *HandlerConfig
+		method     string
+		preflight  bool
+		wantStatus int
+	}{
+		{"preflight not exempt by default", NewHandlerConfig(), "OPTIONS", true, 401},
+		{"preflight exempt", NewHandlerConfig().SetExemptPreflight(true), "OPTIONS", true, 200},
+		{"plain OPTIONS is not a preflight", NewHandlerConfig().SetExemptPreflight(true), "OPTIONS", false, 401},
+		{"POST not exempt", NewHandlerConfig().SetExemptPreflight(true), "POST", false, 401},
+		{"GET exempt as safe method", NewHandlerConfig().SetExemptSafeMethods(true), "GET", false, 200},
+		{"DELETE not a safe method", NewHandlerConfig().SetExemptSafeMethods(true), "DELETE", false, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config.SetFetchVerifier(fetchVerifier)
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+			defer ts.Close()
+			req, err := http.NewRequest(tt.method, ts.URL, nil)
+			assert.NoError(t, err)
+			if tt.preflight {
+				req.Header.Set("Origin", "https://example.com")
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestWrapHandlerVerifiedRequest(t *testing.T) {
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	var gotKeyID, gotLabel string
+	var gotBodies []string
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		vr := GetVerifiedRequest(r)
+		if vr == nil {
+			w.WriteHeader(500)
+			return
+		}
+		gotKeyID, gotLabel = vr.KeyID(), vr.Label()
+		for i := 0; i < 2; i++ {
+			body, err := vr.Body()
+			assert.NoError(t, err)
+			b, _ := io.ReadAll(body)
+			gotBodies = append(gotBodies, string(b))
+		}
+		w.WriteHeader(200)
+	}
+	config := NewHandlerConfig().SetFetchVerifier(fetchVerifier).SetBufferBody(16)
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+	client := NewDefaultClient("sig1", signer, nil, nil)
+	res, err := client.Post(ts.URL, "text/plain", bytes.NewBufferString("hello"))
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "key", gotKeyID)
+	assert.Equal(t, "sig1", gotLabel)
+	assert.Equal(t, []string{"hello", "hello"}, gotBodies)
+
+	res, err = client.Post(ts.URL, "text/plain", bytes.NewBufferString("this body is too long"))
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "body exceeds the buffer limit")
+}
+
+func TestWrapHandlerVerifiedRequestIgnoresTags(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		return "sig1", verifier
+	}
+	selectVerifiers := func(r *http.Request, sigs []SignatureDetails) map[string]Verifier {
+		return map[string]Verifier{"sig1": *verifier}
+	}
+	var gotKeyID, gotLabel string
+	var gotCount int
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		vr := GetVerifiedRequest(r)
+		gotKeyID, gotLabel, gotCount = vr.KeyID(), vr.Label(), len(vr.Signatures())
+		w.WriteHeader(200)
+	}
+	for name, config := range map[string]*HandlerConfig{
+		"fetch verifier":   NewHandlerConfig().SetFetchVerifier(fetchVerifier),
+		"select verifiers": NewHandlerConfig().SetSelectVerifiers(selectVerifiers),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+			defer ts.Close()
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			// an unverified signature that claims the verified signature's label as its tag
+			assert.NoError(t, AddSignature(req.Header, `evil=("@method");keyid="admin";tag="sig1"`, `evil=:AAAA:`))
+			signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method"))
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, 200, res.StatusCode)
+			assert.Equal(t, "key", gotKeyID)
+			assert.Equal(t, "sig1", gotLabel)
+			assert.Equal(t, 1, gotCount, "only the verified signature should be reported")
+		})
+	}
+}
+
+func TestWrapHandlerSignsStatus(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@status", "date"))
+		return "sig1", signer
+	}
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		want    int
+	}{
+		{"implicit status with body", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintln(w, "Hello, client")
+		}, http.StatusOK},
+		{"explicit status with body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintln(w, "Not here")
+		}, http.StatusNotFound},
+		{"explicit status without body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent},
+		{"implicit status without body", func(w http.ResponseWriter, r *http.Request) {
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(tt.handler), *NewHandlerConfig().SetFetchSigner(fetchSigner)))
+			defer ts.Close()
+			res, err := http.Get(ts.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, res.StatusCode)
+			verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetRequireStatusCoverage(true), *NewFields())
+			assert.NoError(t, VerifyResponse("sig1", *verifier, res), "signed status should match the actual one")
+			res.StatusCode = http.StatusTeapot
+			assert.Error(t, VerifyResponse("sig1", *verifier, res))
+		})
+	}
+}
+
+func TestWrapHandlerRequestTrailers(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 64)
+	fields := *NewFields().AddHeader("@method").AddTrailers("X-Checksum")
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyTrailers(1024), fields)
+	config := NewHandlerConfig().SetFetchVerifier(func(r *http.Request) (string, *Verifier) {
+		return "sig1", verifier
+	})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s", b, r.Trailer.Get("X-Checksum"))
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *config))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key", key, nil, fields)
+	for _, checksum := range []string{"abc", "xyz"} {
+		req, _ := http.NewRequest("POST", ts.URL, io.MultiReader(strings.NewReader("streamed "), strings.NewReader("body")))
+		req.ContentLength = -1 // chunked
+		req.Trailer = http.Header{"X-Checksum": []string{"abc"}}
+		sigInput, sig, err := SignRequest("sig1", *signer, req)
+		assert.NoError(t, err)
+		req.Header.Add("Signature-Input", sigInput)
+		req.Header.Add("Signature", sig)
+		req.Trailer.Set("X-Checksum", checksum) // sent after the body
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		b, _ := io.ReadAll(res.Body)
+		if checksum == "abc" {
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "streamed body abc", string(b))
+		} else {
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "trailer was modified")
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	// a generic middleware, e.g. from a middleware library
+	logging := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Logged", "true")
+			next.ServeHTTP(w, r)
+		})
+	}
+	chain := func(h http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello")
+	})
+	ts := httptest.NewServer(chain(handler, logging, Middleware(*NewHandlerConfig().SetFetchVerifier(fetchVerifier))))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "unsigned request")
+	assert.Equal(t, "true", res.Header.Get("X-Logged"))
+
+	signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method"))
+	res, err = NewDefaultClient("sig1", signer, nil, nil).Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "signed request")
+}
+
+func TestSelectMiddleware(t *testing.T) {
+	adminKey, userKey := bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64)
+	configFor := func(key []byte) *HandlerConfig {
+		return NewHandlerConfig().SetFetchVerifier(func(r *http.Request) (string, *Verifier) {
+			verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method", "@path"))
+			return "sig1", verifier
+		})
+	}
+	configs := map[string]*HandlerConfig{"/admin": configFor(adminKey), "/user": configFor(userKey)}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello")
+	})
+	ts := httptest.NewServer(SelectMiddleware(func(r *http.Request) *HandlerConfig {
+		return configs[r.URL.Path]
+	})(handler))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		key        []byte
+		path       string
+		wantStatus int
+	}{
+		{"admin route, admin key", adminKey, "/admin", http.StatusOK},
+		{"admin route, user key", userKey, "/admin", http.StatusUnauthorized},
+		{"user route, user key", userKey, "/user", http.StatusOK},
+		{"public route", userKey, "/public", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", tt.key, nil, Headers("@method", "@path"))
+			res, err := NewDefaultClient("sig1", signer, nil, nil).Get(ts.URL + tt.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestWrapHandlerCoverStatus(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SignCreated(false), Headers("content-type"))
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+	}
+	tests := []struct {
+		name   string
+		config *HandlerConfig
+		want   string
+	}{
+		{"default", NewHandlerConfig(), `sig1=("content-type" "@status");alg="hmac-sha256";keyid="key"`},
+		{"disabled", NewHandlerConfig().SetCoverStatus(false), `sig1=("content-type");alg="hmac-sha256";keyid="key"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *tt.config.SetFetchSigner(fetchSigner)))
+			defer ts.Close()
+			res, err := http.Get(ts.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, res.StatusCode)
+			assert.Equal(t, tt.want, res.Header.Get("Signature-Input"))
+			verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false), Headers("content-type"))
+			assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+		})
+	}
+}
+
+func TestVerifiedRequestDetails(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	var vr *VerifiedRequest
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		vr = GetVerifiedRequest(r)
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchVerifier(fetchVerifier)))
+	defer ts.Close()
+
+	expires := time.Now().Add(time.Minute).Unix()
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetExpires(expires), Headers("@method", "@path"))
+	res, err := NewDefaultClient("sig1", signer, nil, nil).Get(ts.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.NotNil(t, vr) {
+		assert.Equal(t, "sig1", vr.Label())
+		assert.Equal(t, "key", vr.KeyID())
+		assert.Equal(t, "hmac-sha256", vr.Alg())
+		assert.Equal(t, []string{"@method", "@path"}, vr.Components())
+		assert.WithinDuration(t, time.Now(), vr.Created(), 5*time.Second)
+		assert.Equal(t, time.Unix(expires, 0), vr.Expires())
+	}
+	assert.True(t, (&VerifiedRequest{}).Expires().IsZero())
+}
+
+func TestWrapHandlerExemptionRules(t *testing.T) {
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+	internal := func(r *http.Request) bool { return r.Header.Get("X-Internal") == "yes" }
+	tests := []struct {
+		name       string
+		config     *HandlerConfig
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"exact path", NewHandlerConfig().SetExemptPaths("/healthz", "/readyz"), "GET", "/healthz", 200},
+		{"path must match exactly", NewHandlerConfig().SetExemptPaths("/healthz"), "GET", "/healthz/x", 401},
+		{"prefix", NewHandlerConfig().SetExemptPathPrefixes("/public/"), "POST", "/public/a/b", 200},
+		{"not a prefix", NewHandlerConfig().SetExemptPathPrefixes("/public/"), "POST", "/private/a", 401},
+		{"method", NewHandlerConfig().SetExemptMethods("OPTIONS", "HEAD"), "HEAD", "/", 200},
+		{"other method", NewHandlerConfig().SetExemptMethods("OPTIONS", "HEAD"), "GET", "/", 401},
+		{"callback", NewHandlerConfig().SetExempt(internal), "GET", "/internal", 200},
+		{"callback declines", NewHandlerConfig().SetExempt(internal), "GET", "/", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config.SetFetchVerifier(fetchVerifier)
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+			defer ts.Close()
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			assert.NoError(t, err)
+			if tt.path == "/internal" {
+				req.Header.Set("X-Internal", "yes")
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestWrapHandlerLogger(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+		return "sig1", verifier
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello")
+	})
+	var buf bytes.Buffer
+	tests := []struct {
+		name    string
+		config  *HandlerConfig
+		wantLog string
+	}{
+		{"default", NewHandlerConfig().SetFetchVerifier(fetchVerifier), ""},
+		{"logger", NewHandlerConfig().SetFetchVerifier(fetchVerifier).SetLogger(log.New(&buf, "", 0)),
+			"Could not verify request signature: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			ts := httptest.NewServer(WrapHandler(handler, *tt.config))
+			defer ts.Close()
+			res, err := http.Get(ts.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			if tt.wantLog == "" {
+				assert.Empty(t, buf.String())
+			} else {
+				assert.True(t, strings.HasPrefix(buf.String(), tt.wantLog), buf.String())
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package httpsign
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,9 +18,40 @@ type parsedMessage struct {
 	url     *url.URL
 	headers http.Header
 	qParams url.Values
+	body    *io.ReadCloser // the message body, which may be replaced after it is read
+	// contentLength is the declared length of the body, or -1 if unknown
+	contentLength int64
+	request       *parsedMessage // for a response, the request if known
+	// trailers are shared with the original message, since they are only filled in once the body is read
+	trailers http.Header
+	// trailerLimit is the size of the body that may be read to get to the trailers, or 0 for no limit,
+	// or -1 if trailers are not available, e.g. when verifying without VerifyConfig.SetVerifyTrailers
+	trailerLimit     int64
+	canonicalization CanonicalizationMode
+	rejectDuplicates bool
 }
 
-func parseRequest(req *http.Request) (*parsedMessage, error) {
+// derivation overrides the way some derived components are computed, e.g. for a server deployed
+// behind a TLS-terminating load balancer, where the local view of the request is not the client's view.
+// Empty values mean no override. It also determines how header values are canonicalized.
+type derivation struct {
+	scheme           string
+	authority        string
+	trustedProxies   *TrustedProxies
+	canonicalization CanonicalizationMode
+	rejectDuplicates bool
+}
+
+// applyTo sets the way header values of message are canonicalized
+func (d *derivation) applyTo(message *parsedMessage) *parsedMessage {
+	if d != nil {
+		message.canonicalization = d.canonicalization
+		message.rejectDuplicates = d.rejectDuplicates
+	}
+	return message
+}
+
+func parseRequest(req *http.Request, d *derivation) (*parsedMessage, error) {
 	err := validateMessageHeaders(req.Header)
 	if err != nil {
 		return nil, err
@@ -27,36 +60,82 @@ func parseRequest(req *http.Request) (*parsedMessage, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse query: %s", req.URL.RawQuery)
 	}
-	url := req.URL
-	if url.Host == "" {
-		url.Host = req.Host
+	u := *req.URL // do not modify the request
+	if u.Host == "" {
+		u.Host = req.Host
 	}
-	if url.Scheme == "" {
+	if u.Scheme == "" {
 		if req.TLS == nil {
-			url.Scheme = "http"
+			u.Scheme = "http"
 		} else {
-			url.Scheme = "https"
+			u.Scheme = "https"
+		}
+	}
+	authority := req.Host
+	if d != nil {
+		scheme, forwardedAuthority := d.trustedProxies.forwarded(req)
+		if scheme != "" {
+			u.Scheme = scheme
+		}
+		if forwardedAuthority != "" {
+			u.Host = forwardedAuthority
+			authority = forwardedAuthority
+		}
+		if d.scheme != "" {
+			u.Scheme = d.scheme
+		}
+		if d.authority != "" {
+			u.Host = d.authority
+			authority = d.authority
 		}
 	}
-	return &parsedMessage{derived: generateReqDerivedComponents(req), url: url, headers: normalizeHeaderNames(req.Header), qParams: values}, nil
+	return d.applyTo(&parsedMessage{derived: generateReqDerivedComponents(req, &u, authority), url: &u,
+		headers: normalizeHeaderNames(req.Header), qParams: values, body: &req.Body, contentLength: req.ContentLength,
+		trailers: req.Trailer}), nil
 }
 
+// normalizeHeaderNames lowercases the header names. Values set directly in the map under names that differ
+// only in case, e.g. "Content-Type" and "content-type", are combined, in the order of the names.
 func normalizeHeaderNames(header http.Header) http.Header {
 	var t http.Header = http.Header{}
+	var merged []string
 	for k, v := range header {
-		t[strings.ToLower(k)] = v
+		lk := strings.ToLower(k)
+		if _, found := t[lk]; found {
+			merged = append(merged, lk)
+		}
+		t[lk] = v
+	}
+	for _, lk := range merged {
+		var names []string
+		for k := range header {
+			if strings.ToLower(k) == lk {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		var vv []string
+		for _, k := range names {
+			vv = append(vv, header[k]...)
+		}
+		t[lk] = vv
 	}
 	return t
 }
 
-func parseResponse(res *http.Response) (*parsedMessage, error) {
+// parseResponse also parses the response's request, if any, using the derivation d
+func parseResponse(res *http.Response, d *derivation) (*parsedMessage, error) {
 	err := validateMessageHeaders(res.Header)
 	if err != nil {
 		return nil, err
 	}
-
-	return &parsedMessage{derived: generateResDerivedComponents(res), url: nil,
-		headers: normalizeHeaderNames(res.Header)}, nil
+	var request *parsedMessage
+	if res.Request != nil && res.Request.URL != nil {
+		request, _ = parseRequest(res.Request, d) // on failure, "req" components cannot be used
+	}
+	return d.applyTo(&parsedMessage{derived: generateResDerivedComponents(res), url: nil,
+		headers: normalizeHeaderNames(res.Header), body: &res.Body, contentLength: res.ContentLength, request: request,
+		trailers: res.Trailer}), nil
 }
 
 func validateMessageHeaders(header http.Header) error {
@@ -70,26 +149,82 @@ func validateMessageHeaders(header http.Header) error {
 }
 
 func foldFields(fields []string) string {
-	ff := strings.TrimSpace(fields[0])
-	for i := 1; i < len(fields); i++ {
-		ff += ", " + strings.TrimSpace(fields[i])
+	return canonicalizeFields(fields, CanonicalizeStrict)
+}
+
+// canonicalizeFields combines the field lines of a header into its value in the signature base
+func canonicalizeFields(fields []string, mode CanonicalizationMode) string {
+	var b strings.Builder
+	n := 0
+	for _, f := range fields {
+		v := unfold(strings.TrimSpace(f))
+		if mode == CanonicalizeLenient {
+			if v = normalizeSpaces(v); v == "" {
+				continue
+			}
+		}
+		if n > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(v)
+		n++
 	}
-	return ff
+	return b.String()
+}
+
+// unfold replaces obsolete line folding (RFC 9110, Section 5.5) with a single space
+func unfold(v string) string {
+	if strings.IndexByte(v, '\n') < 0 {
+		return v
+	}
+	lines := strings.Split(v, "\n")
+	for i := range lines {
+		lines[i] = strings.Trim(lines[i], " \t\r")
+	}
+	return strings.Join(lines, " ")
+}
+
+// normalizeSpaces collapses runs of whitespace to a single space, and normalizes the whitespace around commas to ", "
+func normalizeSpaces(v string) string {
+	members := strings.Split(v, ",")
+	for i := range members {
+		members[i] = strings.Join(strings.Fields(members[i]), " ")
+	}
+	return strings.TrimSpace(strings.Join(members, ", "))
+}
+
+// knownDerivedComponents lists the derived components this implementation knows how to compute.
+// A signature covering any other "@"-prefixed name cannot be verified by this version.
+var knownDerivedComponents = map[string]bool{
+	"@method":           true,
+	"@target-uri":       true,
+	"@path":             true,
+	"@authority":        true,
+	"@scheme":           true,
+	"@request-target":   true,
+	"@query":            true,
+	"@query-param":      true,
+	"@query-params":     true, // draft name, still accepted
+	"@status":           true,
+	"@request-response": true,
+}
+
+func isKnownDerivedComponent(name string) bool {
+	return knownDerivedComponents[name]
 }
 
 func specialtyComponent(name, v string, components components) {
 	components[name] = v
 }
 
-func generateReqDerivedComponents(req *http.Request) components {
+func generateReqDerivedComponents(req *http.Request, theURL *url.URL, authority string) components {
 	components := components{}
 	specialtyComponent("@method", scMethod(req), components)
-	theURL := req.URL
 	specialtyComponent("@target-uri", scTargetURI(theURL), components)
 	specialtyComponent("@path", scPath(theURL), components)
-	specialtyComponent("@authority", scAuthority(req), components)
+	specialtyComponent("@authority", authority, components)
 	specialtyComponent("@scheme", scScheme(theURL), components)
-	specialtyComponent("@request-target", scRequestTarget(theURL), components)
+	specialtyComponent("@request-target", scRequestTarget(req, theURL), components)
 	specialtyComponent("@query", scQuery(theURL), components)
 	// @request-response does not belong here
 	return components
@@ -103,8 +238,34 @@ func scQuery(url *url.URL) string {
 	return "?" + url.RawQuery
 }
 
-func scRequestTarget(url *url.URL) string {
-	return url.Path
+// encodeQueryParam percent-encodes a query parameter name or value, as required for "@query-param"
+// (RFC 9421, Sec. 2.2.8). Only ASCII alphanumerics and "*-._" are left as is.
+func encodeQueryParam(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			c == '*' || c == '-' || c == '.' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// scRequestTarget is the request target as it appears in the request line (RFC 9110, Section 7.1), as defined
+// by the draft versions that specified "@request-target": usually the path and query, but the authority for CONNECT,
+// "*" for a server-wide OPTIONS request, and the absolute URI of a request that is sent to a proxy.
+// It is kept for peers that still cover it; "@method", "@path" and "@query" replace it.
+func scRequestTarget(req *http.Request, theURL *url.URL) string {
+	if req.Method == http.MethodConnect {
+		return theURL.Host
+	}
+	if req.RequestURI != "" { // received by a server, as sent
+		return req.RequestURI
+	}
+	return theURL.RequestURI()
 }
 
 func scScheme(url *url.URL) string {
@@ -114,10 +275,6 @@ func scScheme(url *url.URL) string {
 	return url.Scheme
 }
 
-func scAuthority(req *http.Request) string {
-	return req.Host
-}
-
 func scTargetURI(url *url.URL) string {
 	return url.String()
 }
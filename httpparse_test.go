@@ -0,0 +1,138 @@
+package httpsign
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCanonicalizeFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		strict  string
+		lenient string
+	}{
+		{"single", []string{"application/json"}, "application/json", "application/json"},
+		{"trimmed", []string{" \tmax-age=60 ", "must-revalidate\t"}, "max-age=60, must-revalidate", "max-age=60, must-revalidate"},
+		{"obs-fold", []string{"a value\r\n  that was folded"}, "a value that was folded", "a value that was folded"},
+		{"bare newline", []string{"first \n\tsecond"}, "first second", "first second"},
+		{"inner whitespace", []string{"a  \t b"}, "a  \t b", "a b"},
+		{"commas", []string{"a,b ,  c"}, "a,b ,  c", "a, b, c"},
+		{"empty line", []string{"a", "", "b"}, "a, , b", "a, b"},
+		{"only empty", []string{""}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.strict, canonicalizeFields(tt.fields, CanonicalizeStrict), "strict")
+			assert.Equal(t, tt.lenient, canonicalizeFields(tt.fields, CanonicalizeLenient), "lenient")
+		})
+	}
+}
+
+func TestCanonicalizationMode(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method", "cache-control")
+	sign := func(mode CanonicalizationMode) (string, string) {
+		signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetCanonicalization(mode), fields)
+		sigInput, sig, err := SignRequest("sig1", *signer, readRequest(httpreq1))
+		assert.NoError(t, err)
+		return sigInput, sig
+	}
+	verify := func(mode CanonicalizationMode, sigInput, sig string, mangle func(values []string) []string) error {
+		req := readRequest(httpreq1)
+		req.Header["Cache-Control"] = mangle(req.Header["Cache-Control"])
+		req.Header.Add("Signature-Input", sigInput)
+		req.Header.Add("Signature", sig)
+		verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetCanonicalization(mode), fields)
+		return VerifyRequest("sig1", *verifier, req)
+	}
+	intact := func(values []string) []string { return values }
+	combined := func(values []string) []string { return []string{"max-age=60,must-revalidate", ""} }
+
+	sigInput, sig := sign(CanonicalizeStrict)
+	assert.NoError(t, verify(CanonicalizeStrict, sigInput, sig, intact))
+	assert.Error(t, verify(CanonicalizeStrict, sigInput, sig, combined), "strict mode should not tolerate changes")
+
+	sigInput, sig = sign(CanonicalizeLenient)
+	assert.NoError(t, verify(CanonicalizeLenient, sigInput, sig, intact))
+	assert.NoError(t, verify(CanonicalizeLenient, sigInput, sig, combined))
+	assert.Error(t, verify(CanonicalizeStrict, sigInput, sig, combined), "both peers must use the same mode")
+}
+
+func TestNormalizeHeaderNames(t *testing.T) {
+	header := http.Header{}
+	header.Add("Content-Type", "text/plain")
+	header["content-type"] = []string{"text/html"}
+	header["X-Custom"] = []string{"a", "b"}
+	normalized := normalizeHeaderNames(header)
+	assert.Equal(t, []string{"text/plain", "text/html"}, normalized["content-type"], "values under differently cased names")
+	assert.Equal(t, []string{"a", "b"}, normalized["x-custom"])
+	assert.Len(t, normalized, 2)
+}
+
+func TestDuplicateHeaders(t *testing.T) {
+	key := make([]byte, 64)
+	fields := Headers("@method", "cache-control", "content-type")
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), fields)
+	req := readRequest(httpreq1)
+	req.Header.Add("Content-Type", "charset=utf-8")
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+
+	// the same field lines, as received on the wire, are combined in the same way
+	received := readRequest(`POST /foo HTTP/1.1
+Host: example.com
+Content-Type: application/json
+Cache-Control: max-age=60
+Content-Type:  charset=utf-8
+Cache-Control:    must-revalidate
+Signature-Input: ` + sigInput + `
+Signature: ` + sig + `
+
+`)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), fields)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, received))
+
+	var duplicate *DuplicateHeaderError
+	verifier, _ = NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetRejectDuplicateHeaders(true), fields)
+	if assert.ErrorAs(t, VerifyRequest("sig1", *verifier, received), &duplicate) {
+		assert.Equal(t, "content-type", duplicate.Header)
+	}
+	signer, _ = NewHMACSHA256Signer("key", key, NewSignConfig().SetRejectDuplicateHeaders(true), fields)
+	_, _, err = SignRequest("sig1", *signer, req)
+	assert.ErrorAs(t, err, &duplicate)
+
+	// list-based headers may always be repeated
+	signer, _ = NewHMACSHA256Signer("key", key, NewSignConfig().SetRejectDuplicateHeaders(true), Headers("cache-control"))
+	_, _, err = SignRequest("sig1", *signer, readRequest(httpreq1))
+	assert.NoError(t, err)
+}
+
+func TestRequestTarget(t *testing.T) {
+	newRequest := func(method, url string) *http.Request {
+		req, _ := http.NewRequest(method, url, nil)
+		return req
+	}
+	tests := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{"origin form", readRequest(httpreq1), "/foo?param=value&pet=dog"},
+		{"client", newRequest("GET", "https://example.com/a%20b/c?x=1&y"), "/a%20b/c?x=1&y"},
+		{"client without path", newRequest("GET", "https://example.com"), "/"},
+		{"connect", readRequest("CONNECT server.example.com:443 HTTP/1.1\nHost: server.example.com:443\n\n"), "server.example.com:443"},
+		{"client connect", newRequest("CONNECT", "https://server.example.com:443"), "server.example.com:443"},
+		{"asterisk form", readRequest("OPTIONS * HTTP/1.1\nHost: server.example.com\n\n"), "*"},
+		{"absolute form", readRequest("GET https://www.example.com/path?param=value HTTP/1.1\nHost: www.example.com\n\n"),
+			"https://www.example.com/path?param=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RequestDerivedComponent("@request-target", tt.req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
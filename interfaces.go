@@ -0,0 +1,54 @@
+package httpsign
+
+import "net/http"
+
+// MessageSigner signs HTTP messages. Signer is the standard implementation. Custom implementations,
+// e.g. audited wrappers around a Signer or clients of a remote signing service, can be used
+// wherever a MessageSigner is accepted.
+type MessageSigner interface {
+	// SignRequest returns the Signature-Input and the Signature header values for the request.
+	SignRequest(signatureName string, req *http.Request) (signatureInput, signature string, err error)
+	// SignResponse returns the Signature-Input and the Signature header values for the response.
+	SignResponse(signatureName string, res *http.Response) (signatureInput, signature string, err error)
+}
+
+// MessageVerifier verifies signed HTTP messages. Verifier is the standard implementation.
+type MessageVerifier interface {
+	// VerifyRequest returns nil if the named signature on the request verifies.
+	VerifyRequest(signatureName string, req *http.Request) error
+	// VerifyResponse returns nil if the named signature on the response verifies.
+	VerifyResponse(signatureName string, res *http.Response) error
+}
+
+// SignRequest signs an HTTP request, see the SignRequest function.
+func (s Signer) SignRequest(signatureName string, req *http.Request) (signatureInput, signature string, err error) {
+	signatureInput, signature, _, err = signRequestInternal(signatureName, s, req, false)
+	return
+}
+
+// SignResponse signs an HTTP response, see the SignResponse function.
+func (s Signer) SignResponse(signatureName string, res *http.Response) (signatureInput, signature string, err error) {
+	return signResponse(signatureName, s, res)
+}
+
+// VerifyRequest verifies a signed HTTP request, see the VerifyRequest function.
+func (v Verifier) VerifyRequest(signatureName string, req *http.Request) error {
+	_, err := verifyRequestInternal(signatureName, v, req, false)
+	return err
+}
+
+// VerifyResponse verifies a signed HTTP response, see the VerifyResponse function.
+func (v Verifier) VerifyResponse(signatureName string, res *http.Response) error {
+	return verifyResponse(signatureName, v, res)
+}
+
+// A nil *Signer stored in an interface is not a nil interface, but it cannot be used either
+func isNilSigner(s MessageSigner) bool {
+	p, ok := s.(*Signer)
+	return s == nil || (ok && p == nil)
+}
+
+func isNilVerifier(v MessageVerifier) bool {
+	p, ok := v.(*Verifier)
+	return v == nil || (ok && p == nil)
+}
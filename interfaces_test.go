@@ -0,0 +1,91 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// An audited signer, wrapping the standard implementation
+type countingSigner struct {
+	Signer
+	count int
+}
+
+func (s *countingSigner) SignRequest(signatureName string, req *http.Request) (string, string, error) {
+	s.count++
+	return s.Signer.SignRequest(signatureName, req)
+}
+
+func (s *countingSigner) SignResponse(signatureName string, res *http.Response) (string, string, error) {
+	s.count++
+	return s.Signer.SignResponse(signatureName, res)
+}
+
+type countingVerifier struct {
+	Verifier
+	count int
+}
+
+func (v *countingVerifier) VerifyRequest(signatureName string, req *http.Request) error {
+	v.count++
+	return v.Verifier.VerifyRequest(signatureName, req)
+}
+
+func (v *countingVerifier) VerifyResponse(signatureName string, res *http.Response) error {
+	v.count++
+	return v.Verifier.VerifyResponse(signatureName, res)
+}
+
+func TestCustomSignerAndVerifier(t *testing.T) {
+	newSigner := func(key byte, fields Fields) *countingSigner {
+		signer, _ := NewHMACSHA256Signer("key", bytes.Repeat([]byte{key}, 64), nil, fields)
+		return &countingSigner{Signer: *signer}
+	}
+	newVerifier := func(key byte, fields Fields) *countingVerifier {
+		verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{key}, 64), nil, fields)
+		return &countingVerifier{Verifier: *verifier}
+	}
+	serverSigner := newSigner(2, Headers("@status"))
+	serverVerifier := newVerifier(1, Headers("@method"))
+	config := NewHandlerConfig().
+		SetFetchMessageSigner(func(res http.Response, r *http.Request) (string, MessageSigner) {
+			return "sig1", serverSigner
+		}).
+		SetFetchMessageVerifier(func(r *http.Request) (string, MessageVerifier) {
+			return "sig1", serverVerifier
+		})
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = fmt.Fprintln(w, "Hello, client")
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+	defer ts.Close()
+
+	clientSigner := newSigner(1, Headers("@method"))
+	clientVerifier := newVerifier(2, Headers("@status"))
+	client := NewDefaultClient("sig1", clientSigner, clientVerifier, nil)
+	res, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	if res != nil {
+		_ = res.Body.Close()
+		assert.Equal(t, 200, res.StatusCode)
+	}
+	for _, count := range []int{clientSigner.count, serverVerifier.count, serverSigner.count, clientVerifier.count} {
+		assert.Equal(t, 1, count, "each custom implementation should have been called once")
+	}
+
+	var nilSigner *Signer
+	_, _, err = SignRequest("sig1", nilSigner, readRequest(httpreq1))
+	assert.Error(t, err, "nil signer")
+	client = NewDefaultClient("sig1", nilSigner, nil, nil)
+	res, err = client.Get(ts.URL)
+	assert.NoError(t, err, "a nil *Signer means no signing")
+	if res != nil {
+		_ = res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+}
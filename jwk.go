@@ -0,0 +1,172 @@
+package httpsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// NewSignerFromJWK returns a new Signer for a private JSON Web Key. The algorithm is inferred from the key:
+// "ecdsa-p256-sha256" or "ecdsa-p384-sha384" for EC keys, "ed25519" for OKP keys and "hmac-sha256" for
+// symmetric (oct) keys. For RSA keys, the JWK "alg" member selects "rsa-v1_5-sha256" (RS256) or
+// "rsa-pss-sha512" (PS512). The JWK "kid" member is the key ID.
+// Config may be nil for a default configuration.
+func NewSignerFromJWK(key jwk.Key, config *SignConfig, fields Fields) (*Signer, error) {
+	raw, alg, err := parseJWK(key)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(alg, key.KeyID(), raw, config, fields)
+}
+
+// NewVerifierFromJWK returns a new Verifier for a JSON Web Key, which may be a public or a private key.
+// The algorithm is inferred as in NewSignerFromJWK, and the JWK "kid" member is the key ID.
+// Config may be nil for a default configuration.
+func NewVerifierFromJWK(key jwk.Key, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	raw, alg, err := parseJWK(key)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifier(alg, key.KeyID(), publicKeyOf(raw), config, fields)
+}
+
+// parseJWK returns the raw key and the inferred algorithm
+func parseJWK(key jwk.Key) (interface{}, string, error) {
+	if key == nil {
+		return nil, "", fmt.Errorf("key must not be nil")
+	}
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, "", fmt.Errorf("cannot read JWK: %w", err)
+	}
+	alg, err := inferAlg(raw, key.Algorithm())
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, alg, nil
+}
+
+// inferAlg determines the signature algorithm for a raw key, using the JOSE algorithm name
+// where the key type is not enough
+func inferAlg(raw interface{}, joseAlg string) (string, error) {
+	switch k := publicKeyOf(raw).(type) {
+	case *rsa.PublicKey:
+		switch joseAlg {
+		case "RS256":
+			return "rsa-v1_5-sha256", nil
+		case "PS512":
+			return "rsa-pss-sha512", nil
+		}
+		return "", fmt.Errorf("RSA key requires an \"alg\" of RS256 or PS512, got \"%s\"", joseAlg)
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ecdsa-p256-sha256", nil
+		case elliptic.P384():
+			return "ecdsa-p384-sha384", nil
+		}
+		return "", fmt.Errorf("unsupported curve \"%s\"", k.Params().Name)
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	case []byte:
+		if joseAlg != "" && joseAlg != "HS256" {
+			return "", fmt.Errorf("symmetric key requires an \"alg\" of HS256, got \"%s\"", joseAlg)
+		}
+		return "hmac-sha256", nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", raw)
+}
+
+// publicKeyOf returns the public part of a private key, or the key itself
+func publicKeyOf(raw interface{}) interface{} {
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return raw
+}
+
+// newSigner returns a Signer for a raw private key, in one of the forms returned by the x509 and jwk packages
+func newSigner(alg, keyID string, key interface{}, config *SignConfig, fields Fields) (*Signer, error) {
+	if err := checkCurve(alg, key); err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch alg {
+		case "rsa-v1_5-sha256":
+			return NewRSASigner(keyID, *k, config, fields)
+		case "rsa-pss-sha512":
+			return NewRSAPSSSigner(keyID, *k, config, fields)
+		}
+	case *ecdsa.PrivateKey:
+		switch alg {
+		case "ecdsa-p256-sha256":
+			return NewP256Signer(keyID, *k, config, fields)
+		case "ecdsa-p384-sha384":
+			return NewP384Signer(keyID, *k, config, fields)
+		}
+	case ed25519.PrivateKey:
+		if alg == "ed25519" {
+			return NewEd25519Signer(keyID, k, config, fields)
+		}
+	case []byte:
+		if alg == "hmac-sha256" {
+			return NewHMACSHA256Signer(keyID, k, config, fields)
+		}
+	default:
+		return nil, fmt.Errorf("not a private key: %T", key)
+	}
+	return nil, fmt.Errorf("key of type %T does not match algorithm \"%s\"", key, alg)
+}
+
+// newVerifier returns a Verifier for a raw public key, in one of the forms returned by the x509 and jwk packages
+func newVerifier(alg, keyID string, key interface{}, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	if err := checkCurve(alg, key); err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "rsa-v1_5-sha256":
+			return NewRSAVerifier(keyID, *k, config, fields)
+		case "rsa-pss-sha512":
+			return NewRSAPSSVerifier(keyID, *k, config, fields)
+		}
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ecdsa-p256-sha256":
+			return NewP256Verifier(keyID, *k, config, fields)
+		case "ecdsa-p384-sha384":
+			return NewP384Verifier(keyID, *k, config, fields)
+		}
+	case ed25519.PublicKey:
+		if alg == "ed25519" {
+			return NewEd25519Verifier(keyID, k, config, fields)
+		}
+	case []byte:
+		if alg == "hmac-sha256" {
+			return NewHMACSHA256Verifier(keyID, k, config, fields)
+		}
+	default:
+		return nil, fmt.Errorf("not a public key: %T", key)
+	}
+	return nil, fmt.Errorf("key of type %T does not match algorithm \"%s\"", key, alg)
+}
+
+// checkCurve verifies that an EC key is on the algorithm's curve
+func checkCurve(alg string, key interface{}) error {
+	if k, ok := publicKeyOf(key).(*ecdsa.PublicKey); ok {
+		if inferred, err := inferAlg(k, ""); err != nil || inferred != alg {
+			return fmt.Errorf("key on curve %s does not match algorithm \"%s\"", k.Params().Name, alg)
+		}
+	}
+	return nil
+}
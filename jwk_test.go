@@ -0,0 +1,75 @@
+package httpsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSignerAndVerifierFromJWK(t *testing.T) {
+	rsaKey, err := parseRsaPrivateKeyFromPemStr(rsaPrvKey)
+	assert.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		raw     interface{}
+		joseAlg string
+		wantAlg string
+		wantErr bool
+	}{
+		{"RSA", rsaKey, "RS256", "rsa-v1_5-sha256", false},
+		{"RSA-PSS", rsaKey, "PS512", "rsa-pss-sha512", false},
+		{"RSA without alg", rsaKey, "", "", true},
+		{"P-256", p256Key, "", "ecdsa-p256-sha256", false},
+		{"P-384", p384Key, "ES384", "ecdsa-p384-sha384", false},
+		{"P-521", p521Key, "", "", true},
+		{"Ed25519", edKey, "", "ed25519", false},
+		{"HMAC", bytes.Repeat([]byte{7}, 64), "HS256", "hmac-sha256", false},
+		{"HMAC with wrong alg", bytes.Repeat([]byte{7}, 64), "HS512", "", true},
+	}
+	fields := Headers("@method", "date")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := jwk.New(tt.raw)
+			assert.NoError(t, err)
+			assert.NoError(t, key.Set(jwk.KeyIDKey, "jwk-key"))
+			if tt.joseAlg != "" {
+				assert.NoError(t, key.Set(jwk.AlgorithmKey, tt.joseAlg))
+			}
+			signer, err := NewSignerFromJWK(key, nil, fields)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			req := readRequest(httpreq2)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			assert.Contains(t, sigInput, `alg="`+tt.wantAlg+`";keyid="jwk-key"`)
+			req.Header.Add("Signature", sig)
+			req.Header.Add("Signature-Input", sigInput)
+
+			pub := key
+			if _, symmetric := tt.raw.([]byte); !symmetric {
+				pub, err = key.PublicKey()
+				assert.NoError(t, err)
+			}
+			verifier, err := NewVerifierFromJWK(pub, NewVerifyConfig().SetVerifyCreated(false), fields)
+			assert.NoError(t, err)
+			assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package httpsign
+
+import (
+	"context"
+	"fmt"
+	"github.com/lestrrat-go/jwx/jwk"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for JWKSResolver
+const (
+	DefaultJWKSRefreshInterval    = time.Hour
+	DefaultJWKSMinRefreshInterval = time.Minute
+	DefaultJWKSRetryInterval      = 10 * time.Second
+)
+
+// maxJWKSSize bounds the size of a fetched key set, which comes from the network
+const maxJWKSSize = 1 << 20
+
+// JWKSResolver resolves the key ID ("keyid" signature parameter) of an incoming message to a Verifier,
+// using the keys published at a JWKS (JSON Web Key Set) URL. Keys are cached by their "kid", and the set is
+// fetched again when it is older than the refresh interval, or when an unknown key ID is seen, but
+// never more often than the minimum refresh interval. A failed fetch is not retried before the retry interval,
+// and meanwhile lookups of keys that are not cached fail with the same error. A JWKSResolver is safe for concurrent use: concurrent
+// lookups that need the key set to be fetched share a single fetch, and lookups that are served from
+// the cache never wait for it.
+type JWKSResolver struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	retryInterval      time.Duration
+	config             *VerifyConfig
+	fields             Fields
+
+	mu      sync.Mutex
+	keys    map[string]jwk.Key
+	fetched time.Time
+	failed  time.Time // the time of the last fetch, if it failed
+	failErr error
+	pending *jwksFetch // the fetch in progress, if any
+}
+
+// jwksFetch is a fetch of the key set, shared by the lookups that wait for it
+type jwksFetch struct {
+	done chan struct{} // closed once keys and err are set
+	keys map[string]jwk.Key
+	err  error
+}
+
+// NewJWKSResolver returns a resolver for the JWKS at url. The Verifiers it returns share config, which may be nil
+// for a default configuration, and fields.
+func NewJWKSResolver(url string, config *VerifyConfig, fields Fields) *JWKSResolver {
+	return &JWKSResolver{
+		url:                url,
+		client:             http.DefaultClient,
+		refreshInterval:    DefaultJWKSRefreshInterval,
+		minRefreshInterval: DefaultJWKSMinRefreshInterval,
+		retryInterval:      DefaultJWKSRetryInterval,
+		config:             config.Clone(),
+		fields:             fields,
+	}
+}
+
+// SetHTTPClient sets the client used to fetch the JWKS. Default: http.DefaultClient.
+func (j *JWKSResolver) SetHTTPClient(client *http.Client) *JWKSResolver {
+	j.client = client
+	return j
+}
+
+// SetRefreshInterval sets the maximum age of the cached key set. Default: DefaultJWKSRefreshInterval.
+func (j *JWKSResolver) SetRefreshInterval(d time.Duration) *JWKSResolver {
+	j.refreshInterval = d
+	return j
+}
+
+// SetMinRefreshInterval limits how often unknown key IDs may cause the key set to be fetched,
+// so that a flood of bogus key IDs cannot overload the JWKS endpoint. Default: DefaultJWKSMinRefreshInterval.
+func (j *JWKSResolver) SetMinRefreshInterval(d time.Duration) *JWKSResolver {
+	j.minRefreshInterval = d
+	return j
+}
+
+// SetRetryInterval limits how often the key set is fetched after a failed fetch, so that lookups of unknown
+// key IDs do not keep hitting a failing JWKS endpoint. Default: DefaultJWKSRetryInterval.
+func (j *JWKSResolver) SetRetryInterval(d time.Duration) *JWKSResolver {
+	j.retryInterval = d
+	return j
+}
+
+// Verifier returns a Verifier for the given key ID.
+func (j *JWKSResolver) Verifier(ctx context.Context, keyID string) (*Verifier, error) {
+	key, err := j.lookup(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return NewVerifierFromJWK(key, j.config, j.fields)
+}
+
+// FetchVerifier returns a callback for HandlerConfig.SetFetchVerifier, which verifies the signature
+// with the given name using the key identified by its "keyid" parameter.
+func (j *JWKSResolver) FetchVerifier(signatureName string) func(r *http.Request) (string, *Verifier) {
+	return func(r *http.Request) (string, *Verifier) {
+		keyID, _, err := RequestDetails(signatureName, r)
+		if err != nil {
+			return signatureName, nil
+		}
+		verifier, err := j.Verifier(r.Context(), keyID)
+		if err != nil {
+			return signatureName, nil
+		}
+		return signatureName, verifier
+	}
+}
+
+// SelectVerifiers is a callback for HandlerConfig.SetSelectVerifiers, which selects every signature
+// whose key is in the key set.
+func (j *JWKSResolver) SelectVerifiers(r *http.Request, sigs []SignatureDetails) map[string]Verifier {
+	verifiers := map[string]Verifier{}
+	for _, s := range sigs {
+		if v, err := j.Verifier(r.Context(), s.KeyID); err == nil {
+			verifiers[s.Label] = *v
+		}
+	}
+	return verifiers
+}
+
+func (j *JWKSResolver) lookup(ctx context.Context, keyID string) (jwk.Key, error) {
+	j.mu.Lock()
+	key, found := j.keys[keyID]
+	age := time.Since(j.fetched)
+	stale := j.keys == nil || age > j.refreshInterval
+	if !stale && (found || age <= j.minRefreshInterval) {
+		j.mu.Unlock()
+		if !found {
+			return nil, fmt.Errorf("unknown key ID \"%s\"", keyID)
+		}
+		return key, nil
+	}
+	if j.failErr != nil && time.Since(j.failed) <= j.retryInterval {
+		err := j.failErr
+		j.mu.Unlock()
+		if found {
+			return key, nil
+		}
+		return nil, err
+	}
+	f, leader := j.pending, j.pending == nil
+	if leader {
+		f = &jwksFetch{done: make(chan struct{})}
+		j.pending = f
+	}
+	j.mu.Unlock() // the network call is made without holding the lock
+
+	if leader {
+		f.keys, f.err = j.fetch(ctx)
+		j.mu.Lock()
+		if f.err == nil {
+			j.keys, j.fetched, j.failErr = f.keys, time.Now(), nil
+		} else if ctx.Err() == nil { // the caller giving up is not a failure of the endpoint
+			j.failed, j.failErr = time.Now(), f.err
+		}
+		j.pending = nil
+		j.mu.Unlock()
+		close(f.done)
+	} else {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			if found {
+				return key, nil
+			}
+			return nil, ctx.Err()
+		}
+	}
+	if f.err != nil {
+		if found {
+			return key, nil // keep using the cached key if the endpoint is unavailable
+		}
+		return nil, f.err
+	}
+	key, found = f.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("unknown key ID \"%s\"", keyID)
+	}
+	return key, nil
+}
+
+func (j *JWKSResolver) fetch(ctx context.Context) (map[string]jwk.Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch JWKS: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch JWKS: status %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch JWKS: %w", err)
+	}
+	if len(body) > maxJWKSSize {
+		return nil, fmt.Errorf("cannot fetch JWKS: larger than %d bytes", maxJWKSSize)
+	}
+	set, err := jwk.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS: %w", err)
+	}
+	keys := map[string]jwk.Key{}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Get(i)
+		if key.KeyID() != "" {
+			keys[key.KeyID()] = key
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,174 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSResolver(t *testing.T) {
+	prvKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	pubKey, err := jwk.New(&prvKey.PublicKey)
+	assert.NoError(t, err)
+	assert.NoError(t, pubKey.Set(jwk.KeyIDKey, "tenant-a"))
+	set := jwk.NewSet()
+	set.Add(pubKey)
+	jwks, err := json.Marshal(set)
+	assert.NoError(t, err)
+
+	var fetches int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+
+	fields := Headers("@method")
+	resolver := NewJWKSResolver(jwksServer.URL, NewVerifyConfig().SetVerifyCreated(false), fields)
+
+	_, err = resolver.Verifier(context.Background(), "tenant-a")
+	assert.NoError(t, err)
+	_, err = resolver.Verifier(context.Background(), "tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "key should be cached")
+
+	_, err = resolver.Verifier(context.Background(), "tenant-b")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "unknown key within the minimum refresh interval")
+
+	resolver.SetMinRefreshInterval(0)
+	_, err = resolver.Verifier(context.Background(), "tenant-b")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "unknown key should trigger a refresh")
+
+	resolver.SetRefreshInterval(time.Nanosecond)
+	_, err = resolver.Verifier(context.Background(), "tenant-a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches), "stale key set should be refreshed")
+
+	// and in a handler
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+	config := NewHandlerConfig().SetFetchVerifier(resolver.FetchVerifier("sig1"))
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *config))
+	defer ts.Close()
+	for _, keyID := range []string{"tenant-a", "tenant-b"} {
+		signer, _ := NewP256Signer(keyID, *prvKey, nil, fields)
+		res, err := NewDefaultClient("sig1", signer, nil, nil).Get(ts.URL)
+		assert.NoError(t, err)
+		_ = res.Body.Close()
+		if keyID == "tenant-a" {
+			assert.Equal(t, 200, res.StatusCode)
+		} else {
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		}
+	}
+}
+
+func TestJWKSResolver_SlowEndpoint(t *testing.T) {
+	prvKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	pubKey, err := jwk.New(&prvKey.PublicKey)
+	assert.NoError(t, err)
+	assert.NoError(t, pubKey.Set(jwk.KeyIDKey, "tenant-a"))
+	set := jwk.NewSet()
+	set.Add(pubKey)
+	jwks, err := json.Marshal(set)
+	assert.NoError(t, err)
+
+	var fetches int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+	resolver := NewJWKSResolver(jwksServer.URL, nil, Headers("@method")).SetMinRefreshInterval(time.Minute)
+	_, err = resolver.Verifier(context.Background(), "tenant-a")
+	assert.NoError(t, err)
+	resolver.fetched = resolver.fetched.Add(-2 * time.Minute) // past the minimum refresh interval, but still fresh
+
+	// unknown key IDs trigger a single, slow fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := resolver.Verifier(context.Background(), "tenant-b")
+			assert.Error(t, err)
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := resolver.Verifier(context.Background(), "tenant-a")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		assert.NoError(t, err, "cached key")
+	case <-time.After(time.Second):
+		t.Error("a cached key should not wait for the fetch")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = resolver.Verifier(ctx, "tenant-c")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "waiting for the fetch is bounded by the context")
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "concurrent lookups should share the fetch")
+}
+
+func TestJWKSResolver_FailingEndpoint(t *testing.T) {
+	var fetches int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer jwksServer.Close()
+	resolver := NewJWKSResolver(jwksServer.URL, nil, Headers("@method")).SetMinRefreshInterval(0)
+
+	for _, keyID := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		_, err := resolver.Verifier(context.Background(), keyID)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "status 503")
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "failure should be cached")
+
+	resolver.SetRetryInterval(0)
+	_, err := resolver.Verifier(context.Background(), "tenant-a")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "fetch should be retried after the retry interval")
+}
+
+func TestJWKSResolver_LargeKeySet(t *testing.T) {
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"keys": [`))
+		_, _ = w.Write(bytes.Repeat([]byte(" "), maxJWKSSize))
+		_, _ = w.Write([]byte(`]}`))
+	}))
+	defer jwksServer.Close()
+	_, err := NewJWKSResolver(jwksServer.URL, nil, Headers("@method")).Verifier(context.Background(), "tenant-a")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "larger than")
+	}
+}
@@ -0,0 +1,87 @@
+package httpsign
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// KeyFetcher locates the verifier for a signature, given its "keyid" parameter and its "alg" parameter,
+// which is empty if the signature does not have one. This is used when the verifier is not known
+// until the signature headers are parsed, see VerifyRequestWithKeyFetcher.
+type KeyFetcher interface {
+	FetchKey(ctx context.Context, keyID, alg string) (MessageVerifier, error)
+}
+
+// KeyFetcherFunc adapts a function to the KeyFetcher interface.
+type KeyFetcherFunc func(ctx context.Context, keyID, alg string) (MessageVerifier, error)
+
+// FetchKey calls f.
+func (f KeyFetcherFunc) FetchKey(ctx context.Context, keyID, alg string) (MessageVerifier, error) {
+	return f(ctx, keyID, alg)
+}
+
+// FetchKey implements KeyFetcher, returning the KeySet itself if it has keys for keyID.
+func (ks *KeySet) FetchKey(_ context.Context, keyID, _ string) (MessageVerifier, error) {
+	if len(ks.Verifiers(keyID)) == 0 {
+		return nil, fmt.Errorf("unknown key ID \"%s\"", keyID)
+	}
+	return ks, nil
+}
+
+// FetchKey implements KeyFetcher.
+func (j *JWKSResolver) FetchKey(ctx context.Context, keyID, _ string) (MessageVerifier, error) {
+	return j.Verifier(ctx, keyID)
+}
+
+// VerifyRequestWithKeyFetcher verifies a signed HTTP request, using the verifier returned by the fetcher
+// for the signature's key ID. The fetcher is called with the request's context.
+func VerifyRequestWithKeyFetcher(signatureName string, fetcher KeyFetcher, req *http.Request) error {
+	if req == nil {
+		return fmt.Errorf("nil request")
+	}
+	keyID, alg, err := RequestDetails(signatureName, req)
+	if err != nil {
+		return err
+	}
+	verifier, err := fetchKey(req.Context(), fetcher, keyID, alg)
+	if err != nil {
+		return err
+	}
+	return VerifyRequest(signatureName, verifier, req)
+}
+
+// VerifyResponseWithKeyFetcher verifies a signed HTTP response, using the verifier returned by the fetcher
+// for the signature's key ID.
+func VerifyResponseWithKeyFetcher(signatureName string, fetcher KeyFetcher, res *http.Response) error {
+	if res == nil {
+		return fmt.Errorf("nil response")
+	}
+	keyID, alg, err := ResponseDetails(signatureName, res)
+	if err != nil {
+		return err
+	}
+	verifier, err := fetchKey(context.Background(), fetcher, keyID, alg)
+	if err != nil {
+		return err
+	}
+	return VerifyResponse(signatureName, verifier, res)
+}
+
+func fetchKey(ctx context.Context, fetcher KeyFetcher, keyID, alg string) (MessageVerifier, error) {
+	if fetcher == nil {
+		return nil, fmt.Errorf("nil key fetcher")
+	}
+	verifier, err := fetcher.FetchKey(ctx, keyID, alg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch key \"%s\": %w", keyID, err)
+	}
+	if isNilVerifier(verifier) {
+		return nil, fmt.Errorf("no verifier for key \"%s\"", keyID)
+	}
+	// A signature that claims a different algorithm than the key's is never valid
+	if v, ok := verifier.(*Verifier); ok && alg != "" && v.alg != "" && v.alg != alg {
+		return nil, fmt.Errorf("signature algorithm \"%s\" does not match key \"%s\"", alg, keyID)
+	}
+	return verifier, nil
+}
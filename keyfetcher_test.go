@@ -0,0 +1,64 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyRequestWithKeyFetcher(t *testing.T) {
+	fields := Headers("@method")
+	keys := map[string][]byte{
+		"alice": bytes.Repeat([]byte{1}, 64),
+		"bob":   bytes.Repeat([]byte{2}, 64),
+	}
+	var gotAlg string
+	fetcher := KeyFetcherFunc(func(_ context.Context, keyID, alg string) (MessageVerifier, error) {
+		gotAlg = alg
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("no such key")
+		}
+		return NewHMACSHA256Verifier(keyID, key, NewVerifyConfig().SetVerifyCreated(false), fields)
+	})
+
+	tests := []struct {
+		name    string
+		keyID   string
+		key     []byte
+		wantErr bool
+	}{
+		{"alice", "alice", keys["alice"], false},
+		{"bob", "bob", keys["bob"], false},
+		{"bob claims to be alice", "alice", keys["bob"], true},
+		{"unknown", "carol", keys["bob"], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer(tt.keyID, tt.key, nil, fields)
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			err = VerifyRequestWithKeyFetcher("sig1", fetcher, req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequestWithKeyFetcher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, "hmac-sha256", gotAlg)
+		})
+	}
+
+	// A key set is a fetcher too
+	verifier, _ := NewHMACSHA256Verifier("alice", keys["alice"], NewVerifyConfig().SetVerifyCreated(false), Headers("@status"))
+	signer, _ := NewHMACSHA256Signer("alice", keys["alice"], nil, Headers("@status"))
+	res := readResponse(httpres1)
+	sigInput, sig, err := SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyResponseWithKeyFetcher("sig1", NewKeySet().Add(verifier), res))
+	assert.Error(t, VerifyResponseWithKeyFetcher("sig1", NewKeySet(), res))
+}
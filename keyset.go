@@ -0,0 +1,85 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// KeySet holds Verifiers indexed by key ID, and verifies a signature with the keys registered for
+// its "keyid" parameter. A key ID may have several keys, e.g. the old and the new key during a rolling
+// key rotation, and the signature is accepted if any of them verifies it.
+// A KeySet is a MessageVerifier, so it can be used wherever a Verifier is expected. It is safe for concurrent use.
+type KeySet struct {
+	mu        sync.RWMutex
+	verifiers map[string][]Verifier
+}
+
+// NewKeySet returns an empty KeySet.
+func NewKeySet() *KeySet {
+	return &KeySet{verifiers: map[string][]Verifier{}}
+}
+
+// Add adds a Verifier for its key ID. Verifiers are tried in the order they were added.
+func (ks *KeySet) Add(verifier *Verifier) *KeySet {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.verifiers[verifier.keyID] = append(ks.verifiers[verifier.keyID], *verifier)
+	return ks
+}
+
+// Remove removes all Verifiers for a key ID.
+func (ks *KeySet) Remove(keyID string) *KeySet {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.verifiers, keyID)
+	return ks
+}
+
+// Verifiers returns the Verifiers for a key ID.
+func (ks *KeySet) Verifiers(keyID string) []Verifier {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return append([]Verifier(nil), ks.verifiers[keyID]...)
+}
+
+// VerifyRequest verifies a signed HTTP request with the keys registered for the signature's key ID.
+func (ks *KeySet) VerifyRequest(signatureName string, req *http.Request) error {
+	keyID, _, err := RequestDetails(signatureName, req)
+	if err != nil {
+		return err
+	}
+	return ks.try(keyID, func(v Verifier) error {
+		return v.VerifyRequest(signatureName, req)
+	})
+}
+
+// VerifyResponse verifies a signed HTTP response with the keys registered for the signature's key ID.
+func (ks *KeySet) VerifyResponse(signatureName string, res *http.Response) error {
+	keyID, _, err := ResponseDetails(signatureName, res)
+	if err != nil {
+		return err
+	}
+	return ks.try(keyID, func(v Verifier) error {
+		return v.VerifyResponse(signatureName, res)
+	})
+}
+
+// try returns nil if verify succeeds with any of the key's Verifiers, and otherwise the first error
+func (ks *KeySet) try(keyID string, verify func(Verifier) error) error {
+	verifiers := ks.Verifiers(keyID)
+	if len(verifiers) == 0 {
+		return fmt.Errorf("unknown key ID \"%s\"", keyID)
+	}
+	var firstErr error
+	for _, v := range verifiers {
+		err := verify(v)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
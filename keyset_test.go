@@ -0,0 +1,46 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeySet(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 64)
+	newKey := bytes.Repeat([]byte{2}, 64)
+	otherKey := bytes.Repeat([]byte{3}, 64)
+	fields := Headers("@method")
+	oldVerifier, _ := NewHMACSHA256Verifier("rotating", oldKey, NewVerifyConfig().SetVerifyCreated(false), fields)
+	newVerifier, _ := NewHMACSHA256Verifier("rotating", newKey, NewVerifyConfig().SetVerifyCreated(false), fields)
+	ks := NewKeySet().Add(oldVerifier).Add(newVerifier)
+
+	tests := []struct {
+		name    string
+		keyID   string
+		key     []byte
+		wantErr bool
+	}{
+		{"old key", "rotating", oldKey, false},
+		{"new key", "rotating", newKey, false},
+		{"wrong key", "rotating", otherKey, true},
+		{"unknown key ID", "other", otherKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer(tt.keyID, tt.key, nil, fields)
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			err = VerifyRequest("sig1", ks, req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	ks.Remove("rotating")
+	assert.Empty(t, ks.Verifiers("rotating"))
+}
@@ -0,0 +1,21 @@
+package httpsign
+
+// Logger receives the package's diagnostic messages, e.g. why a request failed to verify. It is implemented
+// by *log.Logger, so log.Default() restores the standard library's logging. Since verification errors are
+// triggered by clients, a Logger that writes them should be rate limited.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// nopLogger is the default Logger, which discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// loggerOrNop returns l, or a Logger that discards all messages if l is nil
+func loggerOrNop(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+	return l
+}
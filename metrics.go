@@ -0,0 +1,84 @@
+package httpsign
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Metrics receives instrumentation events, e.g. to maintain counters and latency histograms.
+// Implementations must be safe for concurrent use, and should return quickly.
+// The prommetrics sub-package implements Metrics with Prometheus collectors.
+type Metrics interface {
+	// Signed is called after each signing operation, with the signer's algorithm (which may be empty),
+	// the duration of the operation and its error, which is nil on success.
+	Signed(alg string, d time.Duration, err error)
+	// Verified is called after each verification of a single signature, with the verifier's algorithm
+	// (which may be empty), the duration of the operation and its error, which is nil on success.
+	// Use FailureReason to classify the error.
+	Verified(alg string, d time.Duration, err error)
+	// KeyFetched is called after each call to a KeyFetcher wrapped by InstrumentKeyFetcher.
+	KeyFetched(d time.Duration, err error)
+}
+
+// Verification failure reasons returned by FailureReason, suitable as metric labels.
+const (
+	ReasonBadSignature     = "bad_signature"
+	ReasonExpired          = "expired"
+	ReasonCreatedInFuture  = "created_in_future"
+	ReasonTooOld           = "too_old"
+	ReasonKeyIDMismatch    = "keyid_mismatch"
+	ReasonMissingField     = "missing_field"
+	ReasonAlgNotAllowed    = "alg_not_allowed"
+	ReasonDigestMismatch   = "digest_mismatch"
+	ReasonReplay           = "replay"
+	ReasonUnknownComponent = "unknown_component"
+	ReasonTimeout          = "timeout"
+	ReasonOther            = "other"
+)
+
+// FailureReason classifies a verification error into one of a small, fixed set of reasons.
+// Returns an empty string for a nil error.
+func FailureReason(err error) string {
+	var replay *ReplayError
+	var unknown *UnknownComponentError
+	var timeout *TimeoutError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrBadSignature):
+		return ReasonBadSignature
+	case errors.Is(err, ErrSignatureExpired):
+		return ReasonExpired
+	case errors.Is(err, ErrCreatedInFuture):
+		return ReasonCreatedInFuture
+	case errors.Is(err, ErrSignatureTooOld):
+		return ReasonTooOld
+	case errors.Is(err, ErrKeyIDMismatch):
+		return ReasonKeyIDMismatch
+	case errors.Is(err, ErrMissingField):
+		return ReasonMissingField
+	case errors.Is(err, ErrAlgNotAllowed):
+		return ReasonAlgNotAllowed
+	case errors.Is(err, ErrDigestMismatch):
+		return ReasonDigestMismatch
+	case errors.As(err, &replay):
+		return ReasonReplay
+	case errors.As(err, &unknown):
+		return ReasonUnknownComponent
+	case errors.As(err, &timeout):
+		return ReasonTimeout
+	default:
+		return ReasonOther
+	}
+}
+
+// InstrumentKeyFetcher returns a KeyFetcher that reports the latency and outcome of each call to fetcher.
+func InstrumentKeyFetcher(fetcher KeyFetcher, m Metrics) KeyFetcher {
+	return KeyFetcherFunc(func(ctx context.Context, keyID, alg string) (MessageVerifier, error) {
+		start := time.Now()
+		verifier, err := fetcher.FetchKey(ctx, keyID, alg)
+		m.KeyFetched(time.Since(start), err)
+		return verifier, err
+	})
+}
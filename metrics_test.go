@@ -0,0 +1,94 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type event struct {
+	kind string
+	alg  string
+	err  error
+}
+
+type recordingMetrics struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (m *recordingMetrics) record(e event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, e)
+}
+
+func (m *recordingMetrics) Signed(alg string, _ time.Duration, err error) {
+	m.record(event{"sign", alg, err})
+}
+
+func (m *recordingMetrics) Verified(alg string, _ time.Duration, err error) {
+	m.record(event{"verify", alg, err})
+}
+
+func (m *recordingMetrics) KeyFetched(_ time.Duration, err error) {
+	m.record(event{"fetch", "", err})
+}
+
+func TestMetrics(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	m := &recordingMetrics{}
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetMetrics(m), Headers("@method"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetMetrics(m), Headers("@method"))
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	badVerifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{8}, 64), NewVerifyConfig().SetMetrics(m), Headers("@method"))
+	assert.Error(t, VerifyRequest("sig1", *badVerifier, req))
+
+	fetcher := InstrumentKeyFetcher(KeyFetcherFunc(func(_ context.Context, keyID, _ string) (MessageVerifier, error) {
+		if keyID != "key" {
+			return nil, fmt.Errorf("unknown key")
+		}
+		return verifier, nil
+	}), m)
+	assert.NoError(t, VerifyRequestWithKeyFetcher("sig1", fetcher, req))
+
+	if assert.Len(t, m.events, 5) {
+		assert.Equal(t, event{"sign", "hmac-sha256", nil}, m.events[0])
+		assert.Equal(t, event{"verify", "hmac-sha256", nil}, m.events[1])
+		assert.Equal(t, "verify", m.events[2].kind)
+		assert.Equal(t, ReasonBadSignature, FailureReason(m.events[2].err))
+		assert.Equal(t, event{"fetch", "", nil}, m.events[3])
+		assert.Equal(t, event{"verify", "hmac-sha256", nil}, m.events[4])
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"bad signature", ErrBadSignature, ReasonBadSignature},
+		{"wrapped", fmt.Errorf("signature \"sig1\": %w", ErrSignatureExpired), ReasonExpired},
+		{"digest", fmt.Errorf("%w: \"content-digest\" does not match the message body", ErrDigestMismatch), ReasonDigestMismatch},
+		{"replay", &ReplayError{KeyID: "key", Nonce: "n"}, ReasonReplay},
+		{"unknown component", &UnknownComponentError{Component: "@foo"}, ReasonUnknownComponent},
+		{"other", fmt.Errorf("missing \"signature\" header"), ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FailureReason(tt.err))
+		})
+	}
+}
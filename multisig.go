@@ -0,0 +1,263 @@
+package httpsign
+
+import (
+	"fmt"
+	"github.com/dunglas/httpsfv"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VerifyRequestSignatures verifies several signatures on a request in a single call, so that different
+// signatures can be subject to different policies. Each key of the verifiers map is a signature label,
+// or if no signature has that label, the value of a signature's "tag" parameter. Its Verifier, including
+// its VerifyConfig, is applied to that signature only. Returns nil only if all listed signatures verify.
+func VerifyRequestSignatures(verifiers map[string]Verifier, req *http.Request) error {
+	if req == nil {
+		return fmt.Errorf("nil request")
+	}
+	if len(verifiers) == 0 {
+		return fmt.Errorf("no verifiers")
+	}
+	for _, key := range sortedKeys(verifiers) {
+		verifier := verifiers[key]
+		if verifier.config.requestResponse != nil {
+			return fmt.Errorf("use request-response only to verify responses")
+		}
+		parsedMessage, err := parseRequest(req, &verifier.config.derivation)
+		if err != nil {
+			return err
+		}
+		label, err := resolveLabel(*parsedMessage, key)
+		if err != nil {
+			return err
+		}
+		_, err = verifyMessage(req.Context(), *verifier.config, label, verifier, *parsedMessage, verifier.fields, false)
+		if err != nil {
+			return fmt.Errorf("signature \"%s\": %w", label, err)
+		}
+	}
+	return nil
+}
+
+// VerifyResponseSignatures is the response counterpart of VerifyRequestSignatures.
+func VerifyResponseSignatures(verifiers map[string]Verifier, res *http.Response) error {
+	if res == nil {
+		return fmt.Errorf("nil response")
+	}
+	if len(verifiers) == 0 {
+		return fmt.Errorf("no verifiers")
+	}
+	for _, key := range sortedKeys(verifiers) {
+		verifier := verifiers[key]
+		parsedMessage, err := parseResponse(res, &verifier.config.derivation)
+		if err != nil {
+			return err
+		}
+		label, err := resolveLabel(*parsedMessage, key)
+		if err != nil {
+			return err
+		}
+		extendedFields := addPseudoHeaders(parsedMessage, verifier.config.requestResponse, verifier.fields)
+		_, err = verifyMessage(responseContext(res), *verifier.config, label, verifier, *parsedMessage, extendedFields, false)
+		if err != nil {
+			return fmt.Errorf("signature \"%s\": %w", label, err)
+		}
+	}
+	return nil
+}
+
+// SignRequestSignatures signs a request with several independent signers in a single call, e.g. an edge signature
+// and an origin signature. Each key of the signers map is a signature label. Returns the combined values of the
+// Signature-Input and Signature headers, with the signatures sorted by label. No signature covers another one.
+func SignRequestSignatures(signers map[string]MessageSigner, req *http.Request) (signatureInput, signature string, err error) {
+	return signMessageSignatures(signers, func(label string, signer MessageSigner) (string, string, error) {
+		return SignRequest(label, signer, req)
+	})
+}
+
+// SignResponseSignatures is the response counterpart of SignRequestSignatures.
+func SignResponseSignatures(signers map[string]MessageSigner, res *http.Response) (signatureInput, signature string, err error) {
+	return signMessageSignatures(signers, func(label string, signer MessageSigner) (string, string, error) {
+		return SignResponse(label, signer, res)
+	})
+}
+
+func signMessageSignatures(signers map[string]MessageSigner,
+	sign func(label string, signer MessageSigner) (string, string, error)) (signatureInput, signature string, err error) {
+	if len(signers) == 0 {
+		return "", "", fmt.Errorf("no signers")
+	}
+	labels := make([]string, 0, len(signers))
+	for l := range signers {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	var inputs, signatures []string
+	for _, l := range labels {
+		si, sig, err := sign(l, signers[l])
+		if err != nil {
+			return "", "", fmt.Errorf("signature \"%s\": %w", l, err)
+		}
+		inputs = append(inputs, si)
+		signatures = append(signatures, sig)
+	}
+	return strings.Join(inputs, ", "), strings.Join(signatures, ", "), nil
+}
+
+// Map iteration order is random, sort the keys so that errors are reported deterministically
+func sortedKeys(verifiers map[string]Verifier) []string {
+	keys := make([]string, 0, len(verifiers))
+	for k := range verifiers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SignatureDetails describes a signature found on a message, as parsed from the Signature-Input header.
+// Integer parameters are zero and string parameters are empty if they are absent.
+type SignatureDetails struct {
+	Label   string
+	KeyID   string
+	Alg     string
+	Created int64
+	Expires int64
+	Nonce   string
+	Tag     string
+	Fields  Fields
+}
+
+// RequestSignatures parses a request and returns the details of all of its signatures, in the order
+// they appear in the Signature-Input header. The signatures are not verified.
+func RequestSignatures(req *http.Request) ([]SignatureDetails, error) {
+	if req == nil {
+		return nil, fmt.Errorf("nil request")
+	}
+	parsedMessage, err := parseRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return messageSignatures(*parsedMessage)
+}
+
+// ResponseSignatures parses a response and returns the details of all of its signatures.
+// The signatures are not verified.
+func ResponseSignatures(res *http.Response) ([]SignatureDetails, error) {
+	if res == nil {
+		return nil, fmt.Errorf("nil response")
+	}
+	parsedMessage, err := parseResponse(res, nil)
+	if err != nil {
+		return nil, err
+	}
+	return messageSignatures(*parsedMessage)
+}
+
+func messageSignatures(message parsedMessage) ([]SignatureDetails, error) {
+	if psis, ok := message.fastSignatures(); ok {
+		details := make([]SignatureDetails, len(psis))
+		for i, psi := range psis {
+			details[i] = psi.details()
+		}
+		return details, nil
+	}
+	labels, err := message.signatureLabels()
+	if err != nil {
+		return nil, err
+	}
+	var details []SignatureDetails
+	for _, l := range labels {
+		si, err := message.getDictHeader("signature-input", l)
+		if err != nil {
+			return nil, err
+		}
+		psi, err := parseSignatureInput(si[0], l)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, psi.details())
+	}
+	return details, nil
+}
+
+func (psi *psiSignature) details() SignatureDetails {
+	d := SignatureDetails{Label: psi.signatureName, Fields: psi.fields}
+	d.KeyID, _ = psi.params["keyid"].(string)
+	d.Alg, _ = psi.params["alg"].(string)
+	d.Created, _ = psi.params["created"].(int64)
+	d.Expires, _ = psi.params["expires"].(int64)
+	d.Nonce, _ = psi.params["nonce"].(string)
+	d.Tag, _ = psi.params["tag"].(string)
+	return d
+}
+
+// signatureLabels returns the labels of all signatures in the Signature-Input header, in order.
+func (message *parsedMessage) signatureLabels() ([]string, error) {
+	vals, found := message.headers["signature-input"]
+	if !found {
+		return nil, fmt.Errorf("missing \"signature-input\" header")
+	}
+	dict, err := httpsfv.UnmarshalDictionary(vals)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse dictionary for signature-input: %w", err)
+	}
+	return dict.Names(), nil
+}
+
+// resolveLabel returns key if it is the label of a signature on the message, and otherwise
+// the label of the single signature whose "tag" parameter equals key.
+func resolveLabel(message parsedMessage, key string) (string, error) {
+	labels, err := message.signatureLabels()
+	if err != nil {
+		return "", err
+	}
+	for _, l := range labels {
+		if l == key {
+			return l, nil
+		}
+	}
+	var matches []string
+	for _, l := range labels {
+		si, err := message.getDictHeader("signature-input", l)
+		if err != nil || len(si) != 1 {
+			continue
+		}
+		psi, err := parseSignatureInput(si[0], l)
+		if err != nil {
+			continue
+		}
+		if tag, ok := psi.params["tag"].(string); ok && tag == key {
+			matches = append(matches, l)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no signature with label or tag \"%s\"", key)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("more than one signature with tag \"%s\"", key)
+	}
+}
+
+// resolveLabels returns the verifiers keyed by the label of the signature each one verifies, resolving
+// tags to labels once, so that the signatures are later identified by their label only
+func resolveLabels(req *http.Request, verifiers map[string]Verifier) (map[string]Verifier, error) {
+	parsedMessage, err := parseRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	resolved := map[string]Verifier{}
+	for _, key := range sortedKeys(verifiers) {
+		label, err := resolveLabel(*parsedMessage, key)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := resolved[label]; found {
+			return nil, fmt.Errorf("more than one verifier for signature \"%s\"", label)
+		}
+		resolved[label] = verifiers[key]
+	}
+	return resolved, nil
+}
@@ -0,0 +1,135 @@
+package httpsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestVerifyRequestSignatures(t *testing.T) {
+	signedRequest := func() *http.Request {
+		req := readRequest(httpreq1)
+		clientSigner, _ := NewHMACSHA256Signer("client-key", bytes.Repeat([]byte{1}, 64),
+			NewSignConfig().SetTag("client-app"), Headers("@method", "content-type"))
+		sigInput, sig, err := SignRequest("sig1", *clientSigner, req)
+		assert.NoError(t, err)
+		assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+		gatewaySigner, _ := NewHMACSHA256Signer("gateway-key", bytes.Repeat([]byte{2}, 64),
+			NewSignConfig().SetCreated(1618884475), Headers("@method"))
+		sigInput, sig, err = SignRequest("gw", *gatewaySigner, req)
+		assert.NoError(t, err)
+		assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+		return req
+	}
+	strict, _ := NewHMACSHA256Verifier("client-key", bytes.Repeat([]byte{1}, 64), NewVerifyConfig(),
+		Headers("@method", "content-type"))
+	relaxed, _ := NewHMACSHA256Verifier("gateway-key", bytes.Repeat([]byte{2}, 64),
+		NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	strictGateway, _ := NewHMACSHA256Verifier("gateway-key", bytes.Repeat([]byte{2}, 64),
+		NewVerifyConfig(), Headers("@method"))
+
+	tests := []struct {
+		name      string
+		verifiers map[string]Verifier
+		wantErr   bool
+	}{
+		{
+			name:      "by label",
+			verifiers: map[string]Verifier{"sig1": *strict, "gw": *relaxed},
+			wantErr:   false,
+		},
+		{
+			name:      "by tag",
+			verifiers: map[string]Verifier{"client-app": *strict, "gw": *relaxed},
+			wantErr:   false,
+		},
+		{
+			name:      "gateway signature is too old for the strict policy",
+			verifiers: map[string]Verifier{"sig1": *strict, "gw": *strictGateway},
+			wantErr:   true,
+		},
+		{
+			name:      "wrong key for label",
+			verifiers: map[string]Verifier{"sig1": *relaxed},
+			wantErr:   true,
+		},
+		{
+			name:      "missing signature",
+			verifiers: map[string]Verifier{"sig1": *strict, "nonesuch": *relaxed},
+			wantErr:   true,
+		},
+		{
+			name:      "no verifiers",
+			verifiers: map[string]Verifier{},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyRequestSignatures(tt.verifiers, signedRequest())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequestSignatures() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestSignatures(t *testing.T) {
+	req := readRequest(httpreq1)
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64),
+		NewSignConfig().SetCreated(1618884475).SetExpires(1618884575).SetNonce("n1").SetTag("app"),
+		Headers("@method", "content-type"))
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+	sigs, err := RequestSignatures(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []SignatureDetails{{
+		Label:   "sig1",
+		KeyID:   "key1",
+		Alg:     "hmac-sha256",
+		Created: 1618884475,
+		Expires: 1618884575,
+		Nonce:   "n1",
+		Tag:     "app",
+		Fields:  Headers("@method", "content-type"),
+	}}, sigs)
+
+	_, err = RequestSignatures(readRequest(httpreq1))
+	assert.Error(t, err, "unsigned request")
+}
+
+func TestSignRequestSignatures(t *testing.T) {
+	edgeSigner, _ := NewHMACSHA256Signer("edge-key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method", "@authority"))
+	pubKey, prvKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	originSigner, _ := NewEd25519Signer("origin-key", prvKey, NewSignConfig().SetTag("origin"), Headers("@method", "content-type"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequestSignatures(map[string]MessageSigner{"origin": *originSigner, "edge": *edgeSigner}, req)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^edge=\("@method" "@authority"\);created=\d+;alg="hmac-sha256";keyid="edge-key", `+
+		`origin=\("@method" "content-type"\);created=\d+;alg="ed25519";keyid="origin-key";tag="origin"$`, sigInput)
+	assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+
+	edgeVerifier, _ := NewHMACSHA256Verifier("edge-key", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+	originVerifier, _ := NewEd25519Verifier("origin-key", pubKey, nil, Headers("content-type"))
+	assert.NoError(t, VerifyRequestSignatures(map[string]Verifier{"edge": *edgeVerifier, "origin": *originVerifier}, req))
+	assert.NoError(t, RemoveSignature(req.Header, "edge"))
+	assert.NoError(t, VerifyRequest("origin", *originVerifier, req), "signatures are independent")
+
+	_, _, err = SignRequestSignatures(map[string]MessageSigner{}, req)
+	assert.Error(t, err)
+	_, _, err = SignRequestSignatures(map[string]MessageSigner{"origin": *originSigner}, req)
+	assert.Error(t, err, "label is already in use")
+
+	res := readResponse(httpres1)
+	statusSigner, _ := NewHMACSHA256Signer("edge-key", bytes.Repeat([]byte{1}, 64), nil, Headers("@status"))
+	sigInput, sig, err = SignResponseSignatures(map[string]MessageSigner{"a": *statusSigner, "b": *statusSigner}, res)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(res.Header, sigInput, sig))
+	sigs, err := ResponseSignatures(res)
+	assert.NoError(t, err)
+	assert.Len(t, sigs, 2)
+}
@@ -0,0 +1,80 @@
+package httpsign
+
+import (
+	"context"
+	"net/http"
+)
+
+// SigningKey describes a key the server can sign responses with, see HandlerConfig.SetSigningKeys.
+type SigningKey struct {
+	KeyID string
+	Alg   string
+}
+
+// Negotiation is the outcome of reconciling the client's Accept-Signature header with the server's signing keys:
+// the requested signature that the server is able to produce, and the key to produce it with.
+type Negotiation struct {
+	Accepted AcceptedSignature
+	Key      SigningKey
+}
+
+type negotiationKey struct{}
+
+// GetNegotiation returns the negotiated signature parameters, if any. It is meant to be called
+// by the fetchSigner callback (see HandlerConfig.SetFetchSigner) to select the key and algorithm.
+func GetNegotiation(r *http.Request) (Negotiation, bool) {
+	n, ok := r.Context().Value(negotiationKey{}).(Negotiation)
+	return n, ok
+}
+
+// negotiate returns the first requested signature, in the client's order of preference, that can be produced
+// with one of the keys, in the server's order of preference, and whose fields are all available.
+func negotiate(accepted []AcceptedSignature, keys []SigningKey, available func(Fields) bool) (Negotiation, bool) {
+	for _, a := range accepted {
+		if !available(a.Fields) {
+			continue
+		}
+		for _, k := range keys {
+			if (a.KeyID == "" || a.KeyID == k.KeyID) && (a.Alg == "" || a.Alg == k.Alg) {
+				return Negotiation{Accepted: a, Key: k}, true
+			}
+		}
+	}
+	return Negotiation{}, false
+}
+
+// negotiateResponse negotiates the signature of a response, given the request's Accept-Signature header.
+// A field is available if the response, or for "req" fields the request, has it.
+func negotiateResponse(response *http.Response, keys []SigningKey) (Negotiation, bool) {
+	values := response.Request.Header.Values("Accept-Signature")
+	if len(keys) == 0 || len(values) == 0 {
+		return Negotiation{}, false
+	}
+	accepted, err := ParseAcceptSignature(values)
+	if err != nil {
+		return Negotiation{}, false
+	}
+	message, err := parseResponse(response, nil)
+	if err != nil {
+		return Negotiation{}, false
+	}
+	return negotiate(accepted, keys, func(fields Fields) bool {
+		for _, f := range fields.f {
+			if f.tr { // trailers are not known yet
+				return false
+			}
+			if _, err := generateFieldValues(f, *message); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// withNegotiation attaches the negotiated parameters, if any, to the request's context
+func withNegotiation(r *http.Request, n Negotiation, ok bool) *http.Request {
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), negotiationKey{}, n))
+}
@@ -0,0 +1,83 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	keys := []SigningKey{{KeyID: "k1", Alg: "hmac-sha256"}, {KeyID: "k2", Alg: "ed25519"}}
+	all := func(Fields) bool { return true }
+	tests := []struct {
+		name      string
+		accept    string
+		available func(Fields) bool
+		wantLabel string
+		wantKey   string
+		wantOK    bool
+	}{
+		{"any key", `a=("@status")`, all, "a", "k1", true},
+		{"by alg", `a=("@status");alg="ed25519"`, all, "a", "k2", true},
+		{"by key ID", `a=("@status");keyid="k2"`, all, "a", "k2", true},
+		{"client order", `a=("@status");alg="rsa-pss-sha512", b=("@status");keyid="k2", c=("@status")`, all, "b", "k2", true},
+		{"no key", `a=("@status");keyid="k3"`, all, "", "", false},
+		{"conflicting hints", `a=("@status");keyid="k1";alg="ed25519"`, all, "", "", false},
+		{"unavailable fields", `a=("x-missing"), b=("@status")`, func(f Fields) bool { return !f.contains(NewFields().AddHeader("x-missing")) },
+			"b", "k1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted, err := ParseAcceptSignature([]string{tt.accept})
+			assert.NoError(t, err)
+			n, ok := negotiate(accepted, keys, tt.available)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantLabel, n.Accepted.Label)
+			assert.Equal(t, tt.wantKey, n.Key.KeyID)
+		})
+	}
+}
+
+func TestWrapHandlerNegotiation(t *testing.T) {
+	keys := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 64), "k2": bytes.Repeat([]byte{2}, 64)}
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		keyID := "k1"
+		if n, ok := GetNegotiation(r); ok {
+			keyID = n.Key.KeyID
+		}
+		signer, _ := NewHMACSHA256Signer(keyID, keys[keyID], NewSignConfig().SignCreated(false), Headers("@status"))
+		return "default", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchSigner(fetchSigner).
+		SetHonorAcceptSignature(true).SetSigningKeys([]SigningKey{{KeyID: "k1", Alg: "hmac-sha256"}, {KeyID: "k2", Alg: "hmac-sha256"}})))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"no header", "", `default=("@status");alg="hmac-sha256";keyid="k1"`},
+		{"key hint", `mine=("content-type");keyid="k2"`, `mine=("content-type" "@status");alg="hmac-sha256";keyid="k2"`},
+		{"missing field skipped", `a=("x-missing");keyid="k1", b=("content-type");keyid="k2"`,
+			`b=("content-type" "@status");alg="hmac-sha256";keyid="k2"`},
+		{"unknown key", `mine=("content-type");keyid="k3"`, `default=("@status");alg="hmac-sha256";keyid="k1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Signature", tt.accept)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, res.Header.Get("Signature-Input"))
+		})
+	}
+}
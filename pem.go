@@ -0,0 +1,147 @@
+package httpsign
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+var oidRSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+// ParsePrivateKeyPEM parses the first PEM block of pemBytes as a private key, see ParsePrivateKeyDER.
+func ParsePrivateKeyPEM(pemBytes []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("cannot decode PEM")
+	}
+	return ParsePrivateKeyDER(block.Bytes)
+}
+
+// ParsePrivateKeyDER parses a private key in PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) form. PKCS#8 RSA-PSS keys
+// (as generated by "openssl genpkey -algorithm RSA-PSS") are also supported.
+// The result is an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+func ParsePrivateKeyDER(der []byte) (crypto.PrivateKey, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	var p8 struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(der, &p8); err == nil && p8.Algo.Algorithm.Equal(oidRSAPSS) {
+		return x509.ParsePKCS1PrivateKey(p8.PrivateKey)
+	}
+	return nil, fmt.Errorf("cannot parse private key")
+}
+
+// ParsePublicKeyPEM parses the first PEM block of pemBytes as a public key, see ParsePublicKeyDER.
+// If the block is a certificate, its public key is returned.
+func ParsePublicKeyPEM(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("cannot decode PEM")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate: %w", err)
+		}
+		return cert.PublicKey, nil
+	}
+	return ParsePublicKeyDER(block.Bytes)
+}
+
+// ParsePublicKeyDER parses a public key in SPKI (PKIX) or PKCS#1 (RSA) form. SPKI RSA-PSS keys are also supported.
+// The result is an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func ParsePublicKeyDER(der []byte) (crypto.PublicKey, error) {
+	if k, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return k, nil
+	}
+	var spki struct {
+		Algo      pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err == nil && spki.Algo.Algorithm.Equal(oidRSAPSS) {
+		return x509.ParsePKCS1PublicKey(spki.PublicKey.RightAlign())
+	}
+	return nil, fmt.Errorf("cannot parse public key")
+}
+
+func signerFromPEM(alg, keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	key, err := ParsePrivateKeyPEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(alg, keyID, key, config, fields)
+}
+
+func verifierFromPEM(alg, keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	key, err := ParsePublicKeyPEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifier(alg, keyID, key, config, fields)
+}
+
+// NewRSASignerFromPEM is the same as NewRSASigner, for a PEM-encoded private key.
+func NewRSASignerFromPEM(keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	return signerFromPEM("rsa-v1_5-sha256", keyID, pemBytes, config, fields)
+}
+
+// NewRSAPSSSignerFromPEM is the same as NewRSAPSSSigner, for a PEM-encoded private key.
+func NewRSAPSSSignerFromPEM(keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	return signerFromPEM("rsa-pss-sha512", keyID, pemBytes, config, fields)
+}
+
+// NewP256SignerFromPEM is the same as NewP256Signer, for a PEM-encoded private key.
+func NewP256SignerFromPEM(keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	return signerFromPEM("ecdsa-p256-sha256", keyID, pemBytes, config, fields)
+}
+
+// NewP384SignerFromPEM is the same as NewP384Signer, for a PEM-encoded private key.
+func NewP384SignerFromPEM(keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	return signerFromPEM("ecdsa-p384-sha384", keyID, pemBytes, config, fields)
+}
+
+// NewEd25519SignerFromPEM is the same as NewEd25519Signer, for a PEM-encoded private key.
+func NewEd25519SignerFromPEM(keyID string, pemBytes []byte, config *SignConfig, fields Fields) (*Signer, error) {
+	return signerFromPEM("ed25519", keyID, pemBytes, config, fields)
+}
+
+// NewRSAVerifierFromPEM is the same as NewRSAVerifier, for a PEM-encoded public key or certificate.
+func NewRSAVerifierFromPEM(keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	return verifierFromPEM("rsa-v1_5-sha256", keyID, pemBytes, config, fields)
+}
+
+// NewRSAPSSVerifierFromPEM is the same as NewRSAPSSVerifier, for a PEM-encoded public key or certificate.
+func NewRSAPSSVerifierFromPEM(keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	return verifierFromPEM("rsa-pss-sha512", keyID, pemBytes, config, fields)
+}
+
+// NewP256VerifierFromPEM is the same as NewP256Verifier, for a PEM-encoded public key or certificate.
+func NewP256VerifierFromPEM(keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	return verifierFromPEM("ecdsa-p256-sha256", keyID, pemBytes, config, fields)
+}
+
+// NewP384VerifierFromPEM is the same as NewP384Verifier, for a PEM-encoded public key or certificate.
+func NewP384VerifierFromPEM(keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	return verifierFromPEM("ecdsa-p384-sha384", keyID, pemBytes, config, fields)
+}
+
+// NewEd25519VerifierFromPEM is the same as NewEd25519Verifier, for a PEM-encoded public key or certificate.
+func NewEd25519VerifierFromPEM(keyID string, pemBytes []byte, config *VerifyConfig, fields Fields) (*Verifier, error) {
+	return verifierFromPEM("ed25519", keyID, pemBytes, config, fields)
+}
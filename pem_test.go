@@ -0,0 +1,64 @@
+package httpsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseKeysPEM(t *testing.T) {
+	tests := []struct {
+		name     string
+		pem      string
+		private  bool
+		wantType interface{}
+	}{
+		{"PKCS#8 RSA", rsaPrvKey, true, &rsa.PrivateKey{}},
+		{"PKCS#8 RSA-PSS", rsaPSSPrvKey, true, &rsa.PrivateKey{}},
+		{"SEC 1 EC", p256PrvKey, true, &ecdsa.PrivateKey{}},
+		{"PKCS#8 Ed25519", ed25519PrvKey, true, ed25519.PrivateKey{}},
+		{"SPKI RSA", rsaPubKey, false, &rsa.PublicKey{}},
+		{"SPKI EC", p256PubKey, false, &ecdsa.PublicKey{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key interface{}
+			var err error
+			if tt.private {
+				key, err = ParsePrivateKeyPEM([]byte(tt.pem))
+			} else {
+				key, err = ParsePublicKeyPEM([]byte(tt.pem))
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.wantType, key)
+		})
+	}
+	_, err := ParsePrivateKeyPEM([]byte(p256PubKey))
+	assert.Error(t, err, "public key is not a private key")
+	_, err = ParsePublicKeyPEM([]byte("not PEM"))
+	assert.Error(t, err)
+}
+
+func TestSignAndVerifyFromPEM(t *testing.T) {
+	fields := Headers("@method", "date", "content-type")
+	config := NewVerifyConfig().SetVerifyCreated(false)
+	signer, err := NewRSAPSSSignerFromPEM("test-key-rsa-pss", []byte(rsaPSSPrvKey), nil, fields)
+	assert.NoError(t, err)
+	verifier, err := NewRSAPSSVerifierFromPEM("test-key-rsa-pss", []byte(rsaPSSPubKey), config, fields)
+	assert.NoError(t, err)
+	req := readRequest(httpreq2)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature", sig)
+	req.Header.Add("Signature-Input", sigInput)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	_, err = NewP384SignerFromPEM("key", []byte(p256PrvKey), nil, fields)
+	assert.Error(t, err, "wrong curve")
+	_, err = NewEd25519VerifierFromPEM("key", []byte(p256PubKey), nil, fields)
+	assert.Error(t, err, "wrong key type")
+	_, err = NewP256SignerFromPEM("key", []byte(p256PubKey), nil, fields)
+	assert.Error(t, err, "not a private key")
+}
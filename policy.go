@@ -0,0 +1,107 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// SignaturePolicy determines which signatures on a message must verify, see VerifyRequestWithPolicy.
+// Signatures are referred to by label only, never by their "tag" parameter, which is chosen by the sender.
+type SignaturePolicy struct {
+	labels []string
+	all    bool
+}
+
+// AllOf requires all the listed signatures to verify, e.g. both an edge and an origin signature.
+func AllOf(labels ...string) SignaturePolicy {
+	return SignaturePolicy{labels: labels, all: true}
+}
+
+// AnyOf requires at least one of the listed signatures to verify. With no labels,
+// at least one of the signatures that have a verifier must verify.
+func AnyOf(labels ...string) SignaturePolicy {
+	return SignaturePolicy{labels: labels}
+}
+
+// VerifyRequestWithPolicy evaluates the signatures on a request against a set of verifiers. Each key of the verifiers
+// map is a signature label, and a signature is valid only if the verifier for its label verifies it, so that
+// a signature made with one party's key cannot stand in for another party's signature.
+// Returns the details of the valid signatures among those the policy refers to, and an error if the policy
+// is not satisfied.
+func VerifyRequestWithPolicy(policy SignaturePolicy, verifiers map[string]MessageVerifier, req *http.Request) ([]SignatureDetails, error) {
+	sigs, err := RequestSignatures(req)
+	if err != nil {
+		return nil, err
+	}
+	return policy.evaluate(sigs, verifiers, func(label string, v MessageVerifier) error {
+		return v.VerifyRequest(label, req)
+	})
+}
+
+// VerifyResponseWithPolicy is the response counterpart of VerifyRequestWithPolicy.
+func VerifyResponseWithPolicy(policy SignaturePolicy, verifiers map[string]MessageVerifier, res *http.Response) ([]SignatureDetails, error) {
+	sigs, err := ResponseSignatures(res)
+	if err != nil {
+		return nil, err
+	}
+	return policy.evaluate(sigs, verifiers, func(label string, v MessageVerifier) error {
+		return v.VerifyResponse(label, res)
+	})
+}
+
+func (p SignaturePolicy) evaluate(sigs []SignatureDetails, verifiers map[string]MessageVerifier,
+	verify func(label string, v MessageVerifier) error) ([]SignatureDetails, error) {
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("no verifiers")
+	}
+	if p.all && len(p.labels) == 0 {
+		return nil, fmt.Errorf("no signatures are required")
+	}
+	labels := p.labels
+	if len(labels) == 0 {
+		for l := range verifiers {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+	}
+	wanted := map[string]bool{}
+	for _, l := range labels {
+		if isNilVerifier(verifiers[l]) {
+			return nil, fmt.Errorf("no verifier for signature \"%s\"", l)
+		}
+		wanted[l] = true
+	}
+	var valid []SignatureDetails
+	var lastErr error
+	satisfied := map[string]bool{}
+	for _, sig := range sigs {
+		if !wanted[sig.Label] {
+			continue // not referred to by the policy
+		}
+		if lastErr = verify(sig.Label, verifiers[sig.Label]); lastErr != nil {
+			lastErr = fmt.Errorf("signature \"%s\": %w", sig.Label, lastErr)
+			continue
+		}
+		valid = append(valid, sig)
+		satisfied[sig.Label] = true
+	}
+	if p.all {
+		for _, l := range labels {
+			if !satisfied[l] {
+				if lastErr != nil {
+					return valid, fmt.Errorf("required signature \"%s\" did not verify, last error: %w", l, lastErr)
+				}
+				return valid, fmt.Errorf("required signature \"%s\" did not verify", l)
+			}
+		}
+		return valid, nil
+	}
+	if len(valid) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("no signature verified, last error: %w", lastErr)
+		}
+		return nil, fmt.Errorf("no signature verified")
+	}
+	return valid, nil
+}
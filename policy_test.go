@@ -0,0 +1,89 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyWithPolicy(t *testing.T) {
+	edgeKey, originKey := bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64)
+	edgeSigner, _ := NewHMACSHA256Signer("edge-key", edgeKey, NewSignConfig().SetTag("edge"), Headers("@method"))
+	originSigner, _ := NewHMACSHA256Signer("origin-key", originKey, nil, Headers("@method", "content-type"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequestSignatures(map[string]MessageSigner{"sig1": *edgeSigner, "origin": *originSigner}, req)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+	assert.NoError(t, AddSignature(req.Header, `junk=("@method");keyid="edge-key"`, `junk=:AAAA:`))
+
+	edgeVerifier, _ := NewHMACSHA256Verifier("edge-key", edgeKey, nil, *NewFields())
+	originVerifier, _ := NewHMACSHA256Verifier("origin-key", originKey, nil, *NewFields())
+	both := map[string]MessageVerifier{"sig1": *edgeVerifier, "origin": *originVerifier, "junk": *edgeVerifier}
+	edgeOnly := map[string]MessageVerifier{"sig1": *edgeVerifier}
+
+	tests := []struct {
+		name      string
+		policy    SignaturePolicy
+		verifiers map[string]MessageVerifier
+		want      []string
+		wantErr   bool
+	}{
+		{"all of", AllOf("sig1", "origin"), both, []string{"origin", "sig1"}, false},
+		{"all of, by tag", AllOf("edge", "origin"), map[string]MessageVerifier{"edge": *edgeVerifier, "origin": *originVerifier},
+			[]string{"origin"}, true},
+		{"all of, invalid signature", AllOf("origin", "junk"), both, []string{"origin"}, true},
+		{"all of, missing signature", AllOf("origin", "nonesuch"),
+			map[string]MessageVerifier{"origin": *originVerifier, "nonesuch": *originVerifier}, []string{"origin"}, true},
+		{"all of, missing verifier", AllOf("sig1", "origin"), edgeOnly, nil, true},
+		{"all of, wrong verifier", AllOf("origin"), map[string]MessageVerifier{"origin": *edgeVerifier}, nil, true},
+		{"all of nothing", AllOf(), both, nil, true},
+		{"any of the message", AnyOf(), both, []string{"origin", "sig1"}, false},
+		{"any of, some verifiers", AnyOf(), edgeOnly, []string{"sig1"}, false},
+		{"any of, invalid signature", AnyOf("junk"), both, nil, true},
+		{"any of, one is invalid", AnyOf("junk", "origin"), both, []string{"origin"}, false},
+		{"no verifiers", AnyOf(), nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := VerifyRequestWithPolicy(tt.policy, tt.verifiers, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			var labels []string
+			for _, s := range valid {
+				labels = append(labels, s.Label)
+			}
+			assert.Equal(t, tt.want, labels)
+		})
+	}
+
+	res := readResponse(httpres1)
+	statusSigner, _ := NewHMACSHA256Signer("edge-key", edgeKey, nil, Headers("@status"))
+	sigInput, sig, err = SignResponse("sig1", *statusSigner, res)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(res.Header, sigInput, sig))
+	_, err = VerifyResponseWithPolicy(AllOf("sig1"), edgeOnly, res)
+	assert.NoError(t, err)
+}
+
+func TestVerifyWithPolicy_WrongKey(t *testing.T) {
+	// a partner signs under the other partner's label and tag, with its own, valid key
+	partnerA, partnerB := bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64)
+	signerA, _ := NewHMACSHA256Signer("a-key", partnerA, NewSignConfig().SetTag("b"), Headers("@method"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("b", *signerA, req)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+
+	verifierA, _ := NewHMACSHA256Verifier("a-key", partnerA, nil, *NewFields())
+	verifierB, _ := NewHMACSHA256Verifier("b-key", partnerB, nil, *NewFields())
+	keys := NewKeySet().Add(verifierA).Add(verifierB)
+	_, err = VerifyRequestWithPolicy(AllOf("b"), map[string]MessageVerifier{"b": *verifierB}, req)
+	assert.Error(t, err, "signature by A must not satisfy a requirement for B")
+	_, err = VerifyRequestWithPolicy(AllOf("b"), map[string]MessageVerifier{"a": *verifierA, "b": *verifierB}, req)
+	assert.Error(t, err)
+	_, err = VerifyRequestWithPolicy(AllOf("b"), map[string]MessageVerifier{"b": keys}, req)
+	assert.NoError(t, err, "a key set bound to the label accepts any of its keys")
+}
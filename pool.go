@@ -0,0 +1,29 @@
+package httpsign
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a buffer is left to the garbage collector rather than pooled,
+// so that a single large message does not pin a large buffer for the life of the process.
+const maxPooledBuffer = 64 << 10
+
+// bufferPool holds the buffers that signature bases are built in, so that busy servers do not allocate
+// a new buffer for each message.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a buffer to the pool. The buffer's contents must no longer be referenced.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
@@ -0,0 +1,78 @@
+package httpsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestPutBuffer(t *testing.T) {
+	b := getBuffer()
+	b.WriteString("leftover")
+	putBuffer(b)
+	assert.Equal(t, 0, getBuffer().Len(), "pooled buffers should be empty")
+
+	large := bytes.NewBuffer(make([]byte, 0, 2*maxPooledBuffer))
+	putBuffer(large)
+	assert.Equal(t, 2*maxPooledBuffer, large.Cap(), "large buffers should not be reset or pooled")
+}
+
+// Pooled buffers must not leak between concurrent signatures
+func TestBufferPoolConcurrent(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := NewEd25519Signer("key1", priv, NewSignConfig().SetCreated(1618884475), Headers("@method", "@path", "content-type"))
+	assert.NoError(t, err)
+	verifier, err := NewEd25519Verifier("key1", pub, NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				req := readRequest(httpreq1)
+				sigInput, sig, err := SignRequest("sig1", *signer, req)
+				if !assert.NoError(t, err) {
+					return
+				}
+				req.Header.Add("Signature-Input", sigInput)
+				req.Header.Add("Signature", sig)
+				if !assert.NoError(t, VerifyRequest("sig1", *verifier, req)) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSignRequest(b *testing.B) {
+	signer, _ := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), NewSignConfig(),
+		Headers("@method", "@path", "@authority", "content-type", "content-length"))
+	req := readRequest(httpreq1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = SignRequest("sig1", *signer, req)
+	}
+}
+
+func BenchmarkVerifyRequest(b *testing.B) {
+	key := bytes.Repeat([]byte{1}, 64)
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(),
+		Headers("@method", "@path", "@authority", "content-type", "content-length"))
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	req := readRequest(httpreq1)
+	sigInput, sig, _ := SignRequest("sig1", *signer, req)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = VerifyRequest("sig1", *verifier, req)
+	}
+}
@@ -0,0 +1,107 @@
+// Package prommetrics implements httpsign.Metrics with Prometheus collectors. It maintains the following metrics:
+//
+//	httpsign_sign_total{alg, result}                 signing operations, result is "ok" or "error"
+//	httpsign_sign_duration_seconds{alg}              signing latency
+//	httpsign_verify_total{alg, result}               verifications, result is "ok" or a failure reason
+//	httpsign_verify_duration_seconds{alg}            verification latency
+//	httpsign_key_fetch_total{result}                 key fetches, result is "ok" or "error"
+//	httpsign_key_fetch_duration_seconds{}            key fetch latency
+//
+// The failure reasons are those returned by httpsign.FailureReason.
+//
+// To avoid a dependency on the Prometheus client library, the package defines the small Factory interface.
+// With client_golang, it is implemented by a thin wrapper around promauto:
+//
+//	type factory struct{}
+//
+//	func (factory) NewCounterVec(name, help string, labels ...string) prommetrics.CounterVec {
+//		return counterVec{promauto.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)}
+//	}
+//
+//	func (factory) NewHistogramVec(name, help string, labels ...string) prommetrics.HistogramVec {
+//		return histogramVec{promauto.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help}, labels)}
+//	}
+//
+//	type counterVec struct{ *prometheus.CounterVec }
+//
+//	func (c counterVec) Inc(labels ...string) { c.WithLabelValues(labels...).Inc() }
+//
+//	type histogramVec struct{ *prometheus.HistogramVec }
+//
+//	func (h histogramVec) Observe(v float64, labels ...string) { h.WithLabelValues(labels...).Observe(v) }
+package prommetrics
+
+import (
+	"github.com/yaronf/httpsign"
+	"time"
+)
+
+// CounterVec is a counter partitioned by labels, like prometheus.CounterVec.
+type CounterVec interface {
+	Inc(labels ...string)
+}
+
+// HistogramVec is a histogram partitioned by labels, like prometheus.HistogramVec.
+type HistogramVec interface {
+	Observe(v float64, labels ...string)
+}
+
+// Factory creates and registers collectors.
+type Factory interface {
+	NewCounterVec(name, help string, labels ...string) CounterVec
+	NewHistogramVec(name, help string, labels ...string) HistogramVec
+}
+
+// Metrics implements httpsign.Metrics.
+type Metrics struct {
+	signs            CounterVec
+	signDuration     HistogramVec
+	verifications    CounterVec
+	verifyDuration   HistogramVec
+	keyFetches       CounterVec
+	keyFetchDuration HistogramVec
+}
+
+var _ httpsign.Metrics = (*Metrics)(nil)
+
+// New creates the collectors using f, and returns Metrics that update them.
+// It should be called once per registry, since collectors cannot be registered twice.
+func New(f Factory) *Metrics {
+	return &Metrics{
+		signs:            f.NewCounterVec("httpsign_sign_total", "Number of HTTP message signing operations.", "alg", "result"),
+		signDuration:     f.NewHistogramVec("httpsign_sign_duration_seconds", "Latency of HTTP message signing.", "alg"),
+		verifications:    f.NewCounterVec("httpsign_verify_total", "Number of HTTP message signature verifications.", "alg", "result"),
+		verifyDuration:   f.NewHistogramVec("httpsign_verify_duration_seconds", "Latency of HTTP message signature verification.", "alg"),
+		keyFetches:       f.NewCounterVec("httpsign_key_fetch_total", "Number of verification key fetches.", "result"),
+		keyFetchDuration: f.NewHistogramVec("httpsign_key_fetch_duration_seconds", "Latency of verification key fetches."),
+	}
+}
+
+// Signed implements httpsign.Metrics.
+func (m *Metrics) Signed(alg string, d time.Duration, err error) {
+	m.signs.Inc(alg, result(err))
+	m.signDuration.Observe(d.Seconds(), alg)
+}
+
+// Verified implements httpsign.Metrics.
+func (m *Metrics) Verified(alg string, d time.Duration, err error) {
+	r := "ok"
+	if err != nil {
+		r = httpsign.FailureReason(err)
+	}
+	m.verifications.Inc(alg, r)
+	m.verifyDuration.Observe(d.Seconds(), alg)
+}
+
+// KeyFetched implements httpsign.Metrics.
+func (m *Metrics) KeyFetched(d time.Duration, err error) {
+	m.keyFetches.Inc(result(err))
+	m.keyFetchDuration.Observe(d.Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,60 @@
+package prommetrics
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeVec struct {
+	labels  []string
+	counts  map[string]int
+	samples map[string][]float64
+}
+
+func (v *fakeVec) Inc(labels ...string) {
+	v.counts[strings.Join(labels, ",")]++
+}
+
+func (v *fakeVec) Observe(f float64, labels ...string) {
+	k := strings.Join(labels, ",")
+	v.samples[k] = append(v.samples[k], f)
+}
+
+type fakeFactory map[string]*fakeVec
+
+func (f fakeFactory) newVec(name string, labels []string) *fakeVec {
+	v := &fakeVec{labels: labels, counts: map[string]int{}, samples: map[string][]float64{}}
+	f[name] = v
+	return v
+}
+
+func (f fakeFactory) NewCounterVec(name, _ string, labels ...string) CounterVec {
+	return f.newVec(name, labels)
+}
+
+func (f fakeFactory) NewHistogramVec(name, _ string, labels ...string) HistogramVec {
+	return f.newVec(name, labels)
+}
+
+func TestMetrics(t *testing.T) {
+	f := fakeFactory{}
+	m := New(f)
+	assert.Len(t, f, 6)
+	assert.Equal(t, []string{"alg", "result"}, f["httpsign_verify_total"].labels)
+
+	m.Signed("ed25519", time.Millisecond, nil)
+	m.Signed("ed25519", time.Millisecond, fmt.Errorf("no key"))
+	m.Verified("ed25519", 2*time.Second, nil)
+	m.Verified("ed25519", time.Second, fmt.Errorf("signature \"sig1\": %w", httpsign.ErrSignatureExpired))
+	m.KeyFetched(time.Second, nil)
+
+	assert.Equal(t, map[string]int{"ed25519,ok": 1, "ed25519,error": 1}, f["httpsign_sign_total"].counts)
+	assert.Equal(t, map[string]int{"ed25519,ok": 1, "ed25519,expired": 1}, f["httpsign_verify_total"].counts)
+	assert.Equal(t, []float64{2, 1}, f["httpsign_verify_duration_seconds"].samples["ed25519"])
+	assert.Equal(t, map[string]int{"ok": 1}, f["httpsign_key_fetch_total"].counts)
+	assert.Equal(t, []float64{1}, f["httpsign_key_fetch_duration_seconds"].samples[""])
+}
@@ -0,0 +1,75 @@
+package httpsign
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// ProxyConfig contains the configuration of a reverse proxy that verifies inbound requests and signs
+// the requests it forwards upstream, typically with its own key, as in an API gateway.
+type ProxyConfig struct {
+	handler  HandlerConfig
+	sigName  string
+	signer   MessageSigner
+	preserve bool
+}
+
+// NewProxyConfig generates a default configuration, which neither verifies nor signs.
+func NewProxyConfig() *ProxyConfig {
+	return &ProxyConfig{handler: *NewHandlerConfig()}
+}
+
+// SetHandlerConfig determines how inbound requests are verified, and optionally how the responses returned
+// to the client are signed, as for WrapHandler. Requests that fail verification are not forwarded.
+// Default: the result of NewHandlerConfig, i.e. no verification.
+func (p *ProxyConfig) SetHandlerConfig(h HandlerConfig) *ProxyConfig {
+	p.handler = h
+	return p
+}
+
+// SetUpstreamSigner sets the signer of the requests forwarded upstream, and the label of their signature.
+// The signature is computed after the proxy has rewritten the request, so it covers the request as
+// the upstream server receives it. Default: nil, meaning forwarded requests are not signed.
+func (p *ProxyConfig) SetUpstreamSigner(sigName string, signer MessageSigner) *ProxyConfig {
+	p.sigName = sigName
+	if isNilSigner(signer) {
+		signer = nil
+	}
+	p.signer = signer
+	return p
+}
+
+// SetPreserveSignatures forwards the inbound Signature-Input and Signature headers, so that the upstream
+// server can verify the client's signature in addition to the proxy's. The upstream signature must then
+// use a different label than the client's, or the request fails with a 502 status code.
+// Default: false, meaning inbound signatures are stripped.
+func (p *ProxyConfig) SetPreserveSignatures(b bool) *ProxyConfig {
+	p.preserve = b
+	return p
+}
+
+// NewReverseProxy returns a handler that proxies requests to target, as httputil.NewSingleHostReverseProxy does,
+// verifying inbound requests and signing forwarded requests according to config.
+func NewReverseProxy(target *url.URL, config ProxyConfig) http.Handler {
+	return WrapReverseProxy(httputil.NewSingleHostReverseProxy(target), config)
+}
+
+// WrapReverseProxy adds verification and signing to an existing reverse proxy. The proxy itself is not modified.
+// Its Director (or other request rewriting) can retrieve the verified signatures with GetVerifiedRequest.
+func WrapReverseProxy(proxy *httputil.ReverseProxy, config ProxyConfig) http.Handler {
+	p := *proxy
+	if config.signer != nil {
+		p.Transport = NewTransport(config.sigName, config.signer, nil, nil, proxy.Transport)
+	}
+	forward := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.preserve {
+			r = r.WithContext(r.Context())
+			r.Header = r.Header.Clone()
+			r.Header.Del("Signature-Input")
+			r.Header.Del("Signature")
+		}
+		p.ServeHTTP(w, r)
+	})
+	return WrapHandler(forward, config.handler)
+}
@@ -0,0 +1,103 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReverseProxy(t *testing.T) {
+	clientKey := bytes.Repeat([]byte{1}, 64)
+	proxyKey := bytes.Repeat([]byte{2}, 64)
+
+	var upstreamLabels []string
+	upstream := httptest.NewServer(WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamLabels = nil
+		sigs, _ := RequestSignatures(r)
+		for _, s := range sigs {
+			upstreamLabels = append(upstreamLabels, s.Label)
+		}
+		_, _ = fmt.Fprintln(w, "Hello, proxy")
+	}), *NewHandlerConfig().SetFetchVerifier(func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("proxy", proxyKey, NewVerifyConfig(), Headers("@method", "@path"))
+		return "proxy", verifier
+	})))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	assert.NoError(t, err)
+
+	inbound := *NewHandlerConfig().SetFetchVerifier(func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("client", clientKey, NewVerifyConfig(), Headers("@method", "@path"))
+		return "sig1", verifier
+	})
+	proxySigner, err := NewHMACSHA256Signer("proxy", proxyKey, NewSignConfig(), Headers("@method", "@path"))
+	assert.NoError(t, err)
+	clientSigner, err := NewHMACSHA256Signer("client", clientKey, NewSignConfig(), Headers("@method", "@path"))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		config     *ProxyConfig
+		sign       bool
+		wantStatus int
+		wantLabels []string
+	}{
+		{
+			name:       "strip",
+			config:     NewProxyConfig().SetHandlerConfig(inbound).SetUpstreamSigner("proxy", proxySigner),
+			sign:       true,
+			wantStatus: http.StatusOK,
+			wantLabels: []string{"proxy"},
+		},
+		{
+			name:       "preserve",
+			config:     NewProxyConfig().SetHandlerConfig(inbound).SetUpstreamSigner("proxy", proxySigner).SetPreserveSignatures(true),
+			sign:       true,
+			wantStatus: http.StatusOK,
+			wantLabels: []string{"sig1", "proxy"},
+		},
+		{
+			name:       "label conflict",
+			config:     NewProxyConfig().SetHandlerConfig(inbound).SetUpstreamSigner("sig1", proxySigner).SetPreserveSignatures(true),
+			sign:       true,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "unsigned request",
+			config:     NewProxyConfig().SetHandlerConfig(inbound).SetUpstreamSigner("proxy", proxySigner),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no upstream signer",
+			config:     NewProxyConfig().SetHandlerConfig(inbound),
+			sign:       true,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamLabels = nil
+			proxy := httptest.NewServer(NewReverseProxy(target, *tt.config))
+			defer proxy.Close()
+			var client *Client
+			if tt.sign {
+				client = NewDefaultClient("sig1", clientSigner, nil, nil)
+			} else {
+				client = NewDefaultClient("sig1", nil, nil, nil)
+			}
+			res, err := client.Post(proxy.URL+"/foo", "text/plain", strings.NewReader("data"))
+			assert.NoError(t, err)
+			if err != nil {
+				return
+			}
+			_ = res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantLabels, upstreamLabels)
+		})
+	}
+}
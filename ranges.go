@@ -0,0 +1,38 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// rangeHeaders are the headers that select or describe a partial representation.
+var rangeHeaders = []string{"range", "if-range", "content-range"}
+
+// conditionalHeaders are the request headers that make a request conditional (RFC 9110, Sec. 13.1).
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"}
+
+// RangeRequestFields returns the components recommended for signing a range or conditional request:
+// "@method", "@target-uri", and the "range" header and any conditional headers that the request has.
+// Covering these prevents an attacker from changing which part of the resource is returned.
+func RangeRequestFields(req *http.Request) Fields {
+	fs := NewFields().AddHeaders("@method", "@target-uri")
+	for _, hdr := range append([]string{"Range"}, conditionalHeaders...) {
+		if _, found := req.Header[hdr]; found {
+			fs.AddHeader(hdr)
+		}
+	}
+	return *fs
+}
+
+// applyPolicyRange checks that any range-related header in the message is covered by the signature
+func applyPolicyRange(message parsedMessage, psi *psiSignature, config VerifyConfig) error {
+	if !config.requireRange {
+		return nil
+	}
+	for _, hdr := range rangeHeaders {
+		if _, found := message.headers[hdr]; found && !psi.fields.coversName(hdr) {
+			return fmt.Errorf("signature does not cover \"%s\"", hdr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var httpreqRange = `GET /movies/big.mp4 HTTP/1.1
+Host: example.com
+Range: bytes=0-1023
+If-Range: "737060cd8c284d8af7ad3082f209582d"
+
+`
+
+var httpres206 = `HTTP/1.1 206 Partial Content
+Content-Type: video/mp4
+Content-Range: bytes 0-1023/146515
+Content-Digest: sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:
+Content-Length: 1024
+
+`
+
+func TestRangeRequestFields(t *testing.T) {
+	req := readRequest(httpreqRange)
+	assert.Equal(t, Headers("@method", "@target-uri", "range", "if-range"), RangeRequestFields(req))
+	req.Header.Set("If-None-Match", `"xyzzy"`)
+	assert.Equal(t, Headers("@method", "@target-uri", "range", "if-none-match", "if-range"), RangeRequestFields(req))
+}
+
+func TestResponsePresetFieldsPartial(t *testing.T) {
+	res := readResponse(httpres206)
+	assert.Equal(t, Headers("@status", "content-type", "content-digest", "content-range"), ResponsePresetFields(res))
+}
+
+func TestRequireRangeCoverage(t *testing.T) {
+	key := bytes.Repeat([]byte{0x33}, 64)
+	tests := []struct {
+		name     string
+		fields   Fields
+		require  bool
+		response bool
+		wantErr  bool
+	}{
+		{"request, range not covered, no policy", Headers("@method"), false, false, false},
+		{"request, range not covered", Headers("@method"), true, false, true},
+		{"request, if-range not covered", Headers("@method", "range"), true, false, true},
+		{"request, range covered", RangeRequestFields(readRequest(httpreqRange)), true, false, false},
+		{"response, content-range not covered", Headers("@status"), true, true, true},
+		{"response, content-range covered", ResponsePresetFields(readResponse(httpres206)), true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("test-key-hmac", key, nil, tt.fields)
+			verifier, _ := NewHMACSHA256Verifier("test-key-hmac", key,
+				NewVerifyConfig().SetRequireRangeCoverage(tt.require).SetVerifyDigest(false), *NewFields()) // the fixture has no body
+			var sigInput, sig string
+			var err error
+			if tt.response {
+				res := readResponse(httpres206)
+				sigInput, sig, err = SignResponse("sig1", *signer, res)
+				assert.NoError(t, err)
+				res.Header.Add("Signature-Input", sigInput)
+				res.Header.Add("Signature", sig)
+				err = VerifyResponse("sig1", *verifier, res)
+			} else {
+				req := readRequest(httpreqRange)
+				sigInput, sig, err = SignRequest("sig1", *signer, req)
+				assert.NoError(t, err)
+				req.Header.Add("Signature-Input", sigInput)
+				req.Header.Add("Signature", sig)
+				err = VerifyRequest("sig1", *verifier, req)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verification error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Package redisreplay is a httpsign.ReplayCache backed by Redis, or any distributed key-value store with
+// an atomic "set if absent" operation, so that nonce uniqueness is enforced across all server instances.
+//
+// To avoid a dependency on a Redis client, the package defines the small Store interface. With go-redis,
+// it is implemented by a thin wrapper around redis.Client:
+//
+//	func (s myStore) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+//		return s.rdb.SetNX(ctx, key, 1, ttl).Result()
+//	}
+package redisreplay
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Store is the subset of the Redis API used by this package.
+type Store interface {
+	// SetNX sets the key with the given expiration if it does not exist yet, like the Redis command
+	// SET key value NX PX ttl. Returns true if the key was set.
+	SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// Cache is a httpsign.ReplayCache that records nonces in a Store.
+type Cache struct {
+	store   Store
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+	onError func(err error)
+	now     func() time.Time
+}
+
+// New returns a Cache whose keys start with prefix, e.g. "httpsign:nonce:", and that remembers nonces for a TTL
+// after their signature's creation time. The TTL should be at least the verifier's NotOlderThan window.
+func New(store Store, prefix string, ttl time.Duration) *Cache {
+	return &Cache{store: store, prefix: prefix, ttl: ttl, timeout: time.Second, now: time.Now}
+}
+
+// SetTimeout sets the timeout of each store operation. Default: one second.
+func (c *Cache) SetTimeout(d time.Duration) *Cache {
+	c.timeout = d
+	return c
+}
+
+// SetOnError sets a callback for store errors, e.g. for logging. Default: nil.
+func (c *Cache) SetOnError(f func(err error)) *Cache {
+	c.onError = f
+	return c
+}
+
+// Seen implements httpsign.ReplayCache. If the store fails, the nonce is considered seen, so that
+// verification fails closed.
+func (c *Cache) Seen(keyID, nonce string, created time.Time) bool {
+	now := c.now()
+	if created.IsZero() || created.After(now) {
+		created = now
+	}
+	ttl := created.Add(c.ttl).Sub(now)
+	if ttl <= 0 { // too old to be fresh, no need to record it
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	set, err := c.store.SetNX(ctx, c.key(keyID, nonce), ttl)
+	if err != nil {
+		if c.onError != nil {
+			c.onError(err)
+		}
+		return true
+	}
+	return !set
+}
+
+// key combines the key ID and nonce unambiguously, since both are arbitrary strings
+func (c *Cache) key(keyID, nonce string) string {
+	return c.prefix + strconv.Itoa(len(keyID)) + ":" + keyID + ":" + nonce
+}
@@ -0,0 +1,71 @@
+package redisreplay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore behaves like Redis SET NX PX
+type fakeStore struct {
+	mu   sync.Mutex
+	keys map[string]time.Duration
+	err  error
+}
+
+func (s *fakeStore) SetNX(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, found := s.keys[key]; found {
+		return false, nil
+	}
+	s.keys[key] = ttl
+	return true, nil
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := &fakeStore{keys: map[string]time.Duration{}}
+	replica1, replica2 := New(store, "nonce:", time.Minute), New(store, "nonce:", time.Minute)
+	replica1.now = func() time.Time { return now }
+	replica2.now = replica1.now
+
+	assert.False(t, replica1.Seen("k1", "n1", now.Add(-10*time.Second)))
+	assert.Equal(t, 50*time.Second, store.keys["nonce:2:k1:n1"], "TTL counts from the creation time")
+	assert.True(t, replica2.Seen("k1", "n1", now), "seen by another replica")
+	assert.False(t, replica2.Seen("k1:n1", "", now), "keys are unambiguous")
+	assert.False(t, replica1.Seen("k2", "n1", time.Time{}))
+	assert.Equal(t, time.Minute, store.keys["nonce:2:k2:n1"])
+	assert.False(t, replica1.Seen("k1", "old", now.Add(-time.Hour)), "too old to be recorded")
+
+	var gotErr error
+	store.err = errors.New("connection refused")
+	replica1.SetOnError(func(err error) { gotErr = err })
+	assert.True(t, replica1.Seen("k1", "n2", now), "fails closed")
+	assert.Equal(t, store.err, gotErr)
+}
+
+func TestVerify(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	cache := New(&fakeStore{keys: map[string]time.Duration{}}, "nonce:", time.Minute)
+	verifier, _ := httpsign.NewHMACSHA256Verifier("key1", key, httpsign.NewVerifyConfig().SetReplayCache(cache),
+		httpsign.Headers("@method"))
+	signer, _ := httpsign.NewHMACSHA256Signer("key1", key, httpsign.NewSignConfig().SetNonce("abc"), httpsign.Headers("@method"))
+	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+	sigInput, sig, _ := httpsign.SignRequest("sig1", *signer, req)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	assert.NoError(t, httpsign.VerifyRequest("sig1", *verifier, req))
+	var replay *httpsign.ReplayError
+	assert.True(t, errors.As(httpsign.VerifyRequest("sig1", *verifier, req), &replay))
+}
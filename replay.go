@@ -0,0 +1,101 @@
+package httpsign
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// ReplayCache records the nonces of verified signatures, so that a signature cannot be replayed within
+// the freshness window, see VerifyConfig.SetReplayCache. Implementations must be safe for concurrent use.
+type ReplayCache interface {
+	// Seen records the nonce of a signature by the given key, and returns true if it was already recorded.
+	// Created is the time of the "created" parameter, or the zero time if the signature has none.
+	Seen(keyID, nonce string, created time.Time) bool
+}
+
+// MemoryReplayCache is an in-memory ReplayCache, for a single server instance. Nonces are remembered for a TTL
+// after their signature's creation time. The cache is bounded: when it is full, the nonces closest to expiry
+// are forgotten first.
+type MemoryReplayCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[replayKey]*replayEntry
+	byExpiry   replayHeap
+	now        func() time.Time
+}
+
+type replayKey struct {
+	keyID, nonce string
+}
+
+type replayEntry struct {
+	key    replayKey
+	expiry time.Time
+	index  int
+}
+
+// NewMemoryReplayCache returns an in-memory replay cache that holds up to maxEntries nonces. The TTL should be
+// at least the verifier's NotOlderThan window, so that nonces are remembered for as long as their signature is fresh.
+func NewMemoryReplayCache(ttl time.Duration, maxEntries int) *MemoryReplayCache {
+	return &MemoryReplayCache{ttl: ttl, maxEntries: maxEntries, entries: map[replayKey]*replayEntry{}, now: time.Now}
+}
+
+// Seen implements ReplayCache.
+func (c *MemoryReplayCache) Seen(keyID, nonce string, created time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for len(c.byExpiry) > 0 && !c.byExpiry[0].expiry.After(now) {
+		e := heap.Pop(&c.byExpiry).(*replayEntry)
+		delete(c.entries, e.key)
+	}
+	key := replayKey{keyID: keyID, nonce: nonce}
+	if _, found := c.entries[key]; found {
+		return true
+	}
+	if created.IsZero() || created.After(now) {
+		created = now
+	}
+	for c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		e := heap.Pop(&c.byExpiry).(*replayEntry)
+		delete(c.entries, e.key)
+	}
+	e := &replayEntry{key: key, expiry: created.Add(c.ttl)}
+	c.entries[key] = e
+	heap.Push(&c.byExpiry, e)
+	return false
+}
+
+// Len returns the number of nonces currently held.
+func (c *MemoryReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// replayHeap orders entries by expiry, implementing heap.Interface
+type replayHeap []*replayEntry
+
+func (h replayHeap) Len() int           { return len(h) }
+func (h replayHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+func (h replayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *replayHeap) Push(x interface{}) {
+	e := x.(*replayEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,64 @@
+package httpsign
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewMemoryReplayCache(10*time.Second, 3)
+	c.now = func() time.Time { return now }
+
+	assert.False(t, c.Seen("k1", "n1", now))
+	assert.True(t, c.Seen("k1", "n1", now), "replay")
+	assert.False(t, c.Seen("k2", "n1", now), "nonces are per key")
+	assert.False(t, c.Seen("k1", "n2", now.Add(-2*time.Second)))
+	assert.Equal(t, 3, c.Len())
+
+	assert.False(t, c.Seen("k1", "n3", time.Time{}), "evicts the entry closest to expiry")
+	assert.Equal(t, 3, c.Len())
+	assert.True(t, c.Seen("k1", "n1", now), "not evicted")
+	assert.False(t, c.Seen("k1", "n2", now), "evicted")
+
+	now = now.Add(10 * time.Second)
+	assert.False(t, c.Seen("k1", "n1", now), "expired")
+	assert.Equal(t, 1, c.Len(), "expired entries are removed")
+}
+
+func TestVerifyReplayCache(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	cache := NewMemoryReplayCache(time.Minute, 100)
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetReplayCache(cache), Headers("@method"))
+	sign := func(nonce string) *http.Request {
+		signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SetNonce(nonce), Headers("@method"))
+		req, _ := http.NewRequest("GET", "https://example.com/", nil)
+		sigInput, sig, _ := SignRequest("sig1", *signer, req)
+		req.Header.Add("Signature-Input", sigInput)
+		req.Header.Add("Signature", sig)
+		return req
+	}
+
+	req := sign("abc")
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	err := VerifyRequest("sig1", *verifier, req)
+	var replay *ReplayError
+	if assert.True(t, errors.As(err, &replay), "replayed request") {
+		assert.Equal(t, "key1", replay.KeyID)
+		assert.Equal(t, "abc", replay.Nonce)
+	}
+	assert.NoError(t, VerifyRequest("sig1", *verifier, sign("def")), "new nonce")
+
+	forged := sign("ghi")
+	forged.Header.Set("Signature", "sig1=:AAAA:")
+	assert.Error(t, VerifyRequest("sig1", *verifier, forged))
+	assert.NoError(t, VerifyRequest("sig1", *verifier, sign("ghi")), "a forged signature does not consume the nonce")
+
+	req = sign("")
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req), "no nonce, no replay check")
+}
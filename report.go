@@ -0,0 +1,81 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VerificationCheck is a single check performed while verifying a signature, and its outcome.
+// Name is one of "covered-fields", "require-created", "created", "alg", "key-size", "require-expires", "expires",
+// "keyid", "tag", "nonce", "minimum-coverage", "status", "range", "signature", "digest" and "replay", or "field "
+// followed by a covered component, e.g. "field content-type".
+type VerificationCheck struct {
+	Name   string
+	Passed bool
+	Err    error // nil if the check passed
+}
+
+// VerificationReport lists the checks performed while verifying a signature, in the order they were performed.
+// Policy checks are only listed if they are enabled by the VerifyConfig. Verification stops at the first
+// failed check, so checks that would have followed it are not listed.
+type VerificationReport struct {
+	Label  string
+	Checks []VerificationCheck
+}
+
+// Passed returns true if all the listed checks passed.
+func (r *VerificationReport) Passed() bool {
+	for _, c := range r.Checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a one-line summary of the report, suitable for an audit log.
+func (r *VerificationReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "signature \"%s\":", r.Label)
+	for _, c := range r.Checks {
+		if c.Passed {
+			fmt.Fprintf(&b, " %s=ok", c.Name)
+		} else {
+			fmt.Fprintf(&b, " %s=failed (%v)", c.Name, c.Err)
+		}
+	}
+	return b.String()
+}
+
+// add records the outcome of a check, and returns its error. It is a no-op on a nil report.
+func (r *VerificationReport) add(name string, err error) error {
+	if r != nil {
+		r.Checks = append(r.Checks, VerificationCheck{Name: name, Passed: err == nil, Err: err})
+	}
+	return err
+}
+
+// VerifyRequestWithReport verifies a signed HTTP request like VerifyRequest, and also returns a report
+// of the checks performed. The report is returned even if verification fails.
+func VerifyRequestWithReport(signatureName string, verifier Verifier, req *http.Request) (*VerificationReport, error) {
+	report := &VerificationReport{Label: signatureName}
+	_, err := verifyRequestInternal(signatureName, verifier.withReport(report), req, false)
+	return report, err
+}
+
+// VerifyResponseWithReport verifies a signed HTTP response like VerifyResponse, and also returns a report
+// of the checks performed.
+func VerifyResponseWithReport(signatureName string, verifier Verifier, res *http.Response) (*VerificationReport, error) {
+	report := &VerificationReport{Label: signatureName}
+	err := verifyResponse(signatureName, verifier.withReport(report), res)
+	return report, err
+}
+
+// withReport returns a copy of the verifier that records its checks into report
+func (v Verifier) withReport(report *VerificationReport) Verifier {
+	config := *v.config
+	config.report = report
+	v.config = &config
+	return v
+}
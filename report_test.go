@@ -0,0 +1,72 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func checkNames(report *VerificationReport) []string {
+	var names []string
+	for _, c := range report.Checks {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestVerifyRequestWithReport(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), Headers("@method", "content-type"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetAllowedAlgs([]string{"hmac-sha256"}), Headers("@method"))
+	report, err := VerifyRequestWithReport("sig1", *verifier, req)
+	assert.NoError(t, err)
+	assert.True(t, report.Passed())
+	assert.Equal(t, "sig1", report.Label)
+	names := checkNames(report)
+	assert.Contains(t, names, "covered-fields")
+	assert.Contains(t, names, "created")
+	assert.Contains(t, names, "alg")
+	assert.Contains(t, names, "field @method")
+	assert.Contains(t, names, "field content-type")
+	assert.Equal(t, "signature", names[len(names)-1])
+	assert.NotContains(t, names, "digest", "digest is not covered")
+
+	badVerifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{8}, 64), NewVerifyConfig(), Headers("@method"))
+	report, err = VerifyRequestWithReport("sig1", *badVerifier, req)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.False(t, report.Passed())
+	last := report.Checks[len(report.Checks)-1]
+	assert.Equal(t, "signature", last.Name)
+	assert.ErrorIs(t, last.Err, ErrBadSignature)
+	assert.True(t, strings.Contains(report.String(), "signature=failed"), report.String())
+
+	report, err = VerifyRequestWithReport("sig1", *verifier, readRequest(httpreq1))
+	assert.Error(t, err, "unsigned request")
+	assert.Empty(t, report.Checks)
+}
+
+func TestVerifyResponseWithReport(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	res := readResponse(httpres1)
+	digest, err := ContentDigest(DigestSHA256, []byte(`{"hello": "world"}`))
+	assert.NoError(t, err)
+	res.Header.Set("Content-Digest", digest)
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), Headers("@status", "content-digest"))
+	sigInput, sig, err := SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), Headers("@status"))
+	report, err := VerifyResponseWithReport("sig1", *verifier, res)
+	assert.NoError(t, err)
+	assert.True(t, report.Passed())
+	assert.Equal(t, "digest", report.Checks[len(report.Checks)-1].Name)
+}
@@ -0,0 +1,214 @@
+package httpsign
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// The Signature-Input and Signature headers are parsed on every verification. The general path parses
+// each header with httpsfv, re-serializes the member, and parses it again. The functions here parse
+// the headers in place instead, for the common case where the member is in canonical form, i.e.
+// exactly as httpsfv would serialize it. For any other input they return ok=false, and the caller
+// takes the general path, so that the result is always the same.
+
+// fastDictionaryMember returns the raw value of the member key of a strictly valid dictionary header.
+// As with httpsfv, the last member wins if the key is repeated.
+func fastDictionaryMember(values []string, key string) (value string, ok bool) {
+	if len(values) == 0 {
+		return "", false
+	}
+	v := sfValidator{s: strings.Join(values, ",")}
+	err := v.dictionary(func(k, val string) {
+		if k == key {
+			value, ok = val, true
+		}
+	})
+	return value, ok && err == nil
+}
+
+// fastSignatureInput is the fast path for parsing the Signature-Input member with the given label.
+func (message *parsedMessage) fastSignatureInput(name string) (*psiSignature, bool) {
+	raw, ok := fastDictionaryMember(message.headers["signature-input"], name)
+	if !ok {
+		return nil, false
+	}
+	return parseCanonicalSignatureInput(raw, name)
+}
+
+// fastSignature is the fast path for decoding the Signature member with the given label.
+// Only a byte sequence without parameters is accepted.
+func (message *parsedMessage) fastSignature(name string) ([]byte, bool) {
+	raw, ok := fastDictionaryMember(message.headers["signature"], name)
+	if !ok || len(raw) < 2 || raw[0] != ':' || strings.IndexByte(raw[1:], ':') != len(raw)-2 {
+		return nil, false
+	}
+	sig, err := base64.StdEncoding.DecodeString(raw[1 : len(raw)-1])
+	return sig, err == nil
+}
+
+// fastSignatures is the fast path for parsing all the members of Signature-Input, in order.
+func (message *parsedMessage) fastSignatures() ([]*psiSignature, bool) {
+	values := message.headers["signature-input"]
+	if len(values) == 0 {
+		return nil, false
+	}
+	var psis []*psiSignature
+	ok := true
+	v := sfValidator{s: strings.Join(values, ",")}
+	err := v.dictionary(func(k, val string) {
+		if !ok {
+			return
+		}
+		for _, psi := range psis {
+			if psi.signatureName == k { // repeated labels are left to httpsfv
+				ok = false
+			}
+		}
+		psi, parsed := parseCanonicalSignatureInput(val, k)
+		ok = ok && parsed
+		psis = append(psis, psi)
+	})
+	if err != nil || !ok {
+		return nil, false
+	}
+	return psis, true
+}
+
+// parseCanonicalSignatureInput parses a strictly valid Signature-Input member, which is its own serialization
+// if it is in canonical form. Components must be lowercase strings with the parameters that parseSignatureInput
+// accepts, and signature parameters must be strings, integers or true.
+func parseCanonicalSignatureInput(raw, sigName string) (*psiSignature, bool) {
+	s := sfScanner{s: raw}
+	if !s.consume('(') {
+		return nil, false
+	}
+	var fields []field
+	if s.peek() != ')' {
+		fields = make([]field, 0, strings.Count(raw, " ")+1) // at least the number of components
+	}
+	for !s.consume(')') {
+		if len(fields) > 0 && !s.consume(' ') {
+			return nil, false
+		}
+		name, ok := s.str()
+		if !ok || strings.ToLower(name) != name {
+			return nil, false
+		}
+		f := field{name: name}
+		for s.consume(';') {
+			k, ok := s.key()
+			switch {
+			case !ok:
+				return nil, false
+			case s.consume('='):
+				fv, ok := s.str()
+				if !ok || f.flagName != "" || k == "req" || k == "tr" || isBooleanFlag(k) {
+					return nil, false
+				}
+				f.flagName, f.flagValue = k, fv
+			case k == "req" && !f.req:
+				f.req = true
+			case k == "tr" && !f.tr:
+				f.tr = true
+			case isBooleanFlag(k) && f.flagName == "":
+				f.flagName = k
+			default:
+				return nil, false
+			}
+		}
+		fields = append(fields, f)
+	}
+	params := map[string]interface{}{}
+	for s.consume(';') {
+		k, ok := s.key()
+		if _, found := params[k]; found || !ok {
+			return nil, false
+		}
+		if !s.consume('=') {
+			params[k] = true
+			continue
+		}
+		if c := s.peek(); c == '"' {
+			pv, ok := s.str()
+			if !ok {
+				return nil, false
+			}
+			params[k] = pv
+		} else {
+			pv, ok := s.integer()
+			if !ok {
+				return nil, false
+			}
+			params[k] = pv
+		}
+	}
+	if !s.eof() {
+		return nil, false
+	}
+	return &psiSignature{signatureName: sigName, origSigParams: raw, fields: Fields{f: fields}, params: params}, true
+}
+
+// sfScanner reads the canonical form of structured field values that were already validated
+type sfScanner struct {
+	s   string
+	pos int
+}
+
+func (s *sfScanner) eof() bool {
+	return s.pos >= len(s.s)
+}
+
+func (s *sfScanner) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.s[s.pos]
+}
+
+func (s *sfScanner) consume(c byte) bool {
+	if s.peek() != c {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+// key returns a key that immediately follows, without the whitespace that parameters may start with
+func (s *sfScanner) key() (string, bool) {
+	start := s.pos
+	for !s.eof() && isKeyChar(s.s[s.pos]) {
+		s.pos++
+	}
+	return s.s[start:s.pos], s.pos > start
+}
+
+// str returns a string without escapes, which would have to be copied to be unescaped
+func (s *sfScanner) str() (string, bool) {
+	if !s.consume('"') {
+		return "", false
+	}
+	end := strings.IndexByte(s.s[s.pos:], '"')
+	if end < 0 || strings.IndexByte(s.s[s.pos:s.pos+end], '\\') >= 0 {
+		return "", false
+	}
+	v := s.s[s.pos : s.pos+end]
+	s.pos += end + 1
+	return v, true
+}
+
+// integer returns an integer without leading zeros, and excludes decimals
+func (s *sfScanner) integer() (int64, bool) {
+	start := s.pos
+	s.consume('-')
+	digits := s.pos
+	for !s.eof() && isDigit(s.s[s.pos]) {
+		s.pos++
+	}
+	n := s.s[digits:s.pos]
+	if n == "" || (n[0] == '0' && (len(n) > 1 || digits > start)) || s.peek() == '.' {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(s.s[start:s.pos], 10, 64)
+	return i, err == nil
+}
@@ -0,0 +1,113 @@
+package httpsign
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestFastSignatureInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []string
+		fast   bool // whether the fast path applies
+	}{
+		{"canonical", []string{`sig1=("@method" "@path" "content-digest");created=1618884475;keyid="test-key-rsa-pss"`}, true},
+		{"empty list", []string{`sig1=();created=1618884475`}, true},
+		{"component params", []string{`sig1=("@query-param";name="Pet" "example-dict";key="a" "x";sf "y";bs;req;tr);alg="ed25519"`}, true},
+		{"flag", []string{`sig1=();created=1;flag`}, true},
+		{"negative", []string{`sig1=();created=-5`}, true},
+		{"other members", []string{`sig0=("a"), sig1=("b")`, `sig2=();alg="x"`}, true},
+		{"repeated label", []string{`sig1=("a"), sig1=("b")`}, true},
+		{"extra space", []string{`sig1=( "a")`}, false},
+		{"double space", []string{`sig1=("a"  "b")`}, false},
+		{"leading zero", []string{`sig1=();created=01618884475`}, false},
+		{"decimal", []string{`sig1=();created=1.5`}, false},
+		{"explicit true", []string{`sig1=("a";req=?1)`}, false},
+		{"token", []string{`sig1=();alg=ed25519`}, false},
+		{"escape", []string{`sig1=();keyid="a\"b"`}, false},
+		{"uppercase component", []string{`sig1=("Content-Type")`}, false},
+		{"two flags", []string{`sig1=("a";sf;bs)`}, false},
+		{"not a list", []string{`sig1="a"`}, false},
+		{"invalid dictionary", []string{`sig1=("a"), `}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := parsedMessage{headers: http.Header{"signature-input": tt.header}}
+			fast, ok := message.fastSignatureInput("sig1")
+			assert.Equal(t, tt.fast, ok)
+			if !ok {
+				return
+			}
+			wsi, err := message.getDictHeader("signature-input", "sig1")
+			assert.NoError(t, err)
+			slow, err := parseSignatureInput(wsi[0], "sig1")
+			assert.NoError(t, err)
+			assert.Equal(t, slow, fast, "fast and general paths should agree")
+		})
+	}
+}
+
+func TestFastSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		fast   bool
+	}{
+		{"byte sequence", `sig0=:AAAA:, sig1=:dGVzdA==:`, true},
+		{"params", `sig1=:dGVzdA==:;x=1`, false},
+		{"not a byte sequence", `sig1="dGVzdA=="`, false},
+		{"bad padding", `sig1=:dGVzdA:`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := parsedMessage{headers: http.Header{"signature": {tt.header}}}
+			sig, ok := message.fastSignature("sig1")
+			assert.Equal(t, tt.fast, ok)
+			if ok {
+				assert.Equal(t, []byte("test"), sig)
+			}
+		})
+	}
+}
+
+func TestFastSignatures(t *testing.T) {
+	req := readRequest(httpreq1)
+	req.Header.Set("Signature-Input", `sig1=("@method");created=1618884475;keyid="k1", sig2=("@path" "content-type";req);tag="app"`)
+	message, err := parseRequest(req, nil)
+	assert.NoError(t, err)
+	psis, ok := message.fastSignatures()
+	assert.True(t, ok)
+	assert.Len(t, psis, 2)
+	details, err := RequestSignatures(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []SignatureDetails{
+		{Label: "sig1", KeyID: "k1", Created: 1618884475, Fields: Headers("@method")},
+		{Label: "sig2", Tag: "app", Fields: Fields{f: []field{{name: "@path"}, {name: "content-type", req: true}}}},
+	}, details)
+
+	message.headers["signature-input"] = []string{`sig1=("@method"), sig1=("@path")`}
+	_, ok = message.fastSignatures()
+	assert.False(t, ok, "repeated labels are left to the general path")
+}
+
+var benchSignatureHeaders = http.Header{
+	"signature-input": {`sig1=("@method" "@authority" "@path" "content-digest" "content-type" "content-length");created=1618884475;keyid="test-key-ecc-p256"`},
+	"signature":       {`sig1=:X5spyd6CFnAG5QnDyHfqoSNICd+BUP4LYMz2Q0JXlb//4Ijpzp+kve2w4NIyqeAuM7jTDX+sNalzA8ESSaHD3A==:`},
+}
+
+func BenchmarkLookupSignature(b *testing.B) {
+	message := parsedMessage{headers: benchSignatureHeaders}
+	b.Run("httpsfv", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = message.lookupSignatureSFV("sig1")
+		}
+	})
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = message.lookupSignature("sig1")
+		}
+	})
+}
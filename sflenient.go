@@ -0,0 +1,110 @@
+package httpsign
+
+import (
+	"strings"
+)
+
+// tolerateDictionary rewrites the (combined) values of a dictionary header, repairing common
+// peer bugs: extra whitespace, uppercase member keys (signature labels) and unpadded base64
+// in byte sequences. It returns the repaired value and a description of each repair, so that
+// the caller can log what was tolerated. Input that is valid to begin with is returned unchanged.
+func tolerateDictionary(values []string) (string, []string) {
+	s := strings.Join(values, ",")
+	var b strings.Builder
+	var notes []string
+	noted := map[string]bool{}
+	note := func(n string) {
+		if !noted[n] {
+			noted[n] = true
+			notes = append(notes, n)
+		}
+	}
+	depth := 0
+	atKey := true // at the start of a dictionary member key
+	last := func() byte {
+		out := b.String()
+		if len(out) == 0 {
+			return 0
+		}
+		return out[len(out)-1]
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+			atKey = false
+		case c == ' ' || c == '\t':
+			j := i
+			for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+				j++
+			}
+			prev, next := last(), byte(0)
+			if j < len(s) {
+				next = s[j]
+			}
+			switch {
+			case depth > 0 && strings.IndexByte("(=;", prev) < 0 && strings.IndexByte(")=;", next) < 0:
+				// a single space is meaningful between two items of an inner list
+				b.WriteByte(' ')
+				if s[i:j] != " " {
+					note("extra whitespace")
+				}
+			case prev == 0 || prev == ' ' || next == 0 || prev == '(' || next == ')':
+				// allowed by the grammar, dropped silently
+			default:
+				note("extra whitespace")
+			}
+			i = j
+		case c == ':' && strings.IndexByte("=( ", last()) >= 0:
+			j := strings.IndexByte(s[i+1:], ':')
+			if j < 0 {
+				b.WriteString(s[i:])
+				i = len(s)
+				break
+			}
+			b64 := s[i+1 : i+1+j]
+			if pad := len(b64) % 4; pad != 0 && !strings.HasSuffix(b64, "=") {
+				b64 += strings.Repeat("=", 4-pad)
+				note("unpadded base64")
+			}
+			b.WriteString(":" + b64 + ":")
+			i += j + 2
+			atKey = false
+		default:
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					b.WriteString(", ")
+					i++
+					atKey = true
+					continue
+				}
+			case '=', ';':
+				atKey = false
+			}
+			if atKey && c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+				note("uppercase label")
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), notes
+}
@@ -0,0 +1,75 @@
+package httpsign
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_tolerateDictionary(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []string
+		want      string
+		wantNotes []string
+	}{
+		{
+			name:      "valid input",
+			values:    []string{`sig1=("@method" "content-type";key="a");created=1618884473;keyid="x y"`},
+			want:      `sig1=("@method" "content-type";key="a");created=1618884473;keyid="x y"`,
+			wantNotes: nil,
+		},
+		{
+			name:      "extra whitespace",
+			values:    []string{`sig1 = ( "@method"   "@path" ) ; created = 1`},
+			want:      `sig1=("@method" "@path");created=1`,
+			wantNotes: []string{"extra whitespace"},
+		},
+		{
+			name:      "uppercase label",
+			values:    []string{`Sig1=:dGVzdA==:`, `SIG2=:dGVzdA==:`},
+			want:      `sig1=:dGVzdA==:, sig2=:dGVzdA==:`,
+			wantNotes: []string{"uppercase label"},
+		},
+		{
+			name:      "unpadded base64",
+			values:    []string{`sig1=:dGVzdA:`},
+			want:      `sig1=:dGVzdA==:`,
+			wantNotes: []string{"unpadded base64"},
+		},
+		{
+			name:      "tokens with colons are untouched",
+			values:    []string{`a=tok:en`},
+			want:      `a=tok:en`,
+			wantNotes: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotNotes := tolerateDictionary(tt.values)
+			if got != tt.want {
+				t.Errorf("tolerateDictionary() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotNotes, tt.wantNotes) {
+				t.Errorf("tolerateDictionary() gotNotes = %v, want %v", gotNotes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestLenientParsing(t *testing.T) {
+	mangle := func() string {
+		s := strings.Replace(httpreq1pssMinimal, `Signature-Input: sig-b21=();created=1618884473;`,
+			`Signature-Input: SIG-B21 = () ; created=1618884473;`, 1)
+		return strings.Replace(s, `98Xw9Q==:`, `98Xw9Q:`, 1)
+	}
+	verifier := makeRSAVerifier(t, "test-key-rsa-pss", *NewFields())
+	verifier.config = NewVerifyConfig().SetVerifyCreated(false)
+	if err := VerifyRequest("sig-b21", verifier, readRequest(mangle())); err == nil {
+		t.Errorf("default parsing accepted a malformed request")
+	}
+	verifier.config = NewVerifyConfig().SetVerifyCreated(false).SetParsingMode(ParseLenient)
+	if err := VerifyRequest("sig-b21", verifier, readRequest(mangle())); err != nil {
+		t.Errorf("lenient parsing rejected a repairable request: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package httpsign
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// The httpsfv parser is reasonably permissive and does not report where a header went wrong.
+// This is a small, validate-only parser that follows the RFC 8941 grammar exactly, for use
+// in the strict parsing mode. It is intentionally separate from the normal parsing path:
+// once a header is validated here, it is parsed as usual by httpsfv.
+
+var strictBase64 = base64.StdEncoding.Strict()
+
+type sfValidator struct {
+	header string
+	s      string
+	pos    int
+}
+
+func (v *sfValidator) fail(format string, args ...interface{}) error {
+	return &SFParseError{Header: v.header, Offset: v.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// validateStrictDictionary checks that the (combined) header values form a valid RFC 8941 Dictionary.
+func validateStrictDictionary(header string, values []string) error {
+	v := sfValidator{header: header, s: strings.Join(values, ",")}
+	return v.dictionary(nil)
+}
+
+// dictionary validates a Dictionary. If visit is not nil, it is called with the key and the raw value of each member,
+// where the value of a member with an implicit true value consists of its parameters, if any.
+// The visited members are only valid if no error is returned.
+func (v *sfValidator) dictionary(visit func(key, value string)) error {
+	v.skipSP()
+	if v.eof() {
+		return v.fail("empty dictionary")
+	}
+	for {
+		start := v.pos
+		if err := v.key(); err != nil {
+			return err
+		}
+		key := v.s[start:v.pos]
+		if v.peek() == '=' {
+			v.pos++
+			start = v.pos
+			if err := v.memberValue(); err != nil {
+				return err
+			}
+		} else {
+			start = v.pos
+			if err := v.parameters(); err != nil {
+				return err
+			}
+		}
+		if visit != nil {
+			visit(key, v.s[start:v.pos])
+		}
+		v.skipOWS()
+		if v.eof() {
+			return nil
+		}
+		if v.peek() != ',' {
+			return v.fail("expected \",\" after dictionary member")
+		}
+		v.pos++
+		v.skipOWS()
+		if v.eof() {
+			return v.fail("trailing \",\" in dictionary")
+		}
+	}
+}
+
+func (v *sfValidator) eof() bool {
+	return v.pos >= len(v.s)
+}
+
+func (v *sfValidator) peek() byte {
+	if v.eof() {
+		return 0
+	}
+	return v.s[v.pos]
+}
+
+func (v *sfValidator) skipSP() {
+	for !v.eof() && v.s[v.pos] == ' ' {
+		v.pos++
+	}
+}
+
+func (v *sfValidator) skipOWS() {
+	for !v.eof() && (v.s[v.pos] == ' ' || v.s[v.pos] == '\t') {
+		v.pos++
+	}
+}
+
+func isLCAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func (v *sfValidator) key() error {
+	c := v.peek()
+	if !isLCAlpha(c) && c != '*' {
+		return v.fail("key must start with a lowercase letter or \"*\"")
+	}
+	v.pos++
+	for !v.eof() && isKeyChar(v.peek()) {
+		v.pos++
+	}
+	return nil
+}
+
+func (v *sfValidator) memberValue() error {
+	if v.peek() == '(' {
+		return v.innerList()
+	}
+	return v.item()
+}
+
+func (v *sfValidator) innerList() error {
+	v.pos++ // "("
+	for {
+		v.skipSP()
+		if v.eof() {
+			return v.fail("unterminated inner list")
+		}
+		if v.peek() == ')' {
+			v.pos++
+			return v.parameters()
+		}
+		if err := v.item(); err != nil {
+			return err
+		}
+		if c := v.peek(); c != ' ' && c != ')' {
+			return v.fail("expected space or \")\" in inner list")
+		}
+	}
+}
+
+func (v *sfValidator) item() error {
+	if err := v.bareItem(); err != nil {
+		return err
+	}
+	return v.parameters()
+}
+
+func (v *sfValidator) parameters() error {
+	for v.peek() == ';' {
+		v.pos++
+		v.skipSP()
+		if err := v.key(); err != nil {
+			return err
+		}
+		if v.peek() == '=' {
+			v.pos++
+			if err := v.bareItem(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *sfValidator) bareItem() error {
+	c := v.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return v.number()
+	case c == '"':
+		return v.str()
+	case c == ':':
+		return v.byteSequence()
+	case c == '?':
+		return v.boolean()
+	case isAlpha(c) || c == '*':
+		return v.token()
+	default:
+		return v.fail("unexpected character %q at start of item", c)
+	}
+}
+
+func (v *sfValidator) number() error {
+	if v.peek() == '-' {
+		v.pos++
+	}
+	start := v.pos
+	for !v.eof() && isDigit(v.peek()) {
+		v.pos++
+	}
+	intDigits := v.pos - start
+	if intDigits == 0 {
+		return v.fail("expected a digit")
+	}
+	if v.peek() != '.' {
+		if intDigits > 15 {
+			return v.fail("integer has more than 15 digits")
+		}
+		return nil
+	}
+	if intDigits > 12 {
+		return v.fail("decimal has more than 12 integer digits")
+	}
+	v.pos++
+	start = v.pos
+	for !v.eof() && isDigit(v.peek()) {
+		v.pos++
+	}
+	fracDigits := v.pos - start
+	if fracDigits == 0 || fracDigits > 3 {
+		return v.fail("decimal must have between 1 and 3 fractional digits")
+	}
+	return nil
+}
+
+func (v *sfValidator) str() error {
+	v.pos++ // opening quote
+	for !v.eof() {
+		c := v.s[v.pos]
+		switch {
+		case c == '\\':
+			v.pos++
+			if n := v.peek(); n != '"' && n != '\\' {
+				return v.fail("invalid escape in string")
+			}
+			v.pos++
+		case c == '"':
+			v.pos++
+			return nil
+		case c < 0x20 || c > 0x7e:
+			return v.fail("invalid character in string")
+		default:
+			v.pos++
+		}
+	}
+	return v.fail("unterminated string")
+}
+
+func (v *sfValidator) token() error {
+	v.pos++ // first character already checked
+	for !v.eof() && (isTChar(v.peek()) || v.peek() == ':' || v.peek() == '/') {
+		v.pos++
+	}
+	return nil
+}
+
+func (v *sfValidator) byteSequence() error {
+	v.pos++ // opening colon
+	start := v.pos
+	end := strings.IndexByte(v.s[start:], ':')
+	if end < 0 {
+		return v.fail("unterminated byte sequence")
+	}
+	b64 := v.s[start : start+end]
+	for i := 0; i < len(b64); i++ {
+		c := b64[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			v.pos = start + i
+			return v.fail("invalid base64 character %q in byte sequence", c)
+		}
+	}
+	if len(b64)%4 != 0 {
+		return v.fail("byte sequence is not padded to a multiple of 4 characters")
+	}
+	var buf [512]byte // large enough for most signatures, so that validation does not allocate
+	dst := buf[:]
+	if n := strictBase64.DecodedLen(len(b64)); n > len(buf) {
+		dst = make([]byte, n)
+	}
+	if _, err := strictBase64.Decode(dst, []byte(b64)); err != nil {
+		return v.fail("malformed base64 in byte sequence: %v", err)
+	}
+	v.pos = start + end + 1
+	return nil
+}
+
+func (v *sfValidator) boolean() error {
+	v.pos++ // "?"
+	if c := v.peek(); c != '0' && c != '1' {
+		return v.fail("boolean must be ?0 or ?1")
+	}
+	v.pos++
+	return nil
+}
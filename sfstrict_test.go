@@ -0,0 +1,107 @@
+package httpsign
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_validateStrictDictionary(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     []string
+		wantErr    bool
+		wantOffset int
+	}{
+		{
+			name:    "signature input",
+			values:  []string{`sig1=("@method" "content-type";key="a");created=1618884473;keyid="test-key-rsa-pss"`},
+			wantErr: false,
+		},
+		{
+			name:    "multiple lines",
+			values:  []string{`sig1=:dGVzdA==:`, ` proxy_sig=:YWJjZA==:`},
+			wantErr: false,
+		},
+		{
+			name:    "decimal and boolean",
+			values:  []string{`a=1.5, b=?0, c, d=tok/en:1`},
+			wantErr: false,
+		},
+		{
+			name:       "uppercase label",
+			values:     []string{`Sig1=:dGVzdA==:`},
+			wantErr:    true,
+			wantOffset: 0,
+		},
+		{
+			name:       "unpadded base64",
+			values:     []string{`sig1=:dGVzdA:`},
+			wantErr:    true,
+			wantOffset: 6,
+		},
+		{
+			name:       "integer too long",
+			values:     []string{`sig1=();created=1234567890123456`},
+			wantErr:    true,
+			wantOffset: 32,
+		},
+		{
+			name:       "space before equals",
+			values:     []string{`sig1 =:dGVzdA==:`},
+			wantErr:    true,
+			wantOffset: 5,
+		},
+		{
+			name:       "unterminated string",
+			values:     []string{`sig1=("@method`},
+			wantErr:    true,
+			wantOffset: 14,
+		},
+		{
+			name:       "trailing comma",
+			values:     []string{`sig1=:dGVzdA==:,`},
+			wantErr:    true,
+			wantOffset: 16,
+		},
+		{
+			name:       "bad base64 character",
+			values:     []string{`sig1=:dGV!dA==:`},
+			wantErr:    true,
+			wantOffset: 9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStrictDictionary("signature", tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateStrictDictionary() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				var sfErr *SFParseError
+				if !errors.As(err, &sfErr) {
+					t.Errorf("validateStrictDictionary() error is not an SFParseError: %v", err)
+					return
+				}
+				if sfErr.Offset != tt.wantOffset {
+					t.Errorf("validateStrictDictionary() offset = %d, want %d (%v)", sfErr.Offset, tt.wantOffset, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRequestStrictParsing(t *testing.T) {
+	verifier := makeRSAVerifier(t, "test-key-rsa-pss", *NewFields())
+	verifier.config = NewVerifyConfig().SetVerifyCreated(false).SetParsingMode(ParseStrict)
+	if err := VerifyRequest("sig-b21", verifier, readRequest(httpreq1pssMinimal)); err != nil {
+		t.Errorf("strict parsing rejected a valid request: %v", err)
+	}
+	req := readRequest(httpreq1pssMinimal)
+	req.Header.Add("Signature", "other=:abc:")
+	err := VerifyRequest("sig-b21", verifier, req)
+	var sfErr *SFParseError
+	if !errors.As(err, &sfErr) {
+		t.Errorf("expected a strict parsing error, got %v", err)
+	}
+}
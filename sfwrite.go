@@ -0,0 +1,73 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// The signature base and the Signature-Input header are serialized for every message. Their structured field
+// values are simple enough to be written directly into the signature base buffer, without building httpsfv
+// values first. The output is identical to httpsfv's, including which values are rejected.
+
+// writeSFString writes s as an RFC 8941 String
+func writeSFString(b *bytes.Buffer, s string) error {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("invalid character in string")
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return nil
+}
+
+// writeSFKey writes k as an RFC 8941 Key
+func writeSFKey(b *bytes.Buffer, k string) error {
+	if k == "" || (!isLCAlpha(k[0]) && k[0] != '*') {
+		return fmt.Errorf("invalid key %q", k)
+	}
+	for i := 1; i < len(k); i++ {
+		if !isKeyChar(k[i]) {
+			return fmt.Errorf("invalid key %q", k)
+		}
+	}
+	b.WriteString(k)
+	return nil
+}
+
+// writeSFInteger writes i as an RFC 8941 Integer
+func writeSFInteger(b *bytes.Buffer, i int64) error {
+	const maxInteger = 999_999_999_999_999
+	if i > maxInteger || i < -maxInteger {
+		return fmt.Errorf("integer out of range")
+	}
+	var buf [20]byte
+	b.Write(strconv.AppendInt(buf[:0], i, 10))
+	return nil
+}
+
+// writeSFStringParam writes a parameter with a String value
+func writeSFStringParam(b *bytes.Buffer, k, v string) error {
+	b.WriteByte(';')
+	if err := writeSFKey(b, k); err != nil {
+		return err
+	}
+	b.WriteByte('=')
+	return writeSFString(b, v)
+}
+
+// writeSFIntegerParam writes a parameter with an Integer value
+func writeSFIntegerParam(b *bytes.Buffer, k string, v int64) error {
+	b.WriteByte(';')
+	if err := writeSFKey(b, k); err != nil {
+		return err
+	}
+	b.WriteByte('=')
+	return writeSFInteger(b, v)
+}
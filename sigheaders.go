@@ -0,0 +1,146 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Signatures on a message may themselves be covered by other signatures, e.g. by a proxy that signs
+// "signature";key="sig1". The functions here add and remove signatures without re-serializing the
+// signatures that are left untouched, so that such covering signatures remain valid.
+
+// AddSignature adds a signature, as returned by SignRequest or SignResponse, to the message headers.
+// The new values are added as separate header lines, and existing lines are never modified.
+// Returns an error if the message already has a signature with the same label, which would be replaced.
+func AddSignature(h http.Header, signatureInput, signature string) error {
+	if err := checkNewSignature(h, signatureInput, signature); err != nil {
+		return err
+	}
+	h.Add("Signature-Input", signatureInput)
+	h.Add("Signature", signature)
+	return nil
+}
+
+// MergeSignature is the same as AddSignature, but appends the new dictionary members to the last line
+// of the existing Signature-Input and Signature headers, for recipients that only read the first line of
+// each header. Existing members are preserved byte-for-byte.
+func MergeSignature(h http.Header, signatureInput, signature string) error {
+	if err := checkNewSignature(h, signatureInput, signature); err != nil {
+		return err
+	}
+	for _, hv := range [][2]string{{"Signature-Input", signatureInput}, {"Signature", signature}} {
+		key := http.CanonicalHeaderKey(hv[0])
+		if n := len(h[key]); n > 0 {
+			h[key][n-1] += ", " + hv[1]
+		} else {
+			h.Set(key, hv[1])
+		}
+	}
+	return nil
+}
+
+func checkNewSignature(h http.Header, signatureInput, signature string) error {
+	if h == nil {
+		return fmt.Errorf("nil header")
+	}
+	if signatureInput == "" || signature == "" {
+		return fmt.Errorf("empty signature")
+	}
+	existing := map[string]bool{}
+	for _, hdr := range []string{"Signature-Input", "Signature"} {
+		for _, line := range h.Values(hdr) {
+			for _, m := range splitDictMembers(line) {
+				existing[dictMemberKey(m)] = true
+			}
+		}
+	}
+	for _, m := range splitDictMembers(signatureInput) {
+		if label := dictMemberKey(m); existing[label] {
+			return &LabelConflictError{Label: label}
+		}
+	}
+	return nil
+}
+
+// RemoveSignature removes the named signature from both the Signature-Input and the Signature headers.
+// All other dictionary members are preserved byte-for-byte, and header lines that do not contain
+// the signature are not modified at all. Returns an error if the signature was not found.
+func RemoveSignature(h http.Header, signatureName string) error {
+	if h == nil {
+		return fmt.Errorf("nil header")
+	}
+	foundInput := removeDictMember(h, "Signature-Input", signatureName)
+	foundSig := removeDictMember(h, "Signature", signatureName)
+	if !foundInput && !foundSig {
+		return fmt.Errorf("signature \"%s\" not found", signatureName)
+	}
+	return nil
+}
+
+func removeDictMember(h http.Header, hdr, name string) (found bool) {
+	var lines []string
+	for _, line := range h.Values(hdr) {
+		members := splitDictMembers(line)
+		var kept []string
+		for _, m := range members {
+			if dictMemberKey(m) == name {
+				found = true
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if len(kept) == len(members) {
+			lines = append(lines, line) // untouched
+			continue
+		}
+		if len(kept) > 0 {
+			kept[0] = strings.TrimLeft(kept[0], " \t")
+			lines = append(lines, strings.Join(kept, ","))
+		}
+	}
+	if found {
+		if len(lines) == 0 {
+			h.Del(hdr)
+		} else {
+			h[http.CanonicalHeaderKey(hdr)] = lines
+		}
+	}
+	return found
+}
+
+// splitDictMembers splits a single line of a dictionary header into its members, at top-level commas.
+// Each member retains its original text, including any whitespace that follows the preceding comma.
+func splitDictMembers(line string) []string {
+	var members []string
+	depth, start := 0, 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				members = append(members, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(members, line[start:])
+}
+
+// dictMemberKey returns the key of a raw dictionary member
+func dictMemberKey(member string) string {
+	m := strings.TrimLeft(member, " \t")
+	if i := strings.IndexAny(m, "=;"); i >= 0 {
+		m = m[:i]
+	}
+	return strings.TrimRight(m, " \t")
+}
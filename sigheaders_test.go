@@ -0,0 +1,81 @@
+package httpsign
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_splitDictMembers(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"single", `sig1=:AAAA:`, []string{`sig1=:AAAA:`}},
+		{"several", `sig1=:AAAA:,  sig2=:BBBB:`, []string{`sig1=:AAAA:`, `  sig2=:BBBB:`}},
+		{"quoted comma", `sig1=("a" "b");nonce="x,y", sig2=()`, []string{`sig1=("a" "b");nonce="x,y"`, ` sig2=()`}},
+		{"escaped quote", `sig1=();nonce="x\",y", sig2=()`, []string{`sig1=();nonce="x\",y"`, ` sig2=()`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitDictMembers(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitDictMembers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveSignature(t *testing.T) {
+	res := readResponse(httpres2)
+	signer := makeHMACSigner(*NewSignConfig().SignCreated(false), Headers("Content-Type"))
+	for _, name := range []string{"sig1", "sig2"} {
+		sigInput, sig, err := SignResponse(name, signer, res)
+		assert.NoError(t, err)
+		assert.NoError(t, AddSignature(res.Header, sigInput, sig))
+	}
+	// Both signatures on a single line, with non-canonical whitespace that must be preserved
+	res.Header.Set("Signature-Input", res.Header.Values("Signature-Input")[0]+",   "+res.Header.Values("Signature-Input")[1])
+	proxySigner := makeHMACSigner(*NewSignConfig().SignCreated(false), *NewFields().AddDictHeader("Signature", "sig2"))
+	sigInput, sig, err := SignResponse("proxy_sig", proxySigner, res)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(res.Header, sigInput, sig))
+	sig2Input := splitDictMembers(res.Header.Values("Signature-Input")[0])[1]
+
+	assert.NoError(t, RemoveSignature(res.Header, "sig1"))
+	assert.Equal(t, []string{sig2Input[3:], sigInput}, res.Header.Values("Signature-Input"), "remaining members must be preserved")
+	assert.Equal(t, 2, len(res.Header.Values("Signature")))
+	assert.Error(t, RemoveSignature(res.Header, "sig1"), "signature already removed")
+
+	verifier, err := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{0x33}, 64), NewVerifyConfig().SetVerifyCreated(false),
+		*NewFields().AddDictHeader("Signature", "sig2"))
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyResponse("proxy_sig", *verifier, res), "proxy signature should still verify")
+}
+
+func TestMergeSignature(t *testing.T) {
+	res := readResponse(httpres2)
+	res.Header.Set("Signature-Input", `upstream=("content-type");keyid="x",  other=()`)
+	res.Header.Set("Signature", `upstream=:AAAA:,  other=:BBBB:`)
+	signer := makeHMACSigner(*NewSignConfig().SignCreated(false), Headers("Content-Type"))
+	sigInput, sig, err := SignResponse("sig1", signer, res)
+	assert.NoError(t, err)
+	assert.NoError(t, MergeSignature(res.Header, sigInput, sig))
+	assert.Equal(t, []string{`upstream=("content-type");keyid="x",  other=(), ` + sigInput}, res.Header.Values("Signature-Input"),
+		"upstream signatures must be preserved")
+	assert.Equal(t, []string{`upstream=:AAAA:,  other=:BBBB:, ` + sig}, res.Header.Values("Signature"))
+
+	verifier, err := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{0x33}, 64), NewVerifyConfig().SetVerifyCreated(false), *NewFields())
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+
+	var conflict *LabelConflictError
+	assert.ErrorAs(t, MergeSignature(res.Header, sigInput, sig), &conflict, "label is in use")
+	assert.ErrorAs(t, AddSignature(res.Header, `upstream=()`, `upstream=:CCCC:`), &conflict, "would replace the upstream signature")
+
+	h := http.Header{}
+	assert.NoError(t, MergeSignature(h, sigInput, sig))
+	assert.Equal(t, sigInput, h.Get("Signature-Input"))
+}
@@ -8,38 +8,207 @@
 package httpsign
 
 import (
+	"bytes"
+	"context"
+	"crypto/rsa"
 	"encoding/base64"
 	"fmt"
 	"github.com/dunglas/httpsfv"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func signMessage(config SignConfig, signatureName string, signer Signer, parsedMessage parsedMessage,
-	fields Fields) (signatureInputHeader, signature, signatureInput string, err error) {
+// signMessage signs a message. Ctx is passed to a signer that implements ContextSigner.
+func signMessage(ctx context.Context, config SignConfig, signatureName string, signer Signer, parsedMessage parsedMessage,
+	fields Fields, captureInput bool) (signatureInputHeader, signature, signatureInput string, err error) {
+	if config.metrics != nil {
+		start := time.Now()
+		defer func() { config.metrics.Signed(signer.alg, time.Since(start), err) }()
+	}
+	if err = validateLabel(signatureName, config.maxLabelLength); err != nil {
+		return "", "", "", err
+	}
+	signatureName, err = resolveLabelConflict(config, signatureName, parsedMessage)
+	if err != nil {
+		return "", "", "", err
+	}
+	fields, err = applyUnsafeValuePolicy(config.unsafeValues, parsedMessage, fields)
+	if err != nil {
+		return "", "", "", err
+	}
+	fields = wrapNonCombinable(parsedMessage, fields)
 	sigParams, err := generateSigParams(&config, signer.keyID, signer.alg, signer.foreignSigner, fields)
 	if err != nil {
 		return "", "", "", err
 	}
-	signatureInputHeader = fmt.Sprintf("%s=%s", signatureName, sigParams)
-	signatureInput, err = generateSignatureInput(parsedMessage, fields, sigParams)
+	signatureInputHeader = signatureName + "=" + sigParams
+	w, finish := signer.newSigningWriter(ctx)
+	var captured strings.Builder
+	if captureInput || config.baseHook != nil {
+		w = io.MultiWriter(w, &captured)
+	}
+	err = writeSignatureInput(w, parsedMessage, fields, sigParams, nil)
 	if err != nil {
 		return "", "", "", err
 	}
-	signature, err = generateSignature(signatureName, signer, signatureInput)
+	if config.baseHook != nil {
+		config.baseHook(signatureName, captured.String())
+	}
+	raw, err := finish()
 	if err != nil {
 		return "", "", "", err
 	}
-	return signatureInputHeader, signature, signatureInput, nil
+	signature = signatureName + "=" + encodeBytes(raw)
+	return signatureInputHeader, signature, captured.String(), nil
 }
 
-func generateSignature(name string, signer Signer, input string) (string, error) {
-	raw, err := signer.sign([]byte(input))
-	if err != nil {
-		return "", err
+// resolveLabelConflict returns the label to sign with, given the labels already present on the message
+func resolveLabelConflict(config SignConfig, label string, message parsedMessage) (string, error) {
+	used := map[string]bool{}
+	for _, hdr := range []string{"signature-input", "signature"} {
+		vals, found := message.headers[hdr]
+		if !found {
+			continue
+		}
+		dict, err := httpsfv.UnmarshalDictionary(vals)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse dictionary for %s: %w", hdr, err)
+		}
+		for _, l := range dict.Names() {
+			used[l] = true
+		}
+	}
+	if !used[label] {
+		return label, nil
+	}
+	if config.labelConflict != LabelConflictRename {
+		return "", &LabelConflictError{Label: label}
+	}
+	base := strings.TrimRight(label, "0123456789")
+	n, _ := strconv.Atoi(label[len(base):]) // an empty suffix counts as 1
+	if n < 1 {
+		n = 1
+	}
+	for {
+		n++
+		candidate := base + strconv.Itoa(n)
+		if !used[candidate] {
+			if err := validateLabel(candidate, config.maxLabelLength); err != nil {
+				return "", err
+			}
+			return candidate, nil
+		}
+	}
+}
+
+func isUnsafeValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if (c < 0x20 && c != '\t') || c >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizeValue(v string) string {
+	b := []byte(v)
+	for i, c := range b {
+		if (c < 0x20 && c != '\t') || c >= 0x7f {
+			b[i] = '?'
+		}
+	}
+	return string(b)
+}
+
+// nonCombinableHeaders lists headers whose values may contain commas that do not separate list members,
+// e.g. in the Expires attribute of a cookie, so that their field lines cannot be safely combined with ", "
+// (RFC 9421, Section 2.1.3)
+var nonCombinableHeaders = map[string]bool{
+	"set-cookie": true,
+}
+
+// wrapNonCombinable covers the non-combinable headers that appear on more than one field line as byte sequences,
+// so that each field line is signed separately. A verifier that requires the plain header accepts this coverage.
+func wrapNonCombinable(message parsedMessage, fields Fields) Fields {
+	var res Fields
+	for i, f := range fields.f {
+		if f.flagName != "" || f.tr || !nonCombinableHeaders[f.name] {
+			continue
+		}
+		headers := message.headers
+		if f.req {
+			if message.request == nil {
+				continue
+			}
+			headers = message.request.headers
+		}
+		if len(headers[f.name]) > 1 {
+			if res.f == nil {
+				res.f = append([]field(nil), fields.f...)
+			}
+			res.f[i].flagName = "bs"
+		}
+	}
+	if res.f == nil {
+		return fields
 	}
-	return fmt.Sprintf("%s=%s", name, encodeBytes(raw)), nil
+	return res
+}
+
+// applyUnsafeValuePolicy checks the plain headers to be signed for non-ASCII and control characters,
+// and handles them according to the policy. The returned Fields may differ from the input, if
+// headers need to be wrapped as byte sequences. Sanitized values are modified in the message itself.
+func applyUnsafeValuePolicy(policy UnsafeValuePolicy, message parsedMessage, fields Fields) (Fields, error) {
+	if policy == UnsafeValueAllow {
+		return fields, nil
+	}
+	res := Fields{f: make([]field, 0, len(fields.f))}
+	for _, f := range fields.f {
+		if f.flagName == "" && !f.req && !f.tr && !strings.HasPrefix(f.name, "@") {
+			vv := message.headers[f.name]
+			for i, v := range vv {
+				if !isUnsafeValue(v) {
+					continue
+				}
+				switch policy {
+				case UnsafeValueReject:
+					return Fields{}, &UnsafeHeaderValueError{Header: f.name}
+				case UnsafeValueWrap:
+					f.flagName = "bs"
+				case UnsafeValueSanitize:
+					vv[i] = sanitizeValue(v) // shared with the original message
+				}
+			}
+		}
+		res.f = append(res.f, f)
+	}
+	return res, nil
+}
+
+// validateLabel ensures that a signature label is a valid structured field key, and is not
+// too long, so that hostile labels are rejected before they reach any serialization code.
+// A zero or negative maxLength means no length limit.
+func validateLabel(label string, maxLength int) error {
+	if label == "" {
+		return fmt.Errorf("empty signature name")
+	}
+	if maxLength > 0 && len(label) > maxLength {
+		return fmt.Errorf("signature name is longer than %d characters", maxLength)
+	}
+	if !isLCAlpha(label[0]) && label[0] != '*' {
+		return fmt.Errorf("signature name must start with a lowercase letter or \"*\"")
+	}
+	for i := 1; i < len(label); i++ {
+		if !isKeyChar(label[i]) {
+			return fmt.Errorf("invalid character %q in signature name", label[i])
+		}
+	}
+	return nil
 }
 
 func encodeBytes(raw []byte) string {
@@ -47,28 +216,70 @@ func encodeBytes(raw []byte) string {
 }
 
 func generateSignatureInput(message parsedMessage, fields Fields, params string) (string, error) {
-	inp := ""
+	b := getBuffer()
+	defer putBuffer(b)
+	err := writeSignatureInput(b, message, fields, params, nil)
+	return b.String(), err
+}
+
+// writeSignatureInput writes the signature input to w one component at a time, so that a large
+// signature input can be hashed as it is generated without being held in memory.
+// Generating each component's value is recorded into report, if not nil.
+func writeSignatureInput(w io.Writer, message parsedMessage, fields Fields, params string, report *VerificationReport) error {
+	b := getBuffer()
+	defer putBuffer(b)
 	for _, c := range fields.f {
-		f, err := c.asSignatureInput()
-		if err != nil {
-			return "", fmt.Errorf("could not marshal %v", f)
+		if err := c.writeIdentifier(b); err != nil {
+			return fmt.Errorf("could not marshal %v", c.String())
 		}
 		fieldValues, err := generateFieldValues(c, message)
+		if report != nil {
+			err = report.add("field "+c.String(), err)
+		}
 		if err != nil {
-			return "", err
+			return err
+		}
+		id := b.Bytes()
+		for i, v := range fieldValues {
+			if i > 0 { // repeated query parameters
+				b.Write(id)
+			}
+			b.WriteString(": ")
+			b.WriteString(v)
+			b.WriteByte('\n')
 		}
-		for _, v := range fieldValues {
-			inp += fmt.Sprintf("%s: %s\n", f, v)
+		if _, err = w.Write(b.Bytes()); err != nil {
+			return err
 		}
+		b.Reset()
 	}
-	inp += fmt.Sprintf("\"%s\": %s", "@signature-params", params)
-	// log.Println("inp:", "\n"+inp)
-	return inp, nil
+	b.WriteString("\"@signature-params\": ")
+	b.WriteString(params)
+	_, err := w.Write(b.Bytes())
+	return err
 }
 
 func generateFieldValues(f field, message parsedMessage) ([]string, error) {
+	if f.req {
+		if message.request == nil {
+			return nil, fmt.Errorf("request is not available for %s", f.String())
+		}
+		f.req = false
+		return generateFieldValues(f, *message.request)
+	}
+	if f.tr {
+		trailers, err := message.trailerMessage()
+		if err != nil {
+			return nil, err
+		}
+		f.tr = false
+		return generateFieldValues(f, *trailers)
+	}
 	if f.flagName == "" || f.flagName == "sf" {
 		if strings.HasPrefix(f.name, "@") { // derived component
+			if !isKnownDerivedComponent(f.name) {
+				return nil, &UnknownComponentError{Component: f.name}
+			}
 			vv, found := message.derived[f.name]
 			if !found {
 				return nil, fmt.Errorf("derived header %s not found", f.name)
@@ -77,7 +288,25 @@ func generateFieldValues(f field, message parsedMessage) ([]string, error) {
 		}
 		return message.getHeader(f.name, f.flagName == "sf")
 	}
-	if f.name == "@query-params" && f.flagName == "name" {
+	if f.flagName == "bs" {
+		return message.getByteSequenceHeader(f.name)
+	}
+	if f.name == "@query-param" && f.flagName == "name" {
+		name, err := url.QueryUnescape(f.flagValue)
+		if err != nil {
+			return nil, fmt.Errorf("malformed query parameter name %s", f.flagValue)
+		}
+		vals, found := message.qParams[name]
+		if !found {
+			return nil, fmt.Errorf("query parameter %s not found", f.flagValue)
+		}
+		encoded := make([]string, len(vals))
+		for i, v := range vals {
+			encoded[i] = encodeQueryParam(v)
+		}
+		return encoded, nil
+	}
+	if f.name == "@query-params" && f.flagName == "name" { // draft name, values are not re-encoded
 		vals, found := message.qParams[f.flagValue]
 		if !found {
 			return nil, fmt.Errorf("query parameter %s not found", f.flagValue)
@@ -87,18 +316,91 @@ func generateFieldValues(f field, message parsedMessage) ([]string, error) {
 	if f.flagName == "key" { // dictionary header
 		return message.getDictHeader(f.name, f.flagValue)
 	}
-	return nil, fmt.Errorf("unrecognized field %s", f)
+	return nil, fmt.Errorf("unrecognized field %s", f.String())
 }
 
-func (message *parsedMessage) getHeader(hdr string, structured bool) ([]string, error) {
+// trailerMessage returns the trailer fields as a message. Trailers are only complete once the body has been read.
+func (message *parsedMessage) trailerMessage() (*parsedMessage, error) {
+	if message.trailerLimit < 0 {
+		return nil, fmt.Errorf("trailers are not verified, see VerifyConfig.SetVerifyTrailers")
+	}
+	if message.body != nil {
+		limit := message.trailerLimit
+		if limit > 0 && message.contentLength > limit {
+			return nil, &BodyTooLargeError{Limit: limit}
+		}
+		if limit > 0 && *message.body != nil && *message.body != http.NoBody {
+			*message.body = &limitedBody{ReadCloser: *message.body, remaining: limit, limit: limit}
+		}
+		if _, err := readBody(message.body, message.contentLength); err != nil {
+			return nil, err
+		}
+	}
+	return &parsedMessage{derived: components{}, headers: normalizeHeaderNames(message.trailers),
+		canonicalization: message.canonicalization, rejectDuplicates: message.rejectDuplicates}, nil
+}
+
+// limitTrailers sets the size of the body that may be read to get to the trailers of the message and of its
+// request, if any. Unless n is positive, trailers are not available.
+func (message *parsedMessage) limitTrailers(n int64) {
+	if n <= 0 {
+		n = -1
+	}
+	message.trailerLimit = n
+	if message.request != nil {
+		request := *message.request
+		request.trailerLimit = n
+		message.request = &request
+	}
+}
+
+// singletonHeaders lists headers that are defined to have a single value (RFC 9110), so that more than one
+// field line is unexpected
+var singletonHeaders = map[string]bool{
+	"age":                 true,
+	"authorization":       true,
+	"content-length":      true,
+	"content-location":    true,
+	"content-range":       true,
+	"content-type":        true,
+	"date":                true,
+	"etag":                true,
+	"expires":             true,
+	"host":                true,
+	"if-modified-since":   true,
+	"if-range":            true,
+	"if-unmodified-since": true,
+	"last-modified":       true,
+	"location":            true,
+	"max-forwards":        true,
+	"proxy-authorization": true,
+	"range":               true,
+	"referer":             true,
+	"retry-after":         true,
+	"user-agent":          true,
+}
+
+// headerValues returns the field lines of a header, which are combined into a single value
+func (message *parsedMessage) headerValues(hdr string) ([]string, error) {
 	vv, found := message.headers[hdr] // normal header, cannot use "Values" on lowercased header name
 	if !found {
 		return nil, fmt.Errorf("header %s not found", hdr)
 	}
+	if message.rejectDuplicates && len(vv) > 1 && singletonHeaders[hdr] {
+		return nil, &DuplicateHeaderError{Header: hdr}
+	}
+	return vv, nil
+}
+
+func (message *parsedMessage) getHeader(hdr string, structured bool) ([]string, error) {
+	vv, err := message.headerValues(hdr)
+	if err != nil {
+		return nil, err
+	}
 	if !structured {
-		return []string{foldFields(vv)}, nil
+		return []string{canonicalizeFields(vv, message.canonicalization)}, nil
 	}
-	sfv, err := httpsfv.UnmarshalDictionary(vv)
+	sfv, err := unmarshalStructuredField(hdr, vv)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal %s, possibly not a structured field: %w", hdr, err)
 	}
@@ -109,11 +411,78 @@ func (message *parsedMessage) getHeader(hdr string, structured bool) ([]string,
 	return []string{s}, nil
 }
 
+type structuredFieldType int
+
+const (
+	sfDictionary structuredFieldType = iota + 1
+	sfList
+	sfItem
+)
+
+// structuredFields lists the types of registered structured fields, which must be known to parse them correctly
+var structuredFields = map[string]structuredFieldType{
+	"accept-ch":                    sfList,
+	"accept-signature":             sfDictionary,
+	"cache-status":                 sfList,
+	"cdn-cache-control":            sfDictionary,
+	"client-cert":                  sfItem,
+	"client-cert-chain":            sfList,
+	"content-digest":               sfDictionary,
+	"cross-origin-embedder-policy": sfItem,
+	"cross-origin-opener-policy":   sfItem,
+	"origin-agent-cluster":         sfItem,
+	"priority":                     sfDictionary,
+	"proxy-status":                 sfList,
+	"repr-digest":                  sfDictionary,
+	"signature":                    sfDictionary,
+	"signature-input":              sfDictionary,
+	"want-content-digest":          sfDictionary,
+	"want-repr-digest":             sfDictionary,
+}
+
+// unmarshalStructuredField parses a header according to its registered type. The type of other headers
+// is inferred: Dictionary, then List, then Item. Where more than one parses, they re-serialize identically,
+// except for duplicate Dictionary keys.
+func unmarshalStructuredField(hdr string, vv []string) (httpsfv.StructuredFieldValue, error) {
+	switch structuredFields[hdr] {
+	case sfDictionary:
+		return httpsfv.UnmarshalDictionary(vv)
+	case sfList:
+		return httpsfv.UnmarshalList(vv)
+	case sfItem:
+		return httpsfv.UnmarshalItem(vv)
+	}
+	if d, err := httpsfv.UnmarshalDictionary(vv); err == nil {
+		return d, nil
+	}
+	if l, err := httpsfv.UnmarshalList(vv); err == nil {
+		return l, nil
+	}
+	return httpsfv.UnmarshalItem(vv)
+}
+
+// getByteSequenceHeader wraps each of the header's values as a byte sequence, protecting
+// values that cannot be safely canonicalized (e.g. non-ASCII) from being reinterpreted.
+func (message *parsedMessage) getByteSequenceHeader(hdr string) ([]string, error) {
+	vv, err := message.headerValues(hdr)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]string, len(vv))
+	for i, v := range vv {
+		wrapped[i] = encodeBytes([]byte(strings.TrimSpace(v)))
+	}
+	return []string{strings.Join(wrapped, ", ")}, nil
+}
+
 func (message *parsedMessage) getDictHeader(hdr, member string) ([]string, error) {
 	vals, found := message.headers[hdr]
 	if !found {
 		return nil, fmt.Errorf("dictionary header %s not found", hdr)
 	}
+	if t, registered := structuredFields[hdr]; registered && t != sfDictionary {
+		return nil, fmt.Errorf("header %s is not a dictionary", hdr)
+	}
 	dict, err := httpsfv.UnmarshalDictionary(vals)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse dictionary for %s: %w", hdr, err)
@@ -122,62 +491,88 @@ func (message *parsedMessage) getDictHeader(hdr, member string) ([]string, error
 	if !found {
 		return nil, fmt.Errorf("cannot find member %s of dictionary %s", member, hdr)
 	}
-	switch v.(type) {
+	var vv string
+	switch m := v.(type) {
 	case httpsfv.Item:
-		vv, err := httpsfv.Marshal(v.(httpsfv.Item))
-		if err != nil {
-			return nil, fmt.Errorf("malformed dictionry member %s: %v", hdr, err)
-		}
-		return []string{vv}, nil
+		vv, err = httpsfv.Marshal(m)
 	case httpsfv.InnerList:
-		vv, err := httpsfv.Marshal(v.(httpsfv.InnerList))
-		if err != nil {
-			return nil, fmt.Errorf("malformed dictionry member %s: %v", hdr, err)
-		}
-		return []string{vv}, nil
+		vv, err = httpsfv.Marshal(m)
 	default:
 		return nil, fmt.Errorf("unexpected dictionary value")
 	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed dictionary member %s: %v", hdr, err)
+	}
+	return []string{vv}, nil
 }
 
+// generateSigParams serializes the covered components and the signature parameters, in the order created,
+// expires, nonce, alg, keyid and tag. The same serialization is used for both the Signature-Input header and
+// the "@signature-params" component of the signature input, so the two can never diverge.
 func generateSigParams(config *SignConfig, keyID, alg string, foreignSigner interface{}, fields Fields) (string, error) {
-	p := httpsfv.NewParams()
 	var createdTime int64
 	if config.fakeCreated != 0 {
 		createdTime = config.fakeCreated
 	} else {
 		createdTime = time.Now().Unix()
 	}
-	if config.signCreated {
-		p.Add("created", createdTime)
+	nonce, hasNonce := config.nonce, config.nonce != ""
+	if config.nonceGen != nil {
+		var err error
+		if nonce, err = config.nonceGen(); err != nil {
+			return "", fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		hasNonce = true
 	}
-	if config.expires != 0 {
-		p.Add("expires", config.expires)
+	if config.signAlg && foreignSigner != nil {
+		return "", fmt.Errorf("cannot use the alg parameter with a JWS signer")
 	}
-	if config.nonce != "" {
-		p.Add("nonce", config.nonce)
+	b := getBuffer()
+	defer putBuffer(b)
+	err := fields.writeInnerList(b)
+	if err == nil && config.signCreated {
+		err = writeSFIntegerParam(b, "created", createdTime)
 	}
-	if config.signAlg {
-		if foreignSigner != nil {
-			return "", fmt.Errorf("cannot use the alg parameter with a JWS signer")
-		}
-		p.Add("alg", alg)
+	if err == nil && config.expiresIn != 0 {
+		err = writeSFIntegerParam(b, "expires", time.Unix(createdTime, 0).Add(config.expiresIn).Unix())
+	} else if err == nil && config.expires != 0 {
+		err = writeSFIntegerParam(b, "expires", config.expires)
+	}
+	if err == nil && hasNonce {
+		err = writeSFStringParam(b, "nonce", nonce)
+	}
+	if err == nil && config.signAlg {
+		err = writeSFStringParam(b, "alg", alg)
+	}
+	if err == nil {
+		err = writeSFStringParam(b, "keyid", keyID)
+	}
+	if err == nil && config.tag != "" {
+		err = writeSFStringParam(b, "tag", config.tag)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not serialize signature parameters: %w", err)
 	}
-	p.Add("keyid", keyID)
-	return fields.asSignatureInput(p)
+	return b.String(), nil
 }
 
 //
 // SignRequest signs an HTTP request. Returns the Signature-Input and the Signature header values.
+// The signer is typically a Signer, but may be any MessageSigner.
 //
-func SignRequest(signatureName string, signer Signer, req *http.Request) (signatureInputHeader, signature string, err error) {
-	signatureInputHeader, signature, signatureInput, err := signRequestDebug(signatureName, signer, req)
-	_ = signatureInput
-	return
+func SignRequest(signatureName string, signer MessageSigner, req *http.Request) (signatureInputHeader, signature string, err error) {
+	if isNilSigner(signer) {
+		return "", "", fmt.Errorf("nil signer")
+	}
+	return signer.SignRequest(signatureName, req)
 }
 
 // Same as SignRequest, but also returns the raw signature input string
 func signRequestDebug(signatureName string, signer Signer, req *http.Request) (signatureInputHeader, signature, signatureInput string, err error) {
+	return signRequestInternal(signatureName, signer, req, true)
+}
+
+func signRequestInternal(signatureName string, signer Signer, req *http.Request, captureInput bool) (signatureInputHeader, signature, signatureInput string, err error) {
 	if req == nil {
 		return "", "", "", fmt.Errorf("nil request")
 	}
@@ -187,32 +582,104 @@ func signRequestDebug(signatureName string, signer Signer, req *http.Request) (s
 	if signer.config.requestResponse != nil {
 		return "", "", "", fmt.Errorf("use request-response only to sign responses")
 	}
-	parsedMessage, err := parseRequest(req)
+	ctx, span := signer.signSpan(req.Context(), signatureName)
+	defer func() { span.End(err) }()
+	body, read, err := addDigests(ctx, *signer.config, signer.fields, req.Header, &req.Body, req.ContentLength)
 	if err != nil {
 		return "", "", "", err
 	}
-	return signMessage(*signer.config, signatureName, signer, *parsedMessage, signer.fields)
+	if read && req.GetBody != nil { // the body can still be replayed, e.g. on redirects
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	parsedMessage, err := parseRequest(req, &signer.config.derivation)
+	if err != nil {
+		return "", "", "", err
+	}
+	return signMessage(ctx, *signer.config, signatureName, signer, *parsedMessage, signer.fields, captureInput)
 }
 
 //
 // SignResponse signs an HTTP response. Returns the Signature-Input and the Signature header values.
+// The signer is typically a Signer, but may be any MessageSigner.
 //
-func SignResponse(signatureName string, signer Signer, res *http.Response) (signatureInput, signature string, err error) {
+func SignResponse(signatureName string, signer MessageSigner, res *http.Response) (signatureInput, signature string, err error) {
+	if isNilSigner(signer) {
+		return "", "", fmt.Errorf("nil signer")
+	}
+	return signer.SignResponse(signatureName, res)
+}
+
+func signResponse(signatureName string, signer Signer, res *http.Response) (signatureInput, signature string, err error) {
 	if res == nil {
 		return "", "", fmt.Errorf("nil response")
 	}
 	if signatureName == "" {
 		return "", "", fmt.Errorf("empty signature name")
 	}
-	parsedMessage, err := parseResponse(res)
+	ctx, span := signer.signSpan(responseContext(res), signatureName)
+	defer func() { span.End(err) }()
+	if _, _, err = addDigests(ctx, *signer.config, signer.fields, res.Header, &res.Body, res.ContentLength); err != nil {
+		return "", "", err
+	}
+	parsedMessage, err := parseResponse(res, &signer.config.derivation)
 	if err != nil {
 		return "", "", err
 	}
 	extendedFields := addPseudoHeaders(parsedMessage, signer.config.requestResponse, signer.fields)
-	signatureInput, signature, _, err = signMessage(*signer.config, signatureName, signer, *parsedMessage, extendedFields)
+	signatureInput, signature, _, err = signMessage(ctx, *signer.config, signatureName, signer, *parsedMessage, extendedFields, false)
 	return
 }
 
+// ResponsePresetFields returns the components recommended for signing a response: "@status", and
+// "content-type", "content-digest", "content-range" and "repr-digest" if the response has them.
+// For a partial (206) response, "content-digest" covers the enclosed range, while "repr-digest"
+// covers the complete representation.
+func ResponsePresetFields(res *http.Response) Fields {
+	fs := NewFields().AddHeader("@status")
+	for _, hdr := range []string{"Content-Type", "Content-Digest", "Content-Range", "Repr-Digest"} {
+		if _, found := res.Header[hdr]; found {
+			fs.AddHeader(hdr)
+		}
+	}
+	return *fs
+}
+
+// SignResponseForRequest signs a response with a recommended set of components (see ResponsePresetFields),
+// in addition to the signer's own fields, and binds it to req, the request it answers, by covering
+// components of the request (RFC 9421, Sec. 2.4): "@method";req and "@target-uri";req, and if req carries
+// a signature named reqSignatureName, that signature ("signature";req;key=...). The reqSignatureName may be empty
+// if the request is not signed. The verifier must have the same request at hand, see http.Response.Request.
+// Neither the signer nor its configuration is modified.
+func SignResponseForRequest(signatureName string, signer Signer, res *http.Response, req *http.Request,
+	reqSignatureName string) (signatureInput, signature string, err error) {
+	if res == nil {
+		return "", "", fmt.Errorf("nil response")
+	}
+	if req == nil {
+		return "", "", fmt.Errorf("nil request")
+	}
+	fields := ResponsePresetFields(res)
+	fields.AddRequestHeaders("@method", "@target-uri")
+	if reqSignatureName != "" {
+		if _, err := GetRequestSignature(req, reqSignatureName); err != nil {
+			return "", "", err
+		}
+		fields.AddRequestDictHeader("signature", reqSignatureName)
+	}
+	for _, f := range signer.fields.f {
+		if !fields.contains(&Fields{f: []field{f}}) {
+			fields.f = append(fields.f, f)
+		}
+	}
+	signer.fields = fields
+	bound := *res
+	bound.Request = req
+	defer func() { res.Body = bound.Body }() // computing a digest may have buffered the body
+	return SignResponse(signatureName, signer, &bound)
+}
+
 // Handle the special header-like @request-response
 func addPseudoHeaders(message *parsedMessage, rr *requestResponse, fields Fields) Fields {
 	if rr != nil {
@@ -231,12 +698,20 @@ func addPseudoHeaders(message *parsedMessage, rr *requestResponse, fields Fields
 
 //
 // VerifyRequest verifies a signed HTTP request. Returns an error if verification failed for any reason, otherwise nil.
-func VerifyRequest(signatureName string, verifier Verifier, req *http.Request) error {
-	_, err := verifyRequestDebug(signatureName, verifier, req)
-	return err
+// The verifier is typically a Verifier, but may be any MessageVerifier.
+func VerifyRequest(signatureName string, verifier MessageVerifier, req *http.Request) error {
+	if isNilVerifier(verifier) {
+		return fmt.Errorf("nil verifier")
+	}
+	return verifier.VerifyRequest(signatureName, req)
 }
 
+// Same as VerifyRequest, but also returns the raw signature input string
 func verifyRequestDebug(signatureName string, verifier Verifier, req *http.Request) (signatureInput string, err error) {
+	return verifyRequestInternal(signatureName, verifier, req, true)
+}
+
+func verifyRequestInternal(signatureName string, verifier Verifier, req *http.Request, captureInput bool) (signatureInput string, err error) {
 	if req == nil {
 		return "", fmt.Errorf("nil request")
 	}
@@ -246,11 +721,28 @@ func verifyRequestDebug(signatureName string, verifier Verifier, req *http.Reque
 	if verifier.config.requestResponse != nil {
 		return "", fmt.Errorf("use request-response only to verify responses")
 	}
-	parsedMessage, err := parseRequest(req)
+	t := verifier.config.timeouts
+	ctx, cancel := t.withTotal(req.Context())
+	defer cancel()
+	rv := req.WithContext(req.Context()) // the stages may replace the body of this copy
+	defer func() { publishBody(&req.Body, &rv.Body, err) }()
+	var parsedMessage *parsedMessage
+	err = runStage(ctx, StageParse, t.Parse, func(context.Context) (err error) {
+		parsedMessage, err = parseRequest(rv, &verifier.config.derivation)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	var input string
+	err = runStage(ctx, StageCrypto, t.Crypto, func(ctx context.Context) (err error) {
+		input, err = verifyMessage(ctx, *verifier.config, signatureName, verifier, *parsedMessage, verifier.fields, captureInput)
+		return
+	})
 	if err != nil {
 		return "", err
 	}
-	return verifyMessage(*verifier.config, signatureName, verifier, *parsedMessage, verifier.fields)
+	return input, nil
 }
 
 // RequestDetails parses a signed request and returns the key ID and optionally the algorithm used in the given signature.
@@ -261,7 +753,7 @@ func RequestDetails(signatureName string, req *http.Request) (keyID, alg string,
 	if signatureName == "" {
 		return "", "", fmt.Errorf("empty signature name")
 	}
-	parsedMessage, err := parseRequest(req)
+	parsedMessage, err := parseRequest(req, nil)
 	if err != nil {
 		return "", "", err
 	}
@@ -276,7 +768,7 @@ func ResponseDetails(signatureName string, res *http.Response) (keyID, alg strin
 	if signatureName == "" {
 		return "", "", fmt.Errorf("empty signature name")
 	}
-	parsedMessage, err := parseResponse(res)
+	parsedMessage, err := parseResponse(res, nil)
 	if err != nil {
 		return "", "", err
 	}
@@ -292,7 +784,7 @@ func GetRequestSignature(req *http.Request, signatureName string) (string, error
 	if signatureName == "" {
 		return "", fmt.Errorf("empty signature name")
 	}
-	parsedMessage, err := parseRequest(req)
+	parsedMessage, err := parseRequest(req, nil)
 	if err != nil {
 		return "", err
 	}
@@ -312,17 +804,18 @@ func GetRequestSignature(req *http.Request, signatureName string) (string, error
 }
 
 func messageKeyID(signatureName string, parsedMessage parsedMessage) (keyID, alg string, err error) {
-	si, err := parsedMessage.getDictHeader("signature-input", signatureName)
-	if err != nil {
-		return "", "", fmt.Errorf("missing \"signature-input\" header, or cannot find \"%s\": %w", signatureName, err)
-	}
-	if len(si) > 1 {
-		return "", "", fmt.Errorf("more than one \"signature-input\" for %s", signatureName)
-	}
-	signatureInput := si[0]
-	psi, err := parseSignatureInput(signatureInput, signatureName)
-	if err != nil {
-		return
+	psi, ok := parsedMessage.fastSignatureInput(signatureName)
+	if !ok {
+		si, err := parsedMessage.getDictHeader("signature-input", signatureName)
+		if err != nil {
+			return "", "", fmt.Errorf("missing \"signature-input\" header, or cannot find \"%s\": %w", signatureName, err)
+		}
+		if len(si) > 1 {
+			return "", "", fmt.Errorf("more than one \"signature-input\" for %s", signatureName)
+		}
+		if psi, err = parseSignatureInput(si[0], signatureName); err != nil {
+			return "", "", err
+		}
 	}
 	keyIDParam, ok := psi.params["keyid"]
 	if !ok {
@@ -344,83 +837,271 @@ func messageKeyID(signatureName string, parsedMessage parsedMessage) (keyID, alg
 
 //
 // VerifyResponse verifies a signed HTTP response. Returns an error if verification failed for any reason, otherwise nil.
+// The verifier is typically a Verifier, but may be any MessageVerifier.
 //
-func VerifyResponse(signatureName string, verifier Verifier, res *http.Response) (err error) {
+func VerifyResponse(signatureName string, verifier MessageVerifier, res *http.Response) (err error) {
+	if isNilVerifier(verifier) {
+		return fmt.Errorf("nil verifier")
+	}
+	return verifier.VerifyResponse(signatureName, res)
+}
+
+func verifyResponse(signatureName string, verifier Verifier, res *http.Response) (err error) {
 	if res == nil {
 		return fmt.Errorf("nil response")
 	}
 	if signatureName == "" {
 		return fmt.Errorf("empty signature name")
 	}
-	parsedMessage, err := parseResponse(res)
+	t := verifier.config.timeouts
+	ctx, cancel := t.withTotal(context.Background())
+	defer cancel()
+	rc := *res // the stages may replace the body of this copy
+	defer func() { publishBody(&res.Body, &rc.Body, err) }()
+	var parsedMessage *parsedMessage
+	err = runStage(ctx, StageParse, t.Parse, func(context.Context) (err error) {
+		parsedMessage, err = parseResponse(&rc, &verifier.config.derivation)
+		return
+	})
 	if err != nil {
 		return err
 	}
-	extendedFields := addPseudoHeaders(parsedMessage, verifier.config.requestResponse, verifier.fields)
-	_, err = verifyMessage(*verifier.config, signatureName, verifier, *parsedMessage, extendedFields)
-	return err
+	return runStage(ctx, StageCrypto, t.Crypto, func(context.Context) error {
+		extendedFields := addPseudoHeaders(parsedMessage, verifier.config.requestResponse, verifier.fields)
+		_, err := verifyMessage(responseContext(res), *verifier.config, signatureName, verifier, *parsedMessage, extendedFields, false)
+		return err
+	})
 }
 
-func verifyMessage(config VerifyConfig, name string, verifier Verifier, message parsedMessage, fields Fields) (string, error) {
-	wsi, err := message.getDictHeader("signature-input", name)
-	if err != nil {
-		return "", fmt.Errorf("missing \"signature-input\" header, or cannot find signature \"%s\": %w", name, err)
+// verifyMessage verifies a single signature. Ctx is only used as the parent of its tracing spans.
+func verifyMessage(ctx context.Context, config VerifyConfig, name string, verifier Verifier, message parsedMessage,
+	fields Fields, captureInput bool) (signatureInput string, err error) {
+	if config.metrics != nil {
+		start := time.Now()
+		defer func() { config.metrics.Verified(verifier.alg, time.Since(start), err) }()
+	}
+	ctx, span := startSpan(ctx, config.tracer, SpanVerify)
+	defer func() { span.End(err) }()
+	span.SetAttribute(AttributeLabel, name)
+	span.SetAttribute(AttributeKeyID, verifier.keyID)
+	span.SetAttribute(AttributeAlg, verifier.alg)
+	message.limitTrailers(config.maxTrailerBody)
+	if err := message.loadSignatureTrailers(); err != nil {
+		return "", err
 	}
-	if len(wsi) > 1 {
-		return "", fmt.Errorf("multiple \"signature-header\" values for %s", name)
+	if err := applyParsingMode(config, message); err != nil {
+		return "", err
 	}
-	wantSignatureInput := wsi[0]
-	ws, err := message.getDictHeader("signature", name)
-	if err != nil {
-		return "", fmt.Errorf("missing \"signature\" header")
+	if config.parsingMode == ParseLenient {
+		name = strings.ToLower(name)
 	}
-	if len(ws) > 1 {
-		return "", fmt.Errorf("multiple \"signature\" values for %s", name)
+	if err := validateLabel(name, config.maxLabelLength); err != nil {
+		return "", err
 	}
-	wantSignature := ws[0]
-	wantSigRaw, err := parseWantSignature(wantSignature)
+	psiSig, wantSigRaw, err := message.lookupSignature(name)
 	if err != nil {
 		return "", err
 	}
-	psiSig, err := parseSignatureInput(wantSignatureInput, name)
-	if err != nil {
+	if err = checkKnownComponents(psiSig.fields); err != nil {
 		return "", err
 	}
 	if !(psiSig.fields.contains(&fields)) {
-		return "", fmt.Errorf("actual signature does not cover all required fields")
+		err = fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missingFields(psiSig.fields, fields), ", "))
+	}
+	if err = config.report.add("covered-fields", err); err != nil {
+		return "", err
 	}
 	err = applyVerificationPolicy(verifier, message, psiSig, config)
 	if err != nil {
 		return "", err
 	}
-	signatureInput, err := generateSignatureInput(message, psiSig.fields, psiSig.origSigParams)
+	keyID, _ := psiSig.params["keyid"].(string)
+	alg, _ := psiSig.params["alg"].(string)
+	keys, err := verifier.signatureKeys(ctx, keyID, alg)
 	if err != nil {
 		return "", err
 	}
-	return signatureInput, verifySignature(verifier, signatureInput, wantSigRaw)
+	w, finish := newKeysVerifyingWriter(keys)
+	var captured strings.Builder
+	if captureInput || config.baseHook != nil {
+		w = io.MultiWriter(w, &captured)
+	}
+	err = writeSignatureInput(w, message, psiSig.fields, psiSig.origSigParams, config.report)
+	if err != nil {
+		return "", err
+	}
+	if config.baseHook != nil {
+		config.baseHook(name, captured.String())
+	}
+	verified, err := finish(wantSigRaw)
+	if !verified && (err == nil) {
+		err = ErrBadSignature
+	}
+	if err = config.report.add("signature", err); err != nil {
+		return "", err
+	}
+	if !config.skipDigest && coversDigest(psiSig.fields) {
+		_, digestSpan := startSpan(ctx, config.tracer, SpanDigest)
+		err = verifyDigests(config, psiSig.fields, message)
+		digestSpan.End(err)
+		if err = config.report.add("digest", err); err != nil {
+			return "", err
+		}
+	}
+	if _, hasNonce := psiSig.params["nonce"]; hasNonce && config.replayCache != nil {
+		if err = config.report.add("replay", checkReplay(config, verifier, psiSig)); err != nil {
+			return "", err
+		}
+	}
+	return captured.String(), nil
 }
 
-func applyVerificationPolicy(verifier Verifier, message parsedMessage, psi *psiSignature, config VerifyConfig) error {
-	err := applyPolicyCreated(psi, message, config)
+// lookupSignature returns the parsed Signature-Input member and the raw signature with the given label
+func (message *parsedMessage) lookupSignature(name string) (*psiSignature, []byte, error) {
+	if psi, ok := message.fastSignatureInput(name); ok {
+		if sig, ok := message.fastSignature(name); ok {
+			return psi, sig, nil
+		}
+	}
+	return message.lookupSignatureSFV(name)
+}
+
+// lookupSignatureSFV is the general path of lookupSignature, for any valid headers
+func (message *parsedMessage) lookupSignatureSFV(name string) (*psiSignature, []byte, error) {
+	wsi, err := message.getDictHeader("signature-input", name)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("missing \"signature-input\" header, or cannot find signature \"%s\": %w", name, err)
+	}
+	if len(wsi) > 1 {
+		return nil, nil, fmt.Errorf("multiple \"signature-header\" values for %s", name)
+	}
+	wantSignatureInput := wsi[0]
+	ws, err := message.getDictHeader("signature", name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing \"signature\" header")
+	}
+	if len(ws) > 1 {
+		return nil, nil, fmt.Errorf("multiple \"signature\" values for %s", name)
+	}
+	wantSigRaw, err := parseWantSignature(ws[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	psiSig, err := parseSignatureInput(wantSignatureInput, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return psiSig, wantSigRaw, nil
+}
+
+// checkReplay records the nonce of a verified signature, only once it is verified so that forged
+// signatures cannot fill the cache
+func checkReplay(config VerifyConfig, verifier Verifier, psi *psiSignature) error {
+	nonce, ok := psi.params["nonce"].(string)
+	if config.replayCache == nil || !ok {
+		return nil
+	}
+	var created time.Time
+	if c, ok := psi.params["created"].(int64); ok {
+		created = time.Unix(c, 0)
 	}
-	err2 := applyPolicyAlgs(psi, config)
-	if err2 != nil {
-		return err2
+	if config.replayCache.Seen(verifier.keyID, nonce, created) {
+		return &ReplayError{KeyID: verifier.keyID, Nonce: nonce}
+	}
+	return nil
+}
+
+// applyParsingMode applies the configured parsing mode to the signature headers, before they are parsed.
+// In lenient mode, the headers of the parsed message are repaired in place.
+func applyParsingMode(config VerifyConfig, message parsedMessage) error {
+	for _, hdr := range []string{"signature-input", "signature"} {
+		vals, found := message.headers[hdr]
+		if !found {
+			continue
+		}
+		switch config.parsingMode {
+		case ParseStrict:
+			if err := validateStrictDictionary(hdr, vals); err != nil {
+				return err
+			}
+		case ParseLenient:
+			repaired, notes := tolerateDictionary(vals)
+			if len(notes) > 0 {
+				loggerOrNop(config.logger).Printf("Tolerated malformed \"%s\" header: %s", hdr, strings.Join(notes, ", "))
+				message.headers[hdr] = []string{repaired}
+			}
+		}
+	}
+	return nil
+}
+
+// missingFields lists the required fields that the signature does not cover
+func missingFields(covered, required Fields) []string {
+	var missing []string
+	for _, f := range required.f {
+		if !covered.contains(&Fields{f: []field{f}}) {
+			missing = append(missing, f.String())
+		}
+	}
+	return missing
+}
+
+// checkKnownComponents ensures that the signature does not cover derived components we cannot compute,
+// so that callers get a distinct error rather than a generic verification failure.
+func checkKnownComponents(fields Fields) error {
+	for _, f := range fields.f {
+		if strings.HasPrefix(f.name, "@") && !isKnownDerivedComponent(f.name) {
+			return &UnknownComponentError{Component: f.name}
+		}
 	}
-	err3 := applyPolicyExpired(psi, config)
-	if err3 != nil {
-		return err3
+	return nil
+}
+
+// applyVerificationPolicy applies each policy check in turn. Only the checks enabled by the config
+// are recorded into its report.
+func applyVerificationPolicy(verifier Verifier, message parsedMessage, psi *psiSignature, config VerifyConfig) error {
+	checks := []struct {
+		name    string
+		enabled bool
+		apply   func() error
+	}{
+		{"require-created", config.requireCreated, func() error { return applyPolicyRequireCreated(psi, config) }},
+		{"created", config.verifyCreated || config.dateWithin != 0,
+			func() error { return applyPolicyCreated(psi, message, config) }},
+		{"alg", len(config.allowedAlgs) > 0, func() error { return applyPolicyAlgs(psi, config) }},
+		{"key-size", config.minRSABits > 0 || config.minHMACKeyLen > 0,
+			func() error { return applyPolicyKeySize(verifier, config) }},
+		{"require-expires", config.requireExpires, func() error { return applyPolicyRequireExpires(psi, config) }},
+		{"expires", config.rejectExpired, func() error { return applyPolicyExpired(psi, config) }},
+		{"keyid", config.verifyKeyID, func() error { return applyPolicyKeyID(verifier, psi, config) }},
+		{"tag", config.expectedTag != "", func() error { return applyPolicyTag(psi, config) }},
+		{"nonce", config.requireNonce, func() error { return applyPolicyNonce(psi, config) }},
+		{"minimum-coverage", len(config.minCoverage.f) > 0, func() error { return applyPolicyCoverage(psi, config) }},
+		{"status", config.requireStatus, func() error { return applyPolicyStatus(message, psi, config) }},
+		{"range", config.requireRange, func() error { return applyPolicyRange(message, psi, config) }},
+	}
+	for _, c := range checks {
+		err := c.apply()
+		if c.enabled {
+			err = config.report.add(c.name, err)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	err4 := applyPolicyOthers(verifier, psi, config)
-	if err4 != nil {
-		return err4
+	return nil
+}
+
+func applyPolicyStatus(message parsedMessage, psi *psiSignature, config VerifyConfig) error {
+	if config.requireStatus {
+		if _, isResponse := message.derived["@status"]; isResponse && !psi.fields.coversName("@status") {
+			return fmt.Errorf("signature does not cover \"@status\"")
+		}
 	}
 	return nil
 }
 
-func applyPolicyOthers(verifier Verifier, psi *psiSignature, config VerifyConfig) error {
+func applyPolicyKeyID(verifier Verifier, psi *psiSignature, config VerifyConfig) error {
 	if config.verifyKeyID {
 		keyidParam, ok := psi.params["keyid"]
 		if ok {
@@ -428,10 +1109,107 @@ func applyPolicyOthers(verifier Verifier, psi *psiSignature, config VerifyConfig
 			if !ok {
 				return fmt.Errorf("malformed \"keyid\" parameter")
 			}
-			if keyID != verifier.keyID {
-				return fmt.Errorf("wrong keyid \"%s\"", keyID)
+			if !config.acceptsKeyID(verifier.keyID, keyID) {
+				return fmt.Errorf("%w \"%s\"", ErrKeyIDMismatch, keyID)
+			}
+		}
+	}
+	return nil
+}
+
+// signatureKeys returns the keys to verify a signature with the given key ID: the Verifier's own key, or for
+// another allowed key ID, the keys located by the configuration's key fetcher, with the Verifier's policy
+func (v Verifier) signatureKeys(ctx context.Context, keyID, alg string) ([]Verifier, error) {
+	config := v.config
+	if !config.verifyKeyID || len(config.allowedKeyIDs) == 0 || keyID == "" || keyID == v.keyID {
+		return []Verifier{v}, nil
+	}
+	if config.keyFetcher == nil {
+		return nil, fmt.Errorf("no key fetcher for key ID \"%s\", see VerifyConfig.SetKeyFetcher", keyID)
+	}
+	fetched, err := fetchKey(ctx, config.keyFetcher, keyID, alg)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Verifier
+	switch f := fetched.(type) {
+	case *Verifier:
+		keys = []Verifier{*f}
+	case *KeySet:
+		keys = f.Verifiers(keyID)
+	default:
+		return nil, fmt.Errorf("key fetcher returned %T for key \"%s\", expected *Verifier or *KeySet", fetched, keyID)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown key ID \"%s\"", keyID)
+	}
+	for i := range keys {
+		keys[i].config, keys[i].fields = config, v.fields
+		if err = applyPolicyKeySize(keys[i], *config); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// newKeysVerifyingWriter returns a writer that verifies the signature with any of the keys
+func newKeysVerifyingWriter(keys []Verifier) (io.Writer, func(sig []byte) (bool, error)) {
+	if len(keys) == 1 {
+		return keys[0].newVerifyingWriter()
+	}
+	return bufferedVerifier(func(buff, sig []byte) (bool, error) {
+		var firstErr error
+		for _, k := range keys {
+			verified, err := k.verify(buff, sig)
+			if verified && err == nil {
+				return true, nil
+			}
+			if firstErr == nil {
+				firstErr = err
 			}
 		}
+		return false, firstErr
+	})
+}
+
+func applyPolicyCoverage(psi *psiSignature, config VerifyConfig) error {
+	if missing := missingCoverage(psi.fields, config.minCoverage); len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func applyPolicyTag(psi *psiSignature, config VerifyConfig) error {
+	if config.expectedTag != "" {
+		if tag, _ := psi.params["tag"].(string); tag != config.expectedTag {
+			return fmt.Errorf("\"tag\" parameter is not \"%s\"", config.expectedTag)
+		}
+	}
+	return nil
+}
+
+func applyPolicyNonce(psi *psiSignature, config VerifyConfig) error {
+	if config.requireNonce {
+		nonce, ok := psi.params["nonce"]
+		if !ok {
+			return fmt.Errorf("missing \"nonce\" parameter")
+		}
+		if s, ok := nonce.(string); !ok || s == "" {
+			return fmt.Errorf("malformed \"nonce\" parameter")
+		}
+	}
+	return nil
+}
+
+func applyPolicyRequireExpires(psi *psiSignature, config VerifyConfig) error {
+	if config.requireExpires {
+		expires, ok := psi.params["expires"]
+		if !ok {
+			return ErrMissingExpires
+		}
+		if _, ok := expires.(int64); !ok {
+			return fmt.Errorf("malformed \"expires\" parameter")
+		}
 	}
 	return nil
 }
@@ -447,13 +1225,36 @@ func applyPolicyExpired(psi *psiSignature, config VerifyConfig) error {
 			}
 			expiresTime := time.Unix(expires, 0)
 			if now.After(expiresTime) {
-				return fmt.Errorf("expired signature")
+				return ErrSignatureExpired
 			}
 		}
 	}
 	return nil
 }
 
+// applyPolicyKeySize refuses RSA and HMAC keys that are shorter than configured, even if the signature is valid.
+// Keys of other types are not checked.
+func applyPolicyKeySize(verifier Verifier, config VerifyConfig) error {
+	switch k := verifier.key.(type) {
+	case rsa.PublicKey:
+		return checkRSABits(k.N.BitLen(), config)
+	case *rsa.PublicKey:
+		return checkRSABits(k.N.BitLen(), config)
+	case []byte:
+		if config.minHMACKeyLen > 0 && len(k) < config.minHMACKeyLen {
+			return fmt.Errorf("%w: HMAC key of %d bytes, the minimum is %d", ErrWeakKey, len(k), config.minHMACKeyLen)
+		}
+	}
+	return nil
+}
+
+func checkRSABits(bits int, config VerifyConfig) error {
+	if config.minRSABits > 0 && bits < config.minRSABits {
+		return fmt.Errorf("%w: RSA modulus of %d bits, the minimum is %d", ErrWeakKey, bits, config.minRSABits)
+	}
+	return nil
+}
+
 func applyPolicyAlgs(psi *psiSignature, config VerifyConfig) error {
 	if len(config.allowedAlgs) > 0 {
 		algParam, ok := psi.params["alg"]
@@ -471,7 +1272,20 @@ func applyPolicyAlgs(psi *psiSignature, config VerifyConfig) error {
 			}
 		}
 		if !algFound {
-			return fmt.Errorf("\"alg\" parameter not allowed by policy")
+			return fmt.Errorf("%w: \"%s\"", ErrAlgNotAllowed, alg)
+		}
+	}
+	return nil
+}
+
+func applyPolicyRequireCreated(psi *psiSignature, config VerifyConfig) error {
+	if config.requireCreated {
+		created, ok := psi.params["created"]
+		if !ok {
+			return ErrMissingCreated
+		}
+		if _, ok := created.(int64); !ok {
+			return fmt.Errorf("malformed \"created\" parameter")
 		}
 	}
 	return nil
@@ -485,7 +1299,7 @@ func applyPolicyCreated(psi *psiSignature, message parsedMessage, config VerifyC
 		now := time.Now()
 		createdParam, ok := psi.params["created"]
 		if !ok {
-			return fmt.Errorf("missing \"created\" parameter")
+			return ErrMissingCreated
 		}
 		created, ok := createdParam.(int64)
 		if !ok {
@@ -493,10 +1307,10 @@ func applyPolicyCreated(psi *psiSignature, message parsedMessage, config VerifyC
 		}
 		createdTime := time.Unix(created, 0)
 		if createdTime.After(now.Add(config.notNewerThan)) {
-			return fmt.Errorf("message appears to be too new, check for clock skew")
+			return ErrCreatedInFuture
 		}
 		if createdTime.Add(config.notOlderThan).Before(now) {
-			return fmt.Errorf("message is too old, check for replay")
+			return ErrSignatureTooOld
 		}
 
 		if config.dateWithin != 0 {
@@ -519,14 +1333,6 @@ func applyPolicyCreated(psi *psiSignature, message parsedMessage, config VerifyC
 	return nil
 }
 
-func verifySignature(verifier Verifier, input string, signature []byte) error {
-	verified, err := verifier.verify([]byte(input), signature)
-	if !verified && (err == nil) {
-		err = fmt.Errorf("bad signature, check key or signature value")
-	}
-	return err
-}
-
 type psiSignature struct {
 	signatureName string
 	origSigParams string
@@ -541,33 +1347,62 @@ func parseSignatureInput(input string, sigName string) (*psiSignature, error) {
 	}
 	memberForName, _ := sigs.Get(sigName)
 	fieldsList, ok := memberForName.(httpsfv.InnerList)
+	if !ok {
+		return nil, fmt.Errorf("Signature-Input: signature %s does not have an inner list", sigName)
+	}
 	osp, err := httpsfv.Marshal(fieldsList) // undocumented functionality
 	if err != nil {
 		return nil, fmt.Errorf("could not marshal inner list: %w", err)
 	}
-	if !ok {
-		return nil, fmt.Errorf("Signature-Input: signature %s does not have an inner list", sigName)
-	}
 	var f Fields
 	for _, ff := range fieldsList.Items {
 		fname, ok := ff.Value.(string)
 		if !ok {
 			return nil, fmt.Errorf("Signature-Input: value is not a string")
 		}
-		if ff.Params == nil || len(ff.Params.Names()) == 0 {
-			f.f = append(f.f, *fromHeaderName(fname))
+		var flagNames []string
+		req, tr := false, false
+		if ff.Params != nil {
+			for _, p := range ff.Params.Names() {
+				if p != "req" && p != "tr" {
+					flagNames = append(flagNames, p)
+					continue
+				}
+				if b, _ := ff.Params.Get(p); b != true {
+					return nil, fmt.Errorf("parameter \"%s\" of \"%s\" must be true", p, fname)
+				}
+				req = req || p == "req"
+				tr = tr || p == "tr"
+			}
+		}
+		if len(flagNames) == 0 {
+			nf := fromHeaderName(fname)
+			nf.req = req
+			nf.tr = tr
+			f.f = append(f.f, *nf)
 		} else {
-			if len(ff.Params.Names()) > 1 {
+			if len(flagNames) > 1 {
 				return nil, fmt.Errorf("more than one param for \"%s\"", fname)
 			}
-			flagNames := ff.Params.Names()
 			flagName := flagNames[0]
 			flagValue, _ := ff.Params.Get(flagName)
-			fv := flagValue.(string)
+			var fv string
+			if isBooleanFlag(flagName) {
+				if b, ok := flagValue.(bool); !ok || !b {
+					return nil, fmt.Errorf("parameter \"%s\" of \"%s\" must be true", flagName, fname)
+				}
+			} else {
+				fv, ok = flagValue.(string)
+				if !ok {
+					return nil, fmt.Errorf("parameter \"%s\" of \"%s\" is not a string", flagName, fname)
+				}
+			}
 			f.f = append(f.f, field{
 				name:      fname,
 				flagName:  flagName,
 				flagValue: fv,
+				req:       req,
+				tr:        tr,
 			})
 		}
 	}
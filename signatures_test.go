@@ -13,8 +13,10 @@ import (
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
 	"strings"
 	"testing"
@@ -431,7 +433,7 @@ func TestSignRequest(t *testing.T) {
 			args: args{
 				signatureName: "sig-b25",
 				signer: (func() Signer {
-					config := NewSignConfig().SignAlg(false).setFakeCreated(1618884473)
+					config := NewSignConfig().SignAlg(false).SetCreated(1618884473)
 					fields := Headers("date", "@authority", "content-type")
 					key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
 					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, config, fields)
@@ -448,11 +450,11 @@ func TestSignRequest(t *testing.T) {
 			args: args{
 				signatureName: "sig1",
 				signer: (func() Signer {
-					config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+					config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 					fields := Headers("@authorityxx", "date", "content-type")
 					key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
-					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, config, fields)
-					return *signer
+					// NewHMACSHA256Signer would reject the fields, test that signing does too
+					return Signer{keyID: "test-shared-secret", key: key, alg: "hmac-sha256", config: config, fields: fields}
 				})(),
 				req: readRequest(httpreq1),
 			},
@@ -465,7 +467,7 @@ func TestSignRequest(t *testing.T) {
 			args: args{
 				signatureName: "sig1",
 				signer: (func() Signer {
-					config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+					config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 					fields := Headers("@authority", "date-not-really", "content-type")
 					key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
 					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, config, fields)
@@ -486,7 +488,7 @@ func TestSignRequest(t *testing.T) {
 					if err != nil {
 						t.Errorf("cannot parse private key: %v", err)
 					}
-					config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+					config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 					fields := *NewFields()
 					signer, _ := NewRSAPSSSigner("test-key-rsa-pss", *prvKey, config, fields)
 					return *signer
@@ -501,7 +503,7 @@ func TestSignRequest(t *testing.T) {
 			name: "sign request: malicious request",
 			args: args{
 				signatureName: "sig1",
-				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).setFakeCreated(1618884475), *NewFields()),
+				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).SetCreated(1618884475), *NewFields()),
 				req:           readRequest(httpreq3),
 			},
 			want:    "",
@@ -512,7 +514,7 @@ func TestSignRequest(t *testing.T) {
 			name: "sign request: empty sig name",
 			args: args{
 				signatureName: "",
-				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).setFakeCreated(1618884475), *NewFields()),
+				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).SetCreated(1618884475), *NewFields()),
 				req:           readRequest(httpreq1),
 			},
 			want:    "",
@@ -523,7 +525,7 @@ func TestSignRequest(t *testing.T) {
 			name: "sign request: missing required field",
 			args: args{
 				signatureName: "sig1",
-				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).setFakeCreated(1618884475), *NewFields().AddHeader("Missing")),
+				signer:        makeRSAPSSSigner(t, *NewSignConfig().SignAlg(false).SetCreated(1618884475), *NewFields().AddHeader("Missing")),
 				req:           readRequest(httpreq1),
 			},
 			want:    "",
@@ -580,7 +582,7 @@ func TestSignRequestDiscardSig(t *testing.T) {
 				signatureName: "sig-b21",
 				signer: (func() Signer {
 					config := NewSignConfig().SignAlg(false).
-						setFakeCreated(1618884473).SetNonce("b3k2pp5k7z-50gnwp.yemd")
+						SetCreated(1618884473).SetNonce("b3k2pp5k7z-50gnwp.yemd")
 					fields := *NewFields()
 					prvKey, err := loadRSAPSSPrivateKey(rsaPSSPrvKey)
 					if err != nil {
@@ -626,7 +628,7 @@ func readResponse(s string) *http.Response {
 }
 
 func TestSignAndVerifyHMAC(t *testing.T) {
-	config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+	config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 	fields := Headers("@authority", "date", "content-type")
 	signatureName := "sig1"
 	key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
@@ -642,7 +644,7 @@ func TestSignAndVerifyHMAC(t *testing.T) {
 }
 
 func TestSignAndVerifyHMACBad(t *testing.T) {
-	config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+	config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 	fields := Headers("@authority", "date", "content-type")
 	signatureName := "sig1"
 	key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
@@ -663,7 +665,7 @@ func TestCreated(t *testing.T) {
 		fields := Headers("@status", "date", "content-type")
 		signatureName := "sigres"
 		key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
-		signConfig := NewSignConfig().SignCreated(true).setFakeCreated(createdTime)
+		signConfig := NewSignConfig().SignCreated(true).SetCreated(createdTime)
 		signer, _ := NewHMACSHA256Signer("test-shared-secret", key, signConfig, fields)
 		res := readResponse(httpres2)
 		nowStr := time.Now().UTC().Format(http.TimeFormat)
@@ -746,7 +748,7 @@ func TestSignAndVerifyResponseHMAC(t *testing.T) {
 }
 
 func TestSignAndVerifyRSAPSS(t *testing.T) {
-	config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+	config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 	fields := Headers("@authority", "date", "content-type")
 	signatureName := "sig1"
 	prvKey, err := loadRSAPSSPrivateKey(rsaPSSPrvKey)
@@ -773,7 +775,7 @@ func TestSignAndVerifyRSAPSS(t *testing.T) {
 }
 
 func TestSignAndVerifyRSA(t *testing.T) {
-	config := NewSignConfig().SignAlg(false).setFakeCreated(1618884475)
+	config := NewSignConfig().SignAlg(false).SetCreated(1618884475)
 	fields := Headers("@authority", "date", "content-type")
 	signatureName := "sig1"
 	prvKey, err := parseRsaPrivateKeyFromPemStr(rsaPrvKey)
@@ -800,7 +802,7 @@ func TestSignAndVerifyRSA(t *testing.T) {
 }
 
 func TestSignAndVerifyP256(t *testing.T) {
-	config := NewSignConfig().setFakeCreated(1618884475)
+	config := NewSignConfig().SetCreated(1618884475)
 	signatureName := "sig1"
 	prvKey, err := parseECPrivateKeyFromPemStr(p256PrvKey)
 	if err != nil {
@@ -829,12 +831,45 @@ func TestSignAndVerifyP256(t *testing.T) {
 	}
 }
 
+func TestSignAndVerifyP384(t *testing.T) {
+	config := NewSignConfig().SetCreated(1618884475)
+	signatureName := "sig1"
+	prvKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Errorf("cannot generate private key")
+	}
+	fields := *NewFields().AddHeader("@method").AddHeader("Date").AddHeader("Content-Type").AddQueryParam("pet")
+	signer, _ := NewP384Signer("test-key-p384", *prvKey, config, fields)
+	req := readRequest(httpreq2)
+	sigInput, sig, err := SignRequest(signatureName, *signer, req)
+	if err != nil {
+		t.Errorf("signature failed: %v", err)
+	}
+	assert.Contains(t, sigInput, `alg="ecdsa-p384-sha384"`)
+	req.Header.Add("Signature", sig)
+	req.Header.Add("Signature-Input", sigInput)
+	verifier, err := NewP384Verifier("test-key-p384", prvKey.PublicKey, NewVerifyConfig().SetVerifyCreated(false), fields)
+	if err != nil {
+		t.Errorf("could not generate Verifier: %s", err)
+	}
+	err = VerifyRequest(signatureName, *verifier, req)
+	if err != nil {
+		t.Errorf("verification error: %s", err)
+	}
+
+	p256Key, _ := parseECPrivateKeyFromPemStr(p256PrvKey)
+	_, err = NewP384Signer("test-key-p384", *p256Key, config, fields)
+	assert.Error(t, err, "P-256 key")
+	_, err = NewP384Verifier("test-key-p384", p256Key.PublicKey, nil, fields)
+	assert.Error(t, err, "P-256 key")
+}
+
 func TestSignAndVerifyEdDSA(t *testing.T) {
 	pubKey1, prvKey1, err := ed25519.GenerateKey(nil) // Need some tweaking for RFC 8032 keys, see package doc
 	if err != nil {
 		t.Errorf("cannot generate keypair: %s", err)
 	}
-	config := NewSignConfig().setFakeCreated(1618884475)
+	config := NewSignConfig().SetCreated(1618884475)
 	fields := *NewFields().AddHeader("@method").AddHeader("Date").AddHeader("Content-Type").AddQueryParam("pet")
 	signer1, _ := NewEd25519Signer("test-key-ed25519", prvKey1, config, fields)
 
@@ -851,6 +886,11 @@ func TestSignAndVerifyEdDSA(t *testing.T) {
 	signer2, _ := NewEd25519SignerFromSeed("test-key-ed25519", seed2, config, fields)
 
 	signAndVerifyEdDSA(t, signer2, pubKey2, fields)
+
+	_, err = NewEd25519Signer("test-key-ed25519", prvKey1[:ed25519.SeedSize], config, fields)
+	assert.Error(t, err, "short private key")
+	_, err = NewEd25519Verifier("test-key-ed25519", pubKey1[:16], nil, fields)
+	assert.Error(t, err, "short public key")
 }
 
 func signAndVerifyEdDSA(t *testing.T, signer *Signer, pubKey ed25519.PublicKey, fields Fields) {
@@ -891,7 +931,7 @@ func TestSignResponse(t *testing.T) {
 				signatureName: "sig1",
 				signer: (func() Signer {
 					key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
-					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, NewSignConfig().setFakeCreated(1618889999), Headers(
+					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, NewSignConfig().SetCreated(1618889999), Headers(
 						"@status", "date", "content-type"))
 					return *signer
 				})(),
@@ -907,7 +947,7 @@ func TestSignResponse(t *testing.T) {
 				signatureName: "sig1",
 				signer: (func() Signer {
 					key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
-					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, NewSignConfig().setFakeCreated(1618889999), Headers(
+					signer, _ := NewHMACSHA256Signer("test-shared-secret", key, NewSignConfig().SetCreated(1618889999), Headers(
 
 						"@status", "date", "content-type",
 					))
@@ -1472,7 +1512,8 @@ func TestVerifyResponse(t *testing.T) {
 					if err != nil {
 						t.Errorf("cannot parse public key: %v", err)
 					}
-					verifier, _ := NewP256Verifier("test-key-ecc-p256", *pubKey, NewVerifyConfig().SetVerifyCreated(false), *NewFields())
+					// The draft's Content-Digest for this example does not match its body
+					verifier, _ := NewP256Verifier("test-key-ecc-p256", *pubKey, NewVerifyConfig().SetVerifyCreated(false).SetVerifyDigest(false), *NewFields())
 					return *verifier
 				})(),
 				res: readResponse(httpres4),
@@ -1488,3 +1529,595 @@ func TestVerifyResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestUnknownComponent(t *testing.T) {
+	req := readRequest(httpreq1)
+	req.Header.Add("Signature-Input", `sig1=("@method" "@future-component");alg="hmac-sha256";keyid="key1"`)
+	req.Header.Add("Signature", `sig1=:3e9KqLP62NHfHY5OMG4036+U6tvBowZF35ALzTjpsf0=:`)
+	verifier, err := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{1}, 64), NewVerifyConfig().SetVerifyCreated(false), Headers("@method"))
+	assert.NoError(t, err)
+	err = VerifyRequest("sig1", *verifier, req)
+	var unknown *UnknownComponentError
+	if assert.ErrorAs(t, err, &unknown, "expected an UnknownComponentError") {
+		assert.Equal(t, "@future-component", unknown.Component)
+	}
+
+	_, err = NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), nil, Headers("@method", "@future-component"))
+	assert.ErrorAs(t, err, &unknown, "creating the signer should fail with an UnknownComponentError")
+}
+
+func Test_validateLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		label     string
+		maxLength int
+		wantErr   bool
+	}{
+		{"simple", "sig1", 64, false},
+		{"all allowed characters", "*proxy_sig-1.a*", 64, false},
+		{"empty", "", 64, true},
+		{"uppercase", "Sig1", 64, true},
+		{"leading digit", "1sig", 64, true},
+		{"separator", "sig1=x", 64, true},
+		{"space", "sig 1", 64, true},
+		{"too long", strings.Repeat("a", 65), 64, true},
+		{"no limit", strings.Repeat("a", 1000), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLabel(tt.label, tt.maxLength); (err != nil) != tt.wantErr {
+				t.Errorf("validateLabel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignAndVerifyBadLabel(t *testing.T) {
+	signer := makeHMACSigner(*NewSignConfig().SetMaxLabelLength(4), Headers("@method"))
+	_, _, err := SignRequest("sig1,sig2=:AAAA:", signer, readRequest(httpreq1))
+	assert.Error(t, err, "hostile label should be rejected")
+	_, _, err = SignRequest("sig12", signer, readRequest(httpreq1))
+	assert.Error(t, err, "label is too long")
+
+	verifier, err := NewHMACSHA256Verifier("test-shared-secret", bytes.Repeat([]byte{1}, 64), nil, Headers("@method"))
+	assert.NoError(t, err)
+	err = VerifyRequest("Sig1", *verifier, readRequest(httpreq1))
+	assert.Error(t, err, "uppercase label should be rejected")
+}
+
+func TestUnsafeHeaderValues(t *testing.T) {
+	makeReq := func() *http.Request {
+		req := readRequest(httpreq1)
+		req.Header.Set("X-Name", "caf\xc3\xa9")
+		return req
+	}
+	fields := Headers("@method", "x-name")
+	verifier, err := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{0x33}, 64), NewVerifyConfig(), fields)
+	assert.NoError(t, err)
+
+	signer := makeHMACSigner(*NewSignConfig(), fields)
+	req := makeReq()
+	sigInput, sig, err := SignRequest("sig1", signer, req)
+	assert.NoError(t, err, "default policy should sign as is")
+	assert.Contains(t, sigInput, `"x-name"`)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	signer = makeHMACSigner(*NewSignConfig().SetUnsafeValuePolicy(UnsafeValueReject), fields)
+	_, _, err = SignRequest("sig1", signer, makeReq())
+	var unsafe *UnsafeHeaderValueError
+	assert.ErrorAs(t, err, &unsafe, "reject policy should reject")
+
+	signer = makeHMACSigner(*NewSignConfig().SetUnsafeValuePolicy(UnsafeValueWrap), fields)
+	req = makeReq()
+	sigInput, sig, err = SignRequest("sig1", signer, req)
+	assert.NoError(t, err)
+	assert.Contains(t, sigInput, `"x-name";bs`)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req), "wrapped header should satisfy a verifier that requires it")
+
+	signer = makeHMACSigner(*NewSignConfig().SetUnsafeValuePolicy(UnsafeValueSanitize), fields)
+	req = makeReq()
+	sigInput, sig, err = SignRequest("sig1", signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "caf??", req.Header.Get("X-Name"), "header should be sanitized in place")
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req), "sanitized header should verify")
+}
+
+func TestSignatureParamsOrder(t *testing.T) {
+	config := NewSignConfig().SetCreated(1618884475).SetExpires(1618884775).SetNonce("abc").SetTag("app")
+	signer := makeHMACSigner(*config, Headers("@method"))
+	sigInputHeader, _, sigInput, err := signRequestDebug("sig1", signer, readRequest(httpreq1))
+	assert.NoError(t, err)
+	wantParams := `("@method");created=1618884475;expires=1618884775;nonce="abc";alg="hmac-sha256";keyid="test-key-hmac";tag="app"`
+	assert.Equal(t, "sig1="+wantParams, sigInputHeader, "Signature-Input header")
+	assert.True(t, strings.HasSuffix(sigInput, `"@signature-params": `+wantParams), "signature input must match the header")
+}
+
+func TestPinnedSchemeAndAuthority(t *testing.T) {
+	fields := Headers("@scheme", "@authority", "@target-uri")
+	signer := makeHMACSigner(*NewSignConfig(), fields)
+	clientReq, err := http.NewRequest("GET", "https://api.example.com/foo?a=b", nil)
+	assert.NoError(t, err)
+	sigInput, sig, err := SignRequest("sig1", signer, clientReq)
+	assert.NoError(t, err)
+
+	// The same request, as seen by a server behind a TLS-terminating load balancer
+	serverReq := readRequest("GET /foo?a=b HTTP/1.1\nHost: internal:8080\n\n")
+	serverReq.Header.Add("Signature-Input", sigInput)
+	serverReq.Header.Add("Signature", sig)
+	key := bytes.Repeat([]byte{0x33}, 64)
+	verifier, _ := NewHMACSHA256Verifier("test-key-hmac", key, NewVerifyConfig(), fields)
+	assert.Error(t, VerifyRequest("sig1", *verifier, serverReq), "derived components differ")
+	verifier, _ = NewHMACSHA256Verifier("test-key-hmac", key,
+		NewVerifyConfig().SetScheme("https").SetAuthority("api.example.com"), fields)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, serverReq), "pinned derived components should match")
+	assert.Equal(t, "", serverReq.URL.Scheme, "request should not be modified")
+}
+
+func TestSignResponseForRequest(t *testing.T) {
+	req := readRequest(httpreq2)
+	clientSigner := makeHMACSigner(*NewSignConfig(), Headers("@method", "@path"))
+	sigInput, sig, err := SignRequest("sig1", clientSigner, req)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+
+	res := readResponse(httpres2)
+	res.Header.Set("Content-Digest", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+	config := NewSignConfig()
+	serverSigner := makeHMACSigner(*config, Headers("date"))
+	sigInput, sig, err = SignResponseForRequest("sig2", serverSigner, res, req, "sig1")
+	assert.NoError(t, err)
+	assert.Contains(t, sigInput,
+		`("@status" "content-type" "content-digest" "@method";req "@target-uri";req "signature";req;key="sig1" "date")`)
+	assert.Equal(t, Headers("date"), serverSigner.fields, "signer should not be modified")
+	assert.Nil(t, res.Request, "response should not be modified")
+	assert.NoError(t, AddSignature(res.Header, sigInput, sig))
+
+	verifier, err := NewHMACSHA256Verifier("test-key-hmac", bytes.Repeat([]byte{0x33}, 64), NewVerifyConfig(),
+		*NewFields().AddHeaders("@status", "content-digest").AddRequestDictHeader("signature", "sig1"))
+	assert.NoError(t, err)
+	res.Request = req
+	assert.NoError(t, VerifyResponse("sig2", *verifier, res))
+
+	// a response to another request does not verify
+	otherReq := readRequest(httpreq2)
+	sigInput, sig, err = SignRequest("sig1", makeHMACSigner(*NewSignConfig().SetNonce("other"), Headers("@method", "@path")), otherReq)
+	assert.NoError(t, err)
+	assert.NoError(t, AddSignature(otherReq.Header, sigInput, sig))
+	res.Request = otherReq
+	assert.Error(t, VerifyResponse("sig2", *verifier, res))
+
+	_, _, err = SignResponseForRequest("sig2", serverSigner, readResponse(httpres2), readRequest(httpreq2), "sig1")
+	assert.Error(t, err, "request is not signed")
+}
+
+func TestSignLabelConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  []string
+		label     string
+		policy    LabelConflictPolicy
+		wantLabel string
+		wantErr   bool
+	}{
+		{"no conflict", []string{"sig1"}, "sig9", LabelConflictFail, "sig9", false},
+		{"conflict fails", []string{"sig1"}, "sig1", LabelConflictFail, "", true},
+		{"rename increments suffix", []string{"sig1", "sig2"}, "sig1", LabelConflictRename, "sig3", false},
+		{"rename adds suffix", []string{"proxy"}, "proxy", LabelConflictRename, "proxy2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := readRequest(httpreq1)
+			for _, l := range tt.existing {
+				sigInput, sig, err := SignRequest(l, makeHMACSigner(*NewSignConfig(), Headers("@method")), req)
+				assert.NoError(t, err)
+				assert.NoError(t, AddSignature(req.Header, sigInput, sig))
+			}
+			signer := makeHMACSigner(*NewSignConfig().SetLabelConflictPolicy(tt.policy), Headers("@method"))
+			sigInput, _, err := SignRequest(tt.label, signer, req)
+			if tt.wantErr {
+				var conflict *LabelConflictError
+				assert.True(t, errors.As(err, &conflict), "expected a LabelConflictError")
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(sigInput, tt.wantLabel+"="), "unexpected label in %s", sigInput)
+		})
+	}
+}
+
+func TestQueryParam(t *testing.T) {
+	// RFC 9421, Sec. 2.2.8
+	req, err := http.NewRequest("GET", "https://example.com/parameters?var=this%20is%20a%20big%0Avalue&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something&utm_source=x", nil)
+	assert.NoError(t, err)
+	fields := *NewFields().AddQueryParam("var").AddQueryParam("bar").AddQueryParam("façade\": ")
+	signer, err := NewHMACSHA256Signer("key1", bytes.Repeat([]byte{1}, 64), NewSignConfig().SetCreated(1618884475), fields)
+	assert.NoError(t, err)
+	sigInput, sig, base, err := signRequestDebug("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, `"@query-param";name="var": this%20is%20a%20big%0Avalue
+"@query-param";name="bar": with%20plus%20whitespace
+"@query-param";name="fa%C3%A7ade%22%3A%20": something
+"@signature-params": ("@query-param";name="var" "@query-param";name="bar" "@query-param";name="fa%C3%A7ade%22%3A%20");created=1618884475;alg="hmac-sha256";keyid="key1"`, base)
+
+	req.Header.Set("Signature-Input", sigInput)
+	req.Header.Set("Signature", sig)
+	verifier, err := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{1}, 64), NewVerifyConfig().SetVerifyCreated(false), fields)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	req.URL.RawQuery += "&utm_campaign=y" // tracking parameters are not signed
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	req.URL.RawQuery = strings.Replace(req.URL.RawQuery, "bar=with", "bar=without", 1)
+	assert.Error(t, VerifyRequest("sig1", *verifier, req))
+}
+
+func TestRequireStatusCoverage(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("date"))
+	res := readResponse(httpres1)
+	sigInput, sig, err := SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), *NewFields())
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+	strict, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetRequireStatusCoverage(true), *NewFields())
+	assert.Error(t, VerifyResponse("sig1", *strict, res), "status is not covered")
+}
+
+func TestStructuredFieldReserialization(t *testing.T) {
+	tests := []struct {
+		name     string
+		hdr      string
+		value    string
+		reformat string
+		want     string
+		wantErr  bool
+	}{
+		{"dictionary", "Example-Dict", "a=1,    b=2;x=1;y=2,   c=(a   b   c)", "a=1, b=2;x=1;y=2, c=(a b c)",
+			`"example-dict";sf: a=1, b=2;x=1;y=2, c=(a b c)`, false},
+		{"registered list", "Cache-Status", `ExampleCache; hit,OriginCache;fwd=uri-miss`, `ExampleCache;hit, OriginCache;fwd=uri-miss`,
+			`"cache-status";sf: ExampleCache;hit, OriginCache;fwd=uri-miss`, false},
+		{"registered item", "Client-Cert", `:AAEC:`, `:AAEC:`, `"client-cert";sf: :AAEC:`, false},
+		{"inferred list", "Example-List", `"a",  "b"`, `"a", "b"`, `"example-list";sf: "a", "b"`, false},
+		{"inferred item", "Example-Integer", `  42`, `42`, `"example-integer";sf: 42`, false},
+		{"not structured", "Example-Header", `a b (c`, "", "", true},
+		{"registered dictionary with wrong type", "Priority", `"u"`, "", "", true},
+	}
+	key := bytes.Repeat([]byte{1}, 64)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := *NewFields().AddStructuredField(tt.hdr)
+			signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), fields)
+			req := readRequest(httpreq1)
+			req.Header.Set(tt.hdr, tt.value)
+			sigInput, sig, base, err := signRequestDebug("sig1", *signer, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, strings.Split(base, "\n")[0])
+			req.Header.Set(tt.hdr, tt.reformat) // e.g. by an intermediary
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), fields)
+			assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+		})
+	}
+}
+
+func TestByteSequenceHeader(t *testing.T) {
+	// RFC 9421, Sec. 2.1.3
+	key := bytes.Repeat([]byte{1}, 64)
+	fields := *NewFields().AddByteSequenceHeader("Example-Header")
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), fields)
+	req := readRequest(httpreq1)
+	req.Header.Add("Example-Header", "value, with, lots")
+	req.Header.Add("Example-Header", "of, commas")
+	sigInput, sig, base, err := signRequestDebug("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, `"example-header";bs: :dmFsdWUsIHdpdGgsIGxvdHM=:, :b2YsIGNvbW1hcw==:`, strings.Split(base, "\n")[0])
+	assert.Equal(t, `sig1=("example-header";bs);alg="hmac-sha256";keyid="key1"`, sigInput)
+
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), fields)
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	req.Header["Example-Header"] = []string{"value, with, lots, of, commas"} // same folded value
+	assert.Error(t, VerifyRequest("sig1", *verifier, req))
+}
+
+func TestDictHeaderMember(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	fields := *NewFields().AddHeader("@method").AddDictHeader("Cache-Control", "max-age").AddDictHeader("Prefer", "wait")
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), fields)
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), fields)
+	req := readRequest(httpreq1)
+	req.Header.Set("Prefer", "respond-async,  wait=100")
+	sigInput, sig, base, err := signRequestDebug("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, `"@method": POST
+"cache-control";key="max-age": 60
+"prefer";key="wait": 100
+"@signature-params": ("@method" "cache-control";key="max-age" "prefer";key="wait");alg="hmac-sha256";keyid="key1"`, base)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	req.Header.Set("Prefer", "wait=100, handling=lenient") // other members are not covered
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+	req.Header.Set("Prefer", "wait=10")
+	assert.Error(t, VerifyRequest("sig1", *verifier, req))
+	req.Header.Set("Prefer", "respond-async")
+	assert.Error(t, VerifyRequest("sig1", *verifier, req), "member is missing")
+
+	listSigner, _ := NewHMACSHA256Signer("key1", key, nil, *NewFields().AddDictHeader("Cache-Status", "a"))
+	req = readRequest(httpreq1)
+	req.Header.Set("Cache-Status", "a")
+	_, _, err = SignRequest("sig1", *listSigner, req)
+	assert.Error(t, err, "cache-status is a list")
+}
+
+func TestRequestComponents(t *testing.T) {
+	// RFC 9421, Sec. 2.4
+	key := bytes.Repeat([]byte{1}, 64)
+	req := readRequest(httpreq1)
+	reqSigner, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), Headers("@method"))
+	sigInput, sig, err := SignRequest("sig1", *reqSigner, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	reqSig := strings.TrimPrefix(sig, "sig1=")
+
+	fields := *NewFields().AddHeaders("@status", "content-type").AddRequestDictHeader("Signature", "sig1").
+		AddRequestHeaders("@authority", "@method").AddRequestQueryParam("pet")
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), fields)
+	res := readResponse(httpres1)
+	res.Request = req
+	sigInput, sig, err = SignResponse("sig2", *signer, res)
+	assert.NoError(t, err)
+	base, err := ResponseSignatureInput(res, fields, strings.TrimPrefix(sigInput, "sig2="))
+	assert.NoError(t, err)
+	assert.Equal(t, `"@status": 200
+"content-type": application/json
+"signature";req;key="sig1": `+reqSig+`
+"@authority";req: example.com
+"@method";req: POST
+"@query-param";req;name="pet": dog
+"@signature-params": ("@status" "content-type" "signature";req;key="sig1" "@authority";req "@method";req "@query-param";req;name="pet");alg="hmac-sha256";keyid="key1"`, base)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), fields)
+	assert.NoError(t, VerifyResponse("sig2", *verifier, res))
+	req.Method = "PUT"
+	assert.Error(t, VerifyResponse("sig2", *verifier, res), "request was modified")
+	res.Request = nil
+	assert.Error(t, VerifyResponse("sig2", *verifier, res), "request is not available")
+
+	_, _, err = SignRequest("sig3", *signer, readRequest(httpreq1))
+	assert.Error(t, err, "req is only valid in a response")
+}
+
+var httpresTrailer = `HTTP/1.1 200 OK
+Content-Type: text/plain
+Transfer-Encoding: chunked
+Trailer: Expires
+
+4
+HTTP
+8
+ Message
+b
+ Signatures
+0
+Expires: Wed, 9 Nov 2022 07:28:00 GMT
+
+`
+
+func TestTrailers(t *testing.T) {
+	// RFC 9421, Sec. 2.1.4
+	key := bytes.Repeat([]byte{1}, 64)
+	read := func(s string) *http.Response {
+		return readResponse(strings.ReplaceAll(s, "\n", "\r\n")) // chunked encoding requires CRLF
+	}
+	fields := *NewFields().AddHeaders("@status").AddTrailers("Expires") // Go removes the "Trailer" header
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig().SignCreated(false), fields)
+	res := read(httpresTrailer)
+	sigInput, sig, err := SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	assert.Equal(t, `sig1=("@status" "expires";tr);alg="hmac-sha256";keyid="key1"`, sigInput)
+	base, err := ResponseSignatureInput(res, fields, strings.TrimPrefix(sigInput, "sig1="))
+	assert.NoError(t, err)
+	assert.Equal(t, `"@status": 200
+"expires";tr: Wed, 9 Nov 2022 07:28:00 GMT
+"@signature-params": ("@status" "expires";tr);alg="hmac-sha256";keyid="key1"`, base)
+	b, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "HTTP Message Signatures", string(b), "body should be readable after signing")
+
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), fields)
+	res = read(httpresTrailer)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	assert.Error(t, VerifyResponse("sig1", *verifier, res), "trailers are not enabled")
+	b, _ = io.ReadAll(res.Body)
+	assert.Equal(t, "HTTP Message Signatures", string(b), "body should not be read")
+
+	verifier, _ = NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false).SetVerifyTrailers(10), fields)
+	res = read(httpresTrailer)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	var tooLarge *BodyTooLargeError
+	assert.ErrorAs(t, VerifyResponse("sig1", *verifier, res), &tooLarge)
+
+	verifier, _ = NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false).SetVerifyTrailers(1024), fields)
+	res = read(httpresTrailer)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+	res = read(strings.Replace(httpresTrailer, "2022", "2032", 1))
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+	assert.Error(t, VerifyResponse("sig1", *verifier, res), "trailer was modified")
+}
+
+func TestRequireNonce(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	tests := []struct {
+		name    string
+		config  *SignConfig
+		wantErr bool
+	}{
+		{"nonce", NewSignConfig().SetNonce("abc"), false},
+		{"no nonce", NewSignConfig(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", key, tt.config, Headers("@method"))
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig(), Headers("@method"))
+			assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+			strict, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetRequireNonce(true), Headers("@method"))
+			err = VerifyRequest("sig1", *strict, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExpectedTag(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	tests := []struct {
+		name     string
+		tag      string
+		expected string
+		wantErr  bool
+	}{
+		{"matching tag", "app-a", "app-a", false},
+		{"other tag", "app-b", "app-a", true},
+		{"no tag", "", "app-a", true},
+		{"any tag", "app-b", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetTag(tt.tag), Headers("@method"))
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetExpectedTag(tt.expected), Headers("@method"))
+			err = VerifyRequest("sig1", *verifier, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerificationErrors(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	tests := []struct {
+		name      string
+		sign      *SignConfig
+		verify    *VerifyConfig
+		keyID     string
+		verifyKey []byte
+		fields    Fields
+		want      error
+	}{
+		{"bad signature", NewSignConfig(), NewVerifyConfig(), "key", bytes.Repeat([]byte{8}, 64), Headers("@method"), ErrBadSignature},
+		{"expired", NewSignConfig().SetExpires(1), NewVerifyConfig(), "key", key, Headers("@method"), ErrSignatureExpired},
+		{"in the future", NewSignConfig().SetCreated(time.Now().Add(time.Hour).Unix()), NewVerifyConfig(), "key", key,
+			Headers("@method"), ErrCreatedInFuture},
+		{"too old", NewSignConfig().SetCreated(1000), NewVerifyConfig(), "key", key, Headers("@method"), ErrSignatureTooOld},
+		{"key ID", NewSignConfig(), NewVerifyConfig(), "other", key, Headers("@method"), ErrKeyIDMismatch},
+		{"missing field", NewSignConfig(), NewVerifyConfig(), "key", key, Headers("@method", "content-type"), ErrMissingField},
+		{"alg", NewSignConfig(), NewVerifyConfig().SetAllowedAlgs([]string{"ed25519"}), "key", key, Headers("@method"), ErrAlgNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, _ := NewHMACSHA256Signer("key", key, tt.sign, Headers("@method"))
+			req := readRequest(httpreq1)
+			sigInput, sig, err := SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+			verifier, _ := NewHMACSHA256Verifier(tt.keyID, tt.verifyKey, tt.verify, tt.fields)
+			err = VerifyRequest("sig1", *verifier, req)
+			assert.True(t, errors.Is(err, tt.want), "got %v", err)
+		})
+	}
+}
+
+func TestSignatureBaseHook(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	var signedLabel, signedBase, verifiedLabel, verifiedBase string
+	signConfig := NewSignConfig().SetSignatureBaseHook(func(label, base string) {
+		signedLabel, signedBase = label, base
+	})
+	signer, _ := NewHMACSHA256Signer("key", key, signConfig, Headers("@method", "content-type"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "sig1", signedLabel)
+	assert.True(t, strings.HasPrefix(signedBase, "\"@method\": POST\n\"content-type\": application/json\n"), signedBase)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	// The hook is also called when the signature does not verify
+	verifyConfig := NewVerifyConfig().SetSignatureBaseHook(func(label, base string) {
+		verifiedLabel, verifiedBase = label, base
+	})
+	verifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{8}, 64), verifyConfig, Headers("@method"))
+	err = VerifyRequest("sig1", *verifier, req)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.Equal(t, "sig1", verifiedLabel)
+	assert.Equal(t, signedBase, verifiedBase)
+}
+
+func TestSetCookie(t *testing.T) {
+	key := make([]byte, 64)
+	res := readResponse(`HTTP/1.1 200 OK
+Date: Tue, 20 Apr 2021 02:07:56 GMT
+Set-Cookie: id=a3fWa; Expires=Thu, 21 Oct 2021 07:28:00 GMT
+Set-Cookie: lang=en-US; Path=/
+Content-Length: 0
+
+`)
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetCreated(1618884475), Headers("@status", "set-cookie"))
+	sigInput, sig, err := SignResponse("sig1", *signer, res)
+	assert.NoError(t, err)
+	assert.Equal(t, `sig1=("@status" "set-cookie";bs);created=1618884475;alg="hmac-sha256";keyid="key"`, sigInput)
+	res.Header.Add("Signature-Input", sigInput)
+	res.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false), Headers("@status", "set-cookie"))
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res), "a byte sequence satisfies the plain set-cookie requirement")
+
+	res.Header["Set-Cookie"] = []string{"id=a3fWa; Expires=Thu", "21 Oct 2021 07:28:00 GMT, lang=en-US; Path=/"}
+	assert.Error(t, VerifyResponse("sig1", *verifier, res), "cookies split differently")
+
+	// a single cookie is signed as is
+	single := readResponse(httpres2)
+	single.Header.Add("Set-Cookie", "id=a3fWa; Expires=Thu, 21 Oct 2021 07:28:00 GMT")
+	sigInput, _, err = SignResponse("sig1", *signer, single)
+	assert.NoError(t, err)
+	assert.Contains(t, sigInput, `("@status" "set-cookie")`)
+}
@@ -0,0 +1,77 @@
+package httpsign
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Timeouts bounds the time spent verifying a message, so that a slow key backend or a slow-drip request body
+// cannot stall the caller indefinitely. Total applies to the whole verification, and the other members
+// to individual stages. A zero value means no limit. When used in HandlerConfig, Crypto bounds the call to
+// the verifier as a whole, including the verifier's own parsing. A stage that runs out of time fails with a TimeoutError,
+// although the work it started (e.g. a call to a remote key backend) may continue in the background. In that case,
+// the body of the request is no longer available to the caller, since it may still be read in the background.
+type Timeouts struct {
+	Total         time.Duration
+	Parse         time.Duration // parsing the message and its signature headers
+	KeyResolution time.Duration // fetching the verifier (WrapHandler only)
+	Body          time.Duration // reading the request body (WrapHandler only, see HandlerConfig.SetBufferBody)
+	Crypto        time.Duration // computing the signature base and checking the signature
+}
+
+// Names of the verification stages, as reported in TimeoutError
+const (
+	StageParse         = "parse"
+	StageKeyResolution = "key resolution"
+	StageBody          = "body"
+	StageCrypto        = "crypto"
+)
+
+// withTotal derives a context that is bounded by the total timeout, if any
+func (t Timeouts) withTotal(parent context.Context) (context.Context, context.CancelFunc) {
+	if t.Total > 0 {
+		return context.WithTimeout(parent, t.Total)
+	}
+	return parent, func() {}
+}
+
+// runStage runs f, and gives up if the context deadline or the stage budget expires first.
+// If there is no deadline, f is simply called.
+func runStage(ctx context.Context, stage string, budget time.Duration, f func(ctx context.Context) error) error {
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return f(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{Stage: stage, Err: err}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return &TimeoutError{Stage: stage, Err: ctx.Err()}
+	}
+}
+
+// publishBody sets the body of a message to stageBody, the body of the copy of the message that was passed to
+// a stage, which may have replaced it, e.g. with a buffered copy. Once a stage runs out of time its work may
+// continue in the background, so stageBody is not touched and the body is withheld from the message instead.
+func publishBody(body, stageBody *io.ReadCloser, err error) {
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) {
+		*body = http.NoBody
+		return
+	}
+	*body = *stageBody
+}
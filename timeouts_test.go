@@ -0,0 +1,145 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_runStage(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	err := runStage(context.Background(), StageCrypto, 0, func(context.Context) error { return nil })
+	assert.NoError(t, err, "no deadline")
+
+	err = runStage(context.Background(), StageCrypto, 10*time.Millisecond, slow)
+	var te *TimeoutError
+	assert.True(t, errors.As(err, &te), "stage budget")
+	assert.Equal(t, StageCrypto, te.Stage)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel := Timeouts{Total: 10 * time.Millisecond}.withTotal(context.Background())
+	defer cancel()
+	err = runStage(ctx, StageParse, time.Minute, slow)
+	assert.True(t, errors.As(err, &te), "total budget")
+	assert.Equal(t, StageParse, te.Stage)
+
+	err = runStage(ctx, StageCrypto, 0, func(context.Context) error { return nil })
+	assert.True(t, errors.As(err, &te), "deadline already passed")
+}
+
+type slowReader struct{}
+
+func (slowReader) Read(p []byte) (int, error) {
+	time.Sleep(200 * time.Millisecond)
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestWrapHandlerTimeouts(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	simpleHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+	fetchVerifier := func(delay time.Duration) func(r *http.Request) (string, *Verifier) {
+		return func(r *http.Request) (string, *Verifier) {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+			}
+			verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+			return "sig1", verifier
+		}
+	}
+	tests := []struct {
+		name       string
+		config     *HandlerConfig
+		body       io.Reader
+		wantStatus int
+	}{
+		{"fast key backend", NewHandlerConfig().SetFetchVerifier(fetchVerifier(0)).
+			SetTimeouts(Timeouts{KeyResolution: time.Second}), nil, 200},
+		{"slow key backend", NewHandlerConfig().SetFetchVerifier(fetchVerifier(time.Second)).
+			SetTimeouts(Timeouts{KeyResolution: 20 * time.Millisecond}), nil, 401},
+		{"total budget", NewHandlerConfig().SetFetchVerifier(fetchVerifier(time.Second)).
+			SetTimeouts(Timeouts{Total: 20 * time.Millisecond}), nil, 401},
+		{"slow body", NewHandlerConfig().SetFetchVerifier(fetchVerifier(0)).SetBufferBody(100).
+			SetTimeouts(Timeouts{Body: 20 * time.Millisecond}), io.LimitReader(slowReader{}, 5), 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(WrapHandler(http.HandlerFunc(simpleHandler), *tt.config))
+			defer ts.Close()
+			signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method"))
+			client := NewDefaultClient("sig1", signer, nil, nil)
+			res, err := client.Post(ts.URL, "text/plain", tt.body)
+			assert.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestVerifyRequestTimeouts(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 64)
+	signer, _ := NewHMACSHA256Signer("key", key, nil, Headers("@method"))
+	req := readRequest(httpreq1)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).
+		SetTimeouts(Timeouts{Total: time.Second}), Headers("@method"))
+	assert.NoError(t, VerifyRequest("sig1", *verifier, req))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = VerifyRequest("sig1", *verifier, req.WithContext(ctx))
+	assert.True(t, errors.Is(err, context.Canceled), "request context is done")
+
+	// the body is withheld once the digest check runs out of time, since it is still being read
+	signer, _ = NewHMACSHA256Signer("key", key, nil, Headers("@method", "content-digest"))
+	req = readRequest(httpreq1)
+	req.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	sigInput, sig, err = SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+	req.Body = io.NopCloser(io.LimitReader(slowReader{}, 1))
+	verifier, _ = NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyCreated(false).
+		SetTimeouts(Timeouts{Crypto: 20 * time.Millisecond}), Headers("@method"))
+	var timeout *TimeoutError
+	assert.ErrorAs(t, VerifyRequest("sig1", *verifier, req), &timeout)
+	assert.Equal(t, http.NoBody, req.Body)
+	time.Sleep(300 * time.Millisecond) // let the abandoned stage complete
+}
+
+func TestWrapHandlerTimeouts_Body(t *testing.T) {
+	// a stage that runs out of time may still be reading the body, so the body is withheld from the callback
+	key := bytes.Repeat([]byte{1}, 64)
+	verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+	var body []byte
+	config := NewHandlerConfig().SetBufferBody(100).SetTimeouts(Timeouts{Body: 20 * time.Millisecond}).
+		SetFetchVerifier(func(r *http.Request) (string, *Verifier) { return "sig1", verifier }).
+		SetReqNotVerified(func(w http.ResponseWriter, r *http.Request, err error) {
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	req := httptest.NewRequest("POST", "/", io.LimitReader(slowReader{}, 1))
+	_, ok := verifyServerRequest(httptest.NewRecorder(), req, *config)
+	assert.False(t, ok)
+	assert.Empty(t, body)
+	time.Sleep(300 * time.Millisecond) // let the abandoned stage complete
+}
@@ -0,0 +1,98 @@
+package httpsign
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates spans around signing, verification, digest computation and key fetches, so that their
+// overhead shows up in distributed traces. Spans are children of the span in the message's context:
+// the request's Context, or for a response, the Context of its Request if any.
+//
+// To avoid a dependency on OpenTelemetry, Tracer and Span are small interfaces. They are implemented
+// by a thin wrapper around an OpenTelemetry trace.Tracer:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, httpsign.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) SetAttribute(key, value string) { s.Span.SetAttributes(attribute.String(key, value)) }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.Span.RecordError(err)
+//			s.Span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.Span.End()
+//	}
+type Tracer interface {
+	// Start starts a span with the given name as a child of the span in ctx, if any, and returns a context
+	// that contains the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key, value string)
+	// End ends the span, with the error of the operation, which is nil on success.
+	End(err error)
+}
+
+// Span names and attribute keys
+const (
+	SpanSign     = "httpsign.Sign"
+	SpanVerify   = "httpsign.Verify"
+	SpanDigest   = "httpsign.Digest"
+	SpanFetchKey = "httpsign.FetchKey"
+
+	AttributeLabel = "httpsign.label"
+	AttributeKeyID = "httpsign.keyid"
+	AttributeAlg   = "httpsign.alg"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+
+func (noopSpan) End(error) {}
+
+// startSpan starts a span if there is a tracer, and otherwise returns a span that does nothing
+func startSpan(ctx context.Context, t Tracer, name string) (context.Context, Span) {
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+// signSpan starts the signing span of a message
+func (s Signer) signSpan(ctx context.Context, label string) (context.Context, Span) {
+	ctx, span := startSpan(ctx, s.config.tracer, SpanSign)
+	span.SetAttribute(AttributeLabel, label)
+	span.SetAttribute(AttributeKeyID, s.keyID)
+	span.SetAttribute(AttributeAlg, s.alg)
+	return ctx, span
+}
+
+// responseContext returns the context of the response's request, if any
+func responseContext(res *http.Response) context.Context {
+	if res.Request != nil {
+		return res.Request.Context()
+	}
+	return context.Background()
+}
+
+// TraceKeyFetcher returns a KeyFetcher that creates a span around each call to fetcher.
+func TraceKeyFetcher(fetcher KeyFetcher, t Tracer) KeyFetcher {
+	return KeyFetcherFunc(func(ctx context.Context, keyID, alg string) (MessageVerifier, error) {
+		ctx, span := t.Start(ctx, SpanFetchKey)
+		span.SetAttribute(AttributeKeyID, keyID)
+		verifier, err := fetcher.FetchKey(ctx, keyID, alg)
+		span.End(err)
+		return verifier, err
+	})
+}
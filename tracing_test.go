@@ -0,0 +1,92 @@
+package httpsign
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(spanKey{}).(string)
+	s := &recordedSpan{name: name, parent: parent, attrs: map[string]string{}}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, name), s
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.ended, s.err = true, err
+}
+
+func TestTracing(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	tracer := &recordingTracer{}
+	fields := Headers("@method", "content-digest")
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetTracer(tracer), fields)
+	req, err := http.NewRequestWithContext(context.WithValue(context.Background(), spanKey{}, "root"),
+		http.MethodPost, "https://example.com/", strings.NewReader(`{"hello": "world"}`))
+	assert.NoError(t, err)
+	sigInput, sig, err := SignRequest("sig1", *signer, req)
+	assert.NoError(t, err)
+	req.Header.Add("Signature-Input", sigInput)
+	req.Header.Add("Signature", sig)
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetTracer(tracer), fields)
+	fetcher := TraceKeyFetcher(KeyFetcherFunc(func(_ context.Context, keyID, _ string) (MessageVerifier, error) {
+		if keyID != "key" {
+			return nil, fmt.Errorf("unknown key")
+		}
+		return verifier, nil
+	}), tracer)
+	assert.NoError(t, VerifyRequestWithKeyFetcher("sig1", fetcher, req))
+
+	var got []string
+	for _, s := range tracer.spans {
+		got = append(got, s.parent+">"+s.name)
+		assert.True(t, s.ended, s.name)
+		assert.NoError(t, s.err, s.name)
+	}
+	assert.Equal(t, []string{
+		"root>" + SpanSign,
+		SpanSign + ">" + SpanDigest,
+		"root>" + SpanFetchKey,
+		"root>" + SpanVerify,
+		SpanVerify + ">" + SpanDigest,
+	}, got)
+	assert.Equal(t, map[string]string{AttributeLabel: "sig1", AttributeKeyID: "key", AttributeAlg: "hmac-sha256"},
+		tracer.spans[0].attrs)
+	assert.Equal(t, "key", tracer.spans[2].attrs[AttributeKeyID])
+
+	// A failed verification ends its span with the error
+	tracer.spans = nil
+	badVerifier, _ := NewHMACSHA256Verifier("key", bytes.Repeat([]byte{8}, 64), NewVerifyConfig().SetTracer(tracer), fields)
+	assert.Error(t, VerifyRequest("sig1", *badVerifier, req))
+	if assert.Len(t, tracer.spans, 1) {
+		assert.ErrorIs(t, tracer.spans[0].err, ErrBadSignature)
+	}
+}
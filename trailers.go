@@ -0,0 +1,236 @@
+package httpsign
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// trailerSigner holds the state of a response whose signature is sent in trailers
+type trailerSigner struct {
+	signers   []namedSigner // in order of preference, see responseSigners
+	header    http.Header   // the response headers, as sent
+	digest    hash.Hash     // computes the Content-Digest trailer, if covered
+	digestAlg string
+}
+
+// startTrailers fetches the signer and declares the trailers, just before the response headers are sent
+func (w *wrappedResponseWriter) startTrailers() bool {
+	response := serverResponse(w, w.r)
+	candidates, err := responseSigners(response, w.r, w.config)
+	if err != nil {
+		w.sigFailed(err)
+		return false
+	}
+	ts := &trailerSigner{}
+	for _, c := range candidates {
+		s := asSigner(c.signer)
+		if s == nil {
+			ts.signers = append(ts.signers, c)
+			continue
+		}
+		if s.fields.coversName("content-digest") && w.Header().Get("Content-Digest") == "" {
+			continue // the digest of the body is not known yet
+		}
+		if s.fields.coversTrailer("content-digest") && ts.digest == nil {
+			ts.digestAlg = s.config.digestAlgorithm
+			if ts.digestAlg == "" {
+				ts.digestAlg = DigestSHA256
+			}
+			h, err := newDigestHash(ts.digestAlg)
+			if err != nil {
+				w.sigFailed(err)
+				return false
+			}
+			ts.digest = h
+		}
+		ts.signers = append(ts.signers, c)
+	}
+	if len(ts.signers) == 0 {
+		w.sigFailed(fmt.Errorf("cannot compute \"content-digest\" before the body is sent, cover it as a trailer"))
+		return false
+	}
+	declared := []string{"Signature-Input", "Signature"}
+	if ts.digest != nil {
+		declared = append(declared, "Content-Digest")
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Add("Trailer", strings.Join(declared, ", "))
+	ts.header = w.Header().Clone()
+	w.trailers = ts
+	return true
+}
+
+// finishTrailers signs the response once the body has been sent, and sets the signature trailers
+func (w *wrappedResponseWriter) finishTrailers() {
+	ts := w.trailers
+	if ts.digest != nil {
+		digest, err := digestValue(ts.digestAlg, ts.digest.Sum(nil))
+		if err != nil {
+			loggerOrNop(w.config.logger).Printf("Failed to sign response: %v", err)
+			return
+		}
+		w.Header().Set("Content-Digest", digest)
+	}
+	response := serverResponse(w, w.r)
+	response.Header = ts.header
+	response.Trailer = http.Header{}
+	for _, name := range declaredTrailers(ts.header) {
+		if vv, found := w.Header()[name]; found {
+			response.Trailer[name] = vv
+		}
+	}
+	var err error
+	for _, c := range ts.signers {
+		var signatureInput, signature string
+		signatureInput, signature, err = SignResponse(c.sigName, c.signer, &response)
+		if err == nil {
+			w.Header().Set("Signature-Input", signatureInput)
+			w.Header().Set("Signature", signature)
+			return
+		}
+	} // too late to change the status, the client will fail to verify the response
+	loggerOrNop(w.config.logger).Printf("Failed to sign response: %v", err)
+}
+
+// SignRequestInTrailers prepares a request with a body so that it is signed in trailers: the body is streamed
+// rather than buffered, and the Signature-Input and Signature trailers are computed once the body is read to
+// its end, e.g. by http.Client. To protect the body, the signer should cover the "content-digest" trailer
+// (Fields.AddTrailers), which is then computed while the body is read. The request is sent with chunked encoding.
+// The server's verifier must be configured with VerifyConfig.SetVerifyTrailers.
+func SignRequestInTrailers(signatureName string, signer MessageSigner, req *http.Request) error {
+	if req == nil {
+		return fmt.Errorf("nil request")
+	}
+	if isNilSigner(signer) {
+		return fmt.Errorf("nil signer")
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return fmt.Errorf("cannot sign a request without a body in trailers")
+	}
+	body := &trailerRequestBody{body: req.Body, req: req, sigName: signatureName, signer: signer}
+	if req.Trailer == nil {
+		req.Trailer = http.Header{}
+	}
+	if s := asSigner(signer); s != nil {
+		if s.fields.coversName("content-digest") && req.Header.Get("Content-Digest") == "" {
+			return fmt.Errorf("cannot compute \"content-digest\" before the body is sent, cover it as a trailer")
+		}
+		if s.fields.coversTrailer("content-digest") {
+			body.digestAlg = s.config.digestAlgorithm
+			if body.digestAlg == "" {
+				body.digestAlg = DigestSHA256
+			}
+			h, err := newDigestHash(body.digestAlg)
+			if err != nil {
+				return err
+			}
+			body.digest = h
+			req.Trailer["Content-Digest"] = nil
+		}
+	}
+	req.Trailer["Signature-Input"] = nil
+	req.Trailer["Signature"] = nil
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	req.GetBody = nil // the body cannot be replayed with its signature
+	req.Body = body
+	return nil
+}
+
+// trailerRequestBody signs the request when its body has been read in full
+type trailerRequestBody struct {
+	body      io.ReadCloser
+	req       *http.Request
+	sigName   string
+	signer    MessageSigner
+	digest    hash.Hash // computes the Content-Digest trailer, if covered
+	digestAlg string
+	signed    bool
+}
+
+func (b *trailerRequestBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.digest != nil {
+		b.digest.Write(p[:n])
+	}
+	if err == io.EOF && !b.signed {
+		b.signed = true
+		if serr := b.sign(); serr != nil {
+			return n, fmt.Errorf("failed to sign request: %w", serr)
+		}
+	}
+	return n, err
+}
+
+func (b *trailerRequestBody) Close() error {
+	return b.body.Close()
+}
+
+// sign sets the trailers, which the http.Client sends once the body returns io.EOF
+func (b *trailerRequestBody) sign() error {
+	if b.digest != nil {
+		digest, err := digestValue(b.digestAlg, b.digest.Sum(nil))
+		if err != nil {
+			return err
+		}
+		b.req.Trailer.Set("Content-Digest", digest)
+	}
+	request := *b.req
+	request.Body = http.NoBody // already read
+	signatureInput, signature, err := SignRequest(b.sigName, b.signer, &request)
+	if err != nil {
+		return err
+	}
+	b.req.Trailer.Set("Signature-Input", signatureInput)
+	b.req.Trailer.Set("Signature", signature)
+	return nil
+}
+
+// declaredTrailers returns the canonical names listed in the Trailer header
+func declaredTrailers(header http.Header) []string {
+	var names []string
+	for _, v := range header.Values("Trailer") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// asSigner returns the Signer behind a MessageSigner, or nil for other implementations
+func asSigner(s MessageSigner) *Signer {
+	switch v := s.(type) {
+	case *Signer:
+		return v
+	case Signer:
+		return &v
+	}
+	return nil
+}
+
+// loadSignatureTrailers copies the Signature-Input and Signature trailers into the message headers, if
+// the message has no Signature-Input header but declares these trailers, and trailers are available.
+// The body is read in full, up to the limit set by VerifyConfig.SetVerifyTrailers.
+func (message *parsedMessage) loadSignatureTrailers() error {
+	if _, found := message.headers["signature-input"]; found || message.trailerLimit < 0 {
+		return nil
+	}
+	if _, declared := message.trailers["Signature-Input"]; !declared {
+		return nil
+	}
+	trailers, err := message.trailerMessage()
+	if err != nil {
+		return err
+	}
+	for _, hdr := range []string{"signature-input", "signature"} {
+		if vv, found := trailers.headers[hdr]; found {
+			message.headers[hdr] = vv
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrapHandlerSignInTrailers(t *testing.T) {
+	key := bytes.Repeat([]byte{4}, 64)
+	fields := *NewFields().AddHeaders("@status", "content-type").AddTrailers("content-digest")
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig().SetDigestAlgorithm(DigestSHA512), fields)
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) { // e.g. server-sent events
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().
+		SetFetchSigner(fetchSigner).SetSignInTrailers(true)))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Header.Get("Signature"), "signature should not be sent as a header")
+	for _, name := range []string{"Content-Digest", "Signature", "Signature-Input"} {
+		_, declared := res.Trailer[name]
+		assert.True(t, declared, "%s should be declared as a trailer", name)
+	}
+	verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyTrailers(1024), fields)
+	assert.NoError(t, VerifyResponse("sig1", *verifier, res))
+	b, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "data: event 0\n\ndata: event 1\n\ndata: event 2\n\n", string(b))
+	assert.True(t, strings.HasPrefix(res.Trailer.Get("Content-Digest"), "sha-512=:"))
+
+	res, err = http.Get(ts.URL)
+	assert.NoError(t, err)
+	b, _ = io.ReadAll(res.Body)
+	res.Body = io.NopCloser(bytes.NewReader(bytes.ToUpper(b)))
+	assert.Error(t, VerifyResponse("sig1", *verifier, res), "body was modified")
+}
+
+func TestWrapHandlerSignInTrailersRequiresDigestTrailer(t *testing.T) {
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key", bytes.Repeat([]byte{4}, 64), nil, Headers("@status", "content-digest"))
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello, client")
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().
+		SetFetchSigner(fetchSigner).SetSignInTrailers(true)))
+	defer ts.Close()
+	res, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
+
+func TestClientSignInTrailers(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 64)
+	fields := *NewFields().AddHeaders("@method", "content-type").AddTrailers("content-digest")
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyTrailers(1024), fields)
+		return "sig1", verifier
+	}
+	var received string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().SetFetchVerifier(fetchVerifier)))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), fields)
+	client := NewDefaultClient("sig1", signer, nil, nil).SetSignInTrailers(true)
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(pw, "chunk %d\n", i)
+		}
+		_ = pw.Close()
+	}()
+	res, err := client.Post(ts.URL, "text/plain", pr) // unknown length
+	assert.NoError(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		_ = res.Body.Close()
+	}
+	assert.Equal(t, "chunk 0\nchunk 1\nchunk 2\n", received)
+
+	headerDigest, _ := NewHMACSHA256Signer("key", key, NewSignConfig(), *NewFields().AddHeaders("@method", "content-digest"))
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader("data"))
+	assert.Error(t, SignRequestInTrailers("sig1", headerDigest, req), "content-digest must be covered as a trailer")
+}
+
+func TestWrapHandlerSignInTrailersPreparesSigner(t *testing.T) {
+	key := bytes.Repeat([]byte{4}, 64)
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		fields := *NewFields().AddRequestHeaders("@authority").AddTrailers("content-digest")
+		signer, _ := NewHMACSHA256Signer("server-key", key, NewSignConfig().SignCreated(false), fields)
+		return "default", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprintln(w, "Hello, client")
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().
+		SetFetchSigner(fetchSigner).SetSignInTrailers(true).SetHonorAcceptSignature(true).
+		SetTrustedProxies(NewTrustedProxies(ForwardedXForwarded, "127.0.0.1"))))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	req.Header.Set("Accept-Signature", `mine=("content-type");keyid="server-key"`)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(res.Body)
+	assert.Equal(t, `mine=("content-type" "@authority";req "content-digest";tr "@status");alg="hmac-sha256";keyid="server-key"`,
+		res.Trailer.Get("Signature-Input"), "the trailer signature should honor Accept-Signature")
+
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	res.Request.Host = "example.com" // the authority as seen through the trusted proxy
+	fields := *NewFields().AddHeaders("content-type", "@status").AddRequestHeaders("@authority").AddTrailers("content-digest")
+	verifier, _ := NewHMACSHA256Verifier("server-key", key, NewVerifyConfig().SetVerifyCreated(false).SetVerifyTrailers(1024), fields)
+	assert.NoError(t, VerifyResponse("mine", *verifier, res))
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestVerifySignatureTrailersBounded(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 64)
+	body := func() (*http.Request, *countingReader) {
+		c := &countingReader{r: bytes.NewReader(make([]byte, 1<<20))}
+		req := httptest.NewRequest("POST", "/", c)
+		req.ContentLength = -1
+		req.Trailer = http.Header{"Signature-Input": nil, "Signature": nil} // declared, but never sent
+		return req, c
+	}
+
+	verifier, _ := NewHMACSHA256Verifier("key", key, nil, Headers("@method"))
+	req, c := body()
+	assert.Error(t, VerifyRequest("sig1", *verifier, req))
+	assert.Zero(t, c.n, "the body should not be read unless trailers are enabled")
+
+	verifier, _ = NewHMACSHA256Verifier("key", key, NewVerifyConfig().SetVerifyTrailers(1024), Headers("@method"))
+	req, c = body()
+	var tooLarge *BodyTooLargeError
+	assert.ErrorAs(t, VerifyRequest("sig1", *verifier, req), &tooLarge)
+	assert.Less(t, c.n, int64(1<<16), "the body should be read up to the limit only")
+}
@@ -0,0 +1,43 @@
+package httpsign
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that optionally signs requests and optionally verifies responses, so that
+// a plain http.Client, or any library that accepts a Transport, can use HTTP signatures. The signer, verifier
+// and fetchVerifier behave as for Client. A response that fails verification is returned as an error.
+type Transport struct {
+	client Client
+	base   http.RoundTripper
+}
+
+// NewTransport constructs a new Transport that sends requests through base, or through http.DefaultTransport if base is nil.
+func NewTransport(sigName string, signer MessageSigner, verifier MessageVerifier, fetchVerifier func(res *http.Response, req *http.Request) (sigName string, verifier *Verifier), base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{client: *NewClient(sigName, signer, verifier, fetchVerifier, http.Client{}), base: base}
+}
+
+// SetAcceptSignature is the same as Client.SetAcceptSignature.
+func (t *Transport) SetAcceptSignature(b bool) *Transport {
+	t.client.SetAcceptSignature(b)
+	return t
+}
+
+// SetVerificationPolicy is the same as Client.SetVerificationPolicy.
+func (t *Transport) SetVerificationPolicy(p ResponseVerificationPolicy) *Transport {
+	t.client.SetVerificationPolicy(p)
+	return t
+}
+
+// RoundTrip signs and sends the request, and verifies the response. As required of an http.RoundTripper,
+// the request is not modified: the signature is added to a copy.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil transport")
+	}
+	return t.client.roundTrip(req.Clone(req.Context()), t.base.RoundTrip)
+}
@@ -0,0 +1,100 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 64)
+	fetchVerifier := func(r *http.Request) (string, *Verifier) {
+		verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig().SetVerifyCreated(false), Headers("@method", "content-type"))
+		return "sig1", verifier
+	}
+	fetchSigner := func(res http.Response, r *http.Request) (string, *Signer) {
+		signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@status"))
+		return "sig1", signer
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "Hello, client")
+	}
+	ts := httptest.NewServer(WrapHandler(http.HandlerFunc(handler), *NewHandlerConfig().
+		SetFetchVerifier(fetchVerifier).SetFetchSigner(fetchSigner)))
+	defer ts.Close()
+
+	signer, _ := NewHMACSHA256Signer("key1", key, NewSignConfig(), Headers("@method", "content-type"))
+	verifier, _ := NewHMACSHA256Verifier("key1", key, NewVerifyConfig(), Headers("@status"))
+	client := http.Client{Transport: NewTransport("sig1", signer, verifier, nil, nil)}
+	req, err := http.NewRequest("PATCH", ts.URL, strings.NewReader("data"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		_ = res.Body.Close()
+	}
+	assert.Empty(t, req.Header.Get("Signature"), "the original request should not be modified")
+
+	wrongVerifier, _ := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{4}, 64), NewVerifyConfig(), Headers("@status"))
+	client = http.Client{Transport: NewTransport("sig1", signer, wrongVerifier, nil, nil)}
+	req, _ = http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Content-Type", "text/plain")
+	_, err = client.Do(req)
+	assert.Error(t, err, "response should fail verification")
+
+	client = http.Client{Transport: NewTransport("sig1", nil, nil, nil, nil)}
+	res, err = client.Get(ts.URL)
+	assert.NoError(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "unsigned request should be rejected")
+		_ = res.Body.Close()
+	}
+}
+
+// closeTracker is a response body that records whether it was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransport_ClosesRejectedResponse(t *testing.T) {
+	var body *closeTracker
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body = &closeTracker{Reader: strings.NewReader("unsigned")}
+		res := readResponse(httpres1)
+		res.Body = body
+		return res, nil
+	})
+	verifier, _ := NewHMACSHA256Verifier("key1", bytes.Repeat([]byte{3}, 64), NewVerifyConfig(), Headers("@status"))
+	nilVerifier := func(res *http.Response, req *http.Request) (string, *Verifier) { return "sig1", nil }
+	transports := map[string]*Transport{
+		"bad signature": NewTransport("sig1", nil, verifier, nil, base),
+		"no verifier":   NewTransport("sig1", nil, nil, nilVerifier, base),
+	}
+	for name, transport := range transports {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "https://example.com/", nil)
+			_, err := transport.RoundTrip(req)
+			assert.Error(t, err)
+			assert.True(t, body.closed, "the body of a rejected response must be closed")
+		})
+	}
+}
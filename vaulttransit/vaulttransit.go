@@ -0,0 +1,247 @@
+// Package vaulttransit signs and verifies HTTP messages with keys held in the transit secrets engine
+// of HashiCorp Vault, so that private keys never leave Vault.
+//
+// To avoid a dependency on the Vault client library, the package defines the small Client interface.
+// With github.com/hashicorp/vault/api, it is implemented by a thin wrapper around api.Client:
+//
+//	func (c myClient) Write(ctx context.Context, path string, data map[string]interface{}) (map[string]interface{}, error) {
+//		s, err := c.vault.Logical().WriteWithContext(ctx, path, data)
+//		if err != nil || s == nil {
+//			return nil, err
+//		}
+//		return s.Data, nil
+//	}
+//
+// and similarly for Read. Token renewal remains the responsibility of the client, e.g. using api.LifetimeWatcher.
+// A client that also implements TokenRenewer is asked to renew its token once when a call fails, before the call
+// is retried.
+package vaulttransit
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/yaronf/httpsign"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Client is the subset of the Vault logical API used by this package.
+type Client interface {
+	Read(ctx context.Context, path string) (map[string]interface{}, error)
+	Write(ctx context.Context, path string, data map[string]interface{}) (map[string]interface{}, error)
+}
+
+// TokenRenewer is optionally implemented by a Client that can renew (or re-acquire) its Vault token.
+type TokenRenewer interface {
+	RenewToken(ctx context.Context) error
+}
+
+// Key identifies a transit key.
+type Key struct {
+	Mount   string // mount path of the transit engine, default "transit"
+	Name    string
+	Version int // key version, 0 for the latest
+}
+
+func (k Key) mount() string {
+	if k.Mount == "" {
+		return "transit"
+	}
+	return strings.Trim(k.Mount, "/")
+}
+
+type algParams struct {
+	hash      string // hash_algorithm, empty if the input is not prehashed
+	signature string // signature_algorithm, RSA only
+}
+
+var transitAlgorithms = map[string]algParams{
+	"rsa-v1_5-sha256":   {"sha2-256", "pkcs1v15"},
+	"rsa-pss-sha512":    {"sha2-512", "pss"},
+	"ecdsa-p256-sha256": {"sha2-256", ""},
+	"ecdsa-p384-sha384": {"sha2-384", ""},
+	"ed25519":           {"", ""},
+}
+
+// NewSigner returns a Signer whose signatures are computed by Vault. The "keyid" signature parameter is keyID,
+// or the key name if keyID is empty. Alg is the HTTP signature algorithm, which must match the transit key type.
+// The public key is fetched once, using ctx. Each signature is computed with the context of the message being
+// signed, see httpsign.ContextSigner. Config may be nil for a default configuration.
+func NewSigner(ctx context.Context, client Client, key Key, keyID, alg string, config *httpsign.SignConfig,
+	fields httpsign.Fields) (*httpsign.Signer, error) {
+	params, ok := transitAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("algorithm \"%s\" is not supported by Vault transit", alg)
+	}
+	pub, version, err := fetchPublicKey(ctx, client, key)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		keyID = key.Name
+	}
+	key.Version = version // sign with the key whose public key is advertised, even after a rotation
+	s := &transitSigner{client: client, key: key, params: params, public: pub}
+	return httpsign.NewSignerFromCryptoSigner(alg, keyID, s, config, fields)
+}
+
+// NewVerifier returns a Verifier for the public key of a transit key. The "keyid" signature parameter is keyID,
+// or the key name if keyID is empty. The public key is fetched once, using ctx.
+// Config may be nil for a default configuration.
+func NewVerifier(ctx context.Context, client Client, key Key, keyID, alg string, config *httpsign.VerifyConfig,
+	fields httpsign.Fields) (*httpsign.Verifier, error) {
+	pub, _, err := fetchPublicKey(ctx, client, key)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		keyID = key.Name
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "rsa-v1_5-sha256":
+			return httpsign.NewRSAVerifier(keyID, *k, config, fields)
+		case "rsa-pss-sha512":
+			return httpsign.NewRSAPSSVerifier(keyID, *k, config, fields)
+		}
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ecdsa-p256-sha256":
+			return httpsign.NewP256Verifier(keyID, *k, config, fields)
+		case "ecdsa-p384-sha384":
+			return httpsign.NewP384Verifier(keyID, *k, config, fields)
+		}
+	case ed25519.PublicKey:
+		if alg == "ed25519" {
+			return httpsign.NewEd25519Verifier(keyID, k, config, fields)
+		}
+	}
+	return nil, fmt.Errorf("public key of type %T does not match algorithm \"%s\"", pub, alg)
+}
+
+// fetchPublicKey reads the transit key, and returns the public key of the requested version, and that version,
+// which is the latest version if none is requested
+func fetchPublicKey(ctx context.Context, client Client, key Key) (crypto.PublicKey, int, error) {
+	if client == nil {
+		return nil, 0, fmt.Errorf("nil Vault client")
+	}
+	var data map[string]interface{}
+	err := withRenewal(ctx, client, func() (err error) {
+		data, err = client.Read(ctx, key.mount()+"/keys/"+key.Name)
+		return
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read key \"%s\": %w", key.Name, err)
+	}
+	version := key.Version
+	if version == 0 {
+		version, err = intValue(data["latest_version"])
+		if err != nil {
+			return nil, 0, fmt.Errorf("malformed \"latest_version\" for key \"%s\"", key.Name)
+		}
+	}
+	keys, _ := data["keys"].(map[string]interface{})
+	kv, _ := keys[strconv.Itoa(version)].(map[string]interface{})
+	pubKey, _ := kv["public_key"].(string)
+	if pubKey == "" {
+		return nil, 0, fmt.Errorf("no public key for version %d of key \"%s\"", version, key.Name)
+	}
+	if block, _ := pem.Decode([]byte(pubKey)); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return pub, version, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(pubKey) // ed25519 keys are returned as plain base64
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, 0, fmt.Errorf("malformed public key for key \"%s\"", key.Name)
+	}
+	return ed25519.PublicKey(raw), version, nil
+}
+
+// intValue accepts the numeric types that JSON decoders produce
+func intValue(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case fmt.Stringer: // json.Number
+		return strconv.Atoi(n.String())
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+// withRenewal calls f, and if it fails and the client can renew its token, renews it and calls f again
+func withRenewal(ctx context.Context, client Client, f func() error) error {
+	err := f()
+	if err == nil {
+		return nil
+	}
+	renewer, ok := client.(TokenRenewer)
+	if !ok {
+		return err
+	}
+	if rerr := renewer.RenewToken(ctx); rerr != nil {
+		return fmt.Errorf("%w (token renewal failed: %v)", err, rerr)
+	}
+	return f()
+}
+
+// transitSigner is a crypto.Signer backed by a transit key
+type transitSigner struct {
+	client Client
+	key    Key
+	params algParams
+	public crypto.PublicKey
+}
+
+func (s *transitSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *transitSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+func (s *transitSigner) SignContext(ctx context.Context, _ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	path := s.key.mount() + "/sign/" + s.key.Name
+	data := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": s.key.Version,
+	}
+	if s.params.hash != "" {
+		path += "/" + s.params.hash
+		data["prehashed"] = true
+		data["marshaling_algorithm"] = "asn1"
+	}
+	if s.params.signature != "" {
+		data["signature_algorithm"] = s.params.signature
+		if s.params.signature == "pss" {
+			data["salt_length"] = "hash"
+		}
+	}
+	var res map[string]interface{}
+	err := withRenewal(ctx, s.client, func() (err error) {
+		res, err = s.client.Write(ctx, path, data)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig, _ := res["signature"].(string) // vault:v<version>:<base64>
+	parts := strings.Split(sig, ":")
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("malformed signature returned by Vault")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
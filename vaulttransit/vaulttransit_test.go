@@ -0,0 +1,139 @@
+package vaulttransit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yaronf/httpsign"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeVault implements the transit engine with a local key. Its token expires after each renewal's first use,
+// if expiring is set.
+type fakeVault struct {
+	key        interface{} // *ecdsa.PrivateKey or ed25519.PrivateKey
+	expiring   bool
+	valid      bool
+	renewals   int
+	gotPath    string
+	gotVersion interface{}
+}
+
+func (f *fakeVault) checkToken() error {
+	if f.expiring && !f.valid {
+		return fmt.Errorf("permission denied")
+	}
+	f.valid = false
+	return nil
+}
+
+func (f *fakeVault) RenewToken(context.Context) error {
+	f.renewals++
+	f.valid = true
+	return nil
+}
+
+func (f *fakeVault) Read(_ context.Context, path string) (map[string]interface{}, error) {
+	if err := f.checkToken(); err != nil {
+		return nil, err
+	}
+	var pub string
+	switch k := f.key.(type) {
+	case *ecdsa.PrivateKey:
+		der, _ := x509.MarshalPKIXPublicKey(&k.PublicKey)
+		pub = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	case ed25519.PrivateKey:
+		pub = base64.StdEncoding.EncodeToString(k.Public().(ed25519.PublicKey))
+	}
+	return map[string]interface{}{
+		"latest_version": float64(1),
+		"keys":           map[string]interface{}{"1": map[string]interface{}{"public_key": pub}},
+	}, nil
+}
+
+func (f *fakeVault) Write(ctx context.Context, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := f.checkToken(); err != nil {
+		return nil, err
+	}
+	f.gotPath = path
+	f.gotVersion = data["key_version"]
+	input, err := base64.StdEncoding.DecodeString(data["input"].(string))
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	switch k := f.key.(type) {
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, k, input)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig)}, nil
+}
+
+func TestSignAndVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	fields := httpsign.Headers("@method", "@path")
+
+	tests := []struct {
+		name     string
+		vault    *fakeVault
+		alg      string
+		wantPath string
+	}{
+		{"P-256", &fakeVault{key: ecKey}, "ecdsa-p256-sha256", "transit/sign/my-key/sha2-256"},
+		{"Ed25519", &fakeVault{key: edKey}, "ed25519", "transit/sign/my-key"},
+		{"token renewal", &fakeVault{key: ecKey, expiring: true}, "ecdsa-p256-sha256", "transit/sign/my-key/sha2-256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := Key{Name: "my-key"}
+			signer, err := NewSigner(context.Background(), tt.vault, key, "", tt.alg, nil, fields)
+			assert.NoError(t, err)
+			req, _ := http.ReadRequest(bufio.NewReader(strings.NewReader("GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+			sigInput, sig, err := httpsign.SignRequest("sig1", *signer, req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPath, tt.vault.gotPath)
+			assert.Equal(t, 1, tt.vault.gotVersion, "the latest version is resolved once, and then always used")
+			assert.Contains(t, sigInput, `keyid="my-key"`)
+			req.Header.Add("Signature-Input", sigInput)
+			req.Header.Add("Signature", sig)
+
+			verifier, err := NewVerifier(context.Background(), tt.vault, key, "", tt.alg, nil, fields)
+			assert.NoError(t, err)
+			assert.NoError(t, httpsign.VerifyRequest("sig1", *verifier, req))
+			if tt.vault.expiring {
+				assert.Equal(t, 3, tt.vault.renewals)
+			}
+		})
+	}
+	signer, err := NewSigner(context.Background(), &fakeVault{key: ecKey}, Key{Name: "my-key"}, "", "ecdsa-p256-sha256", nil, fields)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://example.com/foo", nil)
+	_, _, err = httpsign.SignRequest("sig1", *signer, req)
+	assert.ErrorIs(t, err, context.Canceled, "Vault should be called with the request context")
+
+	_, err = NewVerifier(context.Background(), &fakeVault{key: ecKey}, Key{Name: "my-key"}, "", "ed25519", nil, fields)
+	assert.Error(t, err, "key type mismatch")
+}
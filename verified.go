@@ -0,0 +1,122 @@
+package httpsign
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type verifiedRequestKey struct{}
+
+// VerifiedRequest is attached to the context of each request that was successfully verified by WrapHandler,
+// so that the application handler does not need to re-parse the signature headers.
+type VerifiedRequest struct {
+	signatures []SignatureDetails
+	body       []byte
+	buffered   bool
+}
+
+// GetVerifiedRequest returns the verification result attached to a request by WrapHandler,
+// or nil if the request was not verified (e.g. because it was exempt from verification).
+func GetVerifiedRequest(r *http.Request) *VerifiedRequest {
+	vr, _ := r.Context().Value(verifiedRequestKey{}).(*VerifiedRequest)
+	return vr
+}
+
+// Signatures returns the details of all signatures that were verified.
+func (v *VerifiedRequest) Signatures() []SignatureDetails {
+	return v.signatures
+}
+
+// Signature returns the details of the verified signature. If more than one signature was verified,
+// this is the first one in the order of the Signature-Input header.
+func (v *VerifiedRequest) Signature() SignatureDetails {
+	if len(v.signatures) == 0 {
+		return SignatureDetails{}
+	}
+	return v.signatures[0]
+}
+
+// Label returns the label (signature name) of the verified signature.
+func (v *VerifiedRequest) Label() string {
+	return v.Signature().Label
+}
+
+// KeyID returns the "keyid" parameter of the verified signature.
+func (v *VerifiedRequest) KeyID() string {
+	return v.Signature().KeyID
+}
+
+// Alg returns the "alg" parameter of the verified signature, which may be empty.
+func (v *VerifiedRequest) Alg() string {
+	return v.Signature().Alg
+}
+
+// Components returns the components covered by the verified signature, see Fields.Components.
+func (v *VerifiedRequest) Components() []string {
+	return v.Signature().Fields.Components()
+}
+
+// Created returns the "created" parameter of the verified signature, or the zero time if it is absent.
+func (v *VerifiedRequest) Created() time.Time {
+	return unixTime(v.Signature().Created)
+}
+
+// Expires returns the "expires" parameter of the verified signature, or the zero time if it is absent.
+func (v *VerifiedRequest) Expires() time.Time {
+	return unixTime(v.Signature().Expires)
+}
+
+func unixTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
+}
+
+// Body returns a fresh reader for the request body, which can be called any number of times.
+// The body is only available if the handler is configured with HandlerConfig.SetBufferBody.
+func (v *VerifiedRequest) Body() (io.ReadCloser, error) {
+	if !v.buffered {
+		return nil, fmt.Errorf("request body was not buffered")
+	}
+	return io.NopCloser(bytes.NewReader(v.body)), nil
+}
+
+// bufferBody reads the request body, and replaces it with a reader over the buffered copy
+func (v *VerifiedRequest) bufferBody(r *http.Request, maxBytes int64) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		v.buffered = true
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read request body: %w", err)
+	}
+	if int64(len(body)) > maxBytes {
+		return fmt.Errorf("request body is larger than %d bytes", maxBytes)
+	}
+	v.body = body
+	v.buffered = true
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// selectSignatures returns the signatures whose label is one of labels, in their original order.
+// Tags are resolved to labels before verification, since a tag is chosen by the sender and does not
+// identify the signature that was verified.
+func selectSignatures(sigs []SignatureDetails, labels []string) []SignatureDetails {
+	var selected []SignatureDetails
+	for _, s := range sigs {
+		for _, l := range labels {
+			if s.Label == l {
+				selected = append(selected, s)
+				break
+			}
+		}
+	}
+	return selected
+}